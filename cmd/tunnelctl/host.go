@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+// hostFlags are the flags of host create and host update.
+type hostFlags struct {
+	ip              string
+	port            int
+	user            string
+	password        string
+	privateKeyFile  string
+	passphrase      string
+	sshKeyID        uint
+	hostGroupID     uint
+	reconnectPolicy string
+	hostKey         string
+	jumpHostIDs     string
+	description     string
+	enabled         bool
+}
+
+func (f *hostFlags) register(c *cmd, update bool) {
+	c.fs.StringVar(&f.ip, "ip", "", "IP address of the Host")
+	c.fs.IntVar(&f.port, "port", 22, "SSH port")
+	c.fs.StringVar(&f.user, "user", "", "SSH user")
+	c.fs.StringVar(&f.password, "password", "", "SSH password")
+	c.fs.StringVar(&f.privateKeyFile, "private-key-file", "", "file of the SSH private key")
+	c.fs.StringVar(&f.passphrase, "passphrase", "", "passphrase of the private key")
+	c.fs.UintVar(&f.sshKeyID, "ssh-key-id", 0, "ID of a stored SSH key to use instead of a password or private key, 0 detaches it on update")
+	c.fs.UintVar(&f.hostGroupID, "host-group-id", 0, "ID of the host group, 0 removes the Host from its group on update")
+	c.fs.StringVar(&f.reconnectPolicy, "reconnect-policy", "", `reconnect policy as a JSON object, e.g. '{"max_attempts":10}'`)
+	c.fs.StringVar(&f.description, "description", "", "description")
+	c.fs.BoolVar(&f.enabled, "enabled", true, "whether the Host is enabled")
+	if update {
+		return
+	}
+	c.fs.StringVar(&f.hostKey, "host-key", "", "host key to pin, in authorized_keys format")
+	c.fs.StringVar(&f.jumpHostIDs, "jump-host-ids", "", "comma separated IDs of the jump hosts, in order")
+}
+
+func (f *hostFlags) readPrivateKey() (string, error) {
+	if f.privateKeyFile == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(f.privateKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func (f *hostFlags) parseReconnectPolicy() (*models.ReconnectPolicy, error) {
+	if f.reconnectPolicy == "" {
+		return nil, nil
+	}
+
+	var policy models.ReconnectPolicy
+	err := json.Unmarshal([]byte(f.reconnectPolicy), &policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reconnect policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+func printHosts(c *cmd, v interface{}, hosts []models.Host) error {
+	return c.print(v, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tIP\tPORT\tUSER\tSSH KEY\tHOST GROUP\tENABLED\tDESCRIPTION")
+		for _, host := range hosts {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%t\t%s\n",
+				host.ID, host.IP, host.Port, host.User,
+				optionalID(host.SSHKeyID), optionalID(host.HostGroupID),
+				host.Enabled, orDash(truncate(host.Description, 40)))
+		}
+	})
+}
+
+var hostCommands = map[string]subcommand{
+	"list": {
+		usage: "host list",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 0)
+			if err != nil {
+				return err
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			hosts, err := cl.ListHosts()
+			if err != nil {
+				return err
+			}
+
+			return printHosts(c, hosts, hosts)
+		},
+	},
+	"get": {
+		usage: "host get ID",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			id, err := parseID(c.args[0])
+			if err != nil {
+				return err
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			host, err := cl.GetHost(id)
+			if err != nil {
+				return err
+			}
+
+			return printHosts(c, host, []models.Host{*host})
+		},
+	},
+	"create": {
+		usage: "host create -ip IP -user USER (-password PASSWORD | -private-key-file FILE | -ssh-key-id ID) [flags]",
+		run: func(c *cmd, args []string) error {
+			var f hostFlags
+			f.register(c, false)
+			err := c.parse(args, 0)
+			if err != nil {
+				return err
+			}
+
+			req := models.CreateHostRequest{
+				IP:          f.ip,
+				Port:        f.port,
+				User:        f.user,
+				Password:    f.password,
+				Passphrase:  f.passphrase,
+				HostKey:     f.hostKey,
+				Description: f.description,
+			}
+			req.PrivateKey, err = f.readPrivateKey()
+			if err != nil {
+				return err
+			}
+			req.ReconnectPolicy, err = f.parseReconnectPolicy()
+			if err != nil {
+				return err
+			}
+			req.JumpHostIDs, err = parseIDs(f.jumpHostIDs)
+			if err != nil {
+				return err
+			}
+			if f.sshKeyID != 0 {
+				req.SSHKeyID = &f.sshKeyID
+			}
+			if f.hostGroupID != 0 {
+				req.HostGroupID = &f.hostGroupID
+			}
+			if c.isSet("enabled") {
+				req.Enabled = &f.enabled
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			host, err := cl.CreateHost(&req)
+			if err != nil {
+				return err
+			}
+
+			return printHosts(c, host, []models.Host{*host})
+		},
+	},
+	"update": {
+		usage: "host update ID [flags]",
+		run: func(c *cmd, args []string) error {
+			var f hostFlags
+			f.register(c, true)
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			id, err := parseID(c.args[0])
+			if err != nil {
+				return err
+			}
+
+			// Only the flags that are given are changed.
+			var req models.UpdateHostRequest
+			req.IP = f.ip
+			req.User = f.user
+			req.Password = f.password
+			req.Passphrase = f.passphrase
+			req.Description = f.description
+			req.PrivateKey, err = f.readPrivateKey()
+			if err != nil {
+				return err
+			}
+			req.ReconnectPolicy, err = f.parseReconnectPolicy()
+			if err != nil {
+				return err
+			}
+			if c.isSet("port") {
+				req.Port = &f.port
+			}
+			if c.isSet("ssh-key-id") {
+				req.SSHKeyID = &f.sshKeyID
+			}
+			if c.isSet("host-group-id") {
+				req.HostGroupID = &f.hostGroupID
+			}
+			if c.isSet("enabled") {
+				req.Enabled = &f.enabled
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			// The API replaces a stored description with the requested one, so it is kept unless given.
+			if !c.isSet("description") {
+				stored, err := cl.GetHost(id)
+				if err != nil {
+					return err
+				}
+				req.Description = stored.Description
+			}
+
+			host, err := cl.UpdateHost(id, &req)
+			if err != nil {
+				return err
+			}
+
+			return printHosts(c, host, []models.Host{*host})
+		},
+	},
+	"delete": {
+		usage: "host delete ID",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			id, err := parseID(c.args[0])
+			if err != nil {
+				return err
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			err = cl.DeleteHost(id)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Deleted Host %d.\n", id)
+			return nil
+		},
+	},
+}
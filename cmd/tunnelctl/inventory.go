@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jollaman999/tunnel-manager/internal/inventory"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+// inventoryCommand plans or applies the inventory file given as argument. The file is parsed
+// before it is sent, so that syntax errors are reported without a request.
+func inventoryCommand(apply bool) subcommand {
+	usage := "inventory plan FILE [-prune]"
+	if apply {
+		usage = "inventory apply FILE [-prune] [-dry-run]"
+	}
+
+	return subcommand{
+		usage: usage,
+		run: func(c *cmd, args []string) error {
+			prune := c.fs.Bool("prune", false, "delete Hosts and service ports that are not in the inventory")
+			dryRun := false
+			if apply {
+				c.fs.BoolVar(&dryRun, "dry-run", false, "only show the changes the inventory would make")
+			}
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			data, err := os.ReadFile(c.args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read inventory: %w", err)
+			}
+
+			_, err = inventory.Parse(data)
+			if err != nil {
+				return err
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			var plan *models.InventoryPlan
+			if apply && !dryRun {
+				plan, err = cl.ApplyInventory(data, *prune)
+			} else {
+				plan, err = cl.PlanInventory(data, *prune)
+			}
+			if err != nil {
+				return err
+			}
+
+			if c.opts.output == outputTable {
+				err = inventory.WritePlan(os.Stdout, plan)
+			} else {
+				err = c.print(plan, nil)
+			}
+			if err != nil {
+				return err
+			}
+			if plan.Failed > 0 {
+				return fmt.Errorf("%d of %d changes failed", plan.Failed, len(plan.Changes))
+			}
+
+			return nil
+		},
+	}
+}
+
+var inventoryCommands = map[string]subcommand{
+	"plan":  inventoryCommand(false),
+	"apply": inventoryCommand(true),
+}
@@ -0,0 +1,231 @@
+// tunnelctl is a command-line client for the REST API of tunnel-manager.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jollaman999/tunnel-manager/internal/client"
+)
+
+const usage = `Usage: tunnelctl <command> <subcommand> [flags] [arguments]
+
+Commands:
+  host list|get|create|update|delete           manage Hosts
+  service-port list|get|create|update|delete   manage service ports
+  status [-host ID] [-watch]                   show the status of tunnels
+  tunnel start|stop|restart|retry              control tunnels
+  inventory plan|apply FILE                    plan or apply an inventory file
+  profile list|use|set|delete                  manage the profiles of the config file
+
+Flags of every command:
+  -profile NAME   profile of the config file to use
+  -server URL     URL of tunnel-manager, overrides the profile
+  -token TOKEN    API token, overrides the profile and $TUNNEL_MANAGER_TOKEN
+  -o FORMAT       output format: table, json or yaml (default table)
+  -config PATH    config file (default $TUNNELCTL_CONFIG or <user config dir>/tunnelctl/config.yaml)
+
+Run "tunnelctl <command> <subcommand> -h" for the flags of a subcommand.
+`
+
+// subcommand runs one verb of a command, such as "host create".
+type subcommand struct {
+	usage string
+	run   func(c *cmd, args []string) error
+}
+
+var commands = map[string]map[string]subcommand{
+	"host":         hostCommands,
+	"service-port": servicePortCommands,
+	"tunnel":       tunnelCommands,
+	"inventory":    inventoryCommands,
+	"profile":      profileCommands,
+}
+
+// options are the flags shared by every subcommand.
+type options struct {
+	profile string
+	server  string
+	token   string
+	output  string
+	config  string
+}
+
+// cmd is a subcommand being run, with its flags and positional arguments.
+type cmd struct {
+	fs   *flag.FlagSet
+	opts options
+	args []string
+}
+
+func newCmd(name, usage string) *cmd {
+	c := &cmd{fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+	c.fs.StringVar(&c.opts.profile, "profile", "", "profile of the config file to use")
+	c.fs.StringVar(&c.opts.server, "server", "", "URL of tunnel-manager, overrides the profile")
+	c.fs.StringVar(&c.opts.token, "token", "", "API token, overrides the profile and $"+client.TokenEnv)
+	c.fs.StringVar(&c.opts.output, "o", "table", "output format: table, json or yaml")
+	c.fs.StringVar(&c.opts.config, "config", "", "config file")
+	c.fs.Usage = func() {
+		fmt.Fprintf(c.fs.Output(), "Usage: tunnelctl %s\n\nFlags:\n", usage)
+		c.fs.PrintDefaults()
+	}
+	return c
+}
+
+// parse parses the flags, which may come before, between or after the positional arguments,
+// and checks that there are nargs positional arguments, unless nargs is negative.
+func (c *cmd) parse(args []string, nargs int) error {
+	for {
+		err := c.fs.Parse(args)
+		if err != nil {
+			return err
+		}
+		args = c.fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		c.args = append(c.args, args[0])
+		args = args[1:]
+	}
+
+	if nargs >= 0 && len(c.args) != nargs {
+		c.fs.Usage()
+		return fmt.Errorf("expected %d arguments, got %d", nargs, len(c.args))
+	}
+
+	switch c.opts.output {
+	case outputTable, outputJSON, outputYAML:
+	default:
+		return fmt.Errorf("unknown output format: %s (use table, json or yaml)", c.opts.output)
+	}
+
+	return nil
+}
+
+// isSet reports whether the flag was given on the command line.
+func (c *cmd) isSet(name string) bool {
+	set := false
+	c.fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// client returns a client for the server and token of the flags, the profile or the environment,
+// in this order.
+func (c *cmd) client() (*client.Client, error) {
+	profile, err := c.profile()
+	if err != nil {
+		return nil, err
+	}
+
+	server := firstNonEmpty(c.opts.server, profile.Server, client.DefaultServer)
+	token := firstNonEmpty(c.opts.token, profile.Token, os.Getenv(client.TokenEnv))
+	if token == "" {
+		return nil, fmt.Errorf("no API token, set one with -token, a profile or %s", client.TokenEnv)
+	}
+
+	return client.NewClient(server, token), nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseID parses a Host or service port ID.
+func parseID(s string) (uint, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid ID: %s", s)
+	}
+	return uint(id), nil
+}
+
+// parseIDs parses a comma separated list of IDs. An empty string is an empty list.
+func parseIDs(s string) ([]uint, error) {
+	var ids []uint
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := parseID(field)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// splitList splits a comma separated list, leaving out empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func names(m map[string]subcommand) string {
+	list := make([]string, 0, len(m))
+	for name := range m {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+func run(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		return nil
+	}
+
+	name := args[0]
+	if name == "status" {
+		return statusCommand.run(newCmd(name, statusCommand.usage), args[1:])
+	}
+
+	subcommands, ok := commands[name]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command: %s", name)
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("usage: tunnelctl %s <subcommand> (one of %s)", name, names(subcommands))
+	}
+
+	sub, ok := subcommands[args[1]]
+	if !ok {
+		return fmt.Errorf("unknown %s subcommand: %s (use %s)", name, args[1], names(subcommands))
+	}
+
+	return sub.run(newCmd(name+" "+args[1], sub.usage), args[2:])
+}
+
+func main() {
+	err := run(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/client"
+)
+
+// received is a request received by a fake tunnel-manager.
+type received struct {
+	method string
+	path   string
+	token  string
+	body   map[string]interface{}
+}
+
+// fakeServer answers every request with data and records the requests.
+type fakeServer struct {
+	url string
+
+	mu       sync.Mutex
+	requests []received
+}
+
+func newFakeServer(t *testing.T, data interface{}) *fakeServer {
+	t.Helper()
+
+	s := &fakeServer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := received{
+			method: r.Method,
+			path:   r.URL.Path,
+			token:  strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req.body)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": data})
+	}))
+	t.Cleanup(server.Close)
+	s.url = server.URL
+
+	return s
+}
+
+func (s *fakeServer) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.requests)
+}
+
+// runOutput runs tunnelctl with args and returns what it wrote to stdout.
+func runOutput(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+
+	err = run(args)
+	_ = w.Close()
+
+	return <-output, err
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		nargs    int
+		wantArgs []string
+		wantErr  bool
+	}{
+		{name: "flags after arguments", args: []string{"1", "-o", "json"}, nargs: 1, wantArgs: []string{"1"}},
+		{name: "flags between arguments", args: []string{"1", "-o", "yaml", "2"}, nargs: 2, wantArgs: []string{"1", "2"}},
+		{name: "any number of arguments", args: []string{"a", "b", "c"}, nargs: -1, wantArgs: []string{"a", "b", "c"}},
+		{name: "too many arguments", args: []string{"1", "2"}, nargs: 1, wantErr: true},
+		{name: "missing argument", args: []string{"-o", "json"}, nargs: 1, wantErr: true},
+		{name: "unknown output format", args: []string{"-o", "xml"}, nargs: 0, wantErr: true},
+		{name: "unknown flag", args: []string{"-port", "22"}, nargs: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCmd("test", "test")
+			c.fs.SetOutput(io.Discard)
+
+			err := c.parse(tt.args, tt.nargs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(c.args, tt.wantArgs) {
+				t.Errorf("parse() args = %q, want %q", c.args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestServerAndToken(t *testing.T) {
+	prod := newFakeServer(t, map[string]interface{}{"id": 1})
+	dev := newFakeServer(t, map[string]interface{}{"id": 1})
+	other := newFakeServer(t, map[string]interface{}{"id": 1})
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := saveConfig(configPath, &Config{
+		Current: "prod",
+		Profiles: map[string]Profile{
+			"prod": {Server: prod.url, Token: "prod-token"},
+			"dev":  {Server: dev.url},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		flags      []string
+		env        string
+		wantServer *fakeServer
+		wantToken  string
+		wantErr    bool
+	}{
+		{name: "current profile", wantServer: prod, wantToken: "prod-token"},
+		{name: "current profile over environment", env: "env-token", wantServer: prod, wantToken: "prod-token"},
+		{name: "profile flag", flags: []string{"-profile", "dev"}, env: "env-token", wantServer: dev, wantToken: "env-token"},
+		{name: "profile without token", flags: []string{"-profile", "dev"}, wantErr: true},
+		{name: "flags over profile", flags: []string{"-server", other.url, "-token", "flag-token"}, wantServer: other, wantToken: "flag-token"},
+		{name: "unknown profile", flags: []string{"-profile", "staging"}, env: "env-token", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(client.TokenEnv, tt.env)
+			t.Setenv(ConfigEnv, configPath)
+			before := map[*fakeServer]int{prod: len(prod.received()), dev: len(dev.received()), other: len(other.received())}
+
+			_, err := runOutput(t, append([]string{"host", "get", "1", "-o", "json"}, tt.flags...)...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for server, n := range before {
+				requests := server.received()[n:]
+				if server != tt.wantServer {
+					if len(requests) > 0 {
+						t.Errorf("unexpected requests to %s: %+v", server.url, requests)
+					}
+					continue
+				}
+				if len(requests) != 1 || requests[0].path != "/api/host/1" || requests[0].token != tt.wantToken {
+					t.Errorf("requests to %s = %+v, want GET /api/host/1 with token %q", server.url, requests, tt.wantToken)
+				}
+			}
+		})
+	}
+}
+
+func TestHostUpdateDescription(t *testing.T) {
+	tests := []struct {
+		name            string
+		flags           []string
+		wantMethods     []string
+		wantDescription string
+	}{
+		{name: "kept", flags: []string{"-port", "2222"}, wantMethods: []string{http.MethodGet, http.MethodPut}, wantDescription: "web-1"},
+		{name: "given", flags: []string{"-description", "web-2"}, wantMethods: []string{http.MethodPut}, wantDescription: "web-2"},
+		{name: "cleared", flags: []string{"-description", ""}, wantMethods: []string{http.MethodPut}, wantDescription: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, map[string]interface{}{"id": 1, "ip": "192.168.0.10", "description": "web-1"})
+
+			_, err := runOutput(t, append([]string{"host", "update", "1", "-server", server.url, "-token", "secret"}, tt.flags...)...)
+			if err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+
+			requests := server.received()
+			var methods []string
+			for _, req := range requests {
+				methods = append(methods, req.method)
+			}
+			if !slices.Equal(methods, tt.wantMethods) {
+				t.Fatalf("requests = %q, want %q", methods, tt.wantMethods)
+			}
+			if got := requests[len(requests)-1].body["description"]; got != tt.wantDescription {
+				t.Errorf("description sent = %v, want %q", got, tt.wantDescription)
+			}
+		})
+	}
+}
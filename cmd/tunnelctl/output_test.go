@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestToYAML(t *testing.T) {
+	type inner struct {
+		Zone string `json:"zone"`
+		Area string `json:"area"`
+	}
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "keys in field order",
+			value: struct {
+				Name    string   `json:"name"`
+				ID      uint     `json:"id"`
+				Ratio   float64  `json:"ratio"`
+				Enabled bool     `json:"enabled"`
+				Inner   inner    `json:"inner"`
+				Tags    []string `json:"tags"`
+				Empty   []int    `json:"empty"`
+				Missing *inner   `json:"missing"`
+			}{Name: "web", ID: 12, Ratio: 0.5, Enabled: true, Inner: inner{Zone: "a", Area: "b"}, Tags: []string{"x", "y"}, Empty: []int{}},
+			want: `name: web
+id: 12
+ratio: 0.5
+enabled: true
+inner:
+  zone: a
+  area: b
+tags:
+- x
+- "y"
+empty: []
+missing: null
+`,
+		},
+		{
+			name:  "list of objects",
+			value: []inner{{Zone: "a", Area: "b"}, {Zone: "c", Area: "d"}},
+			want: `- zone: a
+  area: b
+- zone: c
+  area: d
+`,
+		},
+		{name: "large integer", value: map[string]uint64{"bytes": 1 << 40}, want: "bytes: 1099511627776\n"},
+		{name: "unsupported value", value: func() {}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toYAML(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toYAML() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("toYAML() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigEnv is the environment variable holding the path of the config file.
+const ConfigEnv = "TUNNELCTL_CONFIG"
+
+// Profile is the server and API token of one tunnel-manager instance.
+type Profile struct {
+	Server string `yaml:"server"`
+	Token  string `yaml:"token"`
+}
+
+// Config is the config file of tunnelctl. Current is the profile used when -profile is not given.
+type Config struct {
+	Current  string             `yaml:"current,omitempty"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// configPath returns the path of the config file given by -config, the environment or the
+// user config directory, in this order.
+func (c *cmd) configPath() (string, error) {
+	if c.opts.config != "" {
+		return c.opts.config, nil
+	}
+	if path := os.Getenv(ConfigEnv); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the config directory, use -config or %s: %w", ConfigEnv, err)
+	}
+
+	return filepath.Join(dir, "tunnelctl", "config.yaml"), nil
+}
+
+// loadConfig reads the config file. A missing file is an empty config.
+func loadConfig(path string) (*Config, error) {
+	config := &Config{Profiles: make(map[string]Profile)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return config, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	err = yaml.UnmarshalStrict(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+
+	return config, nil
+}
+
+// saveConfig writes the config file, readable only by its owner since it holds API tokens.
+func saveConfig(path string, config *Config) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
+
+// profile returns the profile given by -profile, or the current one. Without either, it is empty.
+func (c *cmd) profile() (Profile, error) {
+	path, err := c.configPath()
+	if err != nil {
+		return Profile{}, err
+	}
+
+	config, err := loadConfig(path)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	name := firstNonEmpty(c.opts.profile, config.Current)
+	if name == "" {
+		return Profile{}, nil
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %s not found in %s", name, path)
+	}
+
+	return profile, nil
+}
+
+// editConfig loads the config file, applies edit to it and saves it.
+func (c *cmd) editConfig(edit func(config *Config) error) error {
+	path, err := c.configPath()
+	if err != nil {
+		return err
+	}
+
+	config, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	err = edit(config)
+	if err != nil {
+		return err
+	}
+
+	return saveConfig(path, config)
+}
+
+var profileCommands = map[string]subcommand{
+	"list": {
+		usage: "profile list",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 0)
+			if err != nil {
+				return err
+			}
+
+			path, err := c.configPath()
+			if err != nil {
+				return err
+			}
+			config, err := loadConfig(path)
+			if err != nil {
+				return err
+			}
+
+			profileNames := make([]string, 0, len(config.Profiles))
+			for name := range config.Profiles {
+				profileNames = append(profileNames, name)
+			}
+			sort.Strings(profileNames)
+
+			// Tokens are not printed.
+			type profileInfo struct {
+				Name    string `json:"name"`
+				Server  string `json:"server"`
+				Current bool   `json:"current"`
+			}
+			infos := make([]profileInfo, 0, len(profileNames))
+			for _, name := range profileNames {
+				infos = append(infos, profileInfo{
+					Name:    name,
+					Server:  config.Profiles[name].Server,
+					Current: name == config.Current,
+				})
+			}
+
+			return c.print(infos, func(w io.Writer) {
+				fmt.Fprintln(w, "CURRENT\tNAME\tSERVER")
+				for _, info := range infos {
+					current := ""
+					if info.Current {
+						current = "*"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\n", current, info.Name, orDash(info.Server))
+				}
+			})
+		},
+	},
+	"use": {
+		usage: "profile use NAME",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			name := c.args[0]
+			err = c.editConfig(func(config *Config) error {
+				if _, ok := config.Profiles[name]; !ok {
+					return fmt.Errorf("profile %s not found", name)
+				}
+				config.Current = name
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Switched to profile %s.\n", name)
+			return nil
+		},
+	},
+	"set": {
+		usage: "profile set NAME [-server URL] [-token TOKEN]",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			// -server and -token are stored in the profile instead of being used for a request.
+			name := c.args[0]
+			err = c.editConfig(func(config *Config) error {
+				profile := config.Profiles[name]
+				if c.isSet("server") {
+					profile.Server = c.opts.server
+				}
+				if c.isSet("token") {
+					profile.Token = c.opts.token
+				}
+				config.Profiles[name] = profile
+				if config.Current == "" {
+					config.Current = name
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Saved profile %s.\n", name)
+			return nil
+		},
+	},
+	"delete": {
+		usage: "profile delete NAME",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			name := c.args[0]
+			err = c.editConfig(func(config *Config) error {
+				if _, ok := config.Profiles[name]; !ok {
+					return fmt.Errorf("profile %s not found", name)
+				}
+				delete(config.Profiles, name)
+				if config.Current == name {
+					config.Current = ""
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Deleted profile %s.\n", name)
+			return nil
+		},
+	},
+}
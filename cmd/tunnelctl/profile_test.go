@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProfileCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnelctl", "config.yaml")
+	t.Setenv(ConfigEnv, path)
+
+	steps := []struct {
+		args       []string
+		wantErr    bool
+		wantConfig Config
+	}{
+		{
+			// The first profile becomes the current one.
+			args: []string{"profile", "set", "prod", "-server", "https://prod:8888", "-token", "prod-token"},
+			wantConfig: Config{Current: "prod", Profiles: map[string]Profile{
+				"prod": {Server: "https://prod:8888", Token: "prod-token"},
+			}},
+		},
+		{
+			args: []string{"profile", "set", "dev", "-server", "http://127.0.0.1:8888"},
+			wantConfig: Config{Current: "prod", Profiles: map[string]Profile{
+				"prod": {Server: "https://prod:8888", Token: "prod-token"},
+				"dev":  {Server: "http://127.0.0.1:8888"},
+			}},
+		},
+		{
+			// Only the given flags change a profile.
+			args: []string{"profile", "set", "prod", "-token", "rotated"},
+			wantConfig: Config{Current: "prod", Profiles: map[string]Profile{
+				"prod": {Server: "https://prod:8888", Token: "rotated"},
+				"dev":  {Server: "http://127.0.0.1:8888"},
+			}},
+		},
+		{
+			args: []string{"profile", "use", "dev"},
+			wantConfig: Config{Current: "dev", Profiles: map[string]Profile{
+				"prod": {Server: "https://prod:8888", Token: "rotated"},
+				"dev":  {Server: "http://127.0.0.1:8888"},
+			}},
+		},
+		{
+			args:    []string{"profile", "use", "staging"},
+			wantErr: true,
+			wantConfig: Config{Current: "dev", Profiles: map[string]Profile{
+				"prod": {Server: "https://prod:8888", Token: "rotated"},
+				"dev":  {Server: "http://127.0.0.1:8888"},
+			}},
+		},
+		{
+			args: []string{"profile", "delete", "dev"},
+			wantConfig: Config{Profiles: map[string]Profile{
+				"prod": {Server: "https://prod:8888", Token: "rotated"},
+			}},
+		},
+	}
+
+	for _, step := range steps {
+		_, err := runOutput(t, step.args...)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s error = %v, wantErr %v", strings.Join(step.args, " "), err, step.wantErr)
+		}
+
+		config, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if !reflect.DeepEqual(*config, step.wantConfig) {
+			t.Errorf("config after %s = %+v, want %+v", strings.Join(step.args, " "), *config, step.wantConfig)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat config: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Tokens are not printed.
+	output, err := runOutput(t, "profile", "list", "-o", "json")
+	if err != nil {
+		t.Fatalf("profile list error = %v", err)
+	}
+	var profiles []map[string]interface{}
+	err = json.Unmarshal([]byte(output), &profiles)
+	if err != nil {
+		t.Fatalf("invalid profile list %q: %v", output, err)
+	}
+	want := []map[string]interface{}{{"name": "prod", "server": "https://prod:8888", "current": false}}
+	if !reflect.DeepEqual(profiles, want) || strings.Contains(output, "rotated") {
+		t.Errorf("profile list = %s, want %v", output, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Config
+		wantErr bool
+	}{
+		{name: "missing file", want: Config{Profiles: map[string]Profile{}}},
+		{name: "no profiles", content: "current: prod\n", want: Config{Current: "prod", Profiles: map[string]Profile{}}},
+		{
+			name:    "profiles",
+			content: "current: prod\nprofiles:\n  prod:\n    server: https://prod:8888\n    token: secret\n",
+			want:    Config{Current: "prod", Profiles: map[string]Profile{"prod": {Server: "https://prod:8888", Token: "secret"}}},
+		},
+		{name: "unknown field", content: "profiles:\n  prod:\n    url: https://prod:8888\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if tt.content != "" {
+				err := os.WriteFile(path, []byte(tt.content), 0600)
+				if err != nil {
+					t.Fatalf("failed to write config: %v", err)
+				}
+			}
+
+			config, err := loadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(*config, tt.want) {
+				t.Errorf("loadConfig() = %+v, want %+v", *config, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+// servicePortFlags are the flags of service-port create and service-port update.
+type servicePortFlags struct {
+	serviceIP       string
+	servicePort     int
+	localPort       int
+	bindAddress     string
+	direction       string
+	allowlist       string
+	applyToAllHosts bool
+	description     string
+}
+
+func (f *servicePortFlags) register(c *cmd) {
+	c.fs.StringVar(&f.serviceIP, "service-ip", "", "IP address of the service, not used by dynamic service ports")
+	c.fs.IntVar(&f.servicePort, "service-port", 0, "port of the service, not used by dynamic service ports")
+	c.fs.IntVar(&f.localPort, "local-port", 0, "port the tunnel listens on")
+	c.fs.StringVar(&f.bindAddress, "bind-address", "", "address the tunnel listens on (default 0.0.0.0)")
+	c.fs.StringVar(&f.direction, "direction", "", "remote, local or dynamic (default remote)")
+	c.fs.StringVar(&f.allowlist, "allowlist", "", "comma separated destinations a dynamic service port may connect to")
+	c.fs.BoolVar(&f.applyToAllHosts, "all-hosts", false, "assign the service port to every Host")
+	c.fs.StringVar(&f.description, "description", "", "description")
+}
+
+// apply sets the fields of req whose flags are given.
+func (f *servicePortFlags) apply(c *cmd, req *models.CreateServicePortRequest) {
+	if c.isSet("service-ip") {
+		req.ServiceIP = f.serviceIP
+	}
+	if c.isSet("service-port") {
+		req.ServicePort = f.servicePort
+	}
+	if c.isSet("local-port") {
+		req.LocalPort = f.localPort
+	}
+	if c.isSet("bind-address") {
+		req.BindAddress = f.bindAddress
+	}
+	if c.isSet("direction") {
+		req.Direction = f.direction
+	}
+	if c.isSet("allowlist") {
+		req.Allowlist = splitList(f.allowlist)
+	}
+	if c.isSet("all-hosts") {
+		req.ApplyToAllHosts = f.applyToAllHosts
+	}
+	if c.isSet("description") {
+		req.Description = f.description
+	}
+}
+
+func printServicePorts(c *cmd, v interface{}, sps []models.ServicePort) error {
+	return c.print(v, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tDIRECTION\tSERVICE\tLOCAL\tALL HOSTS\tALLOWLIST\tDESCRIPTION")
+		for _, sp := range sps {
+			service := "-"
+			if sp.ServiceIP != nil {
+				service = fmt.Sprintf("%s:%d", *sp.ServiceIP, sp.ServicePort)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s:%d\t%t\t%s\t%s\n",
+				sp.ID, sp.Direction, service, sp.BindAddress, sp.LocalPort, sp.ApplyToAllHosts,
+				orDash(strings.Join(sp.Allowlist, ",")), orDash(truncate(sp.Description, 40)))
+		}
+	})
+}
+
+var servicePortCommands = map[string]subcommand{
+	"list": {
+		usage: "service-port list",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 0)
+			if err != nil {
+				return err
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			sps, err := cl.ListServicePorts()
+			if err != nil {
+				return err
+			}
+
+			return printServicePorts(c, sps, sps)
+		},
+	},
+	"get": {
+		usage: "service-port get ID",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			id, err := parseID(c.args[0])
+			if err != nil {
+				return err
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			sp, err := cl.GetServicePort(id)
+			if err != nil {
+				return err
+			}
+
+			return printServicePorts(c, sp, []models.ServicePort{*sp})
+		},
+	},
+	"create": {
+		usage: "service-port create -local-port PORT [-service-ip IP -service-port PORT] [flags]",
+		run: func(c *cmd, args []string) error {
+			var f servicePortFlags
+			f.register(c)
+			err := c.parse(args, 0)
+			if err != nil {
+				return err
+			}
+
+			var req models.CreateServicePortRequest
+			f.apply(c, &req)
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			sp, err := cl.CreateServicePort(&req)
+			if err != nil {
+				return err
+			}
+
+			return printServicePorts(c, sp, []models.ServicePort{*sp})
+		},
+	},
+	"update": {
+		usage: "service-port update ID [flags]",
+		run: func(c *cmd, args []string) error {
+			var f servicePortFlags
+			f.register(c)
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			id, err := parseID(c.args[0])
+			if err != nil {
+				return err
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			// The API replaces every field, so the flags that are given are laid over the current ones.
+			current, err := cl.GetServicePort(id)
+			if err != nil {
+				return err
+			}
+
+			req := models.CreateServicePortRequest{
+				ServicePort:     current.ServicePort,
+				LocalPort:       current.LocalPort,
+				BindAddress:     current.BindAddress,
+				Direction:       current.Direction,
+				Allowlist:       current.Allowlist,
+				ApplyToAllHosts: current.ApplyToAllHosts,
+				Description:     current.Description,
+			}
+			if current.ServiceIP != nil {
+				req.ServiceIP = *current.ServiceIP
+			}
+			f.apply(c, &req)
+
+			// Fields that the new direction does not use are dropped unless they are given.
+			if req.Direction == "dynamic" {
+				if !c.isSet("service-ip") {
+					req.ServiceIP = ""
+				}
+				if !c.isSet("service-port") {
+					req.ServicePort = 0
+				}
+			} else if !c.isSet("allowlist") {
+				req.Allowlist = nil
+			}
+			if req.Direction == "local" || req.Direction == "dynamic" {
+				if !c.isSet("all-hosts") {
+					req.ApplyToAllHosts = false
+				}
+			}
+
+			sp, err := cl.UpdateServicePort(id, &req)
+			if err != nil {
+				return err
+			}
+
+			return printServicePorts(c, sp, []models.ServicePort{*sp})
+		},
+	},
+	"delete": {
+		usage: "service-port delete ID",
+		run: func(c *cmd, args []string) error {
+			err := c.parse(args, 1)
+			if err != nil {
+				return err
+			}
+
+			id, err := parseID(c.args[0])
+			if err != nil {
+				return err
+			}
+
+			cl, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			err = cl.DeleteServicePort(id)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Deleted service port %d.\n", id)
+			return nil
+		},
+	},
+}
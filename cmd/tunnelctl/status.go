@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/client"
+)
+
+// clearScreen moves the cursor home and clears the terminal before each refresh of -watch.
+const clearScreen = "\033[H\033[2J"
+
+func printStatus(c *cmd, status *client.Status) error {
+	return c.print(status, func(w io.Writer) {
+		fmt.Fprintf(w, "Connected: %d/%d\n\n", status.ConnectedTunnels, status.TotalTunnels)
+		fmt.Fprintln(w, "HOST\tSP\tDIRECTION\tSTATUS\tSERVER\tLOCAL\tREMOTE\tRETRIES\tLAST CONNECTED\tERROR")
+		for _, t := range status.Tunnels {
+			lastConnected := "-"
+			if !t.LastConnectedAt.IsZero() {
+				lastConnected = t.LastConnectedAt.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+				t.HostID, t.SPID, t.Direction, t.Status, t.Server, t.Local, orDash(t.Remote),
+				t.RetryCount, lastConnected, orDash(truncate(t.LastError, 60)))
+		}
+	})
+}
+
+var statusCommand = subcommand{
+	usage: "status [-host ID] [-watch] [-interval DURATION]",
+	run: func(c *cmd, args []string) error {
+		hostID := c.fs.Uint("host", 0, "only show the tunnels of this Host")
+		watch := c.fs.Bool("watch", false, "refresh the status until interrupted")
+		interval := c.fs.Duration("interval", 2*time.Second, "refresh interval of -watch")
+		err := c.parse(args, 0)
+		if err != nil {
+			return err
+		}
+		if *interval < time.Second {
+			return fmt.Errorf("interval must be at least 1s")
+		}
+
+		cl, err := c.client()
+		if err != nil {
+			return err
+		}
+
+		for {
+			status, err := cl.Status(*hostID)
+			if !*watch {
+				if err != nil {
+					return err
+				}
+				return printStatus(c, status)
+			}
+
+			// Tables are redrawn in place, JSON and YAML are streamed one document per refresh.
+			if c.opts.output == outputTable {
+				fmt.Print(clearScreen)
+				fmt.Printf("Every %s, %s\n\n", *interval, time.Now().Format(time.DateTime))
+			} else if c.opts.output == outputYAML {
+				fmt.Println("---")
+			}
+			if err != nil {
+				// A server that is restarting should not end the watch.
+				fmt.Println("Error:", err)
+			} else {
+				err = printStatus(c, status)
+				if err != nil {
+					return err
+				}
+			}
+
+			time.Sleep(*interval)
+		}
+	},
+}
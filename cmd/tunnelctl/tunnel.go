@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+// tunnelActionDone is what a tunnel action prints when it succeeds.
+var tunnelActionDone = map[string]string{
+	"start":   "Started",
+	"stop":    "Stopped",
+	"restart": "Restarted",
+	"retry":   "Retrying",
+}
+
+// tunnelCommand controls the tunnel of one Host and service port, or with -hosts and -sps every
+// tunnel matching them.
+func tunnelCommand(action string) subcommand {
+	return subcommand{
+		usage: fmt.Sprintf("tunnel %[1]s HOST_ID SP_ID | tunnel %[1]s [-hosts IDS] [-sps IDS]", action),
+		run: func(c *cmd, args []string) error {
+			hosts := c.fs.String("hosts", "", "comma separated Host IDs, every Host when omitted")
+			sps := c.fs.String("sps", "", "comma separated service port IDs, every service port when omitted")
+			err := c.parse(args, -1)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case len(c.args) == 2 && !c.isSet("hosts") && !c.isSet("sps"):
+				return controlTunnel(c, action)
+			case len(c.args) == 0 && (*hosts != "" || *sps != ""):
+				var req models.TunnelControlRequest
+				req.HostIDs, err = parseIDs(*hosts)
+				if err != nil {
+					return err
+				}
+				req.SPIDs, err = parseIDs(*sps)
+				if err != nil {
+					return err
+				}
+				return controlTunnels(c, action, &req)
+			default:
+				c.fs.Usage()
+				return fmt.Errorf("give a Host ID and a service port ID, or -hosts and/or -sps")
+			}
+		},
+	}
+}
+
+func controlTunnel(c *cmd, action string) error {
+	hostID, err := parseID(c.args[0])
+	if err != nil {
+		return err
+	}
+	spID, err := parseID(c.args[1])
+	if err != nil {
+		return err
+	}
+
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	err = cl.ControlTunnel(action, hostID, spID)
+	if err != nil {
+		return err
+	}
+
+	result := models.TunnelControlResult{HostID: hostID, SPID: spID}
+	return printTunnelResults(c, result, []models.TunnelControlResult{result}, action)
+}
+
+func controlTunnels(c *cmd, action string, req *models.TunnelControlRequest) error {
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	results, err := cl.ControlTunnels(action, req)
+	if err != nil {
+		return err
+	}
+
+	err = printTunnelResults(c, results, results, action)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tunnels failed to %s", failed, len(results), action)
+	}
+
+	return nil
+}
+
+func printTunnelResults(c *cmd, v interface{}, results []models.TunnelControlResult, action string) error {
+	return c.print(v, func(w io.Writer) {
+		fmt.Fprintln(w, "HOST\tSP\tRESULT")
+		for _, result := range results {
+			outcome := tunnelActionDone[action]
+			if result.Error != "" {
+				outcome = "Error: " + result.Error
+			}
+			fmt.Fprintf(w, "%d\t%d\t%s\n", result.HostID, result.SPID, outcome)
+		}
+	})
+}
+
+var tunnelCommands = map[string]subcommand{
+	"start":   tunnelCommand("start"),
+	"stop":    tunnelCommand("stop"),
+	"restart": tunnelCommand("restart"),
+	"retry":   tunnelCommand("retry"),
+}
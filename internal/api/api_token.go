@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func (h *Handler) CreateAPIToken(c echo.Context) error {
+	var req models.CreateAPITokenRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: expires_at must be in the future",
+		})
+	}
+
+	err = h.checkHostGroupsExist(req.HostGroupIDs)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	apiToken, err := auth.CreateToken(h.db, req.Description, req.Role, req.HostGroupIDs, req.ExpiresAt)
+	if err != nil {
+		h.logger.Error("failed to create API token", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create API token: " + err.Error(),
+		})
+	}
+
+	h.logger.Info("created API token",
+		zap.Uint("token_id", apiToken.ID),
+		zap.String("prefix", apiToken.Prefix),
+		zap.String("role", apiToken.Role),
+		zap.String("description", apiToken.Description))
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Data:    apiToken,
+	})
+}
+
+func (h *Handler) ListAPITokens(c echo.Context) error {
+	var tokens []models.APIToken
+	err := h.db.Find(&tokens).Error
+	if err != nil {
+		h.logger.Error("failed to fetch API tokens", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch API tokens: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    tokens,
+	})
+}
+
+func (h *Handler) GetAPIToken(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid API token ID: " + err.Error(),
+		})
+	}
+
+	var token models.APIToken
+	err = h.db.First(&token, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "API token not found: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    token,
+	})
+}
+
+// DeleteAPIToken revokes an API token. Requests using it are rejected immediately.
+func (h *Handler) DeleteAPIToken(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid API token ID: " + err.Error(),
+		})
+	}
+
+	var token models.APIToken
+	err = h.db.First(&token, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Success: false,
+				Error:   "API token not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch API token: " + err.Error(),
+		})
+	}
+
+	err = h.db.Delete(&token).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to revoke API token: " + err.Error(),
+		})
+	}
+
+	h.logger.Info("revoked API token", zap.Uint("token_id", token.ID), zap.String("prefix", token.Prefix))
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    "API token revoked successfully",
+	})
+}
@@ -0,0 +1,424 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string {
+	return e.msg
+}
+
+func (h *Handler) fetchAssignmentTargets(hostIDs, spIDs []uint) ([]models.Host, []models.ServicePort, error) {
+	var hosts []models.Host
+	err := h.db.Where("id IN ?", hostIDs).Find(&hosts).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch Hosts: %w", err)
+	}
+
+	var sps []models.ServicePort
+	err = h.db.Where("id IN ?", spIDs).Find(&sps).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch service ports: %w", err)
+	}
+
+	found := make(map[uint]bool)
+	for _, host := range hosts {
+		found[host.ID] = true
+	}
+	for _, id := range hostIDs {
+		if !found[id] {
+			return nil, nil, &notFoundError{msg: fmt.Sprintf("Host not found (id=%d)", id)}
+		}
+	}
+
+	found = make(map[uint]bool)
+	for _, sp := range sps {
+		found[sp.ID] = true
+	}
+	for _, id := range spIDs {
+		if !found[id] {
+			return nil, nil, &notFoundError{msg: fmt.Sprintf("Service port not found (id=%d)", id)}
+		}
+	}
+
+	return hosts, sps, nil
+}
+
+func assignmentErrorResponse(c echo.Context, err error) error {
+	var nfErr *notFoundError
+	if errors.As(err, &nfErr) {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   nfErr.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusInternalServerError, models.Response{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+// attach assigns every service port to every host and starts the new tunnels.
+func (h *Handler) attach(hostIDs, spIDs []uint) ([]models.HostServicePort, error) {
+	hosts, sps, err := h.fetchAssignmentTargets(hostIDs, spIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var assignments []models.HostServicePort
+	for _, host := range hosts {
+		for _, sp := range sps {
+			assignments = append(assignments, models.HostServicePort{
+				HostID: host.ID,
+				SPID:   sp.ID,
+			})
+		}
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&assignments).Error
+	})
+	if err != nil {
+		h.logger.Error("failed to create service port assignments", zap.Error(err))
+		return nil, fmt.Errorf("failed to create service port assignments: %w", err)
+	}
+
+	for _, host := range hosts {
+		if !host.Enabled {
+			continue
+		}
+
+		for _, sp := range sps {
+			if sp.ApplyToAllHosts {
+				continue
+			}
+
+			err = h.manager.StartTunnel(&host, &sp)
+			if err != nil {
+				h.logger.Warn("failed to start tunnel",
+					zap.Error(err),
+					zap.String("host_ip", host.IP),
+					zap.Int("service_port", sp.ServicePort))
+			}
+		}
+	}
+
+	return assignments, nil
+}
+
+// detach removes the assignments between the hosts and service ports and stops their tunnels.
+func (h *Handler) detach(hostIDs, spIDs []uint) (int64, error) {
+	hosts, sps, err := h.fetchAssignmentTargets(hostIDs, spIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	// Tunnels of service ports applied to all hosts keep running, and stay stopped if they were stopped.
+	var assignedSPIDs []uint
+	for _, sp := range sps {
+		if !sp.ApplyToAllHosts {
+			assignedSPIDs = append(assignedSPIDs, sp.ID)
+		}
+	}
+
+	var deleted int64
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("host_id IN ? AND sp_id IN ?", hostIDs, spIDs).Delete(&models.HostServicePort{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+
+		if len(assignedSPIDs) == 0 {
+			return nil
+		}
+		return tx.Where("host_id IN ? AND sp_id IN ?", hostIDs, assignedSPIDs).Delete(&models.StoppedTunnel{}).Error
+	})
+	if err != nil {
+		h.logger.Error("failed to delete service port assignments", zap.Error(err))
+		return 0, fmt.Errorf("failed to delete service port assignments: %w", err)
+	}
+
+	for _, host := range hosts {
+		for _, sp := range sps {
+			if sp.ApplyToAllHosts {
+				continue
+			}
+
+			err = h.manager.StopTunnel(host.ID, sp.ID)
+			if err != nil {
+				h.logger.Warn("failed to stop tunnel",
+					zap.Uint("host_id", host.ID),
+					zap.Uint("service_port_id", sp.ID),
+					zap.Error(err))
+			}
+		}
+	}
+
+	return deleted, nil
+}
+
+func parseAssignmentParams(c echo.Context) (uint, uint, error) {
+	hostID, err := strconv.ParseUint(c.Param("hostId"), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid Host ID: %w", err)
+	}
+
+	spID, err := strconv.ParseUint(c.Param("spId"), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid service port ID: %w", err)
+	}
+
+	return uint(hostID), uint(spID), nil
+}
+
+func (h *Handler) ListAssignments(c echo.Context) error {
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	query := h.db
+	if c.QueryParam("host_id") != "" {
+		hostID, err := strconv.ParseUint(c.QueryParam("host_id"), 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid Host ID: " + err.Error(),
+			})
+		}
+		query = query.Where("host_id = ?", hostID)
+	}
+	if c.QueryParam("sp_id") != "" {
+		spID, err := strconv.ParseUint(c.QueryParam("sp_id"), 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid service port ID: " + err.Error(),
+			})
+		}
+		query = query.Where("sp_id = ?", spID)
+	}
+
+	visible, err := h.visibleHostIDs(c)
+	if err != nil {
+		h.logger.Error("failed to fetch service port assignments", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch service port assignments: " + err.Error(),
+		})
+	}
+	if visible != nil {
+		hostIDs := make([]uint, 0, len(visible))
+		for id := range visible {
+			hostIDs = append(hostIDs, id)
+		}
+		query = query.Where("host_id IN ?", hostIDs)
+	}
+
+	var assignments []models.HostServicePort
+	err = query.Find(&assignments).Error
+	if err != nil {
+		h.logger.Error("failed to fetch service port assignments", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch service port assignments: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    assignments,
+	})
+}
+
+func (h *Handler) GetAssignment(c echo.Context) error {
+	hostID, spID, err := parseAssignmentParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	err = h.checkHostsVisible(c, []uint{hostID})
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	var assignment models.HostServicePort
+	err = h.db.Where("host_id = ? AND sp_id = ?", hostID, spID).First(&assignment).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Service port assignment not found: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    assignment,
+	})
+}
+
+func (h *Handler) CreateAssignment(c echo.Context) error {
+	var req models.AssignmentRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	err = h.checkHostsVisible(c, []uint{req.HostID})
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	assignments, err := h.attach([]uint{req.HostID}, []uint{req.SPID})
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Data:    assignments[0],
+	})
+}
+
+func (h *Handler) DeleteAssignment(c echo.Context) error {
+	hostID, spID, err := parseAssignmentParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	err = h.checkHostsVisible(c, []uint{hostID})
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	deleted, err := h.detach([]uint{hostID}, []uint{spID})
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+	if deleted == 0 {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Service port assignment not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    "Service port assignment deleted successfully",
+	})
+}
+
+func (h *Handler) BulkAttach(c echo.Context) error {
+	var req models.BulkAssignmentRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	err = h.checkHostsVisible(c, req.HostIDs)
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	assignments, err := h.attach(req.HostIDs, req.SPIDs)
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    assignments,
+	})
+}
+
+func (h *Handler) BulkDetach(c echo.Context) error {
+	var req models.BulkAssignmentRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	err = h.checkHostsVisible(c, req.HostIDs)
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	deleted, err := h.detach(req.HostIDs, req.SPIDs)
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"deleted": deleted,
+		},
+	})
+}
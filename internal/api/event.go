@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+// ListTunnelEvents returns the state transitions of a tunnel, newest first.
+// The since and until query parameters (RFC 3339) limit the time range and limit caps the number of events.
+func (h *Handler) ListTunnelEvents(c echo.Context) error {
+	hostID, spID, err := parseAssignmentParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	query := h.db.Where("host_id = ? AND sp_id = ?", hostID, spID)
+	if param := c.QueryParam("since"); param != "" {
+		since, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid since: " + err.Error(),
+			})
+		}
+		query = query.Where("created_at >= ?", since.UTC())
+	}
+	if param := c.QueryParam("until"); param != "" {
+		until, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid until: " + err.Error(),
+			})
+		}
+		query = query.Where("created_at < ?", until.UTC())
+	}
+
+	limit := defaultEventLimit
+	if param := c.QueryParam("limit"); param != "" {
+		limit, err = strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > maxEventLimit {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid limit: must be between 1 and " + strconv.Itoa(maxEventLimit),
+			})
+		}
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var host models.Host
+	err = h.hostQuery(c).First(&host, hostID).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Host not found: " + err.Error(),
+		})
+	}
+
+	var events []models.TunnelEvent
+	err = query.Order("created_at DESC, id DESC").Limit(limit).Find(&events).Error
+	if err != nil {
+		h.logger.Error("failed to fetch tunnel events", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch tunnel events: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    events,
+	})
+}
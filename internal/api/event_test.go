@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestListTunnelEvents(t *testing.T) {
+	h, e := newTestHandler(t)
+
+	host := models.Host{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret"}
+	mustCreate(t, h.db, &host)
+
+	base := time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC)
+	for i, status := range []string{"starting", "connected", "reconnecting", "connected", "stopped"} {
+		mustCreate(t, h.db, &models.TunnelEvent{HostID: host.ID, SPID: 1, Status: status, CreatedAt: base.Add(time.Duration(i) * time.Hour)})
+	}
+	mustCreate(t, h.db, &models.TunnelEvent{HostID: host.ID, SPID: 2, Status: "connected", CreatedAt: base})
+
+	tests := []struct {
+		name       string
+		hostID     string
+		query      string
+		wantStatus int
+		wantEvents []string
+	}{
+		{name: "newest first", hostID: "1", wantStatus: http.StatusOK,
+			wantEvents: []string{"stopped", "connected", "reconnecting", "connected", "starting"}},
+		{name: "since", hostID: "1", query: "since=2025-01-10T11:00:00Z", wantStatus: http.StatusOK,
+			wantEvents: []string{"stopped", "connected", "reconnecting"}},
+		{name: "until", hostID: "1", query: "until=2025-01-10T11:00:00Z", wantStatus: http.StatusOK,
+			wantEvents: []string{"connected", "starting"}},
+		{name: "time range in another zone", hostID: "1", query: "since=2025-01-10T19:00:00%2B09:00&until=2025-01-10T21:00:00%2B09:00",
+			wantStatus: http.StatusOK, wantEvents: []string{"reconnecting", "connected"}},
+		{name: "limit", hostID: "1", query: "limit=2", wantStatus: http.StatusOK, wantEvents: []string{"stopped", "connected"}},
+		{name: "invalid since", hostID: "1", query: "since=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "limit out of range", hostID: "1", query: "limit=1001", wantStatus: http.StatusBadRequest},
+		{name: "unknown host", hostID: "2", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/tunnel/"+tt.hostID+"/1/events?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("hostId", "spId")
+			c.SetParamValues(tt.hostID, "1")
+
+			err := h.ListTunnelEvents(c)
+			if err != nil {
+				t.Fatalf("ListTunnelEvents() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("ListTunnelEvents() = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data []models.TunnelEvent `json:"data"`
+			}
+			err = json.Unmarshal(rec.Body.Bytes(), &res)
+			if err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			var got []string
+			for _, event := range res.Data {
+				got = append(got, event.Status)
+			}
+			if !slices.Equal(got, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", got, tt.wantEvents)
+			}
+		})
+	}
+}
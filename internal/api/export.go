@@ -0,0 +1,188 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jollaman999/tunnel-manager/internal/inventory"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/secret"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// PassphraseHeader carries the passphrase that encrypts the secrets of an export and decrypts the
+// secrets of an import. It is a header so that the passphrase does not end up in access logs.
+const PassphraseHeader = "X-Passphrase"
+
+const (
+	exportKindHosts        = "hosts"
+	exportKindServicePorts = "service_ports"
+)
+
+// exportInventory returns every Host and service port as an inventory. Secrets are encrypted
+// with cipher, or left out when it is nil.
+func exportInventory(state *inventoryState, cipher *secret.PassphraseCipher) (*models.Inventory, error) {
+	hostIPs := make(map[uint]string, len(state.hosts))
+	for _, host := range state.hosts {
+		hostIPs[host.ID] = host.IP
+	}
+
+	jumpHostIPs := make(map[uint][]string)
+	for _, jump := range state.jumps {
+		jumpHostIPs[jump.HostID] = append(jumpHostIPs[jump.HostID], hostIPs[jump.JumpHostID])
+	}
+
+	assigned := make(map[uint][]string)
+	for _, assignment := range state.assignments {
+		assigned[assignment.SPID] = append(assigned[assignment.SPID], hostIPs[assignment.HostID])
+	}
+
+	sshKeyNames := make(map[uint]string, len(state.sshKeys))
+	for _, key := range state.sshKeys {
+		sshKeyNames[key.ID] = key.Name
+	}
+
+	hostGroupNames := make(map[uint]string, len(state.hostGroups))
+	for _, group := range state.hostGroups {
+		hostGroupNames[group.ID] = group.Name
+	}
+
+	inv := &models.Inventory{
+		Hosts:        make([]models.InventoryHost, 0, len(state.hosts)),
+		ServicePorts: make([]models.InventoryServicePort, 0, len(state.sps)),
+	}
+
+	for _, host := range state.hosts {
+		item := models.InventoryHost{
+			IP:              host.IP,
+			Port:            host.Port,
+			User:            host.User,
+			ReconnectPolicy: host.ReconnectPolicy,
+			HostKey:         host.HostKey,
+			JumpHosts:       jumpHostIPs[host.ID],
+			Description:     host.Description,
+		}
+		if host.SSHKeyID != nil {
+			item.SSHKey = sshKeyNames[*host.SSHKeyID]
+		}
+		if host.HostGroupID != nil {
+			item.HostGroup = hostGroupNames[*host.HostGroupID]
+		}
+		if !host.Enabled {
+			enabled := false
+			item.Enabled = &enabled
+		}
+
+		if cipher != nil {
+			for _, field := range []struct {
+				value string
+				dst   *string
+			}{
+				{host.Password, &item.Password},
+				{host.PrivateKey, &item.PrivateKey},
+				{host.Passphrase, &item.Passphrase},
+			} {
+				encrypted, err := cipher.Encrypt(field.value)
+				if err != nil {
+					return nil, fmt.Errorf("failed to encrypt secrets of Host %s: %w", host.IP, err)
+				}
+				*field.dst = encrypted
+			}
+		}
+
+		inv.Hosts = append(inv.Hosts, item)
+	}
+
+	for _, sp := range state.sps {
+		item := models.InventoryServicePort{
+			ServicePort:     sp.ServicePort,
+			LocalPort:       sp.LocalPort,
+			BindAddress:     sp.BindAddress,
+			Direction:       sp.Direction,
+			Allowlist:       sp.Allowlist,
+			ApplyToAllHosts: sp.ApplyToAllHosts,
+			Hosts:           assigned[sp.ID],
+			Description:     sp.Description,
+		}
+		if sp.ServiceIP != nil {
+			item.ServiceIP = *sp.ServiceIP
+		}
+
+		inv.ServicePorts = append(inv.ServicePorts, item)
+	}
+
+	return inv, nil
+}
+
+// Export writes every Host and service port as a JSON inventory, or one of the two as a CSV file.
+// Secrets are left out unless a passphrase is given to encrypt them with.
+func (h *Handler) Export(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+	kind := c.QueryParam("kind")
+
+	switch format {
+	case "json":
+	case "csv":
+		if kind != exportKindHosts && kind != exportKindServicePorts {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid kind: CSV export requires kind=hosts or kind=service_ports",
+			})
+		}
+	default:
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid format: " + format,
+		})
+	}
+
+	var cipher *secret.PassphraseCipher
+	if passphrase := c.Request().Header.Get(PassphraseHeader); passphrase != "" {
+		var err error
+		cipher, err = secret.NewPassphraseCipher(passphrase)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to export: " + err.Error(),
+			})
+		}
+	}
+
+	h.rwLock.RLock()
+	state, err := loadInventoryState(h.db)
+	h.rwLock.RUnlock()
+	if err != nil {
+		h.logger.Error("failed to export", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to export: " + err.Error(),
+		})
+	}
+
+	inv, err := exportInventory(state, cipher)
+	if err != nil {
+		h.logger.Error("failed to export", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to export: " + err.Error(),
+		})
+	}
+
+	if format == "json" {
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="inventory.json"`)
+		return c.JSONPretty(http.StatusOK, inv, "  ")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=UTF-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.csv"`, kind))
+	c.Response().WriteHeader(http.StatusOK)
+	if kind == exportKindHosts {
+		return inventory.WriteHostsCSV(c.Response(), inv.Hosts)
+	}
+
+	return inventory.WriteServicePortsCSV(c.Response(), inv.ServicePorts)
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"gorm.io/gorm"
 	"net/http"
+	"reflect"
 	"strconv"
 	"sync"
 
+	"github.com/jollaman999/tunnel-manager/internal/auth"
 	"github.com/jollaman999/tunnel-manager/internal/models"
 	"github.com/jollaman999/tunnel-manager/internal/tunnel"
 	"github.com/labstack/echo/v4"
@@ -18,14 +20,19 @@ type Handler struct {
 	db      *gorm.DB
 	manager *tunnel.Manager
 	logger  *zap.Logger
-	rwLock  sync.RWMutex
+	// allowedOrigins are the origins other than its own from which browsers may open a WebSocket.
+	allowedOrigins []string
+	rwLock         sync.RWMutex
+	// inventoryLock keeps inventories from being planned and applied concurrently.
+	inventoryLock sync.Mutex
 }
 
-func NewHandler(db *gorm.DB, manager *tunnel.Manager, logger *zap.Logger) *Handler {
+func NewHandler(db *gorm.DB, manager *tunnel.Manager, logger *zap.Logger, allowedOrigins []string) *Handler {
 	return &Handler{
-		db:      db,
-		manager: manager,
-		logger:  logger,
+		db:             db,
+		manager:        manager,
+		logger:         logger,
+		allowedOrigins: allowedOrigins,
 	}
 }
 
@@ -50,6 +57,33 @@ func (h *Handler) CreateHost(c echo.Context) error {
 	h.rwLock.Lock()
 	defer h.rwLock.Unlock()
 
+	host, err := h.newHost(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	err = h.checkHostGroup(c, req.HostGroupID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	err = h.checkHostsVisible(c, req.JumpHostIDs)
+	if err == nil {
+		err = h.validateJumpHosts(0, req.JumpHostIDs)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
 	tx := h.db.Begin()
 	err = tx.Error
 	if err != nil {
@@ -59,15 +93,7 @@ func (h *Handler) CreateHost(c echo.Context) error {
 		})
 	}
 
-	host := &models.Host{
-		IP:          req.IP,
-		Port:        req.Port,
-		User:        req.User,
-		Password:    req.Password,
-		Description: req.Description,
-	}
-
-	err = tx.Create(host).Error
+	err = createHost(tx, host, req.JumpHostIDs)
 	if err != nil {
 		tx.Rollback()
 		h.logger.Error("failed to create Host", zap.Error(err))
@@ -78,7 +104,7 @@ func (h *Handler) CreateHost(c echo.Context) error {
 	}
 
 	var sps []models.ServicePort
-	err = tx.Find(&sps).Error
+	err = tx.Where("apply_to_all_hosts = ?", true).Find(&sps).Error
 	if err != nil {
 		tx.Rollback()
 		h.logger.Error("failed to fetch service ports", zap.Error(err))
@@ -96,6 +122,10 @@ func (h *Handler) CreateHost(c echo.Context) error {
 		})
 	}
 
+	// The tunnels of a Host created disabled are started when it is enabled.
+	if !host.Enabled {
+		sps = nil
+	}
 	for _, sp := range sps {
 		err = h.manager.StartTunnel(host, &sp)
 		if err != nil {
@@ -117,7 +147,7 @@ func (h *Handler) ListHosts(c echo.Context) error {
 	defer h.rwLock.RUnlock()
 
 	var hosts []models.Host
-	err := h.db.Find(&hosts).Error
+	err := h.hostQuery(c).Find(&hosts).Error
 	if err != nil {
 		h.logger.Error("failed to fetch Hosts", zap.Error(err))
 		return c.JSON(http.StatusInternalServerError, models.Response{
@@ -145,7 +175,7 @@ func (h *Handler) GetHost(c echo.Context) error {
 	defer h.rwLock.RUnlock()
 
 	var host models.Host
-	err = h.db.First(&host, id).Error
+	err = h.hostQuery(c).First(&host, id).Error
 	if err != nil {
 		return c.JSON(http.StatusNotFound, models.Response{
 			Success: false,
@@ -185,11 +215,18 @@ func (h *Handler) UpdateHost(c echo.Context) error {
 		})
 	}
 
+	if !auth.HasRole(auth.FromContext(c), auth.RoleAdmin) && !onlyEnabled(&req) {
+		return c.JSON(http.StatusForbidden, models.Response{
+			Success: false,
+			Error:   "Forbidden: " + auth.FromContext(c).Role + " role can only change enabled",
+		})
+	}
+
 	h.rwLock.Lock()
 	defer h.rwLock.Unlock()
 
 	var host models.Host
-	err = h.db.First(&host, id).Error
+	err = h.hostQuery(c).First(&host, id).Error
 	if err != nil {
 		return c.JSON(http.StatusNotFound, models.Response{
 			Success: false,
@@ -197,36 +234,22 @@ func (h *Handler) UpdateHost(c echo.Context) error {
 		})
 	}
 
-	var sps []models.ServicePort
-	err = h.db.Find(&sps).Error
+	needTunnelRestart, err := h.applyHostUpdate(c, &host, &req)
 	if err != nil {
-		h.logger.Error("failed to fetch service ports", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, models.Response{
+		return c.JSON(http.StatusBadRequest, models.Response{
 			Success: false,
-			Error:   "Failed to fetch service ports: " + err.Error(),
+			Error:   err.Error(),
 		})
 	}
-
-	needTunnelRestart := (req.IP != "" && host.IP != req.IP) ||
-		(req.Port != nil && host.Port != *req.Port) ||
-		(req.User != "" && host.User != req.User) ||
-		(req.Password != "" && host.Password != req.Password)
 	needTunnelStop := req.Enabled != nil && !*req.Enabled
 
-	if req.IP != "" {
-		host.IP = req.IP
-	}
-	if req.Port != nil {
-		host.Port = *req.Port
-	}
-	if req.User != "" {
-		host.User = req.User
-	}
-	if req.Password != "" {
-		host.Password = req.Password
-	}
-	if host.Description != "" {
-		host.Description = req.Description
+	sps, err := h.manager.ServicePortsForHost(host.ID)
+	if err != nil {
+		h.logger.Error("failed to fetch service ports", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch service ports: " + err.Error(),
+		})
 	}
 
 	tx := h.db.Begin()
@@ -280,6 +303,10 @@ func (h *Handler) UpdateHost(c echo.Context) error {
 		}
 	}
 
+	if needTunnelRestart {
+		h.restartJumpDependents(host.ID)
+	}
+
 	if req.Enabled != nil && host.Enabled != *req.Enabled {
 		host.Enabled = *req.Enabled
 
@@ -330,7 +357,7 @@ func (h *Handler) DeleteHost(c echo.Context) error {
 	defer h.rwLock.Unlock()
 
 	var host models.Host
-	err = h.db.First(&host, id).Error
+	err = h.hostQuery(c).First(&host, id).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.JSON(http.StatusNotFound, models.Response{
@@ -344,8 +371,22 @@ func (h *Handler) DeleteHost(c echo.Context) error {
 		})
 	}
 
-	var sps []models.ServicePort
-	err = h.db.Find(&sps).Error
+	var dependents int64
+	err = h.db.Model(&models.HostJump{}).Where("jump_host_id = ?", host.ID).Count(&dependents).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to check jump host usage: " + err.Error(),
+		})
+	}
+	if dependents > 0 {
+		return c.JSON(http.StatusConflict, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("Host is used as a jump host by %d Host(s)", dependents),
+		})
+	}
+
+	sps, err := h.manager.ServicePortsForHost(host.ID)
 	if err != nil {
 		h.logger.Error("failed to fetch service ports", zap.Error(err))
 		return c.JSON(http.StatusInternalServerError, models.Response{
@@ -373,6 +414,60 @@ func (h *Handler) DeleteHost(c echo.Context) error {
 		})
 	}
 
+	err = tx.Where("host_id = ?", host.ID).Delete(&models.HostServicePort{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete Host's service port assignments: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("host_id = ?", host.ID).Delete(&models.HostJump{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete Host's jump hosts: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("host_id = ?", host.ID).Delete(&models.TunnelTraffic{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete Host's tunnel traffic: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("host_id = ?", host.ID).Delete(&models.TunnelEvent{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete Host's tunnel events: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("host_id = ?", host.ID).Delete(&models.StoppedTunnel{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete Host's stopped tunnels: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("host_id = ?", host.ID).Delete(&models.Schedule{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete Host's schedules: " + err.Error(),
+		})
+	}
+
 	err = tx.Delete(&host).Error
 	if err != nil {
 		tx.Rollback()
@@ -389,6 +484,7 @@ func (h *Handler) DeleteHost(c echo.Context) error {
 			Error:   "Failed to commit transaction: " + err.Error(),
 		})
 	}
+	h.manager.ReloadSchedules()
 
 	return c.JSON(http.StatusOK, models.Response{
 		Success: true,
@@ -396,6 +492,208 @@ func (h *Handler) DeleteHost(c echo.Context) error {
 	})
 }
 
+// newHost validates the authentication and the host key of a validated request and returns the Host
+// to create. The errors are prefixed like the responses of CreateHost.
+func (h *Handler) newHost(req *models.CreateHostRequest) (*models.Host, error) {
+	err := h.validateHostAuth(req.PrivateKey, req.Passphrase, req.SSHKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid authentication: %w", err)
+	}
+
+	err = h.validateReconnectPolicy(req.ReconnectPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("Validation failed: %w", err)
+	}
+
+	var hostKey, hostKeyFingerprint string
+	if req.HostKey != "" {
+		hostKey, hostKeyFingerprint, err = tunnel.ParseHostKey(req.HostKey)
+		if err != nil {
+			return nil, fmt.Errorf("Validation failed: %w", err)
+		}
+	}
+
+	return &models.Host{
+		IP:                 req.IP,
+		Port:               req.Port,
+		User:               req.User,
+		Password:           req.Password,
+		PrivateKey:         req.PrivateKey,
+		Passphrase:         req.Passphrase,
+		SSHKeyID:           req.SSHKeyID,
+		HostGroupID:        req.HostGroupID,
+		ReconnectPolicy:    reconnectPolicy(req.ReconnectPolicy),
+		HostKey:            hostKey,
+		HostKeyFingerprint: hostKeyFingerprint,
+		Description:        req.Description,
+		Enabled:            req.Enabled == nil || *req.Enabled,
+	}, nil
+}
+
+// createHost writes a new Host and its jump hosts in tx.
+func createHost(tx *gorm.DB, host *models.Host, jumpHostIDs []uint) error {
+	enabled := host.Enabled
+	err := tx.Create(host).Error
+	if err != nil {
+		return err
+	}
+
+	// Create replaces a false enabled by the column default, so it is written again.
+	if !enabled {
+		host.Enabled = false
+		err = tx.Model(host).Select("enabled").Updates(host).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return saveJumpHosts(tx, host.ID, jumpHostIDs)
+}
+
+// applyHostUpdate lays the fields given in req, except enabled, over host and validates the result.
+// It reports whether the tunnels of the Host must be restarted. The errors are prefixed like the
+// responses of UpdateHost.
+func (h *Handler) applyHostUpdate(c echo.Context, host *models.Host, req *models.UpdateHostRequest) (bool, error) {
+	if req.HostGroupID != nil {
+		var hostGroupID *uint
+		if *req.HostGroupID != 0 {
+			hostGroupID = req.HostGroupID
+		}
+
+		err := h.checkHostGroup(c, hostGroupID)
+		if err != nil {
+			return false, fmt.Errorf("Validation failed: %w", err)
+		}
+		host.HostGroupID = hostGroupID
+	}
+
+	var sshKeyID *uint
+	if req.SSHKeyID != nil && *req.SSHKeyID != 0 {
+		sshKeyID = req.SSHKeyID
+	}
+
+	needTunnelRestart := (req.IP != "" && host.IP != req.IP) ||
+		(req.Port != nil && host.Port != *req.Port) ||
+		(req.User != "" && host.User != req.User) ||
+		(req.Password != "" && host.Password != req.Password) ||
+		(req.PrivateKey != "" && host.PrivateKey != req.PrivateKey) ||
+		(req.Passphrase != "" && host.Passphrase != req.Passphrase) ||
+		(req.SSHKeyID != nil && !sameSSHKeyID(host.SSHKeyID, sshKeyID)) ||
+		(req.ReconnectPolicy != nil && !reflect.DeepEqual(host.ReconnectPolicy, reconnectPolicy(req.ReconnectPolicy)))
+
+	if req.IP != "" {
+		host.IP = req.IP
+	}
+	if req.Port != nil {
+		host.Port = *req.Port
+	}
+	if req.User != "" {
+		host.User = req.User
+	}
+	if req.Password != "" {
+		host.Password = req.Password
+	}
+	if req.PrivateKey != "" {
+		host.PrivateKey = req.PrivateKey
+		host.Passphrase = req.Passphrase
+		host.SSHKeyID = nil
+	} else if req.Passphrase != "" {
+		host.Passphrase = req.Passphrase
+	}
+	if req.ReconnectPolicy != nil {
+		err := h.validateReconnectPolicy(req.ReconnectPolicy)
+		if err != nil {
+			return false, fmt.Errorf("Validation failed: %w", err)
+		}
+		host.ReconnectPolicy = reconnectPolicy(req.ReconnectPolicy)
+	}
+	if req.SSHKeyID != nil {
+		host.SSHKeyID = sshKeyID
+		if sshKeyID != nil {
+			host.PrivateKey = ""
+			host.Passphrase = ""
+		}
+	}
+	if host.Password == "" && host.PrivateKey == "" && host.SSHKeyID == nil {
+		return false, fmt.Errorf("Validation failed: Host requires a password, a private key or an SSH key")
+	}
+
+	err := h.validateHostAuth(host.PrivateKey, host.Passphrase, host.SSHKeyID)
+	if err != nil {
+		return false, fmt.Errorf("Invalid authentication: %w", err)
+	}
+	if host.Description != "" {
+		host.Description = req.Description
+	}
+
+	return needTunnelRestart, nil
+}
+
+// onlyEnabled reports whether req changes nothing but the enabled state of a Host.
+func onlyEnabled(req *models.UpdateHostRequest) bool {
+	return req.IP == "" && req.Port == nil && req.User == "" && req.Password == "" &&
+		req.PrivateKey == "" && req.Passphrase == "" && req.SSHKeyID == nil &&
+		req.HostGroupID == nil && req.ReconnectPolicy == nil && req.Description == ""
+}
+
+// reconnectPolicy returns nil for a policy that overrides nothing, so the Host uses the global policy.
+func reconnectPolicy(policy *models.ReconnectPolicy) *models.ReconnectPolicy {
+	if policy == nil || *policy == (models.ReconnectPolicy{}) {
+		return nil
+	}
+
+	return policy
+}
+
+// validateReconnectPolicy checks the delays of a Host's reconnect policy together with the global
+// policy it overrides.
+func (h *Handler) validateReconnectPolicy(policy *models.ReconnectPolicy) error {
+	return h.manager.HostBackoff(policy).Validate()
+}
+
+// serviceIP returns nil for dynamic service ports, which have no fixed destination.
+func serviceIP(ip string) *string {
+	if ip == "" {
+		return nil
+	}
+
+	return &ip
+}
+
+// prepareServicePortRequest validates the allowlist of a validated request and sets the default
+// bind address and direction.
+func prepareServicePortRequest(req *models.CreateServicePortRequest) error {
+	allowlist, err := tunnel.ParseAllowlist(req.Allowlist)
+	if err != nil {
+		return err
+	}
+
+	if req.Direction == "" {
+		req.Direction = tunnel.DirectionRemote
+	}
+	if req.BindAddress == "" {
+		req.BindAddress = tunnel.DefaultBindAddress(req.Direction)
+	}
+
+	if req.Direction == tunnel.DirectionDynamic {
+		return tunnel.CheckDynamicBind(req.BindAddress, allowlist)
+	}
+
+	return nil
+}
+
+// applyServicePortRequest sets the fields of sp to the ones of a prepared request.
+func applyServicePortRequest(sp *models.ServicePort, req *models.CreateServicePortRequest) {
+	sp.ServiceIP = serviceIP(req.ServiceIP)
+	sp.ServicePort = req.ServicePort
+	sp.LocalPort = req.LocalPort
+	sp.BindAddress = req.BindAddress
+	sp.Direction = req.Direction
+	sp.Allowlist = req.Allowlist
+	sp.ApplyToAllHosts = req.ApplyToAllHosts
+	sp.Description = req.Description
+}
+
 func (h *Handler) CreateServicePort(c echo.Context) error {
 	var req models.CreateServicePortRequest
 	err := c.Bind(&req)
@@ -414,15 +712,19 @@ func (h *Handler) CreateServicePort(c echo.Context) error {
 		})
 	}
 
+	err = prepareServicePortRequest(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
 	h.rwLock.Lock()
 	defer h.rwLock.Unlock()
 
-	sp := &models.ServicePort{
-		ServiceIP:   req.ServiceIP,
-		ServicePort: req.ServicePort,
-		LocalPort:   req.LocalPort,
-		Description: req.Description,
-	}
+	sp := &models.ServicePort{}
+	applyServicePortRequest(sp, &req)
 
 	tx := h.db.Begin()
 	err = tx.Error
@@ -443,8 +745,16 @@ func (h *Handler) CreateServicePort(c echo.Context) error {
 		})
 	}
 
-	var hosts []models.Host
-	err = h.db.Find(&hosts).Error
+	err = tx.Commit().Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to commit transaction: " + err.Error(),
+		})
+	}
+
+	// Tunnels are started after the commit, the manager writes their status outside the transaction.
+	hosts, err := h.manager.HostsForServicePort(sp)
 	if err != nil {
 		h.logger.Error("failed to fetch Hosts", zap.Error(err))
 		return c.JSON(http.StatusInternalServerError, models.Response{
@@ -456,29 +766,17 @@ func (h *Handler) CreateServicePort(c echo.Context) error {
 	for _, host := range hosts {
 		err = h.manager.StartTunnel(&host, sp)
 		if err != nil {
-			tx.Rollback()
 			h.logger.Error("failed to start new tunnel",
 				zap.Error(err),
 				zap.String("host_ip", host.IP),
 				zap.Int("service_port", sp.ServicePort))
-			return c.JSON(http.StatusInternalServerError, models.Response{
-				Success: false,
-				Error:   fmt.Sprintf("Failed to start new tunnel: %v", err),
-			})
 		}
 	}
 
-	err = tx.Commit().Error
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Success: false,
-			Error:   "Failed to commit transaction: " + err.Error(),
-		})
-	}
-
 	return c.JSON(http.StatusCreated, models.Response{
-		Success: true,
-		Data:    sp,
+		Success:  true,
+		Data:     sp,
+		Warnings: tunnel.BindAddressWarnings(sp.Direction, sp.BindAddress),
 	})
 }
 
@@ -566,17 +864,15 @@ func (h *Handler) UpdateServicePort(c echo.Context) error {
 		})
 	}
 
-	tx := h.db.Begin()
-	err = tx.Error
+	err = prepareServicePortRequest(&req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
+		return c.JSON(http.StatusBadRequest, models.Response{
 			Success: false,
-			Error:   "Failed to start transaction: " + err.Error(),
+			Error:   "Validation failed: " + err.Error(),
 		})
 	}
 
-	var hosts []models.Host
-	err = h.db.Find(&hosts).Error
+	hosts, err := h.manager.HostsForServicePort(&sp)
 	if err != nil {
 		h.logger.Error("failed to fetch Hosts", zap.Error(err))
 		return c.JSON(http.StatusInternalServerError, models.Response{
@@ -595,10 +891,16 @@ func (h *Handler) UpdateServicePort(c echo.Context) error {
 		}
 	}
 
-	sp.ServiceIP = req.ServiceIP
-	sp.ServicePort = req.ServicePort
-	sp.LocalPort = req.LocalPort
-	sp.Description = req.Description
+	tx := h.db.Begin()
+	err = tx.Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to start transaction: " + err.Error(),
+		})
+	}
+
+	applyServicePortRequest(&sp, &req)
 
 	err = tx.Save(&sp).Error
 	if err != nil {
@@ -618,6 +920,15 @@ func (h *Handler) UpdateServicePort(c echo.Context) error {
 		})
 	}
 
+	hosts, err = h.manager.HostsForServicePort(&sp)
+	if err != nil {
+		h.logger.Error("failed to fetch Hosts", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch Hosts: " + err.Error(),
+		})
+	}
+
 	for _, host := range hosts {
 		err = h.manager.StartTunnel(&host, &sp)
 		if err != nil {
@@ -629,8 +940,9 @@ func (h *Handler) UpdateServicePort(c echo.Context) error {
 	}
 
 	return c.JSON(http.StatusOK, models.Response{
-		Success: true,
-		Data:    sp,
+		Success:  true,
+		Data:     sp,
+		Warnings: tunnel.BindAddressWarnings(sp.Direction, sp.BindAddress),
 	})
 }
 
@@ -655,17 +967,7 @@ func (h *Handler) DeleteServicePort(c echo.Context) error {
 		})
 	}
 
-	tx := h.db.Begin()
-	err = tx.Error
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.Response{
-			Success: false,
-			Error:   "Failed to start transaction: " + err.Error(),
-		})
-	}
-
-	var hosts []models.Host
-	err = h.db.Find(&hosts).Error
+	hosts, err := h.manager.HostsForServicePort(&sp)
 	if err != nil {
 		h.logger.Error("failed to fetch Hosts", zap.Error(err))
 		return c.JSON(http.StatusInternalServerError, models.Response{
@@ -684,6 +986,60 @@ func (h *Handler) DeleteServicePort(c echo.Context) error {
 		}
 	}
 
+	tx := h.db.Begin()
+	err = tx.Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to start transaction: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("sp_id = ?", sp.ID).Delete(&models.HostServicePort{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete service port's Host assignments: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("sp_id = ?", sp.ID).Delete(&models.TunnelTraffic{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete service port's tunnel traffic: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("sp_id = ?", sp.ID).Delete(&models.TunnelEvent{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete service port's tunnel events: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("sp_id = ?", sp.ID).Delete(&models.StoppedTunnel{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete service port's stopped tunnels: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("sp_id = ?", sp.ID).Delete(&models.Schedule{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete service port's schedules: " + err.Error(),
+		})
+	}
+
 	err = tx.Delete(&sp).Error
 	if err != nil {
 		tx.Rollback()
@@ -700,6 +1056,7 @@ func (h *Handler) DeleteServicePort(c echo.Context) error {
 			Error:   "Failed to commit transaction: " + err.Error(),
 		})
 	}
+	h.manager.ReloadSchedules()
 
 	return c.JSON(http.StatusOK, models.Response{
 		Success: true,
@@ -719,6 +1076,23 @@ func (h *Handler) GetStatus(c echo.Context) error {
 		})
 	}
 
+	visible, err := h.visibleHostIDs(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch tunnel status: " + err.Error(),
+		})
+	}
+	if visible != nil {
+		filtered := make([]models.Tunnel, 0, len(*tunnels))
+		for _, t := range *tunnels {
+			if visible[t.HostID] {
+				filtered = append(filtered, t)
+			}
+		}
+		tunnels = &filtered
+	}
+
 	var connectedTunnels int
 	for _, t := range *tunnels {
 		if t.Status == "connected" {
@@ -749,7 +1123,7 @@ func (h *Handler) GetHostStatus(c echo.Context) error {
 	defer h.rwLock.RUnlock()
 
 	var host models.Host
-	err = h.db.First(&host, hostID).Error
+	err = h.hostQuery(c).First(&host, hostID).Error
 	if err != nil {
 		return c.JSON(http.StatusNotFound, models.Response{
 			Success: false,
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+)
+
+func TestPrepareServicePortRequest(t *testing.T) {
+	tests := []struct {
+		name            string
+		req             models.CreateServicePortRequest
+		wantDirection   string
+		wantBindAddress string
+		wantErr         bool
+	}{
+		{name: "defaults", wantDirection: tunnel.DirectionRemote, wantBindAddress: "0.0.0.0"},
+		{name: "remote", req: models.CreateServicePortRequest{Direction: tunnel.DirectionRemote},
+			wantDirection: tunnel.DirectionRemote, wantBindAddress: "0.0.0.0"},
+		{name: "local", req: models.CreateServicePortRequest{Direction: tunnel.DirectionLocal},
+			wantDirection: tunnel.DirectionLocal, wantBindAddress: "127.0.0.1"},
+		{name: "dynamic", req: models.CreateServicePortRequest{Direction: tunnel.DirectionDynamic},
+			wantDirection: tunnel.DirectionDynamic, wantBindAddress: "127.0.0.1"},
+		{name: "given bind address", req: models.CreateServicePortRequest{Direction: tunnel.DirectionLocal, BindAddress: "10.0.0.5"},
+			wantDirection: tunnel.DirectionLocal, wantBindAddress: "10.0.0.5"},
+		{name: "exposed dynamic without allowlist", req: models.CreateServicePortRequest{Direction: tunnel.DirectionDynamic, BindAddress: "0.0.0.0"},
+			wantErr: true},
+		{name: "exposed dynamic with allowlist", req: models.CreateServicePortRequest{Direction: tunnel.DirectionDynamic, BindAddress: "0.0.0.0", Allowlist: []string{"10.0.0.0/8"}},
+			wantDirection: tunnel.DirectionDynamic, wantBindAddress: "0.0.0.0"},
+		{name: "exposed dynamic to every destination", req: models.CreateServicePortRequest{Direction: tunnel.DirectionDynamic, BindAddress: "0.0.0.0", Allowlist: []string{"*"}},
+			wantDirection: tunnel.DirectionDynamic, wantBindAddress: "0.0.0.0"},
+		{name: "invalid allowlist", req: models.CreateServicePortRequest{Direction: tunnel.DirectionDynamic, Allowlist: []string{"bad entry"}},
+			wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := prepareServicePortRequest(&req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("prepareServicePortRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (req.Direction != tt.wantDirection || req.BindAddress != tt.wantBindAddress) {
+				t.Errorf("prepareServicePortRequest() = %s on %s, want %s on %s",
+					req.Direction, req.BindAddress, tt.wantDirection, tt.wantBindAddress)
+			}
+		})
+	}
+}
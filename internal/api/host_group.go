@@ -0,0 +1,254 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// hostGroupQuery returns a query on host groups limited to the host groups of the request's token.
+func (h *Handler) hostGroupQuery(c echo.Context) *gorm.DB {
+	token := auth.FromContext(c)
+	if !auth.Scoped(token) {
+		return h.db
+	}
+
+	return h.db.Where("id IN ?", token.HostGroupIDs)
+}
+
+func (h *Handler) CreateHostGroup(c echo.Context) error {
+	var req models.CreateHostGroupRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	group := &models.HostGroup{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+
+	err = h.db.Create(group).Error
+	if err != nil {
+		h.logger.Error("failed to create host group", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create host group: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Data:    group,
+	})
+}
+
+func (h *Handler) ListHostGroups(c echo.Context) error {
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var groups []models.HostGroup
+	err := h.hostGroupQuery(c).Find(&groups).Error
+	if err != nil {
+		h.logger.Error("failed to fetch host groups", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch host groups: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    groups,
+	})
+}
+
+func (h *Handler) GetHostGroup(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid host group ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var group models.HostGroup
+	err = h.hostGroupQuery(c).First(&group, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Host group not found: " + err.Error(),
+		})
+	}
+
+	var hosts []models.Host
+	err = h.db.Where("host_group_id = ?", group.ID).Find(&hosts).Error
+	if err != nil {
+		h.logger.Error("failed to fetch Hosts", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch Hosts: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"host_group": group,
+			"hosts":      hosts,
+		},
+	})
+}
+
+func (h *Handler) UpdateHostGroup(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid host group ID: " + err.Error(),
+		})
+	}
+
+	var req models.CreateHostGroupRequest
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var group models.HostGroup
+	err = h.db.First(&group, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Host group not found: " + err.Error(),
+		})
+	}
+
+	group.Name = req.Name
+	group.Description = req.Description
+
+	err = h.db.Save(&group).Error
+	if err != nil {
+		h.logger.Error("failed to update host group", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update host group: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    group,
+	})
+}
+
+func (h *Handler) DeleteHostGroup(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid host group ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var group models.HostGroup
+	err = h.db.First(&group, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Success: false,
+				Error:   "Host group not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch host group: " + err.Error(),
+		})
+	}
+
+	var count int64
+	err = h.db.Model(&models.Host{}).Where("host_group_id = ?", group.ID).Count(&count).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to check host group usage: " + err.Error(),
+		})
+	}
+	if count > 0 {
+		return c.JSON(http.StatusConflict, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("Host group has %d Host(s)", count),
+		})
+	}
+
+	var tokens []models.APIToken
+	err = h.db.Find(&tokens).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to check host group usage: " + err.Error(),
+		})
+	}
+	for _, token := range tokens {
+		if auth.Scoped(&token) && auth.InScope(&token, &group.ID) {
+			return c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   fmt.Sprintf("Host group is used by API token %d", token.ID),
+			})
+		}
+	}
+
+	err = h.db.Delete(&group).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete host group: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    "Host group deleted successfully",
+	})
+}
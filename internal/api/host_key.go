@@ -0,0 +1,243 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func (h *Handler) restartHostTunnels(host *models.Host) error {
+	// The host connection is only rebuilt once every tunnel of the host has been stopped.
+	h.manager.StopHostTunnels(host.ID)
+
+	if !host.Enabled {
+		return nil
+	}
+
+	sps, err := h.manager.ServicePortsForHost(host.ID)
+	if err != nil {
+		h.logger.Error("failed to fetch service ports", zap.Error(err))
+		return err
+	}
+
+	for _, sp := range sps {
+		err = h.manager.StartTunnel(host, &sp)
+		if err != nil {
+			h.logger.Error("failed to restart tunnel",
+				zap.Error(err),
+				zap.String("host_ip", host.IP),
+				zap.Int("service_port", sp.ServicePort))
+		}
+	}
+
+	return nil
+}
+
+func hostKeyData(host *models.Host) map[string]interface{} {
+	return map[string]interface{}{
+		"host_id":     host.ID,
+		"pinned":      host.HostKeyFingerprint != "",
+		"host_key":    host.HostKey,
+		"fingerprint": host.HostKeyFingerprint,
+	}
+}
+
+func (h *Handler) GetHostKey(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid Host ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var host models.Host
+	err = h.hostQuery(c).First(&host, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Host not found: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    hostKeyData(&host),
+	})
+}
+
+func (h *Handler) PinHostKey(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid Host ID: " + err.Error(),
+		})
+	}
+
+	var req models.PinHostKeyRequest
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	hostKey, fingerprint, err := tunnel.ParseHostKey(req.HostKey)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var host models.Host
+	err = h.hostQuery(c).First(&host, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Host not found: " + err.Error(),
+		})
+	}
+
+	host.HostKey = hostKey
+	host.HostKeyFingerprint = fingerprint
+
+	err = h.db.Save(&host).Error
+	if err != nil {
+		h.logger.Error("failed to pin host key", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to pin host key: " + err.Error(),
+		})
+	}
+
+	err = h.restartHostTunnels(&host)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to restart tunnels: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    hostKeyData(&host),
+	})
+}
+
+func (h *Handler) ResetHostKey(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid Host ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var host models.Host
+	err = h.hostQuery(c).First(&host, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Host not found: " + err.Error(),
+		})
+	}
+
+	host.HostKey = ""
+	host.HostKeyFingerprint = ""
+
+	err = h.db.Save(&host).Error
+	if err != nil {
+		h.logger.Error("failed to reset host key", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to reset host key: " + err.Error(),
+		})
+	}
+
+	err = h.restartHostTunnels(&host)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to restart tunnels: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    "Host key reset successfully",
+	})
+}
+
+func (h *Handler) ImportKnownHosts(c echo.Context) error {
+	var req models.ImportKnownHostsRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	result, err := tunnel.ImportKnownHosts(h.db, []byte(req.KnownHosts), req.Overwrite)
+	if err != nil {
+		h.logger.Error("failed to import known_hosts", zap.Error(err))
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Failed to import known_hosts: " + err.Error(),
+		})
+	}
+
+	for _, hostID := range result.Imported {
+		var host models.Host
+		err = h.db.First(&host, hostID).Error
+		if err != nil {
+			h.logger.Warn("failed to fetch Host", zap.Uint("host_id", hostID), zap.Error(err))
+			continue
+		}
+
+		err = h.restartHostTunnels(&host)
+		if err != nil {
+			h.logger.Warn("failed to restart tunnels", zap.Uint("host_id", hostID), zap.Error(err))
+		}
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    result,
+	})
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestReconnectPolicyValidation(t *testing.T) {
+	h, e := newTestHandler(t)
+
+	// The global policy waits 5 seconds before the first retry, up to 300 seconds.
+	manager, err := tunnel.NewManager(h.db, zap.NewNop(), 1, true, tunnel.BackoffPolicy{
+		InitialDelay: 5 * time.Second,
+		Multiplier:   2,
+		MaxDelay:     300 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	h.manager = manager
+
+	host := models.Host{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret"}
+	mustCreate(t, h.db, &host)
+
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{name: "no policy", policy: `{}`},
+		{name: "both delays", policy: `{"initial_delay_sec": 10, "max_delay_sec": 60}`},
+		{name: "equal delays", policy: `{"initial_delay_sec": 10, "max_delay_sec": 10}`},
+		{name: "max delay below initial delay", policy: `{"initial_delay_sec": 60, "max_delay_sec": 10}`, wantErr: true},
+		{name: "max delay below global initial delay", policy: `{"max_delay_sec": 3}`, wantErr: true},
+		{name: "initial delay above global max delay", policy: `{"initial_delay_sec": 600}`, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantStatus := func(status int) int {
+				if tt.wantErr {
+					return http.StatusBadRequest
+				}
+				return status
+			}
+
+			body := fmt.Sprintf(`{"ip": "192.168.1.%d", "port": 22, "user": "root", "password": "secret", "reconnect_policy": %s}`,
+				i+1, tt.policy)
+			req := httptest.NewRequest(http.MethodPost, "/api/host", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			err := h.CreateHost(e.NewContext(req, rec))
+			if err != nil {
+				t.Fatalf("CreateHost() error = %v", err)
+			}
+			if rec.Code != wantStatus(http.StatusCreated) {
+				t.Fatalf("CreateHost() = %d, want %d: %s", rec.Code, wantStatus(http.StatusCreated), rec.Body.String())
+			}
+
+			req = httptest.NewRequest(http.MethodPut, "/api/host/1", strings.NewReader(`{"reconnect_policy": `+tt.policy+`}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec = httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			c.Set(auth.ContextKey, &models.APIToken{Role: auth.RoleAdmin})
+
+			err = h.UpdateHost(c)
+			if err != nil {
+				t.Fatalf("UpdateHost() error = %v", err)
+			}
+			if rec.Code != wantStatus(http.StatusOK) {
+				t.Fatalf("UpdateHost() = %d, want %d: %s", rec.Code, wantStatus(http.StatusOK), rec.Body.String())
+			}
+			if tt.wantErr && !strings.Contains(rec.Body.String(), "max_delay_sec") {
+				t.Errorf("UpdateHost() error = %s, want it to name max_delay_sec", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestUpdateHostDescription(t *testing.T) {
+	h, e := newTestHandler(t)
+
+	tests := []struct {
+		name            string
+		stored          string
+		body            string
+		wantDescription string
+	}{
+		{name: "replaced", stored: "web-1", body: `{"description": "web-2"}`, wantDescription: "web-2"},
+		{name: "omitted", stored: "web-1", body: `{"port": 2222}`, wantDescription: ""},
+		{name: "no stored description", stored: "", body: `{"description": "web-1"}`, wantDescription: ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := models.Host{IP: fmt.Sprintf("192.168.0.%d", i+1), Port: 22, User: "root", Password: "secret", Description: tt.stored}
+			mustCreate(t, h.db, &host)
+
+			req := httptest.NewRequest(http.MethodPut, "/api/host/:id", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(fmt.Sprint(host.ID))
+			c.Set(auth.ContextKey, &models.APIToken{Role: auth.RoleAdmin})
+
+			err := h.UpdateHost(c)
+			if err != nil {
+				t.Fatalf("UpdateHost() error = %v", err)
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("UpdateHost() = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+			}
+
+			var got models.Host
+			err = h.db.First(&got, host.ID).Error
+			if err != nil {
+				t.Fatalf("failed to fetch Host: %v", err)
+			}
+			if got.Description != tt.wantDescription {
+				t.Errorf("description = %q, want %q", got.Description, tt.wantDescription)
+			}
+		})
+	}
+}
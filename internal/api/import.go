@@ -0,0 +1,714 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jollaman999/tunnel-manager/internal/inventory"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/secret"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// importer applies the rows of an import in one transaction. It tracks the Hosts and service
+// ports by their key, including the ones written by earlier rows, and the tunnels to restart
+// once the transaction is committed.
+type importer struct {
+	h      *Handler
+	c      echo.Context
+	upsert bool
+	cipher *secret.PassphraseCipher
+
+	hosts        map[string]*models.Host
+	sps          map[string]*models.ServicePort
+	jumpHostIDs  map[uint][]uint
+	assigned     map[uint][]uint
+	sshKeyIDs    map[string]uint
+	hostGroupIDs map[string]uint
+
+	hostRows map[string]int
+	spRows   map[string]int
+
+	restartHosts      map[uint]*models.Host
+	restartDependents map[uint]bool
+	restartSPs        map[uint]*models.ServicePort
+}
+
+func newImporter(h *Handler, c echo.Context, state *inventoryState, upsert bool, cipher *secret.PassphraseCipher) *importer {
+	im := &importer{
+		h:                 h,
+		c:                 c,
+		upsert:            upsert,
+		cipher:            cipher,
+		hosts:             make(map[string]*models.Host),
+		sps:               make(map[string]*models.ServicePort),
+		jumpHostIDs:       make(map[uint][]uint),
+		assigned:          make(map[uint][]uint),
+		sshKeyIDs:         make(map[string]uint),
+		hostGroupIDs:      make(map[string]uint),
+		hostRows:          make(map[string]int),
+		spRows:            make(map[string]int),
+		restartHosts:      make(map[uint]*models.Host),
+		restartDependents: make(map[uint]bool),
+		restartSPs:        make(map[uint]*models.ServicePort),
+	}
+
+	for i := range state.hosts {
+		im.hosts[state.hosts[i].IP] = &state.hosts[i]
+	}
+	for i := range state.sps {
+		sp := &state.sps[i]
+		key := inventory.ServicePortKey(sp.Direction, sp.ServiceIP, sp.ServicePort, sp.BindAddress, sp.LocalPort)
+		if _, ok := im.sps[key]; !ok {
+			im.sps[key] = sp
+		}
+	}
+	for _, jump := range state.jumps {
+		im.jumpHostIDs[jump.HostID] = append(im.jumpHostIDs[jump.HostID], jump.JumpHostID)
+	}
+	for _, assignment := range state.assignments {
+		im.assigned[assignment.SPID] = append(im.assigned[assignment.SPID], assignment.HostID)
+	}
+	for _, key := range state.sshKeys {
+		im.sshKeyIDs[key.Name] = key.ID
+	}
+	for _, group := range state.hostGroups {
+		im.hostGroupIDs[group.Name] = group.ID
+	}
+
+	return im
+}
+
+// decrypt replaces the secrets encrypted by an export with their plain text.
+func (im *importer) decrypt(fields map[string]*string) error {
+	for name, field := range fields {
+		if !secret.IsPassphraseEncrypted(*field) {
+			continue
+		}
+		if im.cipher == nil {
+			return fmt.Errorf("%s is encrypted, the passphrase is required in the %s header", name, PassphraseHeader)
+		}
+
+		plaintext, err := im.cipher.Decrypt(*field)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*field = plaintext
+	}
+
+	return nil
+}
+
+// hostIDs resolves the IPs of Hosts that are stored or written by earlier rows.
+func (im *importer) hostIDs(ips []string, what string) ([]uint, error) {
+	ids := make([]uint, 0, len(ips))
+	for _, ip := range ips {
+		host, ok := im.hosts[ip]
+		if !ok {
+			return nil, fmt.Errorf("%s %s not found", what, ip)
+		}
+		if slices.Contains(ids, host.ID) {
+			return nil, fmt.Errorf("duplicated %s %s", what, ip)
+		}
+		ids = append(ids, host.ID)
+	}
+
+	return ids, nil
+}
+
+// host creates the Host of a row, or with upsert updates the stored one with the fields given in
+// the row, and returns the action taken.
+func (im *importer) host(tx *gorm.DB, row *inventory.HostRow) (string, error) {
+	want := &row.Host
+	err := im.decrypt(map[string]*string{
+		"password":    &want.Password,
+		"private_key": &want.PrivateKey,
+		"passphrase":  &want.Passphrase,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var sshKeyID, hostGroupID uint
+	if want.SSHKey != "" {
+		id, ok := im.sshKeyIDs[want.SSHKey]
+		if !ok {
+			return "", fmt.Errorf("SSH key %q not found", want.SSHKey)
+		}
+		sshKeyID = id
+	}
+	if want.HostGroup != "" {
+		id, ok := im.hostGroupIDs[want.HostGroup]
+		if !ok {
+			return "", fmt.Errorf("host group %q not found", want.HostGroup)
+		}
+		hostGroupID = id
+	}
+
+	if slices.Contains(want.JumpHosts, want.IP) {
+		return "", fmt.Errorf("Host cannot be its own jump host")
+	}
+	jumpHostIDs, err := im.hostIDs(want.JumpHosts, "jump host")
+	if err != nil {
+		return "", err
+	}
+
+	host, exists := im.hosts[want.IP]
+	if exists && !im.upsert {
+		return "", fmt.Errorf("Host %s already exists", want.IP)
+	}
+
+	if !exists {
+		req := models.CreateHostRequest{
+			IP:              want.IP,
+			Port:            want.Port,
+			User:            want.User,
+			Password:        want.Password,
+			PrivateKey:      want.PrivateKey,
+			Passphrase:      want.Passphrase,
+			SSHKeyID:        idPointer(sshKeyID),
+			HostGroupID:     idPointer(hostGroupID),
+			ReconnectPolicy: want.ReconnectPolicy,
+			HostKey:         want.HostKey,
+			JumpHostIDs:     jumpHostIDs,
+			Description:     want.Description,
+			Enabled:         want.Enabled,
+		}
+		if req.Port == 0 {
+			req.Port = 22
+		}
+
+		err = im.c.Validate(&req)
+		if err == nil {
+			err = im.h.checkHostGroup(im.c, req.HostGroupID)
+		}
+		if err != nil {
+			return "", fmt.Errorf("Validation failed: %w", err)
+		}
+
+		created, err := im.h.newHost(&req)
+		if err != nil {
+			return "", err
+		}
+
+		err = createHost(tx, created, jumpHostIDs)
+		if err != nil {
+			return "", fmt.Errorf("Failed to create Host: %w", err)
+		}
+
+		im.hosts[created.IP] = created
+		im.jumpHostIDs[created.ID] = jumpHostIDs
+		im.restartHosts[created.ID] = created
+		return inventory.ActionCreate, nil
+	}
+
+	// Only the fields given in the row are changed, with the rules of UpdateHost for empty values,
+	// so that a partial file or an export without secrets keeps the other stored values.
+	given := row.Fields
+	var req models.UpdateHostRequest
+	if given["port"] && want.Port != 0 {
+		req.Port = &want.Port
+	}
+	req.User = want.User
+	req.Password = want.Password
+	req.PrivateKey = want.PrivateKey
+	req.Passphrase = want.Passphrase
+	if want.SSHKey != "" {
+		req.SSHKeyID = &sshKeyID
+	}
+	if given["host_group"] {
+		req.HostGroupID = &hostGroupID
+	}
+	if given["reconnect_policy"] {
+		req.ReconnectPolicy = want.ReconnectPolicy
+		if req.ReconnectPolicy == nil {
+			req.ReconnectPolicy = &models.ReconnectPolicy{}
+		}
+	}
+	// UpdateHost replaces a stored description with the requested one and cannot set one on a
+	// Host without it, so the description is kept here and set from the row below.
+	req.Description = host.Description
+
+	err = im.c.Validate(&req)
+	if err != nil {
+		return "", fmt.Errorf("Validation failed: %w", err)
+	}
+
+	updated := *host
+	restart, err := im.h.applyHostUpdate(im.c, &updated, &req)
+	if err != nil {
+		return "", err
+	}
+	if want.Description != "" {
+		updated.Description = want.Description
+	}
+
+	if want.HostKey != "" {
+		updated.HostKey, updated.HostKeyFingerprint, err = tunnel.ParseHostKey(want.HostKey)
+		if err != nil {
+			return "", fmt.Errorf("Validation failed: %w", err)
+		}
+	}
+	if want.Enabled != nil {
+		updated.Enabled = *want.Enabled
+	}
+
+	jumpHostsChanged := given["jump_hosts"] && !slices.Equal(im.jumpHostIDs[host.ID], jumpHostIDs)
+	if !jumpHostsChanged && reflect.DeepEqual(updated, *host) {
+		return inventory.ActionUnchanged, nil
+	}
+
+	err = tx.Save(&updated).Error
+	if err != nil {
+		return "", fmt.Errorf("Failed to update Host: %w", err)
+	}
+
+	if jumpHostsChanged {
+		err = saveJumpHosts(tx, host.ID, jumpHostIDs)
+		if err != nil {
+			return "", err
+		}
+		im.jumpHostIDs[host.ID] = jumpHostIDs
+	}
+
+	restart = restart || jumpHostsChanged || updated.Enabled != host.Enabled || updated.HostKey != host.HostKey
+	*host = updated
+	if restart {
+		im.restartHosts[host.ID] = host
+		im.restartDependents[host.ID] = true
+	}
+	return inventory.ActionUpdate, nil
+}
+
+// servicePortKey returns the key of the service port of a row, with the default direction and bind address.
+func servicePortKey(want *models.InventoryServicePort) string {
+	direction, bindAddress := want.Direction, want.BindAddress
+	if direction == "" {
+		direction = tunnel.DirectionRemote
+	}
+	if bindAddress == "" {
+		bindAddress = tunnel.DefaultBindAddress(direction)
+	}
+
+	return inventory.ServicePortKey(direction, serviceIP(want.ServiceIP), want.ServicePort, bindAddress, want.LocalPort)
+}
+
+// servicePortRequest returns the request that sets every field of sp.
+func servicePortRequest(sp *models.ServicePort) models.CreateServicePortRequest {
+	req := models.CreateServicePortRequest{
+		ServicePort:     sp.ServicePort,
+		LocalPort:       sp.LocalPort,
+		BindAddress:     sp.BindAddress,
+		Direction:       sp.Direction,
+		Allowlist:       sp.Allowlist,
+		ApplyToAllHosts: sp.ApplyToAllHosts,
+		Description:     sp.Description,
+	}
+	if sp.ServiceIP != nil {
+		req.ServiceIP = *sp.ServiceIP
+	}
+
+	return req
+}
+
+// servicePort creates the service port of a row with the given key, or with upsert updates the stored
+// one with the fields given in the row, and returns the action taken. The Hosts of the row replace
+// its assignments, which are kept when the row does not give them.
+func (im *importer) servicePort(tx *gorm.DB, row *inventory.ServicePortRow, key string) (string, error) {
+	want, given := &row.ServicePort, row.Fields
+	sp, exists := im.sps[key]
+	if exists && !im.upsert {
+		return "", fmt.Errorf("service port %s already exists", key)
+	}
+
+	req := models.CreateServicePortRequest{
+		ServiceIP:       want.ServiceIP,
+		ServicePort:     want.ServicePort,
+		LocalPort:       want.LocalPort,
+		BindAddress:     want.BindAddress,
+		Direction:       want.Direction,
+		Allowlist:       want.Allowlist,
+		ApplyToAllHosts: want.ApplyToAllHosts,
+		Description:     want.Description,
+	}
+	if exists {
+		// Fields missing from the row keep the stored values, the given ones replace them as with
+		// UpdateServicePort.
+		stored := servicePortRequest(sp)
+		if !given["service_ip"] {
+			req.ServiceIP = stored.ServiceIP
+		}
+		if !given["service_port"] {
+			req.ServicePort = stored.ServicePort
+		}
+		if !given["local_port"] {
+			req.LocalPort = stored.LocalPort
+		}
+		if !given["bind_address"] {
+			req.BindAddress = stored.BindAddress
+		}
+		if !given["direction"] {
+			req.Direction = stored.Direction
+		}
+		if !given["allowlist"] {
+			req.Allowlist = stored.Allowlist
+		}
+		if !given["apply_to_all_hosts"] {
+			req.ApplyToAllHosts = stored.ApplyToAllHosts
+		}
+		if !given["description"] {
+			req.Description = stored.Description
+		}
+	}
+
+	err := im.c.Validate(&req)
+	if err == nil {
+		err = prepareServicePortRequest(&req)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Validation failed: %w", err)
+	}
+
+	hostIDs, err := im.hostIDs(want.Hosts, "Host")
+	if err != nil {
+		return "", err
+	}
+	assign := !exists || given["hosts"]
+
+	var updated models.ServicePort
+	if exists {
+		updated = *sp
+	}
+	applyServicePortRequest(&updated, &req)
+
+	action := inventory.ActionCreate
+	if exists {
+		assignmentsChanged := false
+		if assign {
+			assignmentsChanged = len(im.assigned[sp.ID]) != len(hostIDs)
+			for _, id := range hostIDs {
+				if !slices.Contains(im.assigned[sp.ID], id) {
+					assignmentsChanged = true
+				}
+			}
+		}
+		if !assignmentsChanged && reflect.DeepEqual(updated, *sp) {
+			return inventory.ActionUnchanged, nil
+		}
+
+		err = tx.Save(&updated).Error
+		if err != nil {
+			return "", fmt.Errorf("Failed to update service port: %w", err)
+		}
+
+		if assign {
+			err = tx.Where("sp_id = ?", sp.ID).Delete(&models.HostServicePort{}).Error
+			if err != nil {
+				return "", fmt.Errorf("Failed to delete service port assignments: %w", err)
+			}
+		}
+		action = inventory.ActionUpdate
+	} else {
+		sp = &models.ServicePort{}
+		err = tx.Create(&updated).Error
+		if err != nil {
+			return "", fmt.Errorf("Failed to create service port: %w", err)
+		}
+	}
+
+	if assign {
+		for _, hostID := range hostIDs {
+			err = tx.Create(&models.HostServicePort{HostID: hostID, SPID: updated.ID}).Error
+			if err != nil {
+				return "", fmt.Errorf("Failed to create service port assignment: %w", err)
+			}
+		}
+		im.assigned[updated.ID] = hostIDs
+	}
+
+	*sp = updated
+	im.sps[key] = sp
+	im.restartSPs[sp.ID] = sp
+	return action, nil
+}
+
+// run imports the Host rows, ordered so that jump hosts come first, and then the service port rows.
+// Every row is written in a savepoint of tx, so a failed row leaves nothing behind.
+func (im *importer) run(tx *gorm.DB, hostRows []inventory.HostRow, spRows []inventory.ServicePortRow) *models.ImportResult {
+	result := &models.ImportResult{
+		Rows: make([]models.ImportRow, 0, len(hostRows)+len(spRows)),
+	}
+	report := func(row models.ImportRow, err error) {
+		switch {
+		case err != nil:
+			row.Action = ""
+			row.Error = err.Error()
+			result.Failed++
+		case row.Action == inventory.ActionCreate:
+			result.Created++
+		case row.Action == inventory.ActionUpdate:
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	hostResults := make([]error, len(hostRows))
+	hostActions := make([]string, len(hostRows))
+	var pending []*inventory.HostRow
+	for i := range hostRows {
+		row := &hostRows[i]
+		switch {
+		case row.Err != nil:
+			hostResults[i] = fmt.Errorf("Invalid row: %w", row.Err)
+		case row.Host.IP != "" && im.hostRows[row.Host.IP] != 0:
+			hostResults[i] = fmt.Errorf("Host %s is already in row %d", row.Host.IP, im.hostRows[row.Host.IP])
+		default:
+			im.hostRows[row.Host.IP] = row.Row
+			pending = append(pending, row)
+		}
+	}
+
+	ordered, _ := orderByJumpHosts(pending,
+		func(row *inventory.HostRow) string { return row.Host.IP },
+		func(row *inventory.HostRow) []string { return row.Host.JumpHosts })
+	for _, row := range pending {
+		if !slices.Contains(ordered, row) {
+			hostResults[row.Row-1] = errors.New("jump hosts form a cycle")
+		}
+	}
+
+	for _, row := range ordered {
+		var action string
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			var err error
+			action, err = im.host(tx, row)
+			return err
+		})
+		hostResults[row.Row-1] = err
+		hostActions[row.Row-1] = action
+	}
+
+	for i, row := range hostRows {
+		report(models.ImportRow{
+			Kind:   inventory.KindHost,
+			Row:    row.Row,
+			Key:    row.Host.IP,
+			Action: hostActions[i],
+		}, hostResults[i])
+	}
+
+	for i := range spRows {
+		row := &spRows[i]
+		if row.Err != nil {
+			report(models.ImportRow{Kind: inventory.KindServicePort, Row: row.Row}, fmt.Errorf("Invalid row: %w", row.Err))
+			continue
+		}
+
+		key := servicePortKey(&row.ServicePort)
+		if im.spRows[key] != 0 {
+			report(models.ImportRow{Kind: inventory.KindServicePort, Row: row.Row, Key: key},
+				fmt.Errorf("service port %s is already in row %d", key, im.spRows[key]))
+			continue
+		}
+
+		var action string
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			var err error
+			action, err = im.servicePort(tx, row, key)
+			return err
+		})
+		// Only an imported row keeps a later row with the same service port from being imported.
+		if err == nil {
+			im.spRows[key] = row.Row
+		}
+		report(models.ImportRow{
+			Kind:   inventory.KindServicePort,
+			Row:    row.Row,
+			Key:    key,
+			Action: action,
+		}, err)
+	}
+
+	return result
+}
+
+// restartTunnels restarts the tunnels of the imported Hosts and service ports once they are committed.
+func (im *importer) restartTunnels() {
+	for id := range im.restartSPs {
+		im.h.manager.StopServicePortTunnels(id)
+	}
+
+	for id, host := range im.restartHosts {
+		err := im.h.restartHostTunnels(host)
+		if err != nil {
+			im.h.logger.Warn("failed to restart tunnels", zap.Uint("host_id", id), zap.Error(err))
+		}
+	}
+
+	for id := range im.restartDependents {
+		im.h.restartJumpDependents(id)
+	}
+
+	for _, sp := range im.restartSPs {
+		hosts, err := im.h.manager.HostsForServicePort(sp)
+		if err != nil {
+			im.h.logger.Error("failed to fetch Hosts", zap.Error(err))
+			continue
+		}
+
+		for _, host := range hosts {
+			if !host.Enabled || im.restartHosts[host.ID] != nil {
+				continue
+			}
+
+			err = im.h.manager.StartTunnel(&host, sp)
+			if err != nil {
+				im.h.logger.Error("failed to start tunnel",
+					zap.Error(err),
+					zap.String("host_ip", host.IP),
+					zap.Int("service_port", sp.ServicePort))
+			}
+		}
+	}
+}
+
+func parseBoolParam(c echo.Context, name string) (bool, error) {
+	param := c.QueryParam(name)
+	if param == "" {
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %s", name, param)
+	}
+
+	return value, nil
+}
+
+// readImport reads the rows of the request body, a CSV file of the kind given by the kind query
+// parameter when the content type is text/csv and a JSON or YAML inventory otherwise.
+func readImport(c echo.Context) ([]inventory.HostRow, []inventory.ServicePortRow, error) {
+	data, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), "text/csv") {
+		return inventory.ReadRows(data)
+	}
+
+	switch c.QueryParam("kind") {
+	case exportKindHosts:
+		hostRows, err := inventory.ReadHostsCSV(bytes.NewReader(data))
+		return hostRows, nil, err
+	case exportKindServicePorts:
+		spRows, err := inventory.ReadServicePortsCSV(bytes.NewReader(data))
+		return nil, spRows, err
+	default:
+		return nil, nil, fmt.Errorf("CSV import requires kind=hosts or kind=service_ports")
+	}
+}
+
+// Import creates the Hosts and service ports of a JSON inventory or a CSV file. Every row is validated
+// like the matching API call and reported with its own result. With upsert, a row whose Host IP or
+// service port already exists updates it instead of failing. A failed row is skipped, unless the
+// import is atomic, in which case nothing is imported when any row fails.
+func (h *Handler) Import(c echo.Context) error {
+	upsert, err := parseBoolParam(c, "upsert")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+	}
+
+	atomic, err := parseBoolParam(c, "atomic")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request: " + err.Error(),
+		})
+	}
+
+	hostRows, spRows, err := readImport(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	var cipher *secret.PassphraseCipher
+	if passphrase := c.Request().Header.Get(PassphraseHeader); passphrase != "" {
+		cipher, err = secret.NewPassphraseCipher(passphrase)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to import: " + err.Error(),
+			})
+		}
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	tx := h.db.Begin()
+	err = tx.Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to start transaction: " + err.Error(),
+		})
+	}
+
+	state, err := loadInventoryState(tx)
+	if err != nil {
+		tx.Rollback()
+		h.logger.Error("failed to import", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to import: " + err.Error(),
+		})
+	}
+
+	im := newImporter(h, c, state, upsert, cipher)
+	result := im.run(tx, hostRows, spRows)
+
+	if atomic && result.Failed > 0 {
+		tx.Rollback()
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Data:    result,
+			Error:   fmt.Sprintf("Import rolled back: %d of %d rows failed", result.Failed, len(result.Rows)),
+		})
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to commit transaction: " + err.Error(),
+		})
+	}
+	result.Committed = true
+
+	im.restartTunnels()
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    result,
+	})
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/go-playground/validator/v10"
+	"github.com/jollaman999/tunnel-manager/internal/database"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testValidator struct {
+	validator *validator.Validate
+}
+
+func (v *testValidator) Validate(i interface{}) error {
+	return v.validator.Struct(i)
+}
+
+func newTestHandler(t *testing.T) (*Handler, *echo.Echo) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = database.MigrateUp(db)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	manager, err := tunnel.NewManager(db, zap.NewNop(), 1, true, tunnel.BackoffPolicy{})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	e := echo.New()
+	e.Validator = &testValidator{validator: validator.New()}
+
+	return NewHandler(db, manager, zap.NewNop(), nil), e
+}
+
+func mustCreate(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+
+	err := db.Create(value).Error
+	if err != nil {
+		t.Fatalf("failed to create %T: %v", value, err)
+	}
+}
+
+func runImport(t *testing.T, h *Handler, e *echo.Echo, query, contentType, body string) *models.ImportResult {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/import?"+query, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, contentType)
+	rec := httptest.NewRecorder()
+
+	err := h.Import(e.NewContext(req, rec))
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	var res struct {
+		Data  models.ImportResult `json:"data"`
+		Error string              `json:"error"`
+	}
+	err = json.Unmarshal(rec.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import returned %d: %s", rec.Code, res.Error)
+	}
+
+	return &res.Data
+}
+
+func TestImportUpsertKeepsOmittedFields(t *testing.T) {
+	h, e := newTestHandler(t)
+
+	group := models.HostGroup{Name: "web"}
+	mustCreate(t, h.db, &group)
+	jump := models.Host{IP: "10.0.0.1", Port: 22, User: "deploy", Password: "secret"}
+	mustCreate(t, h.db, &jump)
+	maxAttempts := 5
+	host := models.Host{
+		IP:              "10.0.0.2",
+		Port:            2222,
+		User:            "deploy",
+		Password:        "secret",
+		HostGroupID:     &group.ID,
+		ReconnectPolicy: &models.ReconnectPolicy{MaxAttempts: &maxAttempts},
+		Description:     "web-1",
+	}
+	mustCreate(t, h.db, &host)
+	err := h.db.Model(&host).Update("enabled", false).Error
+	if err != nil {
+		t.Fatalf("failed to disable Host: %v", err)
+	}
+	mustCreate(t, h.db, &models.HostJump{HostID: host.ID, Position: 1, JumpHostID: jump.ID})
+	serviceIP := "10.0.0.5"
+	sp := models.ServicePort{
+		ServiceIP:   &serviceIP,
+		ServicePort: 5432,
+		LocalPort:   15432,
+		BindAddress: "127.0.0.1",
+		Direction:   tunnel.DirectionRemote,
+		Description: "postgres",
+	}
+	mustCreate(t, h.db, &sp)
+	mustCreate(t, h.db, &models.HostServicePort{HostID: host.ID, SPID: sp.ID})
+
+	result := runImport(t, h, e, "kind=hosts&upsert=true", "text/csv", "ip,description\n10.0.0.2,web-2\n")
+	if result.Failed != 0 || result.Updated != 1 {
+		t.Fatalf("unexpected hosts import result: %+v", result)
+	}
+
+	result = runImport(t, h, e, "kind=service_ports&upsert=true", "text/csv",
+		"service_ip,service_port,description\n10.0.0.5,5432,postgres-primary\n")
+	if result.Failed != 0 || result.Updated != 1 {
+		t.Fatalf("unexpected service ports import result: %+v", result)
+	}
+
+	var got models.Host
+	err = h.db.First(&got, host.ID).Error
+	if err != nil {
+		t.Fatalf("failed to fetch Host: %v", err)
+	}
+	if got.Description != "web-2" {
+		t.Errorf("description = %q, want %q", got.Description, "web-2")
+	}
+	if got.Port != 2222 || got.Password != "secret" {
+		t.Errorf("port and password = %d, %q, want 2222, %q", got.Port, got.Password, "secret")
+	}
+	if got.HostGroupID == nil || *got.HostGroupID != group.ID {
+		t.Errorf("host_group_id = %v, want %d", got.HostGroupID, group.ID)
+	}
+	if got.ReconnectPolicy == nil || got.ReconnectPolicy.MaxAttempts == nil || *got.ReconnectPolicy.MaxAttempts != maxAttempts {
+		t.Errorf("reconnect_policy = %+v, want max_attempts %d", got.ReconnectPolicy, maxAttempts)
+	}
+	if got.Enabled {
+		t.Errorf("enabled = true, want false")
+	}
+
+	var jumps []models.HostJump
+	err = h.db.Where("host_id = ?", host.ID).Find(&jumps).Error
+	if err != nil {
+		t.Fatalf("failed to fetch jump hosts: %v", err)
+	}
+	if len(jumps) != 1 || jumps[0].JumpHostID != jump.ID {
+		t.Errorf("jump hosts = %+v, want only Host %d", jumps, jump.ID)
+	}
+
+	var gotSP models.ServicePort
+	err = h.db.First(&gotSP, sp.ID).Error
+	if err != nil {
+		t.Fatalf("failed to fetch service port: %v", err)
+	}
+	if gotSP.Description != "postgres-primary" {
+		t.Errorf("description = %q, want %q", gotSP.Description, "postgres-primary")
+	}
+	if gotSP.LocalPort != 15432 || gotSP.BindAddress != "127.0.0.1" {
+		t.Errorf("local address = %s:%d, want 127.0.0.1:15432", gotSP.BindAddress, gotSP.LocalPort)
+	}
+
+	var assignments []models.HostServicePort
+	err = h.db.Where("sp_id = ?", sp.ID).Find(&assignments).Error
+	if err != nil {
+		t.Fatalf("failed to fetch assignments: %v", err)
+	}
+	if len(assignments) != 1 || assignments[0].HostID != host.ID {
+		t.Errorf("assignments = %+v, want only Host %d", assignments, host.ID)
+	}
+}
+
+func TestImportFailedRowDoesNotClaimServicePort(t *testing.T) {
+	h, e := newTestHandler(t)
+
+	// The first row fails validation, the second one has the same key and is imported.
+	result := runImport(t, h, e, "kind=service_ports", "text/csv",
+		"service_ip,service_port,local_port,direction\n10.0.0.5,5432,0,local\n10.0.0.5,5432,15432,local\n")
+	if result.Failed != 1 || result.Created != 1 {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+	if result.Rows[1].Error != "" {
+		t.Errorf("second row failed: %s", result.Rows[1].Error)
+	}
+}
+
+func TestImportUpsertHostDescription(t *testing.T) {
+	h, e := newTestHandler(t)
+
+	for _, host := range []models.Host{
+		{IP: "10.0.0.1", Port: 22, User: "deploy", Password: "secret"},
+		{IP: "10.0.0.2", Port: 22, User: "deploy", Password: "secret", Description: "db-1"},
+	} {
+		mustCreate(t, h.db, &host)
+	}
+
+	// A Host without a description gets the one of its row, an empty one keeps the stored description.
+	result := runImport(t, h, e, "kind=hosts&upsert=true", "text/csv",
+		"ip,port,description\n10.0.0.1,22,web-1\n10.0.0.2,2222,\n")
+	if result.Failed != 0 || result.Updated != 2 {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+
+	want := map[string]string{"10.0.0.1": "web-1", "10.0.0.2": "db-1"}
+	for ip, description := range want {
+		var got models.Host
+		err := h.db.Where("ip = ?", ip).First(&got).Error
+		if err != nil {
+			t.Fatalf("failed to fetch Host %s: %v", ip, err)
+		}
+		if got.Description != description {
+			t.Errorf("description of %s = %q, want %q", ip, got.Description, description)
+		}
+	}
+}
@@ -0,0 +1,931 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/inventory"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/secret"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// inventoryStep is a planned change and the handler call that applies it.
+type inventoryStep struct {
+	change models.InventoryChange
+	apply  func() error
+}
+
+// inventoryIDs maps the hosts and service ports of an inventory to their IDs,
+// including the ones created while it is applied.
+type inventoryIDs struct {
+	hosts map[string]uint
+	sps   map[string]uint
+}
+
+func (ids *inventoryIDs) host(ip string) (uint, error) {
+	id, ok := ids.hosts[ip]
+	if !ok {
+		return 0, fmt.Errorf("Host %s was not created", ip)
+	}
+
+	return id, nil
+}
+
+func (ids *inventoryIDs) hostList(ips []string) ([]uint, error) {
+	hostIDs := make([]uint, 0, len(ips))
+	for _, ip := range ips {
+		id, err := ids.host(ip)
+		if err != nil {
+			return nil, err
+		}
+		hostIDs = append(hostIDs, id)
+	}
+
+	return hostIDs, nil
+}
+
+func (ids *inventoryIDs) servicePort(key string) (uint, error) {
+	id, ok := ids.sps[key]
+	if !ok {
+		return 0, fmt.Errorf("service port %s was not created", key)
+	}
+
+	return id, nil
+}
+
+// responseRecorder keeps the response of a handler called by invoke.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+// invoke calls handler with body and the path params as a request made with the token of c,
+// so that inventory changes get the same validation and tunnel handling as API calls.
+// It returns the data of a successful response and the error message of a failed one.
+func (h *Handler) invoke(c echo.Context, method string, handler echo.HandlerFunc, params map[string]string, body interface{}) (json.RawMessage, error) {
+	reqBody := []byte{}
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(c.Request().Context(), method, c.Request().URL.Path, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+	ctx := c.Echo().NewContext(req, rec)
+	ctx.Set(auth.ContextKey, c.Get(auth.ContextKey))
+	var names, values []string
+	for name, value := range params {
+		names = append(names, name)
+		values = append(values, value)
+	}
+	ctx.SetParamNames(names...)
+	ctx.SetParamValues(values...)
+
+	err = handler(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+		Error   string          `json:"error"`
+	}
+	err = json.Unmarshal(rec.body.Bytes(), &resp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response (HTTP %d): %w", rec.status, err)
+	}
+	if !resp.Success {
+		return nil, errors.New(resp.Error)
+	}
+
+	return resp.Data, nil
+}
+
+func idParam(id uint) map[string]string {
+	return map[string]string{"id": strconv.FormatUint(uint64(id), 10)}
+}
+
+// sameIDs reports whether a nullable ID matches id, where 0 means none.
+func sameIDs(current *uint, id uint) bool {
+	if id == 0 {
+		return current == nil
+	}
+
+	return current != nil && *current == id
+}
+
+func idPointer(id uint) *uint {
+	if id == 0 {
+		return nil
+	}
+
+	return &id
+}
+
+// orderByJumpHosts orders hosts so that every Host comes after the hosts among them that it uses as
+// jump hosts. It returns false when their jump hosts form a cycle.
+func orderByJumpHosts[T any](hosts []T, ip func(T) string, jumpHosts func(T) []string) ([]T, bool) {
+	pending := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		pending[ip(host)] = true
+	}
+
+	ordered := make([]T, 0, len(hosts))
+	for len(ordered) < len(hosts) {
+		progress := false
+		for _, host := range hosts {
+			if !pending[ip(host)] {
+				continue
+			}
+
+			ready := true
+			for _, jumpHost := range jumpHosts(host) {
+				if pending[jumpHost] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, host)
+				delete(pending, ip(host))
+				progress = true
+			}
+		}
+		if !progress {
+			return ordered, false
+		}
+	}
+
+	return ordered, true
+}
+
+// inventoryState is everything an inventory describes, as stored in the database.
+type inventoryState struct {
+	hosts       []models.Host
+	sps         []models.ServicePort
+	assignments []models.HostServicePort
+	jumps       []models.HostJump
+	sshKeys     []models.SSHKey
+	hostGroups  []models.HostGroup
+}
+
+func loadInventoryState(db *gorm.DB) (*inventoryState, error) {
+	state := &inventoryState{}
+
+	err := db.Order("id").Find(&state.hosts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Hosts: %w", err)
+	}
+
+	err = db.Order("id").Find(&state.sps).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch service ports: %w", err)
+	}
+
+	err = db.Order("sp_id, host_id").Find(&state.assignments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch service port assignments: %w", err)
+	}
+
+	err = db.Order("host_id, position").Find(&state.jumps).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jump hosts: %w", err)
+	}
+
+	err = db.Select("id", "name").Find(&state.sshKeys).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SSH keys: %w", err)
+	}
+
+	err = db.Find(&state.hostGroups).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch host groups: %w", err)
+	}
+
+	return state, nil
+}
+
+// planInventory compares inv with the database and returns the steps that make the database match it,
+// in the order they are applied. Hosts and service ports missing from inv are deleted when prune is set.
+// Problems with inv are returned in a list rather than as an error, so that they are reported together.
+func (h *Handler) planInventory(c echo.Context, inv *models.Inventory, prune bool) ([]inventoryStep, []string, error) {
+	state, err := loadInventoryState(h.db)
+	if err != nil {
+		return nil, nil, err
+	}
+	hosts, sps := state.hosts, state.sps
+
+	ids := &inventoryIDs{
+		hosts: make(map[string]uint),
+		sps:   make(map[string]uint),
+	}
+	hostsByIP := make(map[string]*models.Host)
+	hostIPs := make(map[uint]string)
+	for i := range hosts {
+		hostsByIP[hosts[i].IP] = &hosts[i]
+		hostIPs[hosts[i].ID] = hosts[i].IP
+		ids.hosts[hosts[i].IP] = hosts[i].ID
+	}
+
+	spsByKey := make(map[string]*models.ServicePort)
+	spKeys := make(map[uint]string)
+	for i := range sps {
+		sp := &sps[i]
+		key := inventory.ServicePortKey(sp.Direction, sp.ServiceIP, sp.ServicePort, sp.BindAddress, sp.LocalPort)
+		spKeys[sp.ID] = key
+		if _, ok := spsByKey[key]; !ok {
+			spsByKey[key] = sp
+			ids.sps[key] = sp.ID
+		}
+	}
+
+	assigned := make(map[uint][]string)
+	for _, assignment := range state.assignments {
+		assigned[assignment.SPID] = append(assigned[assignment.SPID], hostIPs[assignment.HostID])
+	}
+
+	jumpHostIPs := make(map[uint][]string)
+	for _, jump := range state.jumps {
+		jumpHostIPs[jump.HostID] = append(jumpHostIPs[jump.HostID], hostIPs[jump.JumpHostID])
+	}
+
+	sshKeyIDs := make(map[string]uint)
+	for _, key := range state.sshKeys {
+		sshKeyIDs[key.Name] = key.ID
+	}
+
+	hostGroupIDs := make(map[string]uint)
+	for _, group := range state.hostGroups {
+		hostGroupIDs[group.Name] = group.ID
+	}
+
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	wantHosts := make(map[string]bool)
+	for i := range inv.Hosts {
+		want := &inv.Hosts[i]
+		if net.ParseIP(want.IP) == nil {
+			problem("hosts[%d]: invalid ip %q", i, want.IP)
+			continue
+		}
+		if wantHosts[want.IP] {
+			problem("host %s: listed more than once", want.IP)
+		}
+		wantHosts[want.IP] = true
+
+		if want.Port == 0 {
+			want.Port = 22
+		}
+	}
+
+	// A Host can be referenced when it is in the inventory, or in the database unless it is pruned.
+	checkHostRef := func(owner, ip string) {
+		_, exists := hostsByIP[ip]
+		if !wantHosts[ip] && (prune || !exists) {
+			problem("%s: Host %s is not in the inventory", owner, ip)
+		}
+	}
+
+	var hostCreates, hostUpdates, hostKeyPins, jumpUpdates []inventoryStep
+	var newHosts []*models.InventoryHost
+
+	for i := range inv.Hosts {
+		want := &inv.Hosts[i]
+		if net.ParseIP(want.IP) == nil {
+			continue
+		}
+		owner := "host " + want.IP
+
+		for _, jumpHost := range want.JumpHosts {
+			if jumpHost == want.IP {
+				problem("%s: Host cannot be its own jump host", owner)
+				continue
+			}
+			checkHostRef(owner, jumpHost)
+		}
+
+		var sshKeyID, hostGroupID uint
+		if want.SSHKey != "" {
+			id, ok := sshKeyIDs[want.SSHKey]
+			if !ok {
+				problem("%s: SSH key %q not found", owner, want.SSHKey)
+			}
+			sshKeyID = id
+		}
+		if want.HostGroup != "" {
+			id, ok := hostGroupIDs[want.HostGroup]
+			if !ok {
+				problem("%s: host group %q not found", owner, want.HostGroup)
+			}
+			hostGroupID = id
+		}
+
+		var hostKeyFingerprint string
+		if want.HostKey != "" {
+			_, hostKeyFingerprint, err = tunnel.ParseHostKey(want.HostKey)
+			if err != nil {
+				problem("%s: %v", owner, err)
+			}
+		}
+
+		if secret.IsPassphraseEncrypted(want.Password) || secret.IsPassphraseEncrypted(want.PrivateKey) ||
+			secret.IsPassphraseEncrypted(want.Passphrase) {
+			problem("%s: secrets are encrypted by an export, import it with /api/import", owner)
+			continue
+		}
+
+		enabled := want.Enabled == nil || *want.Enabled
+
+		host, exists := hostsByIP[want.IP]
+		if !exists {
+			req := models.CreateHostRequest{
+				IP:              want.IP,
+				Port:            want.Port,
+				User:            want.User,
+				Password:        want.Password,
+				PrivateKey:      want.PrivateKey,
+				Passphrase:      want.Passphrase,
+				SSHKeyID:        idPointer(sshKeyID),
+				HostGroupID:     idPointer(hostGroupID),
+				ReconnectPolicy: want.ReconnectPolicy,
+				HostKey:         want.HostKey,
+				Description:     want.Description,
+				Enabled:         &enabled,
+			}
+			err = c.Validate(&req)
+			if err == nil {
+				err = h.validateHostAuth(req.PrivateKey, req.Passphrase, req.SSHKeyID)
+			}
+			if err == nil {
+				err = h.validateReconnectPolicy(req.ReconnectPolicy)
+			}
+			if err != nil {
+				problem("%s: %v", owner, err)
+				continue
+			}
+
+			jumpHosts := want.JumpHosts
+			newHosts = append(newHosts, want)
+			hostCreates = append(hostCreates, inventoryStep{
+				change: models.InventoryChange{
+					Action: inventory.ActionCreate,
+					Kind:   inventory.KindHost,
+					Key:    want.IP,
+				},
+				apply: func() error {
+					jumpHostIDs, err := ids.hostList(jumpHosts)
+					if err != nil {
+						return err
+					}
+					req.JumpHostIDs = jumpHostIDs
+
+					data, err := h.invoke(c, http.MethodPost, h.CreateHost, nil, &req)
+					if err != nil {
+						return err
+					}
+
+					var created models.Host
+					err = json.Unmarshal(data, &created)
+					if err != nil {
+						return err
+					}
+					ids.hosts[created.IP] = created.ID
+
+					return nil
+				},
+			})
+			continue
+		}
+
+		var fields []string
+		req := models.UpdateHostRequest{}
+		if host.Port != want.Port {
+			req.Port = &want.Port
+			fields = append(fields, "port")
+		}
+		if want.User != "" && host.User != want.User {
+			req.User = want.User
+			fields = append(fields, "user")
+		}
+		// Omitted credentials keep the stored ones.
+		if want.Password != "" && host.Password != want.Password {
+			req.Password = want.Password
+			fields = append(fields, "password")
+		}
+		if want.PrivateKey != "" && host.PrivateKey != want.PrivateKey {
+			req.PrivateKey = want.PrivateKey
+			req.Passphrase = want.Passphrase
+			fields = append(fields, "private_key")
+		} else if want.Passphrase != "" && host.Passphrase != want.Passphrase {
+			req.Passphrase = want.Passphrase
+			fields = append(fields, "passphrase")
+		}
+		if want.SSHKey != "" && !sameIDs(host.SSHKeyID, sshKeyID) {
+			req.SSHKeyID = &sshKeyID
+			fields = append(fields, "ssh_key")
+		}
+		if !sameIDs(host.HostGroupID, hostGroupID) {
+			req.HostGroupID = &hostGroupID
+			fields = append(fields, "host_group")
+		}
+		if !reflect.DeepEqual(host.ReconnectPolicy, reconnectPolicy(want.ReconnectPolicy)) {
+			req.ReconnectPolicy = want.ReconnectPolicy
+			if req.ReconnectPolicy == nil {
+				req.ReconnectPolicy = &models.ReconnectPolicy{}
+			}
+			fields = append(fields, "reconnect_policy")
+		}
+		// UpdateHost replaces a stored description with the requested one, so it is always sent,
+		// and cannot set one on a Host without it, which is done after the update.
+		req.Description = host.Description
+		description := ""
+		if want.Description != "" && host.Description != want.Description {
+			req.Description = want.Description
+			if host.Description == "" {
+				description = want.Description
+			}
+			fields = append(fields, "description")
+		}
+		if host.Enabled != enabled {
+			req.Enabled = &enabled
+			fields = append(fields, "enabled")
+		}
+
+		if len(fields) > 0 {
+			err = c.Validate(&req)
+			if err == nil && (req.PrivateKey != "" || req.SSHKeyID != nil) {
+				err = h.validateHostAuth(req.PrivateKey, req.Passphrase, req.SSHKeyID)
+			}
+			if err == nil && req.ReconnectPolicy != nil {
+				err = h.validateReconnectPolicy(req.ReconnectPolicy)
+			}
+			if err != nil {
+				problem("%s: %v", owner, err)
+				continue
+			}
+
+			id := host.ID
+			hostUpdates = append(hostUpdates, inventoryStep{
+				change: models.InventoryChange{
+					Action: inventory.ActionUpdate,
+					Kind:   inventory.KindHost,
+					Key:    want.IP,
+					Fields: fields,
+				},
+				apply: func() error {
+					_, err := h.invoke(c, http.MethodPut, h.UpdateHost, idParam(id), &req)
+					if err != nil || description == "" {
+						return err
+					}
+
+					err = h.db.Model(&models.Host{}).Where("id = ?", id).Update("description", description).Error
+					if err != nil {
+						return fmt.Errorf("Failed to update Host: %w", err)
+					}
+					return nil
+				},
+			})
+		}
+
+		if hostKeyFingerprint != "" && host.HostKeyFingerprint != hostKeyFingerprint {
+			id := host.ID
+			req := models.PinHostKeyRequest{HostKey: want.HostKey}
+			hostKeyPins = append(hostKeyPins, inventoryStep{
+				change: models.InventoryChange{
+					Action: inventory.ActionUpdate,
+					Kind:   inventory.KindHostKey,
+					Key:    want.IP,
+				},
+				apply: func() error {
+					_, err := h.invoke(c, http.MethodPut, h.PinHostKey, idParam(id), &req)
+					return err
+				},
+			})
+		}
+
+		if !slices.Equal(jumpHostIPs[host.ID], want.JumpHosts) {
+			id := host.ID
+			jumpHosts := want.JumpHosts
+			jumpUpdates = append(jumpUpdates, inventoryStep{
+				change: models.InventoryChange{
+					Action: inventory.ActionUpdate,
+					Kind:   inventory.KindJumpHosts,
+					Key:    want.IP,
+				},
+				apply: func() error {
+					jumpHostIDs, err := ids.hostList(jumpHosts)
+					if err != nil {
+						return err
+					}
+
+					_, err = h.invoke(c, http.MethodPut, h.SetJumpHosts, idParam(id),
+						&models.SetJumpHostsRequest{JumpHostIDs: jumpHostIDs})
+					return err
+				},
+			})
+		}
+	}
+
+	// New Hosts are created after the new hosts they use as jump hosts.
+	ordered, ok := orderByJumpHosts(newHosts,
+		func(host *models.InventoryHost) string { return host.IP },
+		func(host *models.InventoryHost) []string { return host.JumpHosts })
+	if !ok {
+		problem("jump hosts of the new Hosts form a cycle")
+	} else {
+		position := make(map[string]int, len(ordered))
+		for i, host := range ordered {
+			position[host.IP] = i
+		}
+		slices.SortStableFunc(hostCreates, func(a, b inventoryStep) int {
+			return position[a.change.Key] - position[b.change.Key]
+		})
+	}
+
+	var spChanges, assignmentCreates, assignmentDeletes []inventoryStep
+	wantSPs := make(map[string]bool)
+
+	for i := range inv.ServicePorts {
+		want := &inv.ServicePorts[i]
+		if want.Direction == "" {
+			want.Direction = tunnel.DirectionRemote
+		}
+		if want.BindAddress == "" {
+			want.BindAddress = tunnel.DefaultBindAddress(want.Direction)
+		}
+
+		req := models.CreateServicePortRequest{
+			ServiceIP:       want.ServiceIP,
+			ServicePort:     want.ServicePort,
+			LocalPort:       want.LocalPort,
+			BindAddress:     want.BindAddress,
+			Direction:       want.Direction,
+			Allowlist:       want.Allowlist,
+			ApplyToAllHosts: want.ApplyToAllHosts,
+			Description:     want.Description,
+		}
+		key := inventory.ServicePortKey(req.Direction, serviceIP(req.ServiceIP), req.ServicePort, req.BindAddress, req.LocalPort)
+		owner := "service port " + key
+
+		if wantSPs[key] {
+			problem("%s: listed more than once", owner)
+			continue
+		}
+		wantSPs[key] = true
+
+		err = c.Validate(&req)
+		if err == nil {
+			err = prepareServicePortRequest(&req)
+		}
+		if err != nil {
+			problem("%s: %v", owner, err)
+			continue
+		}
+
+		wantAssigned := make(map[string]bool)
+		for _, ip := range want.Hosts {
+			checkHostRef(owner, ip)
+			wantAssigned[ip] = true
+		}
+
+		sp, exists := spsByKey[key]
+		if !exists {
+			spChanges = append(spChanges, inventoryStep{
+				change: models.InventoryChange{
+					Action: inventory.ActionCreate,
+					Kind:   inventory.KindServicePort,
+					Key:    key,
+				},
+				apply: func() error {
+					data, err := h.invoke(c, http.MethodPost, h.CreateServicePort, nil, &req)
+					if err != nil {
+						return err
+					}
+
+					var created models.ServicePort
+					err = json.Unmarshal(data, &created)
+					if err != nil {
+						return err
+					}
+					ids.sps[key] = created.ID
+
+					return nil
+				},
+			})
+		} else {
+			var fields []string
+			if !reflect.DeepEqual(sp.ServiceIP, serviceIP(req.ServiceIP)) {
+				fields = append(fields, "service_ip")
+			}
+			if sp.ServicePort != req.ServicePort {
+				fields = append(fields, "service_port")
+			}
+			if sp.LocalPort != req.LocalPort {
+				fields = append(fields, "local_port")
+			}
+			if sp.BindAddress != req.BindAddress {
+				fields = append(fields, "bind_address")
+			}
+			if sp.Direction != req.Direction {
+				fields = append(fields, "direction")
+			}
+			if !slices.Equal(sp.Allowlist, req.Allowlist) {
+				fields = append(fields, "allowlist")
+			}
+			if sp.ApplyToAllHosts != req.ApplyToAllHosts {
+				fields = append(fields, "apply_to_all_hosts")
+			}
+			if sp.Description != req.Description {
+				fields = append(fields, "description")
+			}
+
+			if len(fields) > 0 {
+				id := sp.ID
+				spChanges = append(spChanges, inventoryStep{
+					change: models.InventoryChange{
+						Action: inventory.ActionUpdate,
+						Kind:   inventory.KindServicePort,
+						Key:    key,
+						Fields: fields,
+					},
+					apply: func() error {
+						_, err := h.invoke(c, http.MethodPut, h.UpdateServicePort, idParam(id), &req)
+						return err
+					},
+				})
+			}
+
+			for _, ip := range assigned[sp.ID] {
+				if wantAssigned[ip] {
+					delete(wantAssigned, ip)
+					continue
+				}
+				// Assignments of pruned Hosts are deleted with them.
+				if prune && !wantHosts[ip] {
+					continue
+				}
+
+				hostID, spID := ids.hosts[ip], sp.ID
+				assignmentDeletes = append(assignmentDeletes, inventoryStep{
+					change: models.InventoryChange{
+						Action: inventory.ActionDelete,
+						Kind:   inventory.KindAssignment,
+						Key:    inventory.AssignmentKey(ip, key),
+					},
+					apply: func() error {
+						_, err := h.invoke(c, http.MethodDelete, h.DeleteAssignment, map[string]string{
+							"hostId": strconv.FormatUint(uint64(hostID), 10),
+							"spId":   strconv.FormatUint(uint64(spID), 10),
+						}, nil)
+						return err
+					},
+				})
+			}
+		}
+
+		for _, ip := range want.Hosts {
+			if !wantAssigned[ip] {
+				continue
+			}
+			delete(wantAssigned, ip)
+
+			assignmentCreates = append(assignmentCreates, inventoryStep{
+				change: models.InventoryChange{
+					Action: inventory.ActionCreate,
+					Kind:   inventory.KindAssignment,
+					Key:    inventory.AssignmentKey(ip, key),
+				},
+				apply: func() error {
+					hostID, err := ids.host(ip)
+					if err != nil {
+						return err
+					}
+					spID, err := ids.servicePort(key)
+					if err != nil {
+						return err
+					}
+
+					_, err = h.invoke(c, http.MethodPost, h.CreateAssignment, nil,
+						&models.AssignmentRequest{HostID: hostID, SPID: spID})
+					return err
+				},
+			})
+		}
+	}
+
+	var spDeletes, hostDeletes []inventoryStep
+	if prune {
+		for _, sp := range sps {
+			key := spKeys[sp.ID]
+			if wantSPs[key] && spsByKey[key].ID == sp.ID {
+				continue
+			}
+
+			id := sp.ID
+			spDeletes = append(spDeletes, inventoryStep{
+				change: models.InventoryChange{
+					Action: inventory.ActionDelete,
+					Kind:   inventory.KindServicePort,
+					Key:    key,
+				},
+				apply: func() error {
+					_, err := h.invoke(c, http.MethodDelete, h.DeleteServicePort, idParam(id), nil)
+					return err
+				},
+			})
+		}
+
+		var pruned []models.Host
+		for _, host := range hosts {
+			if !wantHosts[host.IP] {
+				pruned = append(pruned, host)
+			}
+		}
+
+		// Hosts used as jump hosts are deleted after the Hosts using them, the reverse of creating them.
+		ordered, _ := orderByJumpHosts(pruned,
+			func(host models.Host) string { return host.IP },
+			func(host models.Host) []string { return jumpHostIPs[host.ID] })
+		slices.Reverse(ordered)
+		for _, host := range ordered {
+			id := host.ID
+			hostDeletes = append(hostDeletes, inventoryStep{
+				change: models.InventoryChange{
+					Action: inventory.ActionDelete,
+					Kind:   inventory.KindHost,
+					Key:    host.IP,
+				},
+				apply: func() error {
+					_, err := h.invoke(c, http.MethodDelete, h.DeleteHost, idParam(id), nil)
+					return err
+				},
+			})
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, problems, nil
+	}
+
+	return slices.Concat(hostCreates, hostUpdates, hostKeyPins, jumpUpdates, spChanges,
+		assignmentCreates, assignmentDeletes, spDeletes, hostDeletes), nil, nil
+}
+
+func inventoryPlan(steps []inventoryStep) *models.InventoryPlan {
+	plan := &models.InventoryPlan{
+		Changes: []models.InventoryChange{},
+	}
+	for _, step := range steps {
+		plan.Changes = append(plan.Changes, step.change)
+		switch step.change.Action {
+		case inventory.ActionCreate:
+			plan.Create++
+		case inventory.ActionUpdate:
+			plan.Update++
+		case inventory.ActionDelete:
+			plan.Delete++
+		}
+	}
+
+	return plan
+}
+
+// readInventory parses the inventory in the request body and the prune query parameter.
+func readInventory(c echo.Context) (*models.Inventory, bool, error) {
+	prune := false
+	if param := c.QueryParam("prune"); param != "" {
+		var err error
+		prune, err = strconv.ParseBool(param)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid prune: %s", param)
+		}
+	}
+
+	data, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	inv, err := inventory.Parse(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return inv, prune, nil
+}
+
+// loadInventoryPlan reads the inventory of the request and plans it. A failure is returned with the
+// status of its response.
+func (h *Handler) loadInventoryPlan(c echo.Context) ([]inventoryStep, int, error) {
+	inv, prune, err := readInventory(c)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid request body: %w", err)
+	}
+
+	h.rwLock.RLock()
+	steps, problems, err := h.planInventory(c, inv, prune)
+	h.rwLock.RUnlock()
+	if err != nil {
+		h.logger.Error("failed to plan inventory", zap.Error(err))
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to plan inventory: %w", err)
+	}
+	if len(problems) > 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid inventory: %s", strings.Join(problems, "; "))
+	}
+
+	return steps, http.StatusOK, nil
+}
+
+// PlanInventory returns the changes that applying the inventory in the request body would make.
+func (h *Handler) PlanInventory(c echo.Context) error {
+	h.inventoryLock.Lock()
+	defer h.inventoryLock.Unlock()
+
+	steps, status, err := h.loadInventoryPlan(c)
+	if err != nil {
+		return c.JSON(status, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    inventoryPlan(steps),
+	})
+}
+
+// ApplyInventory makes the database match the inventory in the request body. Every change is applied
+// through the handler of the matching API call. A failed change does not stop the ones after it,
+// its error is reported in the returned plan.
+func (h *Handler) ApplyInventory(c echo.Context) error {
+	h.inventoryLock.Lock()
+	defer h.inventoryLock.Unlock()
+
+	steps, status, err := h.loadInventoryPlan(c)
+	if err != nil {
+		return c.JSON(status, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	plan := inventoryPlan(steps)
+	for i, step := range steps {
+		err = step.apply()
+		if err != nil {
+			h.logger.Warn("failed to apply inventory change",
+				zap.String("action", step.change.Action),
+				zap.String("kind", step.change.Kind),
+				zap.String("key", step.change.Key),
+				zap.Error(err))
+			plan.Changes[i].Error = err.Error()
+			plan.Failed++
+		}
+	}
+	plan.Applied = true
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    plan,
+	})
+}
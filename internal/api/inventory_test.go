@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func runInventory(t *testing.T, h *Handler, e *echo.Echo, handler func(*Handler, echo.Context) error, body string) *models.InventoryPlan {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/inventory", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(auth.ContextKey, &models.APIToken{Role: auth.RoleAdmin})
+
+	err := handler(h, c)
+	if err != nil {
+		t.Fatalf("inventory failed: %v", err)
+	}
+
+	var res struct {
+		Data  models.InventoryPlan `json:"data"`
+		Error string               `json:"error"`
+	}
+	err = json.Unmarshal(rec.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("inventory returned %d: %s", rec.Code, res.Error)
+	}
+
+	return &res.Data
+}
+
+func TestApplyInventoryHostDescription(t *testing.T) {
+	h, e := newTestHandler(t)
+
+	hosts := []models.Host{
+		{IP: "10.0.0.1", Port: 22, User: "deploy", Password: "secret"},
+		{IP: "10.0.0.2", Port: 22, User: "deploy", Password: "secret", Description: "db-1"},
+		{IP: "10.0.0.3", Port: 22, User: "deploy", Password: "secret", Description: "old"},
+	}
+	for i := range hosts {
+		mustCreate(t, h.db, &hosts[i])
+	}
+
+	inventory := `
+hosts:
+  - ip: 10.0.0.1
+    description: web-1
+  - ip: 10.0.0.2
+    port: 2222
+  - ip: 10.0.0.3
+    description: new
+`
+	plan := runInventory(t, h, e, (*Handler).ApplyInventory, inventory)
+	if plan.Failed != 0 || plan.Update != 3 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	want := map[string]string{"10.0.0.1": "web-1", "10.0.0.2": "db-1", "10.0.0.3": "new"}
+	for ip, description := range want {
+		var got models.Host
+		err := h.db.Where("ip = ?", ip).First(&got).Error
+		if err != nil {
+			t.Fatalf("failed to fetch Host %s: %v", ip, err)
+		}
+		if got.Description != description {
+			t.Errorf("description of %s = %q, want %q", ip, got.Description, description)
+		}
+	}
+
+	plan = runInventory(t, h, e, (*Handler).PlanInventory, inventory)
+	if len(plan.Changes) != 0 {
+		t.Errorf("changes after apply = %+v, want none", plan.Changes)
+	}
+}
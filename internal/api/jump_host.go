@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func (h *Handler) validateJumpHosts(hostID uint, jumpHostIDs []uint) error {
+	seen := make(map[uint]bool)
+	for _, jumpHostID := range jumpHostIDs {
+		if jumpHostID == hostID {
+			return fmt.Errorf("Host cannot be its own jump host (id=%d)", jumpHostID)
+		}
+		if seen[jumpHostID] {
+			return fmt.Errorf("duplicated jump host (id=%d)", jumpHostID)
+		}
+		seen[jumpHostID] = true
+
+		err := h.db.First(&models.Host{}, jumpHostID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("jump host not found (id=%d)", jumpHostID)
+			}
+			return fmt.Errorf("failed to fetch jump host (id=%d): %w", jumpHostID, err)
+		}
+	}
+
+	return nil
+}
+
+func saveJumpHosts(tx *gorm.DB, hostID uint, jumpHostIDs []uint) error {
+	err := tx.Where("host_id = ?", hostID).Delete(&models.HostJump{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete jump hosts: %w", err)
+	}
+
+	for i, jumpHostID := range jumpHostIDs {
+		err = tx.Create(&models.HostJump{
+			HostID:     hostID,
+			Position:   i + 1,
+			JumpHostID: jumpHostID,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to create jump host: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// restartJumpDependents restarts the tunnels of every Host that uses the host as a jump host,
+// so that their connections are rebuilt with its current address and credentials.
+func (h *Handler) restartJumpDependents(hostID uint) {
+	var hostIDs []uint
+	err := h.db.Model(&models.HostJump{}).Distinct("host_id").
+		Where("jump_host_id = ?", hostID).Pluck("host_id", &hostIDs).Error
+	if err != nil {
+		h.logger.Error("failed to fetch jump host dependents", zap.Uint("host_id", hostID), zap.Error(err))
+		return
+	}
+
+	for _, id := range hostIDs {
+		var host models.Host
+		err = h.db.First(&host, id).Error
+		if err != nil {
+			h.logger.Warn("failed to fetch Host", zap.Uint("host_id", id), zap.Error(err))
+			continue
+		}
+
+		err = h.restartHostTunnels(&host)
+		if err != nil {
+			h.logger.Warn("failed to restart tunnels", zap.Uint("host_id", id), zap.Error(err))
+		}
+	}
+}
+
+func (h *Handler) jumpHostData(hostID uint) (map[string]interface{}, error) {
+	jumpHosts, err := h.manager.JumpHostsForHost(hostID)
+	if err != nil {
+		return nil, err
+	}
+
+	jumpHostIDs := make([]uint, 0, len(jumpHosts))
+	for _, jumpHost := range jumpHosts {
+		jumpHostIDs = append(jumpHostIDs, jumpHost.ID)
+	}
+
+	return map[string]interface{}{
+		"host_id":       hostID,
+		"jump_host_ids": jumpHostIDs,
+		"jump_hosts":    jumpHosts,
+	}, nil
+}
+
+func (h *Handler) GetJumpHosts(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid Host ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var host models.Host
+	err = h.hostQuery(c).First(&host, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Host not found: " + err.Error(),
+		})
+	}
+
+	data, err := h.jumpHostData(host.ID)
+	if err != nil {
+		h.logger.Error("failed to fetch jump hosts", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch jump hosts: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    data,
+	})
+}
+
+func (h *Handler) SetJumpHosts(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid Host ID: " + err.Error(),
+		})
+	}
+
+	var req models.SetJumpHostsRequest
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var host models.Host
+	err = h.hostQuery(c).First(&host, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Host not found: " + err.Error(),
+		})
+	}
+
+	err = h.checkHostsVisible(c, req.JumpHostIDs)
+	if err == nil {
+		err = h.validateJumpHosts(host.ID, req.JumpHostIDs)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return saveJumpHosts(tx, host.ID, req.JumpHostIDs)
+	})
+	if err != nil {
+		h.logger.Error("failed to update jump hosts", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update jump hosts: " + err.Error(),
+		})
+	}
+
+	err = h.restartHostTunnels(&host)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to restart tunnels: " + err.Error(),
+		})
+	}
+
+	data, err := h.jumpHostData(host.ID)
+	if err != nil {
+		h.logger.Error("failed to fetch jump hosts", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch jump hosts: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    data,
+	})
+}
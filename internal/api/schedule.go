@@ -0,0 +1,276 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// applyScheduleRequest sets the fields of schedule from req. An omitted enabled keeps its current value,
+// and an omitted time zone means UTC.
+func applyScheduleRequest(schedule *models.Schedule, req *models.ScheduleRequest) {
+	schedule.Name = req.Name
+	schedule.HostID = req.HostID
+	schedule.SPID = req.SPID
+	schedule.Open = req.Open
+	schedule.Close = req.Close
+	schedule.TimeZone = req.TimeZone
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = tunnel.DefaultTimeZone
+	}
+	schedule.Description = req.Description
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+}
+
+// checkScheduleTargets checks that the host and service port of schedule exist.
+func (h *Handler) checkScheduleTargets(schedule *models.Schedule) error {
+	var hostIDs, spIDs []uint
+	if schedule.HostID != nil {
+		hostIDs = []uint{*schedule.HostID}
+	}
+	if schedule.SPID != nil {
+		spIDs = []uint{*schedule.SPID}
+	}
+
+	_, _, err := h.fetchAssignmentTargets(hostIDs, spIDs)
+	return err
+}
+
+func setScheduleState(schedules []models.Schedule) {
+	now := time.Now()
+	for i := range schedules {
+		schedules[i].State, _ = tunnel.ScheduleState(&schedules[i], now)
+	}
+}
+
+func (h *Handler) CreateSchedule(c echo.Context) error {
+	var req models.ScheduleRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	schedule := models.Schedule{
+		Enabled: true,
+	}
+	applyScheduleRequest(&schedule, &req)
+
+	err = tunnel.ValidateSchedule(&schedule)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid schedule: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	err = h.checkScheduleTargets(&schedule)
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	// Create replaces a false enabled by the database default, so it is written again.
+	enabled := schedule.Enabled
+	err = h.db.Create(&schedule).Error
+	if err == nil {
+		err = h.db.Model(&schedule).Update("enabled", enabled).Error
+	}
+	if err != nil {
+		h.logger.Error("failed to create schedule", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create schedule: " + err.Error(),
+		})
+	}
+	schedule.State, _ = tunnel.ScheduleState(&schedule, time.Now())
+	h.manager.ReloadSchedules()
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Data:    schedule,
+	})
+}
+
+func (h *Handler) ListSchedules(c echo.Context) error {
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var schedules []models.Schedule
+	err := h.db.Find(&schedules).Error
+	if err != nil {
+		h.logger.Error("failed to fetch schedules", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch schedules: " + err.Error(),
+		})
+	}
+	setScheduleState(schedules)
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    schedules,
+	})
+}
+
+func (h *Handler) GetSchedule(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid schedule ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var schedule models.Schedule
+	err = h.db.First(&schedule, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Schedule not found: " + err.Error(),
+		})
+	}
+	schedule.State, _ = tunnel.ScheduleState(&schedule, time.Now())
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    schedule,
+	})
+}
+
+func (h *Handler) UpdateSchedule(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid schedule ID: " + err.Error(),
+		})
+	}
+
+	var req models.ScheduleRequest
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var schedule models.Schedule
+	err = h.db.First(&schedule, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Schedule not found: " + err.Error(),
+		})
+	}
+
+	applyScheduleRequest(&schedule, &req)
+
+	err = tunnel.ValidateSchedule(&schedule)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid schedule: " + err.Error(),
+		})
+	}
+
+	err = h.checkScheduleTargets(&schedule)
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	err = h.db.Save(&schedule).Error
+	if err != nil {
+		h.logger.Error("failed to update schedule", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update schedule: " + err.Error(),
+		})
+	}
+	schedule.State, _ = tunnel.ScheduleState(&schedule, time.Now())
+	h.manager.ReloadSchedules()
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    schedule,
+	})
+}
+
+func (h *Handler) DeleteSchedule(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid schedule ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var schedule models.Schedule
+	err = h.db.First(&schedule, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Success: false,
+				Error:   "Schedule not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch schedule: " + err.Error(),
+		})
+	}
+
+	err = h.db.Delete(&schedule).Error
+	if err != nil {
+		h.logger.Error("failed to delete schedule", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete schedule: " + err.Error(),
+		})
+	}
+	h.manager.ReloadSchedules()
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    "Schedule deleted successfully",
+	})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// hostQuery returns a query on Hosts limited to the host groups of the request's token.
+// Hosts outside of them are reported as not found.
+func (h *Handler) hostQuery(c echo.Context) *gorm.DB {
+	token := auth.FromContext(c)
+	if !auth.Scoped(token) {
+		return h.db
+	}
+
+	return h.db.Where("host_group_id IN ?", token.HostGroupIDs)
+}
+
+// visibleHostIDs returns the IDs of the Hosts the request's token can access,
+// or nil when the token can access every Host.
+func (h *Handler) visibleHostIDs(c echo.Context) (map[uint]bool, error) {
+	if !auth.Scoped(auth.FromContext(c)) {
+		return nil, nil
+	}
+
+	var ids []uint
+	err := h.hostQuery(c).Model(&models.Host{}).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Hosts: %w", err)
+	}
+
+	visible := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		visible[id] = true
+	}
+
+	return visible, nil
+}
+
+// checkHostsVisible returns a notFoundError for the first Host the request's token cannot access.
+func (h *Handler) checkHostsVisible(c echo.Context, hostIDs []uint) error {
+	visible, err := h.visibleHostIDs(c)
+	if err != nil || visible == nil {
+		return err
+	}
+
+	for _, id := range hostIDs {
+		if !visible[id] {
+			return &notFoundError{msg: fmt.Sprintf("Host not found (id=%d)", id)}
+		}
+	}
+
+	return nil
+}
+
+// checkHostGroup validates the host group of a Host created or changed with the request's token.
+// Scoped tokens can only place Hosts in their own host groups.
+func (h *Handler) checkHostGroup(c echo.Context, hostGroupID *uint) error {
+	if !auth.InScope(auth.FromContext(c), hostGroupID) {
+		if hostGroupID == nil {
+			return fmt.Errorf("host_group_id is required for a token scoped to host groups")
+		}
+		return fmt.Errorf("host group is outside of the token's host groups (id=%d)", *hostGroupID)
+	}
+	if hostGroupID == nil {
+		return nil
+	}
+
+	return h.checkHostGroupsExist([]uint{*hostGroupID})
+}
+
+func (h *Handler) checkHostGroupsExist(hostGroupIDs []uint) error {
+	for _, id := range hostGroupIDs {
+		var count int64
+		err := h.db.Model(&models.HostGroup{}).Where("id = ?", id).Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("failed to fetch host group (id=%d): %w", id, err)
+		}
+		if count == 0 {
+			return fmt.Errorf("host group not found (id=%d)", id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+func sameSSHKeyID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func (h *Handler) validateHostAuth(privateKey, passphrase string, sshKeyID *uint) error {
+	if privateKey != "" {
+		_, err := tunnel.ParseSigner(privateKey, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sshKeyID != nil {
+		err := h.db.First(&models.SSHKey{}, *sshKeyID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("SSH key not found (id=%d)", *sshKeyID)
+			}
+			return fmt.Errorf("failed to fetch SSH key (id=%d): %w", *sshKeyID, err)
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) CreateSSHKey(c echo.Context) error {
+	var req models.CreateSSHKeyRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	signer, err := tunnel.ParseSigner(req.PrivateKey, req.Passphrase)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid private key: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	key := &models.SSHKey{
+		Name:        req.Name,
+		PrivateKey:  req.PrivateKey,
+		Passphrase:  req.Passphrase,
+		PublicKey:   string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		Description: req.Description,
+	}
+
+	err = h.db.Create(key).Error
+	if err != nil {
+		h.logger.Error("failed to create SSH key", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create SSH key: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Data:    key,
+	})
+}
+
+func (h *Handler) ListSSHKeys(c echo.Context) error {
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var keys []models.SSHKey
+	err := h.db.Find(&keys).Error
+	if err != nil {
+		h.logger.Error("failed to fetch SSH keys", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch SSH keys: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    keys,
+	})
+}
+
+func (h *Handler) GetSSHKey(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid SSH key ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var key models.SSHKey
+	err = h.db.First(&key, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "SSH key not found: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    key,
+	})
+}
+
+func (h *Handler) UpdateSSHKey(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid SSH key ID: " + err.Error(),
+		})
+	}
+
+	var req models.CreateSSHKeyRequest
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	signer, err := tunnel.ParseSigner(req.PrivateKey, req.Passphrase)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid private key: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var key models.SSHKey
+	err = h.db.First(&key, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "SSH key not found: " + err.Error(),
+		})
+	}
+
+	needTunnelRestart := key.PrivateKey != req.PrivateKey || key.Passphrase != req.Passphrase
+
+	key.Name = req.Name
+	key.PrivateKey = req.PrivateKey
+	key.Passphrase = req.Passphrase
+	key.PublicKey = string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	key.Fingerprint = ssh.FingerprintSHA256(signer.PublicKey())
+	key.Description = req.Description
+
+	err = h.db.Save(&key).Error
+	if err != nil {
+		h.logger.Error("failed to update SSH key", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update SSH key: " + err.Error(),
+		})
+	}
+
+	if needTunnelRestart {
+		var hosts []models.Host
+		err = h.db.Where("ssh_key_id = ?", key.ID).Find(&hosts).Error
+		if err != nil {
+			h.logger.Error("failed to fetch Hosts", zap.Error(err))
+			return c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to fetch Hosts: " + err.Error(),
+			})
+		}
+
+		for _, host := range hosts {
+			err = h.restartHostTunnels(&host)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, models.Response{
+					Success: false,
+					Error:   "Failed to restart tunnels: " + err.Error(),
+				})
+			}
+			h.restartJumpDependents(host.ID)
+		}
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    key,
+	})
+}
+
+func (h *Handler) DeleteSSHKey(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid SSH key ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var key models.SSHKey
+	err = h.db.First(&key, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Success: false,
+				Error:   "SSH key not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch SSH key: " + err.Error(),
+		})
+	}
+
+	var count int64
+	err = h.db.Model(&models.Host{}).Where("ssh_key_id = ?", key.ID).Count(&count).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to check SSH key usage: " + err.Error(),
+		})
+	}
+	if count > 0 {
+		return c.JSON(http.StatusConflict, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("SSH key is used by %d Host(s)", count),
+		})
+	}
+
+	err = h.db.Delete(&key).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete SSH key: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    "SSH key deleted successfully",
+	})
+}
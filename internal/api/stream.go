@@ -0,0 +1,394 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// streamKeepAliveInterval is how often an idle Server-Sent Events stream sends a comment
+// so that proxies do not close it.
+const streamKeepAliveInterval = 30 * time.Second
+
+// streamTokenCheckInterval is how often a status stream checks that its API token was not revoked.
+const streamTokenCheckInterval = 10 * time.Second
+
+// statusMessage is sent to status stream subscribers. Type is "snapshot" for the status of every
+// tunnel sent first, and "update" for each tunnel status change after it.
+type statusMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// statusFilter selects the tunnels sent to a status stream.
+type statusFilter struct {
+	hostID   uint
+	spID     uint
+	statuses map[string]bool
+}
+
+func (f *statusFilter) match(t models.Tunnel) bool {
+	if f.hostID != 0 && t.HostID != f.hostID {
+		return false
+	}
+	if f.spID != 0 && t.SPID != f.spID {
+		return false
+	}
+	if len(f.statuses) > 0 && !f.statuses[t.Status] {
+		return false
+	}
+
+	return true
+}
+
+// parseStatusFilter reads the host_id, sp_id and status query parameters of a status stream.
+// status accepts a comma separated list of statuses.
+func (h *Handler) parseStatusFilter(c echo.Context) (*statusFilter, error) {
+	filter := &statusFilter{}
+
+	if param := c.QueryParam("host_id"); param != "" {
+		hostID, err := strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Host ID: %w", err)
+		}
+		filter.hostID = uint(hostID)
+	}
+	if param := c.QueryParam("sp_id"); param != "" {
+		spID, err := strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid service port ID: %w", err)
+		}
+		filter.spID = uint(spID)
+	}
+	if param := c.QueryParam("status"); param != "" {
+		filter.statuses = make(map[string]bool)
+		for _, status := range strings.Split(param, ",") {
+			status = strings.TrimSpace(status)
+			if status != "" {
+				filter.statuses[status] = true
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// streamAccess checks the access of a status stream's token for as long as the stream is open,
+// since the token can be revoked or expire and Hosts can move in and out of its host groups.
+type streamAccess struct {
+	h     *Handler
+	c     echo.Context
+	token *models.APIToken
+}
+
+func (h *Handler) newStreamAccess(c echo.Context) *streamAccess {
+	return &streamAccess{h: h, c: c, token: auth.FromContext(c)}
+}
+
+// valid reports whether the token still exists and has not expired.
+func (a *streamAccess) valid() (bool, error) {
+	if a.token == nil {
+		return true, nil
+	}
+	if a.token.ExpiresAt != nil && !time.Now().Before(*a.token.ExpiresAt) {
+		return false, nil
+	}
+
+	var count int64
+	err := a.h.db.Model(&models.APIToken{}).Where("id = ?", a.token.ID).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch API token: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// visible reports whether the token can currently access the Host.
+func (a *streamAccess) visible(hostID uint) (bool, error) {
+	if !auth.Scoped(a.token) {
+		return true, nil
+	}
+
+	var count int64
+	err := a.h.hostQuery(a.c).Model(&models.Host{}).Where("id = ?", hostID).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch Host: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// sendUpdate reports whether an update of the tunnel is sent to the stream.
+func (a *streamAccess) sendUpdate(filter *statusFilter, t models.Tunnel) bool {
+	if !filter.match(t) {
+		return false
+	}
+
+	visible, err := a.visible(t.HostID)
+	if err != nil {
+		a.h.logger.Warn("failed to check tunnel visibility for status stream", zap.Error(err))
+		return false
+	}
+
+	return visible
+}
+
+// checkToken reports whether the stream may go on, logging why it may not.
+func (a *streamAccess) checkToken() bool {
+	valid, err := a.valid()
+	if err != nil {
+		a.h.logger.Warn("failed to check API token of status stream", zap.Error(err))
+		return true
+	}
+	if !valid {
+		a.h.logger.Debug("API token of status stream was revoked or expired, closing stream")
+	}
+
+	return valid
+}
+
+// statusSnapshot returns the status of the tunnels matching filter in the format of GetStatus.
+func (h *Handler) statusSnapshot(c echo.Context, filter *statusFilter) (map[string]interface{}, error) {
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	visible, err := h.visibleHostIDs(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnels, err := h.manager.GetAllTunnels()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]models.Tunnel, 0, len(*tunnels))
+	var connectedTunnels int
+	for _, t := range *tunnels {
+		if visible != nil && !visible[t.HostID] {
+			continue
+		}
+		if !filter.match(t) {
+			continue
+		}
+		filtered = append(filtered, t)
+		if t.Status == "connected" {
+			connectedTunnels++
+		}
+	}
+
+	return map[string]interface{}{
+		"total_tunnels":     len(filtered),
+		"connected_tunnels": connectedTunnels,
+		"tunnels":           filtered,
+	}, nil
+}
+
+// StreamStatus sends the status of every tunnel and then each tunnel status change as Server-Sent Events.
+// The stream ends when the client falls too far behind, after which the client should reconnect,
+// or when its API token is revoked or expires.
+func (h *Handler) StreamStatus(c echo.Context) error {
+	filter, err := h.parseStatusFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	access := h.newStreamAccess(c)
+	updates, unsubscribe := h.manager.SubscribeStatus()
+	defer unsubscribe()
+
+	snapshot, err := h.statusSnapshot(c, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch tunnel status: " + err.Error(),
+		})
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	writeEvent := func(event string, data interface{}) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, payload)
+		if err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+
+	err = writeEvent("snapshot", snapshot)
+	if err != nil {
+		return nil
+	}
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+	tokenCheck := time.NewTicker(streamTokenCheckInterval)
+	defer tokenCheck.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-tokenCheck.C:
+			if !access.checkToken() {
+				return nil
+			}
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(res, ": keepalive\n\n")
+			if err != nil {
+				return nil
+			}
+			res.Flush()
+		case t, ok := <-updates:
+			if !ok {
+				h.logger.Debug("status stream subscriber fell behind, closing stream")
+				return nil
+			}
+			if !access.sendUpdate(filter, t) {
+				continue
+			}
+			err = writeEvent("update", t)
+			if err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// checkWebSocketOrigin accepts WebSocket requests from the API's own origin and from the CORS
+// allowed origins. WebSocket requests are not subject to CORS, so without this check any page
+// opened in a browser holding an API token could read the status stream. Clients other than
+// browsers may leave the Origin header out.
+func (h *Handler) checkWebSocketOrigin(req *http.Request) error {
+	origin := req.Header.Get(echo.HeaderOrigin)
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, req.Host) {
+		return nil
+	}
+	for _, allowed := range h.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+// selectWebSocketProtocol returns the subprotocol answered to a client offering protocols:
+// auth.WebSocketProtocol when offered, so that a token offered along with it is never echoed back.
+func selectWebSocketProtocol(protocols []string) []string {
+	for _, protocol := range protocols {
+		if protocol == auth.WebSocketProtocol {
+			return []string{protocol}
+		}
+	}
+
+	return nil
+}
+
+// StatusWebSocket sends the same messages as StreamStatus over a WebSocket, as JSON statusMessages.
+func (h *Handler) StatusWebSocket(c echo.Context) error {
+	filter, err := h.parseStatusFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			err := h.checkWebSocketOrigin(req)
+			if err != nil {
+				h.logger.Debug("rejected WebSocket handshake", zap.Error(err))
+				return err
+			}
+
+			config.Protocol = selectWebSocketProtocol(config.Protocol)
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			access := h.newStreamAccess(c)
+			updates, unsubscribe := h.manager.SubscribeStatus()
+			defer unsubscribe()
+
+			snapshot, err := h.statusSnapshot(c, filter)
+			if err != nil {
+				h.logger.Error("failed to fetch tunnel status", zap.Error(err))
+				return
+			}
+
+			err = websocket.JSON.Send(ws, statusMessage{Type: "snapshot", Data: snapshot})
+			if err != nil {
+				return
+			}
+
+			// Messages from the client are ignored; reading detects when it goes away.
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			tokenCheck := time.NewTicker(streamTokenCheckInterval)
+			defer tokenCheck.Stop()
+
+			for {
+				select {
+				case <-closed:
+					return
+				case <-tokenCheck.C:
+					if !access.checkToken() {
+						return
+					}
+				case t, ok := <-updates:
+					if !ok {
+						h.logger.Debug("status stream subscriber fell behind, closing WebSocket")
+						return
+					}
+					if !access.sendUpdate(filter, t) {
+						continue
+					}
+					err = websocket.JSON.Send(ws, statusMessage{Type: "update", Data: t})
+					if err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// ListTraffic returns the cumulative traffic of every tunnel, including tunnels that are no longer running.
+// The results can be filtered with the host_id and sp_id query parameters.
+func (h *Handler) ListTraffic(c echo.Context) error {
+	var hostID, spID uint64
+	var err error
+	if param := c.QueryParam("host_id"); param != "" {
+		hostID, err = strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid Host ID: " + err.Error(),
+			})
+		}
+	}
+	if param := c.QueryParam("sp_id"); param != "" {
+		spID, err = strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid service port ID: " + err.Error(),
+			})
+		}
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	records, err := h.manager.ListTraffic()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch tunnel traffic: " + err.Error(),
+		})
+	}
+
+	visible, err := h.visibleHostIDs(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch tunnel traffic: " + err.Error(),
+		})
+	}
+
+	filtered := make([]models.TunnelTraffic, 0, len(records))
+	var bytesIn, bytesOut, connections int64
+	for _, record := range records {
+		if visible != nil && !visible[record.HostID] {
+			continue
+		}
+		if hostID != 0 && record.HostID != uint(hostID) {
+			continue
+		}
+		if spID != 0 && record.SPID != uint(spID) {
+			continue
+		}
+
+		filtered = append(filtered, record)
+		bytesIn += record.BytesIn
+		bytesOut += record.BytesOut
+		connections += record.Connections
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"total_bytes_in":    bytesIn,
+			"total_bytes_out":   bytesOut,
+			"total_connections": connections,
+			"traffic":           filtered,
+		},
+	})
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var errHostDisabled = errors.New("host is disabled")
+
+// tunnelAction is an operation on the tunnel of one host and service port pair.
+type tunnelAction struct {
+	name string
+	done string
+	run  func(h *Handler, host *models.Host, sp *models.ServicePort) error
+}
+
+var (
+	startAction = tunnelAction{
+		name: "start",
+		done: "Tunnel started",
+		run: func(h *Handler, host *models.Host, sp *models.ServicePort) error {
+			if !host.Enabled {
+				return errHostDisabled
+			}
+			return h.manager.ReleaseTunnel(host, sp)
+		},
+	}
+	stopAction = tunnelAction{
+		name: "stop",
+		done: "Tunnel stopped",
+		run: func(h *Handler, host *models.Host, sp *models.ServicePort) error {
+			return h.manager.HoldTunnel(host, sp)
+		},
+	}
+	restartAction = tunnelAction{
+		name: "restart",
+		done: "Tunnel restarted",
+		run: func(h *Handler, host *models.Host, sp *models.ServicePort) error {
+			if !host.Enabled {
+				return errHostDisabled
+			}
+			return h.manager.RestartTunnel(host, sp)
+		},
+	}
+	retryAction = tunnelAction{
+		name: "retry",
+		done: "Tunnel retry started",
+		run: func(h *Handler, host *models.Host, sp *models.ServicePort) error {
+			if !host.Enabled {
+				return errHostDisabled
+			}
+			return h.manager.RetryTunnel(host, sp)
+		},
+	}
+)
+
+// isTunnelConflict reports whether err rejects an action because of the current state of the tunnel or its host.
+func isTunnelConflict(err error) bool {
+	return errors.Is(err, errHostDisabled) ||
+		errors.Is(err, tunnel.ErrTunnelRunning) ||
+		errors.Is(err, tunnel.ErrTunnelActive) ||
+		errors.Is(err, tunnel.ErrTunnelHeld) ||
+		errors.Is(err, tunnel.ErrTunnelClosed)
+}
+
+// fetchTunnelTarget returns the host and service port of a tunnel visible to the request's token.
+func (h *Handler) fetchTunnelTarget(c echo.Context, hostID, spID uint) (*models.Host, *models.ServicePort, error) {
+	err := h.checkHostsVisible(c, []uint{hostID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hosts, sps, err := h.fetchAssignmentTargets([]uint{hostID}, []uint{spID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	assigned, err := h.manager.IsAssigned(hostID, &sps[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if !assigned {
+		return nil, nil, &notFoundError{msg: "Service port is not assigned to the host"}
+	}
+
+	return &hosts[0], &sps[0], nil
+}
+
+// tunnelTargets returns the host and service port pairs of the tunnels matching req among the hosts
+// visible to the request's token. Omitted host or service port IDs match every host or service port.
+func (h *Handler) tunnelTargets(c echo.Context, req *models.TunnelControlRequest) ([]models.Host, map[uint][]models.ServicePort, error) {
+	var hosts []models.Host
+	if len(req.HostIDs) > 0 {
+		err := h.checkHostsVisible(c, req.HostIDs)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hosts, _, err = h.fetchAssignmentTargets(req.HostIDs, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		err := h.hostQuery(c).Find(&hosts).Error
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch Hosts: %w", err)
+		}
+	}
+
+	spIDs := make(map[uint]bool)
+	if len(req.SPIDs) > 0 {
+		_, sps, err := h.fetchAssignmentTargets(nil, req.SPIDs)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, sp := range sps {
+			spIDs[sp.ID] = true
+		}
+	}
+
+	targets := make(map[uint][]models.ServicePort)
+	for _, host := range hosts {
+		sps, err := h.manager.ServicePortsForHost(host.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, sp := range sps {
+			if len(spIDs) == 0 || spIDs[sp.ID] {
+				targets[host.ID] = append(targets[host.ID], sp)
+			}
+		}
+	}
+
+	return hosts, targets, nil
+}
+
+func (h *Handler) controlTunnel(c echo.Context, action tunnelAction) error {
+	hostID, spID, err := parseAssignmentParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	host, sp, err := h.fetchTunnelTarget(c, hostID, spID)
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	err = action.run(h, host, sp)
+	if err != nil {
+		if isTunnelConflict(err) {
+			return c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   "Failed to " + action.name + " tunnel: " + err.Error(),
+			})
+		}
+		h.logger.Error("failed to "+action.name+" tunnel",
+			zap.Error(err),
+			zap.String("host_ip", host.IP),
+			zap.Int("service_port", sp.ServicePort))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to " + action.name + " tunnel: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    action.done,
+	})
+}
+
+func (h *Handler) controlTunnels(c echo.Context, action tunnelAction) error {
+	var req models.TunnelControlRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	hosts, targets, err := h.tunnelTargets(c, &req)
+	if err != nil {
+		return assignmentErrorResponse(c, err)
+	}
+
+	results := []models.TunnelControlResult{}
+	for _, host := range hosts {
+		for _, sp := range targets[host.ID] {
+			result := models.TunnelControlResult{
+				HostID: host.ID,
+				SPID:   sp.ID,
+			}
+
+			err = action.run(h, &host, &sp)
+			if err != nil {
+				if !isTunnelConflict(err) {
+					h.logger.Warn("failed to "+action.name+" tunnel",
+						zap.Error(err),
+						zap.String("host_ip", host.IP),
+						zap.Int("service_port", sp.ServicePort))
+				}
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    results,
+	})
+}
+
+// StartTunnel starts a tunnel that is not running, including one stopped with StopTunnel.
+func (h *Handler) StartTunnel(c echo.Context) error {
+	return h.controlTunnel(c, startAction)
+}
+
+// StopTunnel stops a tunnel and keeps it stopped, also across restarts, until it is started again.
+func (h *Handler) StopTunnel(c echo.Context) error {
+	return h.controlTunnel(c, stopAction)
+}
+
+// RestartTunnel stops a tunnel and starts it again without touching the other tunnels of its host.
+func (h *Handler) RestartTunnel(c echo.Context) error {
+	return h.controlTunnel(c, restartAction)
+}
+
+// RetryTunnel starts a tunnel again without changing its host, after it stopped retrying by itself
+// (failed, auth_failed, host_key_failed) or could not open its listener (bind_failed).
+// When the host connection gave up, every tunnel of the host is retried with it.
+func (h *Handler) RetryTunnel(c echo.Context) error {
+	return h.controlTunnel(c, retryAction)
+}
+
+// BulkStartTunnels starts the tunnels matching the host_ids and sp_ids filters of the request.
+func (h *Handler) BulkStartTunnels(c echo.Context) error {
+	return h.controlTunnels(c, startAction)
+}
+
+// BulkStopTunnels stops the tunnels matching the host_ids and sp_ids filters of the request.
+func (h *Handler) BulkStopTunnels(c echo.Context) error {
+	return h.controlTunnels(c, stopAction)
+}
+
+// BulkRestartTunnels restarts the tunnels matching the host_ids and sp_ids filters of the request.
+func (h *Handler) BulkRestartTunnels(c echo.Context) error {
+	return h.controlTunnels(c, restartAction)
+}
+
+// BulkRetryTunnels retries the tunnels matching the host_ids and sp_ids filters of the request.
+func (h *Handler) BulkRetryTunnels(c echo.Context) error {
+	return h.controlTunnels(c, retryAction)
+}
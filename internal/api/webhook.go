@@ -0,0 +1,325 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeliveryLimit = 100
+	maxDeliveryLimit     = 1000
+)
+
+// applyWebhookRequest sets the fields of webhook from req. Omitted optional fields keep their current value,
+// and an empty secret disables signing.
+func applyWebhookRequest(webhook *models.Webhook, req *models.WebhookRequest) {
+	webhook.Name = req.Name
+	webhook.URL = req.URL
+	webhook.Events = req.Events
+	webhook.Description = req.Description
+	if req.Secret != nil {
+		webhook.Secret = *req.Secret
+	}
+	if req.MaxRetries != nil {
+		webhook.MaxRetries = *req.MaxRetries
+	}
+	if req.RetryIntervalSec != nil {
+		webhook.RetryIntervalSec = *req.RetryIntervalSec
+	}
+	if req.Enabled != nil {
+		webhook.Enabled = *req.Enabled
+	}
+}
+
+func setWebhookSigned(webhooks []models.Webhook) {
+	for i := range webhooks {
+		webhooks[i].Signed = webhooks[i].Secret != ""
+	}
+}
+
+func (h *Handler) CreateWebhook(c echo.Context) error {
+	var req models.WebhookRequest
+	err := c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	webhook := models.Webhook{
+		MaxRetries:       3,
+		RetryIntervalSec: 10,
+		Enabled:          true,
+	}
+	applyWebhookRequest(&webhook, &req)
+
+	// Create replaces zero values of fields with a default by the database default,
+	// so enabled and max_retries are written again.
+	enabled, maxRetries := webhook.Enabled, webhook.MaxRetries
+	err = h.db.Create(&webhook).Error
+	if err == nil {
+		err = h.db.Model(&webhook).Updates(map[string]interface{}{
+			"enabled":     enabled,
+			"max_retries": maxRetries,
+		}).Error
+	}
+	if err != nil {
+		h.logger.Error("failed to create webhook", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create webhook: " + err.Error(),
+		})
+	}
+	webhook.Signed = webhook.Secret != ""
+
+	return c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Data:    webhook,
+	})
+}
+
+func (h *Handler) ListWebhooks(c echo.Context) error {
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var webhooks []models.Webhook
+	err := h.db.Find(&webhooks).Error
+	if err != nil {
+		h.logger.Error("failed to fetch webhooks", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch webhooks: " + err.Error(),
+		})
+	}
+	setWebhookSigned(webhooks)
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    webhooks,
+	})
+}
+
+func (h *Handler) GetWebhook(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid webhook ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var webhook models.Webhook
+	err = h.db.First(&webhook, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Webhook not found: " + err.Error(),
+		})
+	}
+	webhook.Signed = webhook.Secret != ""
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    webhook,
+	})
+}
+
+func (h *Handler) UpdateWebhook(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid webhook ID: " + err.Error(),
+		})
+	}
+
+	var req models.WebhookRequest
+	err = c.Bind(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body: " + err.Error(),
+		})
+	}
+
+	err = c.Validate(&req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Validation failed: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var webhook models.Webhook
+	err = h.db.First(&webhook, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Webhook not found: " + err.Error(),
+		})
+	}
+
+	applyWebhookRequest(&webhook, &req)
+
+	err = h.db.Save(&webhook).Error
+	if err != nil {
+		h.logger.Error("failed to update webhook", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update webhook: " + err.Error(),
+		})
+	}
+	webhook.Signed = webhook.Secret != ""
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    webhook,
+	})
+}
+
+func (h *Handler) DeleteWebhook(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid webhook ID: " + err.Error(),
+		})
+	}
+
+	h.rwLock.Lock()
+	defer h.rwLock.Unlock()
+
+	var webhook models.Webhook
+	err = h.db.First(&webhook, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, models.Response{
+				Success: false,
+				Error:   "Webhook not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch webhook: " + err.Error(),
+		})
+	}
+
+	tx := h.db.Begin()
+	err = tx.Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to start transaction: " + err.Error(),
+		})
+	}
+
+	err = tx.Where("webhook_id = ?", webhook.ID).Delete(&models.WebhookDelivery{}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete webhook deliveries: " + err.Error(),
+		})
+	}
+
+	err = tx.Delete(&webhook).Error
+	if err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete webhook: " + err.Error(),
+		})
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to commit transaction: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    "Webhook deleted successfully",
+	})
+}
+
+// ListWebhookDeliveries returns the delivery attempts of a webhook, newest first.
+// The limit query parameter caps the number of attempts and event_id selects the attempts of one event.
+func (h *Handler) ListWebhookDeliveries(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid webhook ID: " + err.Error(),
+		})
+	}
+
+	limit := defaultDeliveryLimit
+	if param := c.QueryParam("limit"); param != "" {
+		limit, err = strconv.Atoi(param)
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			return c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid limit: must be between 1 and " + strconv.Itoa(maxDeliveryLimit),
+			})
+		}
+	}
+
+	h.rwLock.RLock()
+	defer h.rwLock.RUnlock()
+
+	var webhook models.Webhook
+	err = h.db.First(&webhook, id).Error
+	if err != nil {
+		return c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Webhook not found: " + err.Error(),
+		})
+	}
+
+	query := h.db.Where("webhook_id = ?", webhook.ID)
+	if eventID := c.QueryParam("event_id"); eventID != "" {
+		query = query.Where("event_id = ?", eventID)
+	}
+
+	var deliveries []models.WebhookDelivery
+	err = query.Order("created_at DESC, id DESC").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		h.logger.Error("failed to fetch webhook deliveries", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch webhook deliveries: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    deliveries,
+	})
+}
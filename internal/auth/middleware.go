@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ContextKey is the echo context key holding the authenticated *models.APIToken.
+const ContextKey = "api_token"
+
+// lastUsedInterval limits how often the last used time of a token is written.
+const lastUsedInterval = time.Minute
+
+// WebSocketProtocol is the WebSocket subprotocol of the API. Browsers cannot set the Authorization
+// header of a WebSocket request, so they offer it together with "bearer.<token>" instead, as in
+// new WebSocket(url, ["tunnel-manager", "bearer." + token]). Only WebSocketProtocol is selected,
+// so the token is not sent back.
+const WebSocketProtocol = "tunnel-manager"
+
+// webSocketTokenPrefix starts the WebSocket subprotocol carrying an API token.
+const webSocketTokenPrefix = "bearer."
+
+// requestToken returns the API token of a request, from its "Authorization: Bearer <token>" header
+// or, for WebSocket requests without one, from its subprotocols.
+func requestToken(r *http.Request) string {
+	if authorization := r.Header.Get(echo.HeaderAuthorization); authorization != "" {
+		scheme, token, found := strings.Cut(authorization, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+
+	if !strings.EqualFold(r.Header.Get(echo.HeaderUpgrade), "websocket") {
+		return ""
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			token, found := strings.CutPrefix(strings.TrimSpace(protocol), webSocketTokenPrefix)
+			if found {
+				return token
+			}
+		}
+	}
+
+	return ""
+}
+
+// Middleware requires every request to carry a valid API token in an
+// "Authorization: Bearer <token>" header, or in the subprotocols of a WebSocket request
+// as described for WebSocketProtocol.
+func Middleware(db *gorm.DB, logger *zap.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := requestToken(c.Request())
+			if token == "" {
+				return unauthorized(c, "Missing API token")
+			}
+
+			apiToken, err := Authenticate(db, token)
+			if err != nil {
+				if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrExpiredToken) {
+					return unauthorized(c, "Unauthorized: "+err.Error())
+				}
+				logger.Error("failed to authenticate API token", zap.Error(err))
+				return c.JSON(http.StatusInternalServerError, models.Response{
+					Success: false,
+					Error:   "Failed to authenticate API token: " + err.Error(),
+				})
+			}
+
+			now := time.Now()
+			if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > lastUsedInterval {
+				err = db.Model(apiToken).UpdateColumn("last_used_at", now).Error
+				if err != nil {
+					logger.Warn("failed to update API token last used time", zap.Uint("token_id", apiToken.ID), zap.Error(err))
+				}
+			}
+
+			c.Set(ContextKey, apiToken)
+
+			return next(c)
+		}
+	}
+}
+
+func unauthorized(c echo.Context, message string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="tunnel-manager"`)
+	return c.JSON(http.StatusUnauthorized, models.Response{
+		Success: false,
+		Error:   message,
+	})
+}
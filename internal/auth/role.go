@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Roles granted to API tokens. Each role includes the permissions of the roles before it.
+const (
+	// RoleViewer can read hosts, service ports, assignments and tunnel status.
+	RoleViewer = "viewer"
+	// RoleOperator can also enable and disable hosts.
+	RoleOperator = "operator"
+	// RoleAdmin can also create, change and delete hosts and their settings.
+	RoleAdmin = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ValidRole reports whether role is a known role.
+func ValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// HasRole reports whether token is granted role or a role above it.
+func HasRole(token *models.APIToken, role string) bool {
+	return token != nil && roleLevels[token.Role] >= roleLevels[role]
+}
+
+// Scoped reports whether token is limited to the hosts of its host groups.
+// Tokens without host groups can access every host.
+func Scoped(token *models.APIToken) bool {
+	return token != nil && len(token.HostGroupIDs) > 0
+}
+
+// InScope reports whether token can access a host in the given host group.
+// Hosts without a group are only accessible with tokens that are not scoped.
+func InScope(token *models.APIToken, hostGroupID *uint) bool {
+	if !Scoped(token) {
+		return true
+	}
+	if hostGroupID == nil {
+		return false
+	}
+
+	for _, id := range token.HostGroupIDs {
+		if id == *hostGroupID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FromContext returns the API token that authenticated the request.
+func FromContext(c echo.Context) *models.APIToken {
+	token, _ := c.Get(ContextKey).(*models.APIToken)
+	return token
+}
+
+// Require rejects requests whose token is not granted role.
+func Require(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !HasRole(FromContext(c), role) {
+				return c.JSON(http.StatusForbidden, models.Response{
+					Success: false,
+					Error:   "Forbidden: requires " + role + " role",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// RequireGlobal rejects requests whose token is not granted role or is scoped to host groups.
+// It guards resources shared by every host, such as service ports, SSH keys and API tokens.
+func RequireGlobal(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := FromContext(c)
+			if !HasRole(token, role) {
+				return c.JSON(http.StatusForbidden, models.Response{
+					Success: false,
+					Error:   "Forbidden: requires " + role + " role",
+				})
+			}
+			if Scoped(token) {
+				return c.JSON(http.StatusForbidden, models.Response{
+					Success: false,
+					Error:   "Forbidden: requires a token that is not scoped to host groups",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
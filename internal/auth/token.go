@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"gorm.io/gorm"
+)
+
+// TokenPrefix starts every API token, which makes leaked tokens easy to recognize.
+const TokenPrefix = "tm_"
+
+// tokenBytes is the number of random bytes in an API token.
+const tokenBytes = 32
+
+// prefixLength is the number of leading token characters kept in plain text
+// so that tokens can be told apart when listed.
+const prefixLength = len(TokenPrefix) + 8
+
+var (
+	ErrInvalidToken = errors.New("invalid API token")
+	ErrExpiredToken = errors.New("API token has expired")
+)
+
+// HashToken returns the hex encoded SHA-256 hash of token, which is what is stored in the database.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateToken returns a new random API token.
+func GenerateToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateToken stores a new API token and returns it with the plain text token set.
+// The plain text token is not stored and cannot be retrieved again.
+func CreateToken(db *gorm.DB, description, role string, hostGroupIDs []uint, expiresAt *time.Time) (*models.APIToken, error) {
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("invalid role: %s", role)
+	}
+
+	token, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	apiToken := &models.APIToken{
+		Prefix:       token[:prefixLength],
+		TokenHash:    HashToken(token),
+		Role:         role,
+		HostGroupIDs: hostGroupIDs,
+		Description:  description,
+		ExpiresAt:    expiresAt,
+	}
+
+	err = db.Create(apiToken).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API token: %w", err)
+	}
+	apiToken.Token = token
+
+	return apiToken, nil
+}
+
+// Authenticate returns the stored API token matching token.
+func Authenticate(db *gorm.DB, token string) (*models.APIToken, error) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+
+	var apiToken models.APIToken
+	err := db.Where("token_hash = ?", HashToken(token)).First(&apiToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("failed to fetch API token: %w", err)
+	}
+
+	if apiToken.ExpiresAt != nil && !time.Now().Before(*apiToken.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+
+	return &apiToken, nil
+}
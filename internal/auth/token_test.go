@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/jollaman999/tunnel-manager/internal/database"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = database.MigrateUp(db)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	return db
+}
+
+func TestCreateToken(t *testing.T) {
+	db := newTestDB(t)
+
+	apiToken, err := CreateToken(db, "ci", RoleViewer, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateToken() error = %v", err)
+	}
+	if !strings.HasPrefix(apiToken.Token, TokenPrefix) || apiToken.Prefix != apiToken.Token[:prefixLength] {
+		t.Errorf("token %q has prefix %q", apiToken.Token, apiToken.Prefix)
+	}
+
+	var stored models.APIToken
+	err = db.First(&stored, apiToken.ID).Error
+	if err != nil {
+		t.Fatalf("failed to fetch token: %v", err)
+	}
+	if stored.TokenHash != HashToken(apiToken.Token) || stored.TokenHash == apiToken.Token {
+		t.Errorf("stored hash = %q, want the SHA-256 hash of the token", stored.TokenHash)
+	}
+	if stored.Role != RoleViewer || stored.Description != "ci" {
+		t.Errorf("stored token has role %q and description %q", stored.Role, stored.Description)
+	}
+
+	_, err = CreateToken(db, "unknown role", "root", nil, nil)
+	if err == nil {
+		t.Errorf("CreateToken() with an unknown role succeeded, want error")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	db := newTestDB(t)
+
+	valid, err := CreateToken(db, "valid", RoleAdmin, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateToken() error = %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	expiring, err := CreateToken(db, "expiring", RoleAdmin, nil, &future)
+	if err != nil {
+		t.Fatalf("CreateToken() error = %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	expired, err := CreateToken(db, "expired", RoleAdmin, nil, &past)
+	if err != nil {
+		t.Fatalf("CreateToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantID  uint
+		wantErr error
+	}{
+		{name: "valid", token: valid.Token, wantID: valid.ID},
+		{name: "not yet expired", token: expiring.Token, wantID: expiring.ID},
+		{name: "expired", token: expired.Token, wantErr: ErrExpiredToken},
+		{name: "unknown", token: TokenPrefix + "unknown", wantErr: ErrInvalidToken},
+		{name: "stored hash", token: HashToken(valid.Token), wantErr: ErrInvalidToken},
+		{name: "without prefix", token: strings.TrimPrefix(valid.Token, TokenPrefix), wantErr: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiToken, err := Authenticate(db, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && apiToken.ID != tt.wantID {
+				t.Errorf("Authenticate() = token %d, want %d", apiToken.ID, tt.wantID)
+			}
+		})
+	}
+}
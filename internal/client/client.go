@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+const (
+	// TokenEnv is the environment variable holding the API token of command-line clients.
+	TokenEnv = "TUNNEL_MANAGER_TOKEN"
+	// DefaultServer is the URL command-line clients use when no server is given.
+	DefaultServer = "http://127.0.0.1:8888"
+)
+
+// Client calls the REST API of a tunnel-manager server.
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http: &http.Client{
+			Timeout: 5 * time.Minute,
+		},
+	}
+}
+
+// Do sends body to the API path and decodes the data of the response into out.
+// A response that is not successful is returned as an error with its message.
+func (c *Client) Do(method, path string, query url.Values, contentType string, body io.Reader, out interface{}) error {
+	u := c.baseURL + "/api" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var result struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+		Error   string          `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("invalid response from %s (HTTP %d): %w", u, resp.StatusCode, err)
+	}
+	if !result.Success {
+		msg := result.Error
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return fmt.Errorf("%s (HTTP %d)", msg, resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(result.Data, out)
+}
+
+// Status is the tunnel status returned by /status and /status/:hostId.
+type Status struct {
+	Host             *models.Host    `json:"host,omitempty"`
+	TotalTunnels     int             `json:"total_tunnels"`
+	ConnectedTunnels int             `json:"connected_tunnels"`
+	Tunnels          []models.Tunnel `json:"tunnels"`
+}
+
+func (c *Client) doJSON(method, path string, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	return c.Do(method, path, nil, contentType, body, out)
+}
+
+func idPath(path string, id uint) string {
+	return path + "/" + strconv.FormatUint(uint64(id), 10)
+}
+
+func (c *Client) ListHosts() ([]models.Host, error) {
+	var hosts []models.Host
+	err := c.doJSON(http.MethodGet, "/host", nil, &hosts)
+	return hosts, err
+}
+
+func (c *Client) GetHost(id uint) (*models.Host, error) {
+	var host models.Host
+	err := c.doJSON(http.MethodGet, idPath("/host", id), nil, &host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &host, nil
+}
+
+func (c *Client) CreateHost(req *models.CreateHostRequest) (*models.Host, error) {
+	var host models.Host
+	err := c.doJSON(http.MethodPost, "/host", req, &host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &host, nil
+}
+
+func (c *Client) UpdateHost(id uint, req *models.UpdateHostRequest) (*models.Host, error) {
+	var host models.Host
+	err := c.doJSON(http.MethodPut, idPath("/host", id), req, &host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &host, nil
+}
+
+func (c *Client) DeleteHost(id uint) error {
+	return c.doJSON(http.MethodDelete, idPath("/host", id), nil, nil)
+}
+
+func (c *Client) ListServicePorts() ([]models.ServicePort, error) {
+	var sps []models.ServicePort
+	err := c.doJSON(http.MethodGet, "/service-port", nil, &sps)
+	return sps, err
+}
+
+func (c *Client) GetServicePort(id uint) (*models.ServicePort, error) {
+	var sp models.ServicePort
+	err := c.doJSON(http.MethodGet, idPath("/service-port", id), nil, &sp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sp, nil
+}
+
+func (c *Client) CreateServicePort(req *models.CreateServicePortRequest) (*models.ServicePort, error) {
+	var sp models.ServicePort
+	err := c.doJSON(http.MethodPost, "/service-port", req, &sp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sp, nil
+}
+
+// UpdateServicePort replaces every field of the service port with req.
+func (c *Client) UpdateServicePort(id uint, req *models.CreateServicePortRequest) (*models.ServicePort, error) {
+	var sp models.ServicePort
+	err := c.doJSON(http.MethodPut, idPath("/service-port", id), req, &sp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sp, nil
+}
+
+func (c *Client) DeleteServicePort(id uint) error {
+	return c.doJSON(http.MethodDelete, idPath("/service-port", id), nil, nil)
+}
+
+// Status returns the status of every tunnel, or of the tunnels of one Host when hostID is not 0.
+func (c *Client) Status(hostID uint) (*Status, error) {
+	path := "/status"
+	if hostID != 0 {
+		path = idPath(path, hostID)
+	}
+
+	var status Status
+	err := c.doJSON(http.MethodGet, path, nil, &status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// ControlTunnel runs action (start, stop, restart or retry) on the tunnel of a Host and service port.
+func (c *Client) ControlTunnel(action string, hostID, spID uint) error {
+	path := fmt.Sprintf("/tunnel/%d/%d/%s", hostID, spID, action)
+	return c.doJSON(http.MethodPost, path, nil, nil)
+}
+
+// ControlTunnels runs action on every tunnel matching req and returns the result of each.
+func (c *Client) ControlTunnels(action string, req *models.TunnelControlRequest) ([]models.TunnelControlResult, error) {
+	var results []models.TunnelControlResult
+	err := c.doJSON(http.MethodPost, "/tunnel/"+action, req, &results)
+	return results, err
+}
+
+// PlanInventory returns the changes applying the inventory in data would make.
+func (c *Client) PlanInventory(data []byte, prune bool) (*models.InventoryPlan, error) {
+	return c.inventory("/inventory/plan", data, prune)
+}
+
+// ApplyInventory applies the inventory in data and returns the changes it made.
+func (c *Client) ApplyInventory(data []byte, prune bool) (*models.InventoryPlan, error) {
+	return c.inventory("/inventory/apply", data, prune)
+}
+
+func (c *Client) inventory(path string, data []byte, prune bool) (*models.InventoryPlan, error) {
+	contentType := "application/yaml"
+	if json.Valid(data) {
+		contentType = "application/json"
+	}
+
+	var plan models.InventoryPlan
+	err := c.Do(http.MethodPost, path, url.Values{"prune": {fmt.Sprint(prune)}}, contentType, bytes.NewReader(data), &plan)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
@@ -6,24 +6,63 @@ import (
 	"os"
 )
 
+// DatabaseConfig selects the database driver and how to connect to it. Host, port, user, password
+// and name are used by mysql and postgres, ssl_mode by postgres and path by sqlite.
+type DatabaseConfig struct {
+	Driver      string `yaml:"driver"`
+	Host        string `yaml:"host"`
+	Port        int    `yaml:"port"`
+	User        string `yaml:"user"`
+	Password    string `yaml:"password"`
+	Name        string `yaml:"name"`
+	SSLMode     string `yaml:"ssl_mode"`
+	Path        string `yaml:"path"`
+	TimeoutSec  int    `yaml:"timeout_sec"`
+	AutoMigrate *bool  `yaml:"auto_migrate"`
+}
+
 type Config struct {
-	Database struct {
-		Host       string `yaml:"host"`
-		Port       int    `yaml:"port"`
-		User       string `yaml:"user"`
-		Password   string `yaml:"password"`
-		Name       string `yaml:"name"`
-		TimeoutSec int    `yaml:"timeout_sec"`
-	} `yaml:"database"`
+	Database DatabaseConfig `yaml:"database"`
 
 	API struct {
-		Port int `yaml:"port"`
+		Port               int      `yaml:"port"`
+		CORSAllowedOrigins []string `yaml:"cors_allowed_origins"`
 	} `yaml:"api"`
 
 	Monitoring struct {
-		IntervalSec int `yaml:"interval_sec"`
+		IntervalSec             int `yaml:"interval_sec"`
+		TrafficFlushIntervalSec int `yaml:"traffic_flush_interval_sec"`
+		EventRetentionDays      int `yaml:"event_retention_days"`
 	} `yaml:"monitoring"`
 
+	Reconnect struct {
+		InitialDelaySec int      `yaml:"initial_delay_sec"`
+		Multiplier      float64  `yaml:"multiplier"`
+		MaxDelaySec     int      `yaml:"max_delay_sec"`
+		Jitter          *float64 `yaml:"jitter"`
+		MaxAttempts     int      `yaml:"max_attempts"`
+	} `yaml:"reconnect"`
+
+	Webhook struct {
+		ReconnectingThresholdSec int `yaml:"reconnecting_threshold_sec"`
+	} `yaml:"webhook"`
+
+	Metrics struct {
+		Enabled      *bool `yaml:"enabled"`
+		RequireToken bool  `yaml:"require_token"`
+	} `yaml:"metrics"`
+
+	SSH struct {
+		TrustOnFirstUse *bool  `yaml:"trust_on_first_use"`
+		KnownHostsFile  string `yaml:"known_hosts_file"`
+	} `yaml:"ssh"`
+
+	Secrets struct {
+		Key              string   `yaml:"key"`
+		KeyFile          string   `yaml:"key_file"`
+		PreviousKeyFiles []string `yaml:"previous_key_files"`
+	} `yaml:"secrets"`
+
 	Logging struct {
 		Level  string `yaml:"level"`
 		Format string `yaml:"format"`
@@ -38,20 +77,29 @@ type Config struct {
 }
 
 func (c *Config) Validate() error {
-	if c.Database.Host == "" {
-		return fmt.Errorf("database host is required")
-	}
-	if c.Database.Port < 1 || c.Database.Port > 65535 {
-		return fmt.Errorf("invalid database port: %d", c.Database.Port)
-	}
-	if c.Database.User == "" {
-		return fmt.Errorf("database user is required")
-	}
-	if c.Database.Password == "" {
-		return fmt.Errorf("database password is required")
-	}
-	if c.Database.Name == "" {
-		return fmt.Errorf("database name is required")
+	switch c.Database.Driver {
+	case "mysql", "postgres":
+		if c.Database.Host == "" {
+			return fmt.Errorf("database host is required")
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			return fmt.Errorf("invalid database port: %d", c.Database.Port)
+		}
+		if c.Database.User == "" {
+			return fmt.Errorf("database user is required")
+		}
+		if c.Database.Password == "" {
+			return fmt.Errorf("database password is required")
+		}
+		if c.Database.Name == "" {
+			return fmt.Errorf("database name is required")
+		}
+	case "sqlite":
+		if c.Database.Path == "" {
+			return fmt.Errorf("database path is required")
+		}
+	default:
+		return fmt.Errorf("invalid database driver: %s", c.Database.Driver)
 	}
 	if c.Database.TimeoutSec <= 0 {
 		return fmt.Errorf("invalid database timeout: %d", c.Database.TimeoutSec)
@@ -60,10 +108,67 @@ func (c *Config) Validate() error {
 	if c.API.Port < 1 || c.API.Port > 65535 {
 		return fmt.Errorf("invalid API port: %d", c.API.Port)
 	}
+	for _, origin := range c.API.CORSAllowedOrigins {
+		if origin == "" {
+			return fmt.Errorf("invalid API CORS allowed origin: empty origin")
+		}
+	}
 
 	if c.Monitoring.IntervalSec <= 0 {
 		return fmt.Errorf("invalid monitoring interval: %d", c.Monitoring.IntervalSec)
 	}
+	if c.Monitoring.TrafficFlushIntervalSec <= 0 {
+		return fmt.Errorf("invalid traffic flush interval: %d", c.Monitoring.TrafficFlushIntervalSec)
+	}
+	if c.Monitoring.EventRetentionDays <= 0 {
+		return fmt.Errorf("invalid event retention: %d", c.Monitoring.EventRetentionDays)
+	}
+
+	if c.Reconnect.InitialDelaySec <= 0 {
+		return fmt.Errorf("invalid reconnect initial delay: %d", c.Reconnect.InitialDelaySec)
+	}
+	if c.Reconnect.Multiplier < 1 {
+		return fmt.Errorf("invalid reconnect multiplier: %g", c.Reconnect.Multiplier)
+	}
+	if c.Reconnect.MaxDelaySec < c.Reconnect.InitialDelaySec {
+		return fmt.Errorf("invalid reconnect max delay: %d", c.Reconnect.MaxDelaySec)
+	}
+	if *c.Reconnect.Jitter < 0 || *c.Reconnect.Jitter > 1 {
+		return fmt.Errorf("invalid reconnect jitter: %g", *c.Reconnect.Jitter)
+	}
+	if c.Reconnect.MaxAttempts < 0 {
+		return fmt.Errorf("invalid reconnect max attempts: %d", c.Reconnect.MaxAttempts)
+	}
+
+	if c.Webhook.ReconnectingThresholdSec <= 0 {
+		return fmt.Errorf("invalid webhook reconnecting threshold: %d", c.Webhook.ReconnectingThresholdSec)
+	}
+
+	if c.SSH.KnownHostsFile != "" {
+		_, err := os.Stat(c.SSH.KnownHostsFile)
+		if err != nil {
+			return fmt.Errorf("invalid known_hosts file: %w", err)
+		}
+	}
+
+	if c.Secrets.Key != "" && c.Secrets.KeyFile != "" {
+		return fmt.Errorf("only one of secrets key and key file can be set")
+	}
+	if c.Secrets.KeyFile != "" {
+		_, err := os.Stat(c.Secrets.KeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid secrets key file: %w", err)
+		}
+	}
+	if len(c.Secrets.PreviousKeyFiles) > 0 && c.Secrets.Key == "" && c.Secrets.KeyFile == "" {
+		return fmt.Errorf("secrets previous key files require a secrets key or key file")
+	}
+	for _, keyFile := range c.Secrets.PreviousKeyFiles {
+		_, err := os.Stat(keyFile)
+		if err != nil {
+			return fmt.Errorf("invalid secrets previous key file: %w", err)
+		}
+	}
 
 	validLevels := map[string]bool{
 		"debug":  true,
@@ -100,6 +205,57 @@ func (c *Config) Validate() error {
 }
 
 func (c *Config) setDefaults() {
+	if c.Database.Driver == "" {
+		c.Database.Driver = "mysql"
+	}
+	if c.Database.Port == 0 {
+		switch c.Database.Driver {
+		case "mysql":
+			c.Database.Port = 3306
+		case "postgres":
+			c.Database.Port = 5432
+		}
+	}
+	if c.Database.SSLMode == "" {
+		c.Database.SSLMode = "disable"
+	}
+	if c.Database.Path == "" && c.Database.Driver == "sqlite" {
+		c.Database.Path = "data/tunnel-manager.db"
+	}
+	if c.Database.AutoMigrate == nil {
+		autoMigrate := true
+		c.Database.AutoMigrate = &autoMigrate
+	}
+	if c.Monitoring.TrafficFlushIntervalSec == 0 {
+		c.Monitoring.TrafficFlushIntervalSec = 60
+	}
+	if c.Monitoring.EventRetentionDays == 0 {
+		c.Monitoring.EventRetentionDays = 30
+	}
+	if c.Reconnect.InitialDelaySec == 0 {
+		c.Reconnect.InitialDelaySec = c.Monitoring.IntervalSec
+	}
+	if c.Reconnect.Multiplier == 0 {
+		c.Reconnect.Multiplier = 2
+	}
+	if c.Reconnect.MaxDelaySec == 0 {
+		c.Reconnect.MaxDelaySec = max(300, c.Reconnect.InitialDelaySec)
+	}
+	if c.Reconnect.Jitter == nil {
+		jitter := 0.2
+		c.Reconnect.Jitter = &jitter
+	}
+	if c.Webhook.ReconnectingThresholdSec == 0 {
+		c.Webhook.ReconnectingThresholdSec = 60
+	}
+	if c.Metrics.Enabled == nil {
+		enabled := true
+		c.Metrics.Enabled = &enabled
+	}
+	if c.SSH.TrustOnFirstUse == nil {
+		trustOnFirstUse := true
+		c.SSH.TrustOnFirstUse = &trustOnFirstUse
+	}
 	if c.Logging.Level == "" {
 		c.Logging.Level = "info"
 	}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigDatabase(t *testing.T) {
+	const rest = `
+api:
+  port: 8888
+monitoring:
+  interval_sec: 5
+`
+
+	tests := []struct {
+		name     string
+		database string
+		want     DatabaseConfig
+		wantErr  bool
+	}{
+		{
+			name: "mysql by default",
+			database: `
+  host: db
+  user: tunnel
+  password: secret
+  name: tunnel
+  timeout_sec: 30`,
+			want: DatabaseConfig{Driver: "mysql", Host: "db", Port: 3306, User: "tunnel", Password: "secret", Name: "tunnel",
+				SSLMode: "disable", TimeoutSec: 30},
+		},
+		{
+			name: "postgres",
+			database: `
+  driver: postgres
+  host: db
+  user: tunnel
+  password: secret
+  name: tunnel
+  ssl_mode: require
+  timeout_sec: 30`,
+			want: DatabaseConfig{Driver: "postgres", Host: "db", Port: 5432, User: "tunnel", Password: "secret", Name: "tunnel",
+				SSLMode: "require", TimeoutSec: 30},
+		},
+		{
+			name: "sqlite needs no server",
+			database: `
+  driver: sqlite
+  timeout_sec: 30`,
+			want: DatabaseConfig{Driver: "sqlite", SSLMode: "disable", Path: "data/tunnel-manager.db", TimeoutSec: 30},
+		},
+		{
+			name: "mysql without host",
+			database: `
+  user: tunnel
+  password: secret
+  name: tunnel
+  timeout_sec: 30`,
+			wantErr: true,
+		},
+		{
+			name: "unknown driver",
+			database: `
+  driver: oracle
+  timeout_sec: 30`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(path, []byte("database:"+tt.database+rest), 0600)
+			if err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+
+			cfg, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got := cfg.Database
+			got.AutoMigrate = nil
+			if got != tt.want {
+				t.Errorf("LoadConfig() database = %+v, want %+v", got, tt.want)
+			}
+			if cfg.Database.AutoMigrate == nil || !*cfg.Database.AutoMigrate {
+				t.Errorf("LoadConfig() auto_migrate = %v, want true", cfg.Database.AutoMigrate)
+			}
+		})
+	}
+}
@@ -2,37 +2,84 @@ package database
 
 import (
 	"fmt"
-	"github.com/jollaman999/tunnel-manager/internal/models"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jollaman999/tunnel-manager/internal/config"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-	"time"
 )
 
-func NewDatabase(host string, port int, user, password, dbname string) (*gorm.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		user, password, host, port, dbname)
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// dialector returns the GORM dialector of the configured driver.
+func dialector(cfg *config.DatabaseConfig) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case DriverMySQL:
+		dsn := mysqldriver.NewConfig()
+		dsn.User = cfg.User
+		dsn.Passwd = cfg.Password
+		dsn.Net = "tcp"
+		dsn.Addr = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+		dsn.DBName = cfg.Name
+		dsn.Params = map[string]string{"charset": "utf8mb4"}
+		dsn.ParseTime = true
+		dsn.Loc = time.Local
+		return mysql.Open(dsn.FormatDSN()), nil
+	case DriverPostgres:
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(cfg.User, cfg.Password),
+			Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+			Path:     cfg.Name,
+			RawQuery: url.Values{"sslmode": {cfg.SSLMode}, "TimeZone": {"UTC"}}.Encode(),
+		}
+		return postgres.Open(dsn.String()), nil
+	case DriverSQLite:
+		err := os.MkdirAll(filepath.Dir(cfg.Path), 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+
+		// Writers wait for each other instead of failing, and transactions take the write lock
+		// when they begin so that they never fail to upgrade a read lock.
+		dsn := cfg.Path + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+		return sqlite.Open(dsn), nil
+	}
 
-	config := &gorm.Config{
+	return nil, fmt.Errorf("unsupported database driver: %s", cfg.Driver)
+}
+
+// NewDatabase connects to the configured database. The schema is managed by the migrations in this package.
+func NewDatabase(cfg *config.DatabaseConfig) (*gorm.DB, error) {
+	dialector, err := dialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	gormConfig := &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 		NowFunc: func() time.Time {
 			return time.Now().UTC()
 		},
 	}
 
-	db, err := gorm.Open(mysql.Open(dsn), config)
+	db, err := gorm.Open(dialector, gormConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	err = db.AutoMigrate(
-		&models.Host{},
-		&models.ServicePort{},
-		&models.Tunnel{},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
-	}
-
 	return db, nil
 }
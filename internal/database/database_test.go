@@ -0,0 +1,128 @@
+package database
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jollaman999/tunnel-manager/internal/config"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+)
+
+func TestDialector(t *testing.T) {
+	server := config.DatabaseConfig{Host: "db", Port: 5432, User: "tunnel", Password: "p@ss:w/rd", Name: "tunnel", SSLMode: "require"}
+
+	t.Run("mysql", func(t *testing.T) {
+		cfg := server
+		cfg.Driver, cfg.Port = DriverMySQL, 3306
+
+		dialector, err := dialector(&cfg)
+		if err != nil {
+			t.Fatalf("dialector() error = %v", err)
+		}
+		got, ok := dialector.(*mysql.Dialector)
+		if !ok {
+			t.Fatalf("dialector() = %T, want *mysql.Dialector", dialector)
+		}
+
+		dsn, err := mysqldriver.ParseDSN(got.DSN)
+		if err != nil {
+			t.Fatalf("invalid DSN %q: %v", got.DSN, err)
+		}
+		if dsn.Addr != "db:3306" || dsn.User != "tunnel" || dsn.Passwd != "p@ss:w/rd" || dsn.DBName != "tunnel" ||
+			dsn.Params["charset"] != "utf8mb4" || !dsn.ParseTime || dsn.Loc != time.Local {
+			t.Errorf("DSN = %q, want host db:3306, user tunnel, password %q, database tunnel, utf8mb4 and local times",
+				got.DSN, server.Password)
+		}
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		cfg := server
+		cfg.Driver = DriverPostgres
+
+		dialector, err := dialector(&cfg)
+		if err != nil {
+			t.Fatalf("dialector() error = %v", err)
+		}
+		got, ok := dialector.(*postgres.Dialector)
+		if !ok {
+			t.Fatalf("dialector() = %T, want *postgres.Dialector", dialector)
+		}
+
+		// The password is escaped, so it may contain any character.
+		dsn, err := url.Parse(got.DSN)
+		if err != nil {
+			t.Fatalf("invalid DSN %q: %v", got.DSN, err)
+		}
+		password, _ := dsn.User.Password()
+		if dsn.Host != "db:5432" || dsn.User.Username() != "tunnel" || password != "p@ss:w/rd" || dsn.Path != "/tunnel" ||
+			dsn.Query().Get("sslmode") != "require" || dsn.Query().Get("TimeZone") != "UTC" {
+			t.Errorf("DSN = %q, want host db:5432, user tunnel, password %q, database tunnel, sslmode require and time zone UTC",
+				got.DSN, server.Password)
+		}
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data", "tunnel-manager.db")
+		dialector, err := dialector(&config.DatabaseConfig{Driver: DriverSQLite, Path: path})
+		if err != nil {
+			t.Fatalf("dialector() error = %v", err)
+		}
+		if _, ok := dialector.(*sqlite.Dialector); !ok {
+			t.Fatalf("dialector() = %T, want *sqlite.Dialector", dialector)
+		}
+
+		// The directory of the database file is created.
+		info, err := os.Stat(filepath.Dir(path))
+		if err != nil || !info.IsDir() {
+			t.Errorf("database directory was not created: %v", err)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := dialector(&config.DatabaseConfig{Driver: "oracle"})
+		if err == nil {
+			t.Errorf("dialector() error = nil, want an error")
+		}
+	})
+}
+
+func TestNewDatabaseSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "tunnel-manager.db")
+	db, err := NewDatabase(&config.DatabaseConfig{Driver: DriverSQLite, Path: path, TimeoutSec: 30})
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+
+	_, err = MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	pragmas := []struct {
+		name string
+		want string
+	}{
+		{name: "journal_mode", want: "wal"},
+		{name: "busy_timeout", want: "10000"},
+	}
+	for _, pragma := range pragmas {
+		var got string
+		err = db.Raw("PRAGMA " + pragma.name).Scan(&got).Error
+		if err != nil {
+			t.Fatalf("PRAGMA %s error = %v", pragma.name, err)
+		}
+		if got != pragma.want {
+			t.Errorf("PRAGMA %s = %q, want %q", pragma.name, got, pragma.want)
+		}
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("database file was not created: %v", err)
+	}
+}
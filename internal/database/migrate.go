@@ -0,0 +1,240 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrSchemaTooNew is returned when the database was migrated by a newer version of Tunnel Manager.
+	ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+	// ErrSchemaOutdated is returned by CheckSchema when migrations are pending.
+	ErrSchemaOutdated = errors.New("database schema has pending migrations")
+	// ErrDropsAllData is returned when reverting the initial schema, which drops every table
+	// and its data, is not explicitly allowed.
+	ErrDropsAllData = errors.New("reverting the initial schema drops every table and its data")
+)
+
+// Migration is a numbered schema change. Up applies it and Down reverts it, each in its own transaction.
+// Migrations change the schema with explicit statements instead of the models, so that later
+// changes to the models do not change what an earlier migration does.
+type Migration struct {
+	Version     uint
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration in the schema_migrations table.
+type SchemaMigration struct {
+	Version     uint      `gorm:"primaryKey;autoIncrement:false"`
+	Description string    `gorm:"size:255;not null"`
+	AppliedAt   time.Time `gorm:"not null"`
+}
+
+// MigrationStatus is a migration known to the binary or recorded in the database.
+// AppliedAt is nil for a pending migration, and Known is false for a migration
+// applied by a newer binary.
+type MigrationStatus struct {
+	Version     uint
+	Description string
+	AppliedAt   *time.Time
+	Known       bool
+}
+
+// LatestVersion returns the schema version this binary migrates to.
+func LatestVersion() uint {
+	return migrations[len(migrations)-1].Version
+}
+
+func appliedMigrations(db *gorm.DB) (map[uint]SchemaMigration, error) {
+	err := createTable(db, "schema_migrations", []string{
+		"version {ref} NOT NULL PRIMARY KEY",
+		"description VARCHAR(255) NOT NULL",
+		"applied_at {time} NOT NULL",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var records []SchemaMigration
+	err = db.Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch applied migrations: %w", err)
+	}
+
+	applied := make(map[uint]SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	return applied, nil
+}
+
+// SchemaVersion returns the highest applied migration version, 0 for an empty database.
+func SchemaVersion(db *gorm.DB) (uint, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	var version uint
+	for v := range applied {
+		version = max(version, v)
+	}
+
+	return version, nil
+}
+
+// MigrationStatuses returns every known migration and every migration recorded in the database, by version.
+func MigrationStatuses(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+			Known:       true,
+		}
+		if record, ok := applied[m.Version]; ok {
+			status.AppliedAt = &record.AppliedAt
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		statuses = append(statuses, MigrationStatus{
+			Version:     record.Version,
+			Description: record.Description,
+			AppliedAt:   &record.AppliedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// MigrateTo applies or reverts migrations until the schema is at target and returns the migrations
+// it ran in order. A target of 0 reverts every migration and drops all tables, which is refused
+// with ErrDropsAllData unless dropAll is set.
+// It refuses to touch a schema migrated by a newer binary (ErrSchemaTooNew).
+func MigrateTo(db *gorm.DB, target uint, dropAll bool) ([]Migration, error) {
+	latest := LatestVersion()
+	if target > latest {
+		return nil, fmt.Errorf("unknown schema version %d, the latest version is %d", target, latest)
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	for version := range applied {
+		if version > latest {
+			return nil, fmt.Errorf("%w: database is at version %d, this binary supports up to %d", ErrSchemaTooNew, version, latest)
+		}
+	}
+	if _, ok := applied[migrations[0].Version]; ok && target < migrations[0].Version && !dropAll {
+		return nil, ErrDropsAllData
+	}
+
+	var ran []Migration
+	for _, m := range migrations {
+		if m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := m.Up(tx)
+			if err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaMigration{
+				Version:     m.Version,
+				Description: m.Description,
+				AppliedAt:   time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		ran = append(ran, m)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := m.Down(tx)
+			if err != nil {
+				return err
+			}
+
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("failed to revert migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+// MigrateUp applies every pending migration.
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	return MigrateTo(db, LatestVersion(), false)
+}
+
+// MigrateDown reverts the latest applied migration. Reverting the initial schema is refused
+// unless dropAll is set, as with MigrateTo.
+func MigrateDown(db *gorm.DB, dropAll bool) ([]Migration, error) {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return nil, nil
+	}
+
+	return MigrateTo(db, version-1, dropAll)
+}
+
+// CheckSchema returns ErrSchemaTooNew when the database was migrated by a newer binary
+// and ErrSchemaOutdated when migrations are pending.
+func CheckSchema(db *gorm.DB) error {
+	statuses, err := MigrationStatuses(db)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		switch {
+		case !status.Known:
+			return fmt.Errorf("%w: database is at version %d, this binary supports up to %d", ErrSchemaTooNew, status.Version, LatestVersion())
+		case status.AppliedAt == nil:
+			return fmt.Errorf("%w: migration %d (%s) is not applied", ErrSchemaOutdated, status.Version, status.Description)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"gorm.io/gorm"
+)
+
+// schemaOf returns the columns and indexes of every table of a SQLite database except
+// schema_migrations, one line each.
+func schemaOf(t *testing.T, db *gorm.DB) []string {
+	t.Helper()
+
+	var objects []struct {
+		Type    string
+		Name    string
+		TblName string
+		SQL     *string
+	}
+	err := db.Raw("SELECT type, name, tbl_name, sql FROM sqlite_master " +
+		"WHERE name NOT LIKE 'sqlite_%' AND tbl_name <> 'schema_migrations'").Scan(&objects).Error
+	if err != nil {
+		t.Fatalf("failed to read schema: %v", err)
+	}
+
+	var schema []string
+	for _, object := range objects {
+		if object.Type == "index" {
+			schema = append(schema, fmt.Sprintf("%s index %s: %s", object.TblName, object.Name, *object.SQL))
+			continue
+		}
+
+		var columns []struct {
+			Name      string
+			Type      string
+			NotNull   bool
+			DfltValue *string
+			PK        int
+		}
+		err = db.Raw(fmt.Sprintf("PRAGMA table_info(%s)", object.Name)).Scan(&columns).Error
+		if err != nil {
+			t.Fatalf("failed to read columns of %s: %v", object.Name, err)
+		}
+		for _, column := range columns {
+			definition := fmt.Sprintf("%s.%s %s not null %v primary key %d", object.Name, column.Name, column.Type, column.NotNull, column.PK)
+			if column.DfltValue != nil {
+				definition += " default " + *column.DfltValue
+			}
+			schema = append(schema, definition)
+		}
+	}
+	slices.Sort(schema)
+
+	return schema
+}
+
+// diffSchema returns the lines of want missing from got and the lines of got not in want.
+func diffSchema(got, want []string) (missing, extra []string) {
+	for _, line := range want {
+		if !slices.Contains(got, line) {
+			missing = append(missing, line)
+		}
+	}
+	for _, line := range got {
+		if !slices.Contains(want, line) {
+			extra = append(extra, line)
+		}
+	}
+
+	return missing, extra
+}
+
+// checkModelColumns checks that the database has a column for every field of the models.
+func checkModelColumns(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	for _, model := range []any{
+		&models.Host{}, &models.SSHKey{}, &models.HostGroup{}, &models.ServicePort{}, &models.HostServicePort{},
+		&models.HostJump{}, &models.Tunnel{}, &models.StoppedTunnel{}, &models.TunnelTraffic{}, &models.TunnelEvent{},
+		&models.APIToken{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.Schedule{},
+	} {
+		stmt := &gorm.Statement{DB: db}
+		err := stmt.Parse(model)
+		if err != nil {
+			t.Fatalf("failed to parse %T: %v", model, err)
+		}
+
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || field.IgnoreMigration {
+				continue
+			}
+			if !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("table %s has no column %s for %T.%s", stmt.Schema.Table, field.DBName, model, field.Name)
+			}
+		}
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	db := newTestDB(t)
+
+	// Each migration is applied on its own and must change the schema.
+	snapshots := map[uint][]string{0: schemaOf(t, db)}
+	previous := uint(0)
+	for _, m := range migrations {
+		ran, err := MigrateTo(db, m.Version, false)
+		if err != nil {
+			t.Fatalf("MigrateTo(%d) error = %v", m.Version, err)
+		}
+		if len(ran) != 1 || ran[0].Version != m.Version {
+			t.Fatalf("MigrateTo(%d) ran %d migrations, want only migration %d", m.Version, len(ran), m.Version)
+		}
+
+		snapshots[m.Version] = schemaOf(t, db)
+		if slices.Equal(snapshots[m.Version], snapshots[previous]) {
+			t.Errorf("migration %d (%s) did not change the schema", m.Version, m.Description)
+		}
+		previous = m.Version
+	}
+	checkModelColumns(t, db)
+
+	host := models.Host{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret"}
+	err := db.Create(&host).Error
+	if err != nil {
+		t.Fatalf("failed to create host: %v", err)
+	}
+
+	// Reverting a migration restores exactly the schema before it.
+	for i := len(migrations) - 1; i > 0; i-- {
+		target := migrations[i-1].Version
+		ran, err := MigrateTo(db, target, false)
+		if err != nil {
+			t.Fatalf("MigrateTo(%d) error = %v", target, err)
+		}
+		if len(ran) != 1 || ran[0].Version != migrations[i].Version {
+			t.Fatalf("MigrateTo(%d) reverted %d migrations, want only migration %d", target, len(ran), migrations[i].Version)
+		}
+
+		missing, extra := diffSchema(schemaOf(t, db), snapshots[target])
+		if len(missing) > 0 || len(extra) > 0 {
+			t.Errorf("schema after reverting migration %d is missing %q and has extra %q", migrations[i].Version, missing, extra)
+		}
+	}
+
+	var ip string
+	err = db.Table("hosts").Select("ip").Where("id = ?", host.ID).Row().Scan(&ip)
+	if err != nil || ip != host.IP {
+		t.Errorf("host at the initial schema = %q, %v, want %q", ip, err, host.IP)
+	}
+
+	_, err = MigrateTo(db, 0, false)
+	if !errors.Is(err, ErrDropsAllData) {
+		t.Fatalf("MigrateTo(0) error = %v, want %v", err, ErrDropsAllData)
+	}
+	_, err = MigrateTo(db, 0, true)
+	if err != nil {
+		t.Fatalf("MigrateTo(0) with dropAll error = %v", err)
+	}
+	if schema := schemaOf(t, db); len(schema) > 0 {
+		t.Errorf("schema after reverting every migration = %q, want no tables", schema)
+	}
+
+	_, err = MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+	missing, extra := diffSchema(schemaOf(t, db), snapshots[LatestVersion()])
+	if len(missing) > 0 || len(extra) > 0 {
+		t.Errorf("schema after MigrateUp() is missing %q and has extra %q", missing, extra)
+	}
+}
+
+func TestMigrateExistingDatabase(t *testing.T) {
+	db := newTestDB(t)
+
+	// The tables as releases before versioned migrations created them.
+	type Host struct {
+		ID          uint   `gorm:"primaryKey;autoIncrement"`
+		IP          string `gorm:"uniqueIndex:idx_hosts_ip;not null"`
+		Port        int    `gorm:"not null"`
+		User        string `gorm:"not null"`
+		Password    string `gorm:"not null"`
+		Description string
+		Enabled     bool `gorm:"default:true"`
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+	}
+	type ServicePort struct {
+		ID          uint   `gorm:"primaryKey;autoIncrement"`
+		ServiceIP   string `gorm:"uniqueIndex:idx_service_ip_port;not null"`
+		ServicePort int    `gorm:"uniqueIndex:idx_service_ip_port;not null"`
+		LocalPort   int    `gorm:"not null"`
+		Description string
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+	}
+	type Tunnel struct {
+		HostID          uint   `gorm:"primaryKey;not null"`
+		SPID            uint   `gorm:"primaryKey;not null"`
+		Status          string `gorm:"not null"`
+		LastError       string
+		RetryCount      int `gorm:"default:0"`
+		LastConnectedAt time.Time
+		Server          string `gorm:"not null"`
+		Local           string `gorm:"not null"`
+		Remote          string `gorm:"not null"`
+	}
+	err := db.AutoMigrate(&Host{}, &ServicePort{}, &Tunnel{})
+	if err != nil {
+		t.Fatalf("failed to create tables: %v", err)
+	}
+
+	host := Host{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret", Enabled: true}
+	err = db.Create(&host).Error
+	if err != nil {
+		t.Fatalf("failed to create host: %v", err)
+	}
+	sp := ServicePort{ServiceIP: "10.0.0.5", ServicePort: 80, LocalPort: 8080}
+	err = db.Create(&sp).Error
+	if err != nil {
+		t.Fatalf("failed to create service port: %v", err)
+	}
+
+	ran, err := MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+	if len(ran) != len(migrations) {
+		t.Fatalf("MigrateUp() ran %d migrations, want %d", len(ran), len(migrations))
+	}
+	checkModelColumns(t, db)
+
+	var gotHost models.Host
+	err = db.First(&gotHost, host.ID).Error
+	if err != nil || gotHost.IP != host.IP || gotHost.Password != host.Password {
+		t.Errorf("host after migration = %+v, %v, want %+v", gotHost, err, host)
+	}
+
+	// Service ports created before host assignments existed stay open on every host.
+	var gotSP models.ServicePort
+	err = db.First(&gotSP, sp.ID).Error
+	if err != nil {
+		t.Fatalf("failed to fetch service port: %v", err)
+	}
+	if gotSP.ServiceIP == nil || *gotSP.ServiceIP != sp.ServiceIP || !gotSP.ApplyToAllHosts ||
+		gotSP.BindAddress != "0.0.0.0" || gotSP.Direction != "remote" {
+		t.Errorf("service port after migration = %+v, want %s:%d on all hosts, bound to 0.0.0.0, remote",
+			gotSP, sp.ServiceIP, sp.ServicePort)
+	}
+
+	// New service ports are not applied to all hosts, and dynamic ones have no service IP.
+	dynamic := models.ServicePort{ServicePort: 1080, LocalPort: 1080, BindAddress: "127.0.0.1", Direction: "dynamic"}
+	err = db.Create(&dynamic).Error
+	if err != nil {
+		t.Fatalf("failed to create dynamic service port: %v", err)
+	}
+	var applyToAllHosts bool
+	err = db.Table("service_ports").Select("apply_to_all_hosts").Where("id = ?", dynamic.ID).Row().Scan(&applyToAllHosts)
+	if err != nil || applyToAllHosts {
+		t.Errorf("apply_to_all_hosts of a new service port = %v, %v, want false", applyToAllHosts, err)
+	}
+}
+
+func TestMigrateDynamicTunnels(t *testing.T) {
+	db := newTestDB(t)
+	_, err := MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	dynamic := models.ServicePort{ServicePort: 1080, LocalPort: 1080, BindAddress: "127.0.0.1", Direction: "dynamic"}
+	err = db.Create(&dynamic).Error
+	if err != nil {
+		t.Fatalf("failed to create dynamic service port: %v", err)
+	}
+
+	// The service IP cannot be required again while dynamic service ports exist.
+	_, err = MigrateTo(db, 6, false)
+	if err == nil {
+		t.Fatal("MigrateTo(6) with a dynamic service port error = nil, want an error")
+	}
+	version, err := SchemaVersion(db)
+	if err != nil || version != 7 {
+		t.Fatalf("SchemaVersion() = %d, %v, want 7", version, err)
+	}
+	var count int64
+	db.Table("service_ports").Count(&count)
+	if count != 1 {
+		t.Fatalf("%d service ports after the refused migration, want 1", count)
+	}
+
+	err = db.Delete(&models.ServicePort{}, dynamic.ID).Error
+	if err != nil {
+		t.Fatalf("failed to delete dynamic service port: %v", err)
+	}
+	_, err = MigrateTo(db, 6, false)
+	if err != nil {
+		t.Fatalf("MigrateTo(6) error = %v", err)
+	}
+	err = db.Exec("INSERT INTO service_ports (service_port, local_port) VALUES (1080, 1080)").Error
+	if err == nil {
+		t.Error("service port without service IP was created at version 6, want NOT NULL constraint failure")
+	}
+}
@@ -0,0 +1,681 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// migrations are applied in order. A released migration must never change; change the schema
+// by appending a new one.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up:          initialSchemaUp,
+		// Reverting the initial schema drops every table, see ErrDropsAllData.
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "tunnels", "service_ports", "hosts")
+		},
+	},
+	{
+		Version:     2,
+		Description: "SSH keys",
+		Up:          sshKeysUp,
+		Down:        sshKeysDown,
+	},
+	{
+		Version:     3,
+		Description: "host keys",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "hosts",
+				"host_key {text}",
+				"host_key_fingerprint {text}",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "hosts", "host_key", "host_key_fingerprint")
+		},
+	},
+	{
+		Version:     4,
+		Description: "host service port assignments",
+		Up:          hostServicePortsUp,
+		Down: func(tx *gorm.DB) error {
+			err := dropTables(tx, "host_service_ports")
+			if err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "service_ports", "apply_to_all_hosts")
+		},
+	},
+	{
+		Version:     5,
+		Description: "bind addresses",
+		Up: func(tx *gorm.DB) error {
+			err := addColumns(tx, "service_ports", "bind_address VARCHAR(64) NOT NULL DEFAULT '0.0.0.0'")
+			if err != nil {
+				return err
+			}
+
+			return addColumns(tx, "tunnels", "warning {text}")
+		},
+		Down: func(tx *gorm.DB) error {
+			err := dropColumns(tx, "service_ports", "bind_address")
+			if err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "tunnels", "warning")
+		},
+	},
+	{
+		Version:     6,
+		Description: "forwarding directions",
+		Up: func(tx *gorm.DB) error {
+			err := addColumns(tx, "service_ports", "direction VARCHAR(16) NOT NULL DEFAULT 'remote'")
+			if err != nil {
+				return err
+			}
+
+			return addColumns(tx, "tunnels", "direction VARCHAR(16) NOT NULL DEFAULT 'remote'")
+		},
+		Down: func(tx *gorm.DB) error {
+			err := dropColumns(tx, "service_ports", "direction")
+			if err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "tunnels", "direction")
+		},
+	},
+	{
+		Version:     7,
+		Description: "dynamic tunnels",
+		Up:          dynamicTunnelsUp,
+		Down:        dynamicTunnelsDown,
+	},
+	{
+		Version:     8,
+		Description: "jump hosts",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, "host_jumps", []string{
+				"host_id {ref} NOT NULL",
+				`"position" INTEGER NOT NULL`,
+				"jump_host_id {ref} NOT NULL",
+				"created_at {time}",
+				`PRIMARY KEY (host_id, "position")`,
+			}, index{name: "idx_host_jumps_jump_host_id", columns: "jump_host_id"})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "host_jumps")
+		},
+	},
+	{
+		Version:     9,
+		Description: "API tokens",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, "api_tokens", []string{
+				"id {id}",
+				"prefix VARCHAR(16) NOT NULL",
+				"token_hash VARCHAR(64) NOT NULL",
+				"description {text}",
+				"expires_at {time}",
+				"last_used_at {time}",
+				"created_at {time}",
+			}, index{name: "idx_api_tokens_hash", columns: "token_hash", unique: true})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "api_tokens")
+		},
+	},
+	{
+		Version:     10,
+		Description: "roles and host groups",
+		Up:          hostGroupsUp,
+		Down:        hostGroupsDown,
+	},
+	{
+		Version:     11,
+		Description: "tunnel traffic",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, "tunnel_traffics", []string{
+				"host_id {ref} NOT NULL",
+				"sp_id {ref} NOT NULL",
+				"bytes_in BIGINT NOT NULL DEFAULT 0",
+				"bytes_out BIGINT NOT NULL DEFAULT 0",
+				"connections BIGINT NOT NULL DEFAULT 0",
+				"last_activity_at {time}",
+				"created_at {time}",
+				"updated_at {time}",
+				"PRIMARY KEY (host_id, sp_id)",
+			})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "tunnel_traffics")
+		},
+	},
+	{
+		Version:     12,
+		Description: "tunnel events",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, "tunnel_events", []string{
+				"id {id}",
+				"host_id {ref} NOT NULL",
+				"sp_id {ref} NOT NULL",
+				"status VARCHAR(32) NOT NULL",
+				"error {text}",
+				"retry_count INTEGER NOT NULL DEFAULT 0",
+				"created_at {time}",
+			},
+				index{name: "idx_tunnel_events_tunnel", columns: "host_id, sp_id, created_at"},
+				index{name: "idx_tunnel_events_created_at", columns: "created_at"},
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "tunnel_events")
+		},
+	},
+	{
+		Version:     13,
+		Description: "webhooks",
+		Up:          webhooksUp,
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "webhook_deliveries", "webhooks")
+		},
+	},
+	{
+		Version:     14,
+		Description: "host reconnect policies",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "hosts", "reconnect_policy {text}")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "hosts", "reconnect_policy")
+		},
+	},
+	{
+		Version:     15,
+		Description: "stopped tunnels",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, "stopped_tunnels", []string{
+				"host_id {ref} NOT NULL",
+				"sp_id {ref} NOT NULL",
+				"created_at {time}",
+				"PRIMARY KEY (host_id, sp_id)",
+			}, index{name: "idx_stopped_tunnels_sp_id", columns: "sp_id"})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "stopped_tunnels")
+		},
+	},
+	{
+		Version:     16,
+		Description: "schedules",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, "schedules", []string{
+				"id {id}",
+				"name VARCHAR(191) NOT NULL",
+				"host_id {ref}",
+				"sp_id {ref}",
+				`"open" VARCHAR(255) NOT NULL`,
+				`"close" VARCHAR(255) NOT NULL`,
+				"time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC'",
+				"enabled {bool} NOT NULL DEFAULT TRUE",
+				"description {text}",
+				"created_at {time}",
+				"updated_at {time}",
+			},
+				index{name: "idx_schedules_name", columns: "name", unique: true},
+				index{name: "idx_schedules_host_id", columns: "host_id"},
+				index{name: "idx_schedules_sp_id", columns: "sp_id"},
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "schedules")
+		},
+	},
+}
+
+// columnTypes are the column types of each database driver, used in place of {name} in the
+// statements of the migrations.
+var columnTypes = map[string]map[string]string{
+	DriverMySQL: {
+		"id":   "BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY",
+		"ref":  "BIGINT UNSIGNED",
+		"text": "LONGTEXT",
+		"time": "DATETIME(3)",
+		"bool": "BOOLEAN",
+	},
+	DriverPostgres: {
+		"id":   "BIGSERIAL PRIMARY KEY",
+		"ref":  "BIGINT",
+		"text": "TEXT",
+		"time": "TIMESTAMPTZ",
+		"bool": "BOOLEAN",
+	},
+	DriverSQLite: {
+		"id":   "INTEGER PRIMARY KEY AUTOINCREMENT",
+		"ref":  "INTEGER",
+		"text": "TEXT",
+		"time": "DATETIME",
+		"bool": "NUMERIC",
+	},
+}
+
+// exec runs statements with the column types of the driver of tx. Identifiers that are reserved
+// words are written in double quotes, which are replaced by backquotes for MySQL.
+func exec(tx *gorm.DB, statements ...string) error {
+	driver := tx.Dialector.Name()
+	types, ok := columnTypes[driver]
+	if !ok {
+		return fmt.Errorf("unsupported database driver: %s", driver)
+	}
+
+	replacements := make([]string, 0, 2*len(types)+2)
+	for name, columnType := range types {
+		replacements = append(replacements, "{"+name+"}", columnType)
+	}
+	if driver == DriverMySQL {
+		replacements = append(replacements, `"`, "`")
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	for _, statement := range statements {
+		err := tx.Exec(replacer.Replace(statement)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// index is an index created with a table.
+type index struct {
+	name    string
+	columns string
+	unique  bool
+}
+
+// createTable creates a table and its indexes. Tables and indexes that already exist, because
+// the database was created before versioned migrations, are kept.
+func createTable(tx *gorm.DB, table string, columns []string, indexes ...index) error {
+	err := exec(tx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", table, strings.Join(columns, ",\n\t")))
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		if tx.Migrator().HasIndex(table, idx.name) {
+			continue
+		}
+
+		statement := "CREATE INDEX"
+		if idx.unique {
+			statement = "CREATE UNIQUE INDEX"
+		}
+		err = exec(tx, fmt.Sprintf("%s %s ON %s (%s)", statement, idx.name, table, idx.columns))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dropTables(tx *gorm.DB, tables ...string) error {
+	for _, table := range tables {
+		err := exec(tx, "DROP TABLE IF EXISTS "+table)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addColumns adds columns, each given as its name followed by its definition, that the table
+// does not have yet.
+func addColumns(tx *gorm.DB, table string, columns ...string) error {
+	_, err := addMissingColumns(tx, table, columns...)
+	return err
+}
+
+// addMissingColumns is addColumns that also reports whether any column was added.
+func addMissingColumns(tx *gorm.DB, table string, columns ...string) (bool, error) {
+	added := false
+	for _, column := range columns {
+		name, _, _ := strings.Cut(column, " ")
+		if tx.Migrator().HasColumn(table, strings.Trim(name, `"`)) {
+			continue
+		}
+
+		err := exec(tx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+		if err != nil {
+			return added, err
+		}
+		added = true
+	}
+
+	return added, nil
+}
+
+func dropColumns(tx *gorm.DB, table string, columns ...string) error {
+	for _, column := range columns {
+		if !tx.Migrator().HasColumn(table, column) {
+			continue
+		}
+
+		err := exec(tx, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropIndex drops an index, which SQLite requires before dropping its columns.
+func dropIndex(tx *gorm.DB, table, name string) error {
+	if !tx.Migrator().HasIndex(table, name) {
+		return nil
+	}
+
+	if tx.Dialector.Name() == DriverMySQL {
+		return exec(tx, fmt.Sprintf("DROP INDEX %s ON %s", name, table))
+	}
+
+	return exec(tx, "DROP INDEX "+name)
+}
+
+// initialSchemaUp creates the tables of the releases before versioned migrations, which created
+// them with AutoMigrate. Databases created by those releases already have them and keep them.
+func initialSchemaUp(tx *gorm.DB) error {
+	err := createTable(tx, "hosts", []string{
+		"id {id}",
+		"ip VARCHAR(191) NOT NULL",
+		"port INTEGER NOT NULL",
+		`"user" {text} NOT NULL`,
+		"password {text} NOT NULL",
+		"description {text}",
+		"enabled {bool} DEFAULT TRUE",
+		"created_at {time}",
+		"updated_at {time}",
+	}, index{name: "idx_hosts_ip", columns: "ip", unique: true})
+	if err != nil {
+		return err
+	}
+
+	err = createTable(tx, "service_ports", []string{
+		"id {id}",
+		"service_ip VARCHAR(191) NOT NULL",
+		"service_port INTEGER NOT NULL",
+		"local_port INTEGER NOT NULL",
+		"description {text}",
+		"created_at {time}",
+		"updated_at {time}",
+	}, index{name: "idx_service_ip_port", columns: "service_ip, service_port", unique: true})
+	if err != nil {
+		return err
+	}
+
+	return createTable(tx, "tunnels", []string{
+		"host_id {ref} NOT NULL",
+		"sp_id {ref} NOT NULL",
+		"status {text} NOT NULL",
+		"last_error {text}",
+		"retry_count INTEGER DEFAULT 0",
+		"last_connected_at {time}",
+		"server {text} NOT NULL",
+		"local {text} NOT NULL",
+		"remote {text} NOT NULL",
+		"PRIMARY KEY (host_id, sp_id)",
+	})
+}
+
+func sshKeysUp(tx *gorm.DB) error {
+	err := createTable(tx, "ssh_keys", []string{
+		"id {id}",
+		"name VARCHAR(191) NOT NULL",
+		"private_key {text} NOT NULL",
+		"passphrase {text}",
+		"public_key {text}",
+		"fingerprint {text}",
+		"description {text}",
+		"created_at {time}",
+		"updated_at {time}",
+	}, index{name: "idx_ssh_keys_name", columns: "name", unique: true})
+	if err != nil {
+		return err
+	}
+
+	err = addColumns(tx, "hosts",
+		"private_key {text}",
+		"passphrase {text}",
+		"ssh_key_id {ref}",
+	)
+	if err != nil {
+		return err
+	}
+	if tx.Migrator().HasIndex("hosts", "idx_hosts_ssh_key_id") {
+		return nil
+	}
+
+	return exec(tx, "CREATE INDEX idx_hosts_ssh_key_id ON hosts (ssh_key_id)")
+}
+
+func sshKeysDown(tx *gorm.DB) error {
+	err := dropIndex(tx, "hosts", "idx_hosts_ssh_key_id")
+	if err != nil {
+		return err
+	}
+
+	err = dropColumns(tx, "hosts", "private_key", "passphrase", "ssh_key_id")
+	if err != nil {
+		return err
+	}
+
+	return dropTables(tx, "ssh_keys")
+}
+
+func hostServicePortsUp(tx *gorm.DB) error {
+	err := createTable(tx, "host_service_ports", []string{
+		"host_id {ref} NOT NULL",
+		"sp_id {ref} NOT NULL",
+		"created_at {time}",
+		"PRIMARY KEY (host_id, sp_id)",
+	}, index{name: "idx_host_service_ports_sp_id", columns: "sp_id"})
+	if err != nil {
+		return err
+	}
+
+	added, err := addMissingColumns(tx, "service_ports", "apply_to_all_hosts {bool} NOT NULL DEFAULT FALSE")
+	if err != nil {
+		return err
+	}
+
+	// Service ports created before host assignments existed were opened on every host.
+	if added {
+		return exec(tx, "UPDATE service_ports SET apply_to_all_hosts = TRUE")
+	}
+
+	return nil
+}
+
+// servicePortColumns are the columns of service_ports from version 6 on, without the allowlist
+// added by version 7.
+const servicePortColumns = "id, service_ip, service_port, local_port, description, created_at, updated_at, " +
+	"apply_to_all_hosts, bind_address, direction"
+
+// setServiceIPNull makes the service_ip column of service_ports nullable or not. SQLite cannot
+// change a column, so the table is copied into a new one.
+func setServiceIPNull(tx *gorm.DB, nullable bool) error {
+	switch tx.Dialector.Name() {
+	case DriverMySQL:
+		if nullable {
+			return exec(tx, "ALTER TABLE service_ports MODIFY service_ip VARCHAR(191) NULL")
+		}
+		return exec(tx, "ALTER TABLE service_ports MODIFY service_ip VARCHAR(191) NOT NULL")
+	case DriverPostgres:
+		if nullable {
+			return exec(tx, "ALTER TABLE service_ports ALTER COLUMN service_ip DROP NOT NULL")
+		}
+		return exec(tx, "ALTER TABLE service_ports ALTER COLUMN service_ip SET NOT NULL")
+	}
+
+	columns := servicePortColumns
+	serviceIP := "service_ip VARCHAR(191) NOT NULL"
+	if nullable {
+		columns += ", allowlist"
+		serviceIP = "service_ip VARCHAR(191)"
+	}
+
+	definitions := []string{
+		"id {id}",
+		serviceIP,
+		"service_port INTEGER NOT NULL",
+		"local_port INTEGER NOT NULL",
+		"description {text}",
+		"created_at {time}",
+		"updated_at {time}",
+		"apply_to_all_hosts {bool} NOT NULL DEFAULT FALSE",
+		"bind_address VARCHAR(64) NOT NULL DEFAULT '0.0.0.0'",
+		"direction VARCHAR(16) NOT NULL DEFAULT 'remote'",
+	}
+	if nullable {
+		definitions = append(definitions, "allowlist {text}")
+	}
+
+	err := dropIndex(tx, "service_ports", "idx_service_ip_port")
+	if err != nil {
+		return err
+	}
+
+	return exec(tx,
+		fmt.Sprintf("CREATE TABLE service_ports_new (\n\t%s\n)", strings.Join(definitions, ",\n\t")),
+		fmt.Sprintf("INSERT INTO service_ports_new (%s) SELECT %s FROM service_ports", columns, columns),
+		"DROP TABLE service_ports",
+		"ALTER TABLE service_ports_new RENAME TO service_ports",
+		"CREATE UNIQUE INDEX idx_service_ip_port ON service_ports (service_ip, service_port)",
+	)
+}
+
+// dynamicTunnelsUp adds the allowlist of dynamic service ports, which have no service IP.
+func dynamicTunnelsUp(tx *gorm.DB) error {
+	err := addColumns(tx, "service_ports", "allowlist {text}")
+	if err != nil {
+		return err
+	}
+
+	return setServiceIPNull(tx, true)
+}
+
+// dynamicTunnelsDown refuses to run while dynamic service ports exist, since the service_ip
+// column cannot be made required again before they are deleted.
+func dynamicTunnelsDown(tx *gorm.DB) error {
+	var count int64
+	err := tx.Table("service_ports").Where("service_ip IS NULL").Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%d dynamic service ports must be deleted first", count)
+	}
+
+	err = dropColumns(tx, "service_ports", "allowlist")
+	if err != nil {
+		return err
+	}
+
+	return setServiceIPNull(tx, false)
+}
+
+func hostGroupsUp(tx *gorm.DB) error {
+	err := createTable(tx, "host_groups", []string{
+		"id {id}",
+		"name VARCHAR(191) NOT NULL",
+		"description {text}",
+		"created_at {time}",
+		"updated_at {time}",
+	}, index{name: "idx_host_groups_name", columns: "name", unique: true})
+	if err != nil {
+		return err
+	}
+
+	err = addColumns(tx, "hosts", "host_group_id {ref}")
+	if err != nil {
+		return err
+	}
+	if !tx.Migrator().HasIndex("hosts", "idx_hosts_host_group_id") {
+		err = exec(tx, "CREATE INDEX idx_hosts_host_group_id ON hosts (host_group_id)")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Tokens created before roles existed keep full access.
+	return addColumns(tx, "api_tokens",
+		"role VARCHAR(16) NOT NULL DEFAULT 'admin'",
+		"host_group_ids {text}",
+	)
+}
+
+func hostGroupsDown(tx *gorm.DB) error {
+	err := dropColumns(tx, "api_tokens", "role", "host_group_ids")
+	if err != nil {
+		return err
+	}
+
+	err = dropIndex(tx, "hosts", "idx_hosts_host_group_id")
+	if err != nil {
+		return err
+	}
+
+	err = dropColumns(tx, "hosts", "host_group_id")
+	if err != nil {
+		return err
+	}
+
+	return dropTables(tx, "host_groups")
+}
+
+func webhooksUp(tx *gorm.DB) error {
+	err := createTable(tx, "webhooks", []string{
+		"id {id}",
+		"name VARCHAR(191) NOT NULL",
+		"url {text} NOT NULL",
+		"events {text}",
+		"secret {text}",
+		"max_retries INTEGER NOT NULL DEFAULT 3",
+		"retry_interval_sec INTEGER NOT NULL DEFAULT 10",
+		"enabled {bool} NOT NULL DEFAULT TRUE",
+		"description {text}",
+		"created_at {time}",
+		"updated_at {time}",
+	}, index{name: "idx_webhooks_name", columns: "name", unique: true})
+	if err != nil {
+		return err
+	}
+
+	return createTable(tx, "webhook_deliveries", []string{
+		"id {id}",
+		"webhook_id {ref} NOT NULL",
+		"event_id VARCHAR(32) NOT NULL",
+		"event VARCHAR(32) NOT NULL",
+		"host_id {ref} NOT NULL",
+		"sp_id {ref} NOT NULL",
+		"attempt INTEGER NOT NULL",
+		"status_code INTEGER",
+		"success {bool} NOT NULL DEFAULT FALSE",
+		"error {text}",
+		"duration_ms BIGINT",
+		"created_at {time}",
+	},
+		index{name: "idx_webhook_deliveries_webhook_id", columns: "webhook_id"},
+		index{name: "idx_webhook_deliveries_event_id", columns: "event_id"},
+		index{name: "idx_webhook_deliveries_created_at", columns: "created_at"},
+	)
+}
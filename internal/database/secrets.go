@@ -0,0 +1,104 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/jollaman999/tunnel-manager/internal/secret"
+	"gorm.io/gorm"
+)
+
+// secretColumns lists the columns written through the "secret" serializer.
+var secretColumns = map[string][]string{
+	"hosts":    {"password", "private_key", "passphrase"},
+	"ssh_keys": {"private_key", "passphrase"},
+	"webhooks": {"secret"},
+}
+
+// MigrateSecrets encrypts every stored secret with the current key of keyring. Plain text
+// values written before encryption was enabled are encrypted, and values encrypted with a
+// previous key of keyring are re-encrypted, which is how keys are rotated.
+// With a nil keyring it only checks that no stored secret is encrypted.
+// It returns the number of updated values.
+func MigrateSecrets(db *gorm.DB, keyring *secret.Keyring) (int, error) {
+	updated := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for table, columns := range secretColumns {
+			n, err := migrateSecretTable(tx, keyring, table, columns)
+			if err != nil {
+				return err
+			}
+			updated += n
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return updated, nil
+}
+
+func migrateSecretTable(tx *gorm.DB, keyring *secret.Keyring, table string, columns []string) (int, error) {
+	var rows []map[string]interface{}
+	err := tx.Table(table).Select(append([]string{"id"}, columns...)).Find(&rows).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch %s: %w", table, err)
+	}
+
+	updated := 0
+	for _, row := range rows {
+		changes := make(map[string]interface{})
+		for _, column := range columns {
+			value := columnString(row[column])
+			if value == "" {
+				continue
+			}
+
+			if keyring == nil {
+				if secret.IsEncrypted(value) {
+					return 0, fmt.Errorf("%s.%s (id=%v) is encrypted but no secrets key is configured", table, column, row["id"])
+				}
+				continue
+			}
+
+			if secret.IsEncrypted(value) && secret.KeyIDOf(value) == keyring.KeyID() {
+				continue
+			}
+
+			plaintext, err := keyring.Decrypt(value)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decrypt %s.%s (id=%v): %w", table, column, row["id"], err)
+			}
+
+			encrypted, err := keyring.Encrypt(plaintext)
+			if err != nil {
+				return 0, fmt.Errorf("failed to encrypt %s.%s (id=%v): %w", table, column, row["id"], err)
+			}
+			changes[column] = encrypted
+		}
+
+		if len(changes) == 0 {
+			continue
+		}
+
+		err = tx.Table(table).Where("id = ?", row["id"]).UpdateColumns(changes).Error
+		if err != nil {
+			return 0, fmt.Errorf("failed to update %s (id=%v): %w", table, row["id"], err)
+		}
+		updated += len(changes)
+	}
+
+	return updated, nil
+}
+
+func columnString(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	default:
+		return ""
+	}
+}
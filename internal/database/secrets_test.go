@@ -0,0 +1,144 @@
+package database
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/secret"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns an empty SQLite database.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	return db
+}
+
+// setDefaultKeyring sets the keyring of the "secret" serializer until the test ends.
+func setDefaultKeyring(t *testing.T, keyring *secret.Keyring) {
+	t.Helper()
+
+	previous := secret.Default()
+	secret.SetDefault(keyring)
+	t.Cleanup(func() {
+		secret.SetDefault(previous)
+	})
+}
+
+func newTestKeyring(t *testing.T, keys ...byte) *secret.Keyring {
+	t.Helper()
+
+	var previous [][]byte
+	for _, b := range keys[1:] {
+		previous = append(previous, bytes.Repeat([]byte{b}, secret.KeySize))
+	}
+	keyring, err := secret.NewKeyring(bytes.Repeat([]byte{keys[0]}, secret.KeySize), previous...)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	return keyring
+}
+
+// storedPassword returns the password column of a host as it is stored.
+func storedPassword(t *testing.T, db *gorm.DB, id uint) string {
+	t.Helper()
+
+	var password string
+	err := db.Table("hosts").Select("password").Where("id = ?", id).Row().Scan(&password)
+	if err != nil {
+		t.Fatalf("failed to read password: %v", err)
+	}
+
+	return password
+}
+
+func TestMigrateSecrets(t *testing.T) {
+	db := newTestDB(t)
+	_, err := MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	// Without a keyring, secrets are stored in plain text as before encryption was introduced.
+	setDefaultKeyring(t, nil)
+	host := models.Host{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret"}
+	err = db.Create(&host).Error
+	if err != nil {
+		t.Fatalf("failed to create host: %v", err)
+	}
+	if stored := storedPassword(t, db, host.ID); stored != "secret" {
+		t.Fatalf("stored password = %q, want plain text", stored)
+	}
+
+	n, err := MigrateSecrets(db, nil)
+	if err != nil || n != 0 {
+		t.Fatalf("MigrateSecrets() without keyring = %d, %v, want 0, nil", n, err)
+	}
+
+	// Plain text values stay readable once a key is configured and are encrypted by MigrateSecrets.
+	oldKeyring := newTestKeyring(t, 1)
+	setDefaultKeyring(t, oldKeyring)
+	var read models.Host
+	err = db.First(&read, host.ID).Error
+	if err != nil || read.Password != "secret" {
+		t.Fatalf("password read with keyring = %q, %v, want %q", read.Password, err, "secret")
+	}
+
+	n, err = MigrateSecrets(db, oldKeyring)
+	if err != nil || n != 1 {
+		t.Fatalf("MigrateSecrets() = %d, %v, want 1, nil", n, err)
+	}
+	stored := storedPassword(t, db, host.ID)
+	if !secret.IsEncrypted(stored) || secret.KeyIDOf(stored) != oldKeyring.KeyID() {
+		t.Fatalf("stored password = %q, want it encrypted with key %s", stored, oldKeyring.KeyID())
+	}
+
+	n, err = MigrateSecrets(db, oldKeyring)
+	if err != nil || n != 0 {
+		t.Fatalf("MigrateSecrets() of encrypted values = %d, %v, want 0, nil", n, err)
+	}
+
+	// Rotation re-encrypts with the new key, after which the old key is no longer needed.
+	rotated := newTestKeyring(t, 2, 1)
+	n, err = MigrateSecrets(db, rotated)
+	if err != nil || n != 1 {
+		t.Fatalf("MigrateSecrets() after rotation = %d, %v, want 1, nil", n, err)
+	}
+	if stored := storedPassword(t, db, host.ID); secret.KeyIDOf(stored) != rotated.KeyID() {
+		t.Fatalf("stored password is encrypted with key %s, want %s", secret.KeyIDOf(stored), rotated.KeyID())
+	}
+
+	setDefaultKeyring(t, newTestKeyring(t, 2))
+	read = models.Host{}
+	err = db.First(&read, host.ID).Error
+	if err != nil || read.Password != "secret" {
+		t.Fatalf("password read with the new key = %q, %v, want %q", read.Password, err, "secret")
+	}
+
+	// Encrypted values cannot be read, nor left as they are, without the key.
+	setDefaultKeyring(t, nil)
+	err = db.First(&models.Host{}, host.ID).Error
+	if err == nil {
+		t.Errorf("reading an encrypted password without keyring succeeded, want error")
+	}
+	_, err = MigrateSecrets(db, nil)
+	if err == nil {
+		t.Errorf("MigrateSecrets() of encrypted values without keyring succeeded, want error")
+	}
+	_, err = MigrateSecrets(db, oldKeyring)
+	if err == nil {
+		t.Errorf("MigrateSecrets() with a keyring missing the current key succeeded, want error")
+	}
+}
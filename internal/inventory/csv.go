@@ -0,0 +1,286 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+// column maps a CSV column to a field of a host or service port.
+type column[T any] struct {
+	name string
+	get  func(*T) string
+	set  func(*T, string) error
+}
+
+func intColumn[T any](name string, field func(*T) *int) column[T] {
+	return column[T]{
+		name: name,
+		get: func(v *T) string {
+			if *field(v) == 0 {
+				return ""
+			}
+			return strconv.Itoa(*field(v))
+		},
+		set: func(v *T, s string) error {
+			if s == "" {
+				return nil
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("%s: invalid number %q", name, s)
+			}
+			*field(v) = n
+			return nil
+		},
+	}
+}
+
+func stringColumn[T any](name string, field func(*T) *string) column[T] {
+	return column[T]{
+		name: name,
+		get:  func(v *T) string { return *field(v) },
+		set: func(v *T, s string) error {
+			*field(v) = s
+			return nil
+		},
+	}
+}
+
+// listColumn holds a list separated by spaces.
+func listColumn[T any](name string, field func(*T) *[]string) column[T] {
+	return column[T]{
+		name: name,
+		get:  func(v *T) string { return strings.Join(*field(v), " ") },
+		set: func(v *T, s string) error {
+			if s == "" {
+				return nil
+			}
+			*field(v) = strings.Fields(s)
+			return nil
+		},
+	}
+}
+
+// hostColumns are the columns of a hosts CSV file, in the order they are written.
+var hostColumns = []column[models.InventoryHost]{
+	stringColumn("ip", func(h *models.InventoryHost) *string { return &h.IP }),
+	intColumn("port", func(h *models.InventoryHost) *int { return &h.Port }),
+	stringColumn("user", func(h *models.InventoryHost) *string { return &h.User }),
+	stringColumn("password", func(h *models.InventoryHost) *string { return &h.Password }),
+	stringColumn("private_key", func(h *models.InventoryHost) *string { return &h.PrivateKey }),
+	stringColumn("passphrase", func(h *models.InventoryHost) *string { return &h.Passphrase }),
+	stringColumn("ssh_key", func(h *models.InventoryHost) *string { return &h.SSHKey }),
+	stringColumn("host_group", func(h *models.InventoryHost) *string { return &h.HostGroup }),
+	listColumn("jump_hosts", func(h *models.InventoryHost) *[]string { return &h.JumpHosts }),
+	stringColumn("host_key", func(h *models.InventoryHost) *string { return &h.HostKey }),
+	{
+		// The reconnect policy is written as a JSON object.
+		name: "reconnect_policy",
+		get: func(h *models.InventoryHost) string {
+			if h.ReconnectPolicy == nil {
+				return ""
+			}
+			data, _ := json.Marshal(h.ReconnectPolicy)
+			return string(data)
+		},
+		set: func(h *models.InventoryHost, s string) error {
+			if s == "" {
+				return nil
+			}
+			var policy models.ReconnectPolicy
+			err := json.Unmarshal([]byte(s), &policy)
+			if err != nil {
+				return fmt.Errorf("reconnect_policy: invalid JSON object: %w", err)
+			}
+			h.ReconnectPolicy = &policy
+			return nil
+		},
+	},
+	{
+		name: "enabled",
+		get: func(h *models.InventoryHost) string {
+			if h.Enabled == nil {
+				return ""
+			}
+			return strconv.FormatBool(*h.Enabled)
+		},
+		set: func(h *models.InventoryHost, s string) error {
+			if s == "" {
+				return nil
+			}
+			enabled, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("enabled: invalid boolean %q", s)
+			}
+			h.Enabled = &enabled
+			return nil
+		},
+	},
+	stringColumn("description", func(h *models.InventoryHost) *string { return &h.Description }),
+}
+
+// servicePortColumns are the columns of a service ports CSV file, in the order they are written.
+var servicePortColumns = []column[models.InventoryServicePort]{
+	stringColumn("service_ip", func(sp *models.InventoryServicePort) *string { return &sp.ServiceIP }),
+	intColumn("service_port", func(sp *models.InventoryServicePort) *int { return &sp.ServicePort }),
+	intColumn("local_port", func(sp *models.InventoryServicePort) *int { return &sp.LocalPort }),
+	stringColumn("bind_address", func(sp *models.InventoryServicePort) *string { return &sp.BindAddress }),
+	stringColumn("direction", func(sp *models.InventoryServicePort) *string { return &sp.Direction }),
+	listColumn("allowlist", func(sp *models.InventoryServicePort) *[]string { return &sp.Allowlist }),
+	{
+		name: "apply_to_all_hosts",
+		get: func(sp *models.InventoryServicePort) string {
+			return strconv.FormatBool(sp.ApplyToAllHosts)
+		},
+		set: func(sp *models.InventoryServicePort, s string) error {
+			if s == "" {
+				return nil
+			}
+			all, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("apply_to_all_hosts: invalid boolean %q", s)
+			}
+			sp.ApplyToAllHosts = all
+			return nil
+		},
+	},
+	listColumn("hosts", func(sp *models.InventoryServicePort) *[]string { return &sp.Hosts }),
+	stringColumn("description", func(sp *models.InventoryServicePort) *string { return &sp.Description }),
+}
+
+func writeCSV[T any](w io.Writer, columns []column[T], rows []T) error {
+	writer := csv.NewWriter(w)
+
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.name
+	}
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for i := range rows {
+		record := make([]string, len(columns))
+		for j, col := range columns {
+			record[j] = col.get(&rows[i])
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// readCSV reads the rows of a CSV file with a header naming its columns, and returns them with
+// the names of the columns in the header. Columns may be omitted and appear in any order.
+// A row with an invalid value is returned with its error.
+func readCSV[T any](r io.Reader, columns []column[T]) ([]T, []error, map[string]bool, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, nil, fmt.Errorf("CSV file is empty")
+		}
+		return nil, nil, nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	byName := make(map[string]column[T], len(columns))
+	for _, col := range columns {
+		byName[col.name] = col
+	}
+
+	used := make([]column[T], len(header))
+	seen := make(map[string]bool)
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		col, ok := byName[name]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		if seen[name] {
+			return nil, nil, nil, fmt.Errorf("duplicated CSV column %q", name)
+		}
+		seen[name] = true
+		used[i] = col
+	}
+
+	var rows []T
+	var rowErrs []error
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var row T
+		if err == nil {
+			for i, value := range record {
+				err = used[i].set(&row, strings.TrimSpace(value))
+				if err != nil {
+					break
+				}
+			}
+		} else if !errors.Is(err, csv.ErrFieldCount) {
+			// The reader cannot go past a syntax error.
+			return nil, nil, nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		rows = append(rows, row)
+		rowErrs = append(rowErrs, err)
+	}
+
+	return rows, rowErrs, seen, nil
+}
+
+// WriteHostsCSV writes hosts as a CSV file with a header row.
+func WriteHostsCSV(w io.Writer, hosts []models.InventoryHost) error {
+	return writeCSV(w, hostColumns, hosts)
+}
+
+// WriteServicePortsCSV writes service ports as a CSV file with a header row.
+func WriteServicePortsCSV(w io.Writer, sps []models.InventoryServicePort) error {
+	return writeCSV(w, servicePortColumns, sps)
+}
+
+// ReadHostsCSV reads a CSV file of hosts. Rows are numbered from 1, not counting the header.
+func ReadHostsCSV(r io.Reader) ([]HostRow, error) {
+	hosts, errs, fields, err := readCSV(r, hostColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]HostRow, len(hosts))
+	for i := range hosts {
+		rows[i] = HostRow{Row: i + 1, Host: hosts[i], Fields: fields, Err: errs[i]}
+	}
+
+	return rows, nil
+}
+
+// ReadServicePortsCSV reads a CSV file of service ports. Rows are numbered from 1, not counting the header.
+func ReadServicePortsCSV(r io.Reader) ([]ServicePortRow, error) {
+	sps, errs, fields, err := readCSV(r, servicePortColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]ServicePortRow, len(sps))
+	for i := range sps {
+		rows[i] = ServicePortRow{Row: i + 1, ServicePort: sps[i], Fields: fields, Err: errs[i]}
+	}
+
+	return rows, nil
+}
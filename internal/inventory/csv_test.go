@@ -0,0 +1,234 @@
+package inventory
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestReadHostsCSV(t *testing.T) {
+	maxAttempts := 10
+	enabled := false
+
+	tests := []struct {
+		name       string
+		data       string
+		wantHosts  []models.InventoryHost
+		wantFields map[string]bool
+		wantRowErr []bool
+		wantErr    bool
+	}{
+		{
+			name: "every column",
+			data: "ip,port,user,password,private_key,passphrase,ssh_key,host_group,jump_hosts,host_key,reconnect_policy,enabled,description\n" +
+				`192.168.0.10,2222,deploy,secret,,,deploy-key,web,192.168.0.1 192.168.0.2,,"{""max_attempts"":10}",false,web-1` + "\n",
+			wantHosts: []models.InventoryHost{{
+				IP:              "192.168.0.10",
+				Port:            2222,
+				User:            "deploy",
+				Password:        "secret",
+				SSHKey:          "deploy-key",
+				HostGroup:       "web",
+				JumpHosts:       []string{"192.168.0.1", "192.168.0.2"},
+				ReconnectPolicy: &models.ReconnectPolicy{MaxAttempts: &maxAttempts},
+				Enabled:         &enabled,
+				Description:     "web-1",
+			}},
+			wantRowErr: []bool{false},
+		},
+		{
+			name:       "partial columns in any order",
+			data:       "description, ip\nweb-1, 192.168.0.10\nweb-2,192.168.0.11\n",
+			wantHosts:  []models.InventoryHost{{IP: "192.168.0.10", Description: "web-1"}, {IP: "192.168.0.11", Description: "web-2"}},
+			wantFields: map[string]bool{"ip": true, "description": true},
+			wantRowErr: []bool{false, false},
+		},
+		{
+			name:       "empty values are left unset",
+			data:       "ip,port,jump_hosts,reconnect_policy,enabled\n192.168.0.10,,,,\n",
+			wantHosts:  []models.InventoryHost{{IP: "192.168.0.10"}},
+			wantRowErr: []bool{false},
+		},
+		{
+			name:       "invalid values fail their row only",
+			data:       "ip,port,enabled\n192.168.0.10,ssh,\n192.168.0.11,22,maybe\n192.168.0.12,22,true\n",
+			wantHosts:  []models.InventoryHost{{IP: "192.168.0.10"}, {IP: "192.168.0.11", Port: 22}, {IP: "192.168.0.12", Port: 22, Enabled: boolPointer(true)}},
+			wantRowErr: []bool{true, true, false},
+		},
+		{
+			name:       "wrong number of fields fails the row",
+			data:       "ip,user\n192.168.0.10\n192.168.0.11,deploy\n",
+			wantHosts:  []models.InventoryHost{{}, {IP: "192.168.0.11", User: "deploy"}},
+			wantRowErr: []bool{true, false},
+		},
+		{
+			name:    "unknown column",
+			data:    "ip,colour\n192.168.0.10,blue\n",
+			wantErr: true,
+		},
+		{
+			name:    "duplicated column",
+			data:    "ip,user,ip\n192.168.0.10,deploy,192.168.0.11\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty file",
+			data:    "",
+			wantErr: true,
+		},
+		{
+			name:    "syntax error",
+			data:    "ip,description\n192.168.0.10,\"web\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := ReadHostsCSV(strings.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadHostsCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(rows) != len(tt.wantHosts) {
+				t.Fatalf("ReadHostsCSV() returned %d rows, want %d", len(rows), len(tt.wantHosts))
+			}
+			for i, row := range rows {
+				if row.Row != i+1 {
+					t.Errorf("row %d is numbered %d", i+1, row.Row)
+				}
+				if (row.Err != nil) != tt.wantRowErr[i] {
+					t.Errorf("row %d error = %v, wantErr %v", i+1, row.Err, tt.wantRowErr[i])
+				}
+				if !reflect.DeepEqual(row.Host, tt.wantHosts[i]) {
+					t.Errorf("row %d = %+v, want %+v", i+1, row.Host, tt.wantHosts[i])
+				}
+				if tt.wantFields != nil && !reflect.DeepEqual(row.Fields, tt.wantFields) {
+					t.Errorf("row %d fields = %v, want %v", i+1, row.Fields, tt.wantFields)
+				}
+			}
+		})
+	}
+}
+
+func TestReadServicePortsCSV(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		want       []models.InventoryServicePort
+		wantFields map[string]bool
+		wantRowErr []bool
+	}{
+		{
+			name: "remote and dynamic",
+			data: "service_ip,service_port,local_port,bind_address,direction,allowlist,apply_to_all_hosts,hosts,description\n" +
+				"10.0.0.5,5432,15432,127.0.0.1,local,,false,192.168.0.10 192.168.0.11,postgres\n" +
+				",,1080,,dynamic,10.0.0.0/8 *.internal,,192.168.0.10,\n",
+			want: []models.InventoryServicePort{
+				{
+					ServiceIP:   "10.0.0.5",
+					ServicePort: 5432,
+					LocalPort:   15432,
+					BindAddress: "127.0.0.1",
+					Direction:   "local",
+					Hosts:       []string{"192.168.0.10", "192.168.0.11"},
+					Description: "postgres",
+				},
+				{
+					LocalPort: 1080,
+					Direction: "dynamic",
+					Allowlist: []string{"10.0.0.0/8", "*.internal"},
+					Hosts:     []string{"192.168.0.10"},
+				},
+			},
+			wantRowErr: []bool{false, false},
+		},
+		{
+			name:       "partial columns",
+			data:       "service_port,service_ip,description\n5432,10.0.0.5,primary\n",
+			want:       []models.InventoryServicePort{{ServiceIP: "10.0.0.5", ServicePort: 5432, Description: "primary"}},
+			wantFields: map[string]bool{"service_ip": true, "service_port": true, "description": true},
+			wantRowErr: []bool{false},
+		},
+		{
+			name:       "invalid boolean",
+			data:       "service_ip,service_port,local_port,apply_to_all_hosts\n10.0.0.5,5432,15432,all\n",
+			want:       []models.InventoryServicePort{{ServiceIP: "10.0.0.5", ServicePort: 5432, LocalPort: 15432}},
+			wantRowErr: []bool{true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := ReadServicePortsCSV(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ReadServicePortsCSV() error = %v", err)
+			}
+
+			if len(rows) != len(tt.want) {
+				t.Fatalf("ReadServicePortsCSV() returned %d rows, want %d", len(rows), len(tt.want))
+			}
+			for i, row := range rows {
+				if (row.Err != nil) != tt.wantRowErr[i] {
+					t.Errorf("row %d error = %v, wantErr %v", i+1, row.Err, tt.wantRowErr[i])
+				}
+				if !reflect.DeepEqual(row.ServicePort, tt.want[i]) {
+					t.Errorf("row %d = %+v, want %+v", i+1, row.ServicePort, tt.want[i])
+				}
+				if tt.wantFields != nil && !reflect.DeepEqual(row.Fields, tt.wantFields) {
+					t.Errorf("row %d fields = %v, want %v", i+1, row.Fields, tt.wantFields)
+				}
+			}
+		})
+	}
+}
+
+func TestHostsCSVRoundTrip(t *testing.T) {
+	maxAttempts := 3
+	hosts := []models.InventoryHost{
+		{
+			IP:              "192.168.0.10",
+			Port:            22,
+			User:            "deploy",
+			Password:        "a,b \"c\"",
+			HostGroup:       "web",
+			JumpHosts:       []string{"192.168.0.1"},
+			ReconnectPolicy: &models.ReconnectPolicy{MaxAttempts: &maxAttempts},
+			Enabled:         boolPointer(true),
+			Description:     "line 1\nline 2",
+		},
+		{IP: "192.168.0.1", Port: 22, User: "jump", SSHKey: "jump-key", Enabled: boolPointer(false)},
+	}
+
+	var buf bytes.Buffer
+	err := WriteHostsCSV(&buf, hosts)
+	if err != nil {
+		t.Fatalf("WriteHostsCSV() error = %v", err)
+	}
+
+	rows, err := ReadHostsCSV(&buf)
+	if err != nil {
+		t.Fatalf("ReadHostsCSV() error = %v", err)
+	}
+	if len(rows) != len(hosts) {
+		t.Fatalf("ReadHostsCSV() returned %d rows, want %d", len(rows), len(hosts))
+	}
+	for i, row := range rows {
+		if row.Err != nil {
+			t.Errorf("row %d error = %v", i+1, row.Err)
+		}
+		if !reflect.DeepEqual(row.Host, hosts[i]) {
+			t.Errorf("row %d = %+v, want %+v", i+1, row.Host, hosts[i])
+		}
+	}
+}
+
+func boolPointer(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,216 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	// ActionUnchanged is reported for an imported row that matches the database.
+	ActionUnchanged = "unchanged"
+
+	KindHost        = "host"
+	KindJumpHosts   = "jump_hosts"
+	KindHostKey     = "host_key"
+	KindServicePort = "service_port"
+	KindAssignment  = "assignment"
+)
+
+// Parse reads an inventory written in YAML or JSON. Unknown fields are rejected so that
+// a misspelled field is not silently ignored.
+func Parse(data []byte) (*models.Inventory, error) {
+	data, err := toJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var inv models.Inventory
+	err = decodeStrict(data, &inv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory: %w", err)
+	}
+
+	return &inv, nil
+}
+
+// HostRow is a host read from an import file. Fields holds the names of the fields given in the row,
+// its JSON keys or the CSV columns. Err is set when the row could not be read.
+type HostRow struct {
+	Row    int
+	Host   models.InventoryHost
+	Fields map[string]bool
+	Err    error
+}
+
+// ServicePortRow is a service port read from an import file. Fields holds the names of the fields
+// given in the row, its JSON keys or the CSV columns. Err is set when the row could not be read.
+type ServicePortRow struct {
+	Row         int
+	ServicePort models.InventoryServicePort
+	Fields      map[string]bool
+	Err         error
+}
+
+// ReadRows reads the hosts and service ports of an inventory written in JSON or YAML one row
+// at a time, so that a row with an invalid or unknown field does not fail the others.
+// Rows are numbered from 1 in each list.
+func ReadRows(data []byte) ([]HostRow, []ServicePortRow, error) {
+	data, err := toJSON(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var raw struct {
+		Hosts        []json.RawMessage `json:"hosts"`
+		ServicePorts []json.RawMessage `json:"service_ports"`
+	}
+	err = decodeStrict(data, &raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid inventory: %w", err)
+	}
+
+	hosts := make([]HostRow, len(raw.Hosts))
+	for i, item := range raw.Hosts {
+		hosts[i].Row = i + 1
+		hosts[i].Err = decodeStrict(item, &hosts[i].Host)
+		if hosts[i].Err == nil {
+			hosts[i].Fields, hosts[i].Err = keys(item)
+		}
+	}
+
+	sps := make([]ServicePortRow, len(raw.ServicePorts))
+	for i, item := range raw.ServicePorts {
+		sps[i].Row = i + 1
+		sps[i].Err = decodeStrict(item, &sps[i].ServicePort)
+		if sps[i].Err == nil {
+			sps[i].Fields, sps[i].Err = keys(item)
+		}
+	}
+
+	return hosts, sps, nil
+}
+
+// toJSON converts a YAML document to JSON. JSON is returned as it is.
+func toJSON(data []byte) ([]byte, error) {
+	if json.Valid(data) {
+		return data, nil
+	}
+
+	var doc interface{}
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+
+	data, err = json.Marshal(jsonValue(doc))
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+
+	return data, nil
+}
+
+// keys returns the keys of a JSON object.
+func keys(data []byte) (map[string]bool, error) {
+	var object map[string]json.RawMessage
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool, len(object))
+	for key := range object {
+		fields[key] = true
+	}
+
+	return fields, nil
+}
+
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+// jsonValue converts the maps decoded by yaml.v2, which have interface{} keys, into maps JSON can encode.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonValue(v[i])
+		}
+	}
+
+	return v
+}
+
+// ServicePortKey identifies a service port in an inventory. Remote and local service ports are
+// identified by their destination, dynamic ones by the address they listen on.
+func ServicePortKey(direction string, serviceIP *string, servicePort int, bindAddress string, localPort int) string {
+	if direction == "dynamic" {
+		return fmt.Sprintf("dynamic %s:%d", bindAddress, localPort)
+	}
+
+	ip := ""
+	if serviceIP != nil {
+		ip = *serviceIP
+	}
+
+	return fmt.Sprintf("%s:%d", ip, servicePort)
+}
+
+// AssignmentKey identifies the assignment of a service port to a host.
+func AssignmentKey(hostIP, spKey string) string {
+	return hostIP + " -> " + spKey
+}
+
+var actionSymbols = map[string]string{
+	ActionCreate: "+",
+	ActionUpdate: "~",
+	ActionDelete: "-",
+}
+
+// WritePlan prints plan one change per line, followed by a summary.
+func WritePlan(w io.Writer, plan *models.InventoryPlan) error {
+	var b strings.Builder
+
+	for _, change := range plan.Changes {
+		fmt.Fprintf(&b, "%s %s %s", actionSymbols[change.Action], change.Kind, change.Key)
+		if len(change.Fields) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(change.Fields, ", "))
+		}
+		b.WriteString("\n")
+		if change.Error != "" {
+			fmt.Fprintf(&b, "    error: %s\n", change.Error)
+		}
+	}
+
+	switch {
+	case len(plan.Changes) == 0:
+		b.WriteString("No changes, the database matches the inventory.\n")
+	case plan.Applied:
+		fmt.Fprintf(&b, "Applied %d of %d changes, %d failed.\n",
+			len(plan.Changes)-plan.Failed, len(plan.Changes), plan.Failed)
+	default:
+		fmt.Fprintf(&b, "Plan: %d to create, %d to update, %d to delete.\n", plan.Create, plan.Update, plan.Delete)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
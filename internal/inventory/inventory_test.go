@@ -0,0 +1,260 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestParse(t *testing.T) {
+	maxAttempts := 10
+	enabled := false
+	want := &models.Inventory{
+		Hosts: []models.InventoryHost{
+			{
+				IP:              "192.168.0.10",
+				Port:            2222,
+				User:            "deploy",
+				SSHKey:          "deploy-key",
+				ReconnectPolicy: &models.ReconnectPolicy{MaxAttempts: &maxAttempts},
+				JumpHosts:       []string{"192.168.0.1"},
+				Enabled:         &enabled,
+			},
+		},
+		ServicePorts: []models.InventoryServicePort{
+			{
+				LocalPort: 1080,
+				Direction: "dynamic",
+				Allowlist: []string{"10.0.0.0/8", "*.internal"},
+				Hosts:     []string{"192.168.0.10"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    *models.Inventory
+		wantErr bool
+	}{
+		{
+			name: "JSON",
+			data: `{
+				"hosts": [{"ip": "192.168.0.10", "port": 2222, "user": "deploy", "ssh_key": "deploy-key",
+					"reconnect_policy": {"max_attempts": 10}, "jump_hosts": ["192.168.0.1"], "enabled": false}],
+				"service_ports": [{"local_port": 1080, "direction": "dynamic",
+					"allowlist": ["10.0.0.0/8", "*.internal"], "hosts": ["192.168.0.10"]}]
+			}`,
+			want: want,
+		},
+		{
+			name: "YAML",
+			data: `
+hosts:
+  - ip: 192.168.0.10
+    port: 2222
+    user: deploy
+    ssh_key: deploy-key
+    reconnect_policy:
+      max_attempts: 10
+    jump_hosts: [192.168.0.1]
+    enabled: false
+service_ports:
+  - local_port: 1080
+    direction: dynamic
+    allowlist: [10.0.0.0/8, "*.internal"]
+    hosts: [192.168.0.10]
+`,
+			want: want,
+		},
+		{
+			name: "empty YAML document",
+			data: "hosts: []\n",
+			want: &models.Inventory{Hosts: []models.InventoryHost{}},
+		},
+		{
+			name:    "unknown field",
+			data:    "hosts:\n  - ip: 192.168.0.10\n    colour: blue\n",
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			data:    `{"hosts": [{"ip": "192.168.0.10", "port": "ssh"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid YAML",
+			data:    "hosts: [192.168.0.10\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServicePortKey(t *testing.T) {
+	serviceIP := "10.0.0.5"
+	tests := []struct {
+		name        string
+		direction   string
+		serviceIP   *string
+		servicePort int
+		bindAddress string
+		localPort   int
+		want        string
+	}{
+		{
+			name:        "remote",
+			direction:   "remote",
+			serviceIP:   &serviceIP,
+			servicePort: 5432,
+			bindAddress: "0.0.0.0",
+			localPort:   15432,
+			want:        "10.0.0.5:5432",
+		},
+		{
+			name:        "local uses the same key as remote",
+			direction:   "local",
+			serviceIP:   &serviceIP,
+			servicePort: 5432,
+			bindAddress: "127.0.0.1",
+			localPort:   25432,
+			want:        "10.0.0.5:5432",
+		},
+		{
+			name:        "dynamic",
+			direction:   "dynamic",
+			bindAddress: "127.0.0.1",
+			localPort:   1080,
+			want:        "dynamic 127.0.0.1:1080",
+		},
+		{
+			name:        "dynamic ignores the service",
+			direction:   "dynamic",
+			serviceIP:   &serviceIP,
+			servicePort: 5432,
+			bindAddress: "0.0.0.0",
+			localPort:   1080,
+			want:        "dynamic 0.0.0.0:1080",
+		},
+		{
+			name:        "remote without service IP",
+			direction:   "remote",
+			servicePort: 5432,
+			want:        ":5432",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ServicePortKey(tt.direction, tt.serviceIP, tt.servicePort, tt.bindAddress, tt.localPort)
+			if got != tt.want {
+				t.Errorf("ServicePortKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantHosts    []models.InventoryHost
+		wantHostKeys []map[string]bool
+		wantHostErr  []bool
+		wantSPs      []models.InventoryServicePort
+		wantSPErr    []bool
+		wantErr      bool
+	}{
+		{
+			name: "JSON rows with the keys they give",
+			data: `{"hosts": [{"ip": "192.168.0.10", "host_group": ""}, {"ip": "192.168.0.11", "description": "web-2"}],
+				"service_ports": [{"service_ip": "10.0.0.5", "service_port": 5432, "hosts": []}]}`,
+			wantHosts:    []models.InventoryHost{{IP: "192.168.0.10"}, {IP: "192.168.0.11", Description: "web-2"}},
+			wantHostKeys: []map[string]bool{{"ip": true, "host_group": true}, {"ip": true, "description": true}},
+			wantHostErr:  []bool{false, false},
+			wantSPs:      []models.InventoryServicePort{{ServiceIP: "10.0.0.5", ServicePort: 5432, Hosts: []string{}}},
+			wantSPErr:    []bool{false},
+		},
+		{
+			name: "YAML rows with an invalid row",
+			data: `
+hosts:
+  - ip: 192.168.0.10
+    colour: blue
+  - ip: 192.168.0.11
+    port: 2222
+service_ports:
+  - local_port: ssh
+`,
+			wantHosts:    []models.InventoryHost{{IP: "192.168.0.10"}, {IP: "192.168.0.11", Port: 2222}},
+			wantHostKeys: []map[string]bool{nil, {"ip": true, "port": true}},
+			wantHostErr:  []bool{true, false},
+			wantSPs:      []models.InventoryServicePort{{}},
+			wantSPErr:    []bool{true},
+		},
+		{
+			name:    "unknown top level field",
+			data:    `{"hosts": [], "groups": []}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid document",
+			data:    "hosts: [",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, sps, err := ReadRows([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadRows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(hosts) != len(tt.wantHosts) {
+				t.Fatalf("ReadRows() returned %d hosts, want %d", len(hosts), len(tt.wantHosts))
+			}
+			for i, row := range hosts {
+				if row.Row != i+1 {
+					t.Errorf("host row %d is numbered %d", i+1, row.Row)
+				}
+				if (row.Err != nil) != tt.wantHostErr[i] {
+					t.Errorf("host row %d error = %v, wantErr %v", i+1, row.Err, tt.wantHostErr[i])
+				}
+				if !reflect.DeepEqual(row.Host, tt.wantHosts[i]) {
+					t.Errorf("host row %d = %+v, want %+v", i+1, row.Host, tt.wantHosts[i])
+				}
+				if !reflect.DeepEqual(row.Fields, tt.wantHostKeys[i]) {
+					t.Errorf("host row %d fields = %v, want %v", i+1, row.Fields, tt.wantHostKeys[i])
+				}
+			}
+
+			if len(sps) != len(tt.wantSPs) {
+				t.Fatalf("ReadRows() returned %d service ports, want %d", len(sps), len(tt.wantSPs))
+			}
+			for i, row := range sps {
+				if (row.Err != nil) != tt.wantSPErr[i] {
+					t.Errorf("service port row %d error = %v, wantErr %v", i+1, row.Err, tt.wantSPErr[i])
+				}
+				if !reflect.DeepEqual(row.ServicePort, tt.wantSPs[i]) {
+					t.Errorf("service port row %d = %+v, want %+v", i+1, row.ServicePort, tt.wantSPs[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const namespace = "tunnel_manager"
+
+// tunnelLabels identify the tunnel of a service port on a host.
+var tunnelLabels = []string{"host_id", "host_ip", "sp_id", "service_port"}
+
+// Flows of forwarded bytes.
+const (
+	// flowToService counts bytes copied from the client to the service.
+	flowToService = "to_service"
+	// flowToClient counts bytes copied from the service back to the client.
+	flowToClient = "to_client"
+)
+
+var (
+	tunnelStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tunnel_status",
+		Help:      "Current status of a tunnel, 1 for the status the tunnel is in.",
+	}, append(tunnelLabels, "direction", "status"))
+
+	tunnelReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnel_reconnects_total",
+		Help:      "Number of times a tunnel started reconnecting.",
+	}, tunnelLabels)
+
+	activeConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tunnel_active_connections",
+		Help:      "Number of connections currently forwarded through a tunnel.",
+	}, tunnelLabels)
+
+	forwardedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnel_forwarded_bytes_total",
+		Help:      "Bytes forwarded through a tunnel, by flow (to_service or to_client).",
+	}, append(tunnelLabels, "flow"))
+
+	sshDialDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ssh_dial_duration_seconds",
+		Help:      "Time taken to establish the SSH connection of a host, including jump hosts.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"host_id", "host_ip", "result"})
+
+	dbErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "database_errors_total",
+		Help:      "Number of failed database queries, by operation and table.",
+	}, []string{"operation", "table"})
+
+	webhookDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_status_changes_dropped_total",
+		Help:      "Number of tunnel status changes the webhook dispatcher dropped because its queue was full.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		tunnelStatus,
+		tunnelReconnects,
+		activeConnections,
+		forwardedBytes,
+		sshDialDuration,
+		dbErrors,
+		webhookDropped,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Tunnel records the metrics of one tunnel. Once deleted it records nothing,
+// so that connections closed after the tunnel is stopped do not recreate its series.
+type Tunnel struct {
+	labels     prometheus.Labels
+	direction  string
+	reconnects prometheus.Counter
+	active     prometheus.Gauge
+	toService  prometheus.Counter
+	toClient   prometheus.Counter
+	deleted    bool
+	mu         sync.RWMutex
+}
+
+// NewTunnel returns the metrics of the tunnel of service port spID on host hostID.
+// servicePort is 0 for dynamic tunnels, which have no fixed destination.
+func NewTunnel(hostID uint, hostIP string, spID uint, servicePort int, direction string) *Tunnel {
+	port := ""
+	if servicePort != 0 {
+		port = strconv.Itoa(servicePort)
+	}
+
+	t := &Tunnel{
+		labels: prometheus.Labels{
+			"host_id":      strconv.FormatUint(uint64(hostID), 10),
+			"host_ip":      hostIP,
+			"sp_id":        strconv.FormatUint(uint64(spID), 10),
+			"service_port": port,
+		},
+		direction: direction,
+	}
+	t.reconnects = tunnelReconnects.With(t.labels)
+	t.active = activeConnections.With(t.labels)
+	t.toService = forwardedBytes.With(t.with(prometheus.Labels{"flow": flowToService}))
+	t.toClient = forwardedBytes.With(t.with(prometheus.Labels{"flow": flowToClient}))
+
+	return t
+}
+
+func (t *Tunnel) with(extra prometheus.Labels) prometheus.Labels {
+	labels := make(prometheus.Labels, len(t.labels)+len(extra))
+	for k, v := range t.labels {
+		labels[k] = v
+	}
+	for k, v := range extra {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+// SetStatus marks status as the current status of the tunnel.
+func (t *Tunnel) SetStatus(status string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.deleted {
+		return
+	}
+
+	tunnelStatus.DeletePartialMatch(t.labels)
+	tunnelStatus.With(t.with(prometheus.Labels{"direction": t.direction, "status": status})).Set(1)
+}
+
+// Reconnected counts a reconnect attempt of the tunnel.
+func (t *Tunnel) Reconnected() {
+	if t == nil {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.deleted {
+		return
+	}
+
+	t.reconnects.Inc()
+}
+
+// ConnectionOpened counts a newly forwarded connection as active.
+func (t *Tunnel) ConnectionOpened() {
+	if t == nil {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.deleted {
+		return
+	}
+
+	t.active.Inc()
+}
+
+// ConnectionClosed removes a forwarded connection from the active connections.
+func (t *Tunnel) ConnectionClosed() {
+	if t == nil {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.deleted {
+		return
+	}
+
+	t.active.Dec()
+}
+
+// ForwardedToService counts n bytes copied from the client to the service.
+func (t *Tunnel) ForwardedToService(n int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.deleted {
+		return
+	}
+
+	t.toService.Add(float64(n))
+}
+
+// ForwardedToClient counts n bytes copied from the service back to the client.
+func (t *Tunnel) ForwardedToClient(n int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.deleted {
+		return
+	}
+
+	t.toClient.Add(float64(n))
+}
+
+// Delete removes every series of the tunnel once it is stopped.
+func (t *Tunnel) Delete() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.deleted = true
+
+	tunnelStatus.DeletePartialMatch(t.labels)
+	tunnelReconnects.DeletePartialMatch(t.labels)
+	activeConnections.DeletePartialMatch(t.labels)
+	forwardedBytes.DeletePartialMatch(t.labels)
+}
+
+// ObserveSSHDial records how long establishing the SSH connection of a host took.
+func ObserveSSHDial(hostID uint, hostIP string, seconds float64, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	sshDialDuration.WithLabelValues(strconv.FormatUint(uint64(hostID), 10), hostIP, result).Observe(seconds)
+}
+
+// InstrumentDB counts the failed queries of db. Missing records are not counted as errors.
+func InstrumentDB(db *gorm.DB) error {
+	count := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if tx.Error == nil || errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				return
+			}
+			dbErrors.WithLabelValues(operation, tx.Statement.Table).Inc()
+		}
+	}
+
+	callback := db.Callback()
+	err := callback.Create().After("gorm:create").Register("metrics:create", count("create"))
+	if err != nil {
+		return err
+	}
+	err = callback.Query().After("gorm:query").Register("metrics:query", count("query"))
+	if err != nil {
+		return err
+	}
+	err = callback.Update().After("gorm:update").Register("metrics:update", count("update"))
+	if err != nil {
+		return err
+	}
+	err = callback.Delete().After("gorm:delete").Register("metrics:delete", count("delete"))
+	if err != nil {
+		return err
+	}
+	err = callback.Row().After("gorm:row").Register("metrics:row", count("row"))
+	if err != nil {
+		return err
+	}
+
+	return callback.Raw().After("gorm:raw").Register("metrics:raw", count("raw"))
+}
+
+// WebhookStatusChangeDropped counts a tunnel status change the webhook dispatcher could not queue.
+func WebhookStatusChangeDropped() {
+	webhookDropped.Inc()
+}
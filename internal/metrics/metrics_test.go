@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// value returns the value of the series of the metric with the given labels.
+func value(t *testing.T, name string, labels prometheus.Labels) (float64, bool) {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, metric := range family.GetMetric() {
+			got := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				got[label.GetName()] = label.GetValue()
+			}
+			for k, v := range labels {
+				if got[k] != v {
+					continue series
+				}
+			}
+
+			switch {
+			case metric.GetGauge() != nil:
+				return metric.GetGauge().GetValue(), true
+			case metric.GetCounter() != nil:
+				return metric.GetCounter().GetValue(), true
+			case metric.GetHistogram() != nil:
+				return float64(metric.GetHistogram().GetSampleCount()), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func TestTunnel(t *testing.T) {
+	tunnel := NewTunnel(1001, "192.168.0.10", 2001, 5432, "remote")
+	labels := prometheus.Labels{"host_id": "1001", "host_ip": "192.168.0.10", "sp_id": "2001", "service_port": "5432"}
+	with := func(name, value string) prometheus.Labels {
+		l := prometheus.Labels{name: value}
+		for k, v := range labels {
+			l[k] = v
+		}
+		return l
+	}
+
+	tunnel.SetStatus("starting")
+	tunnel.SetStatus("connected")
+	tunnel.Reconnected()
+	tunnel.ConnectionOpened()
+	tunnel.ConnectionOpened()
+	tunnel.ConnectionClosed()
+	tunnel.ForwardedToService(10)
+	tunnel.ForwardedToClient(20)
+	tunnel.ForwardedToClient(5)
+
+	tests := []struct {
+		name   string
+		metric string
+		labels prometheus.Labels
+		want   float64
+	}{
+		{name: "current status", metric: "tunnel_manager_tunnel_status", labels: with("status", "connected"), want: 1},
+		{name: "reconnects", metric: "tunnel_manager_tunnel_reconnects_total", labels: labels, want: 1},
+		{name: "active connections", metric: "tunnel_manager_tunnel_active_connections", labels: labels, want: 1},
+		{name: "bytes to service", metric: "tunnel_manager_tunnel_forwarded_bytes_total", labels: with("flow", flowToService), want: 10},
+		{name: "bytes to client", metric: "tunnel_manager_tunnel_forwarded_bytes_total", labels: with("flow", flowToClient), want: 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := value(t, tt.metric, tt.labels)
+			if !ok || got != tt.want {
+				t.Errorf("%s%v = %v (found %v), want %v", tt.metric, tt.labels, got, ok, tt.want)
+			}
+		})
+	}
+
+	// Only the current status is reported.
+	if _, ok := value(t, "tunnel_manager_tunnel_status", with("status", "starting")); ok {
+		t.Errorf("previous status is still reported")
+	}
+
+	// A deleted tunnel has no series and records nothing more.
+	tunnel.Delete()
+	tunnel.ForwardedToService(10)
+	tunnel.SetStatus("stopped")
+	for _, metric := range []string{"tunnel_manager_tunnel_status", "tunnel_manager_tunnel_forwarded_bytes_total",
+		"tunnel_manager_tunnel_active_connections", "tunnel_manager_tunnel_reconnects_total"} {
+		if _, ok := value(t, metric, labels); ok {
+			t.Errorf("%s is still reported after Delete", metric)
+		}
+	}
+}
+
+func TestObserveSSHDial(t *testing.T) {
+	ObserveSSHDial(1002, "192.168.0.11", 0.2, nil)
+	ObserveSSHDial(1002, "192.168.0.11", 5, errors.New("connection refused"))
+	ObserveSSHDial(1002, "192.168.0.11", 0.1, nil)
+
+	for result, want := range map[string]float64{"success": 2, "failure": 1} {
+		got, _ := value(t, "tunnel_manager_ssh_dial_duration_seconds", prometheus.Labels{"host_id": "1002", "result": result})
+		if got != want {
+			t.Errorf("%s dials = %v, want %v", result, got, want)
+		}
+	}
+}
+
+func TestInstrumentDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	err = InstrumentDB(db)
+	if err != nil {
+		t.Fatalf("InstrumentDB() error = %v", err)
+	}
+
+	type record struct {
+		ID   uint
+		Name string
+	}
+	err = db.Table("metrics_test_records").AutoMigrate(&record{})
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	labels := prometheus.Labels{"operation": "query", "table": "metrics_test_missing"}
+	before, _ := value(t, "tunnel_manager_database_errors_total", labels)
+
+	// A missing record is not an error, a missing table is.
+	err = db.Table("metrics_test_records").First(&record{}).Error
+	if err == nil {
+		t.Fatalf("First() on an empty table succeeded")
+	}
+	_ = db.Table("metrics_test_missing").Find(&[]record{}).Error
+
+	after, _ := value(t, "tunnel_manager_database_errors_total", labels)
+	if after-before != 1 {
+		t.Errorf("query errors on the missing table increased by %v, want 1", after-before)
+	}
+	if _, ok := value(t, "tunnel_manager_database_errors_total", prometheus.Labels{"table": "metrics_test_records"}); ok {
+		t.Errorf("a missing record was counted as a database error")
+	}
+}
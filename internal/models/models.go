@@ -2,68 +2,392 @@ package models
 
 import (
 	"time"
+
+	// Registers the "secret" serializer used by credential fields.
+	_ "github.com/jollaman999/tunnel-manager/internal/secret"
 )
 
 type Host struct {
+	ID                 uint             `gorm:"primaryKey;autoIncrement" json:"id"`
+	IP                 string           `gorm:"uniqueIndex:idx_hosts_ip;not null" json:"ip"`
+	Port               int              `gorm:"not null" json:"port"`
+	User               string           `gorm:"not null" json:"user"`
+	Password           string           `gorm:"type:text;serializer:secret" json:"-"`
+	PrivateKey         string           `gorm:"type:text;serializer:secret" json:"-"`
+	Passphrase         string           `gorm:"type:text;serializer:secret" json:"-"`
+	SSHKeyID           *uint            `gorm:"index" json:"ssh_key_id"`
+	HostGroupID        *uint            `gorm:"index" json:"host_group_id"`
+	ReconnectPolicy    *ReconnectPolicy `gorm:"type:text;serializer:json" json:"reconnect_policy"`
+	HostKey            string           `gorm:"type:text" json:"host_key"`
+	HostKeyFingerprint string           `json:"host_key_fingerprint"`
+	Description        string           `json:"description"`
+	Enabled            bool             `gorm:"default:true" json:"enabled"`
+	CreatedAt          time.Time        `json:"created_at"`
+	UpdatedAt          time.Time        `json:"updated_at"`
+}
+
+type ReconnectPolicy struct {
+	InitialDelaySec *int     `json:"initial_delay_sec,omitempty" validate:"omitempty,min=1"`
+	Multiplier      *float64 `json:"multiplier,omitempty" validate:"omitempty,min=1"`
+	MaxDelaySec     *int     `json:"max_delay_sec,omitempty" validate:"omitempty,min=1"`
+	Jitter          *float64 `json:"jitter,omitempty" validate:"omitempty,min=0,max=1"`
+	MaxAttempts     *int     `json:"max_attempts,omitempty" validate:"omitempty,min=0"`
+}
+
+type SSHKey struct {
 	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
-	IP          string    `gorm:"uniqueIndex:idx_hosts_ip;not null" json:"ip"`
-	Port        int       `gorm:"not null" json:"port"`
-	User        string    `gorm:"not null" json:"user"`
-	Password    string    `gorm:"not null" json:"-"`
+	Name        string    `gorm:"uniqueIndex:idx_ssh_keys_name;size:191;not null" json:"name"`
+	PrivateKey  string    `gorm:"type:text;not null;serializer:secret" json:"-"`
+	Passphrase  string    `gorm:"type:text;serializer:secret" json:"-"`
+	PublicKey   string    `gorm:"type:text" json:"public_key"`
+	Fingerprint string    `json:"fingerprint"`
 	Description string    `json:"description"`
-	Enabled     bool      `gorm:"default:true" json:"enabled"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
-type ServicePort struct {
+type HostGroup struct {
 	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
-	ServiceIP   string    `gorm:"uniqueIndex:idx_service_ip_port;not null" json:"service_ip"`
-	ServicePort int       `gorm:"uniqueIndex:idx_service_ip_port;not null" json:"service_port"`
-	LocalPort   int       `gorm:"not null" json:"local_port"`
+	Name        string    `gorm:"uniqueIndex:idx_host_groups_name;size:191;not null" json:"name"`
 	Description string    `json:"description"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+type ServicePort struct {
+	ID              uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	ServiceIP       *string   `gorm:"uniqueIndex:idx_service_ip_port" json:"service_ip"`
+	ServicePort     int       `gorm:"uniqueIndex:idx_service_ip_port;not null" json:"service_port"`
+	LocalPort       int       `gorm:"not null" json:"local_port"`
+	BindAddress     string    `gorm:"not null;default:'0.0.0.0'" json:"bind_address"`
+	Direction       string    `gorm:"not null;default:'remote'" json:"direction"`
+	Allowlist       []string  `gorm:"type:text;serializer:json" json:"allowlist"`
+	ApplyToAllHosts bool      `gorm:"not null;default:false" json:"apply_to_all_hosts"`
+	Description     string    `json:"description"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type HostServicePort struct {
+	HostID    uint      `gorm:"primaryKey;not null" json:"host_id"`
+	SPID      uint      `gorm:"primaryKey;not null;index" json:"sp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type HostJump struct {
+	HostID     uint      `gorm:"primaryKey;not null" json:"host_id"`
+	Position   int       `gorm:"primaryKey;not null" json:"position"`
+	JumpHostID uint      `gorm:"not null;index" json:"jump_host_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 type Tunnel struct {
-	HostID          uint      `gorm:"primaryKey;not null" json:"host_id"`
-	SPID            uint      `gorm:"primaryKey;not null" json:"sp_id"`
-	Status          string    `gorm:"not null" json:"status"`
-	LastError       string    `json:"last_error"`
-	RetryCount      int       `gorm:"default:0" json:"retry_count"`
-	LastConnectedAt time.Time `json:"last_connected_at"`
-	Server          string    `gorm:"not null" json:"server"`
-	Local           string    `gorm:"not null" json:"local"`
-	Remote          string    `gorm:"not null" json:"remote"`
+	HostID          uint            `gorm:"primaryKey;not null" json:"host_id"`
+	SPID            uint            `gorm:"primaryKey;not null" json:"sp_id"`
+	Direction       string          `gorm:"not null;default:'remote'" json:"direction"`
+	Status          string          `gorm:"not null" json:"status"`
+	LastError       string          `json:"last_error"`
+	RetryCount      int             `gorm:"default:0" json:"retry_count"`
+	LastConnectedAt time.Time       `json:"last_connected_at"`
+	Server          string          `gorm:"not null" json:"server"`
+	Local           string          `gorm:"not null" json:"local"`
+	Remote          string          `gorm:"not null" json:"remote"`
+	Warning         string          `json:"warning"`
+	Traffic         *TunnelTraffic  `gorm:"-" json:"traffic,omitempty"`
+	Schedule        *TunnelSchedule `gorm:"-" json:"schedule,omitempty"`
+}
+
+type TunnelSchedule struct {
+	Open           bool       `json:"open"`
+	NextTransition *time.Time `json:"next_transition"`
+	ScheduleIDs    []uint     `json:"schedule_ids"`
+}
+
+type StoppedTunnel struct {
+	HostID    uint      `gorm:"primaryKey;not null" json:"host_id"`
+	SPID      uint      `gorm:"primaryKey;not null;index" json:"sp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TunnelTraffic struct {
+	HostID         uint       `gorm:"primaryKey;not null" json:"host_id"`
+	SPID           uint       `gorm:"primaryKey;not null" json:"sp_id"`
+	BytesIn        int64      `gorm:"not null;default:0" json:"bytes_in"`
+	BytesOut       int64      `gorm:"not null;default:0" json:"bytes_out"`
+	Connections    int64      `gorm:"not null;default:0" json:"connections"`
+	LastActivityAt *time.Time `json:"last_activity_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type TunnelEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	HostID     uint      `gorm:"not null;index:idx_tunnel_events_tunnel" json:"host_id"`
+	SPID       uint      `gorm:"not null;index:idx_tunnel_events_tunnel" json:"sp_id"`
+	Status     string    `gorm:"size:32;not null" json:"status"`
+	Error      string    `gorm:"type:text" json:"error"`
+	RetryCount int       `gorm:"not null;default:0" json:"retry_count"`
+	CreatedAt  time.Time `gorm:"index:idx_tunnel_events_tunnel;index" json:"created_at"`
+}
+
+type APIToken struct {
+	ID           uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Prefix       string     `gorm:"size:16;not null" json:"prefix"`
+	TokenHash    string     `gorm:"uniqueIndex:idx_api_tokens_hash;size:64;not null" json:"-"`
+	Role         string     `gorm:"size:16;not null;default:'admin'" json:"role"`
+	HostGroupIDs []uint     `gorm:"type:text;serializer:json" json:"host_group_ids"`
+	Description  string     `json:"description"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Token        string     `gorm:"-" json:"token,omitempty"`
+}
+
+type Webhook struct {
+	ID               uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name             string    `gorm:"uniqueIndex:idx_webhooks_name;size:191;not null" json:"name"`
+	URL              string    `gorm:"type:text;not null" json:"url"`
+	Events           []string  `gorm:"type:text;serializer:json" json:"events"`
+	Secret           string    `gorm:"type:text;serializer:secret" json:"-"`
+	Signed           bool      `gorm:"-" json:"signed"`
+	MaxRetries       int       `gorm:"not null;default:3" json:"max_retries"`
+	RetryIntervalSec int       `gorm:"not null;default:10" json:"retry_interval_sec"`
+	Enabled          bool      `gorm:"not null;default:true" json:"enabled"`
+	Description      string    `json:"description"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type Schedule struct {
+	ID          uint           `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name        string         `gorm:"uniqueIndex:idx_schedules_name;size:191;not null" json:"name"`
+	HostID      *uint          `gorm:"index" json:"host_id"`
+	SPID        *uint          `gorm:"index" json:"sp_id"`
+	Open        string         `gorm:"size:255;not null" json:"open"`
+	Close       string         `gorm:"size:255;not null" json:"close"`
+	TimeZone    string         `gorm:"size:64;not null;default:'UTC'" json:"time_zone"`
+	Enabled     bool           `gorm:"not null;default:true" json:"enabled"`
+	Description string         `json:"description"`
+	State       *ScheduleState `gorm:"-" json:"state,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type ScheduleState struct {
+	Open      bool      `json:"open"`
+	NextOpen  time.Time `json:"next_open"`
+	NextClose time.Time `json:"next_close"`
+}
+
+type WebhookDelivery struct {
+	ID         uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	WebhookID  uint      `gorm:"not null;index" json:"webhook_id"`
+	EventID    string    `gorm:"size:32;not null;index" json:"event_id"`
+	Event      string    `gorm:"size:32;not null" json:"event"`
+	HostID     uint      `gorm:"not null" json:"host_id"`
+	SPID       uint      `gorm:"not null" json:"sp_id"`
+	Attempt    int       `gorm:"not null" json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Success    bool      `gorm:"not null;default:false" json:"success"`
+	Error      string    `gorm:"type:text" json:"error"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
 }
 
 type CreateHostRequest struct {
-	IP          string `json:"ip" validate:"required,ip"`
-	Port        int    `json:"port" validate:"required,min=1,max=65535"`
-	User        string `json:"user" validate:"required"`
-	Password    string `json:"password" validate:"required"`
-	Description string `json:"description"`
+	IP              string           `json:"ip" validate:"required,ip"`
+	Port            int              `json:"port" validate:"required,min=1,max=65535"`
+	User            string           `json:"user" validate:"required"`
+	Password        string           `json:"password" validate:"required_without_all=PrivateKey SSHKeyID"`
+	PrivateKey      string           `json:"private_key" validate:"omitempty,excluded_with=SSHKeyID"`
+	Passphrase      string           `json:"passphrase"`
+	SSHKeyID        *uint            `json:"ssh_key_id" validate:"omitempty,min=1"`
+	HostGroupID     *uint            `json:"host_group_id" validate:"omitempty,min=1"`
+	ReconnectPolicy *ReconnectPolicy `json:"reconnect_policy"`
+	HostKey         string           `json:"host_key"`
+	JumpHostIDs     []uint           `json:"jump_host_ids" validate:"omitempty,max=8,dive,min=1"`
+	Description     string           `json:"description"`
+	Enabled         *bool            `json:"enabled"`
 }
 
 type UpdateHostRequest struct {
-	IP          string `json:"ip" validate:"omitempty,ip"`
-	Port        *int   `json:"port" validate:"omitempty,min=1,max=65535"`
-	User        string `json:"user" validate:"omitempty"`
-	Password    string `json:"password" validate:"omitempty"`
+	IP              string           `json:"ip" validate:"omitempty,ip"`
+	Port            *int             `json:"port" validate:"omitempty,min=1,max=65535"`
+	User            string           `json:"user" validate:"omitempty"`
+	Password        string           `json:"password" validate:"omitempty"`
+	PrivateKey      string           `json:"private_key" validate:"omitempty,excluded_with=SSHKeyID"`
+	Passphrase      string           `json:"passphrase"`
+	SSHKeyID        *uint            `json:"ssh_key_id"`
+	HostGroupID     *uint            `json:"host_group_id"`
+	ReconnectPolicy *ReconnectPolicy `json:"reconnect_policy"`
+	Description     string           `json:"description"`
+	Enabled         *bool            `json:"enabled"`
+}
+
+type PinHostKeyRequest struct {
+	HostKey string `json:"host_key" validate:"required"`
+}
+
+type SetJumpHostsRequest struct {
+	JumpHostIDs []uint `json:"jump_host_ids" validate:"max=8,dive,min=1"`
+}
+
+type ImportKnownHostsRequest struct {
+	KnownHosts string `json:"known_hosts" validate:"required"`
+	Overwrite  bool   `json:"overwrite"`
+}
+
+type CreateSSHKeyRequest struct {
+	Name        string `json:"name" validate:"required,max=191"`
+	PrivateKey  string `json:"private_key" validate:"required"`
+	Passphrase  string `json:"passphrase"`
 	Description string `json:"description"`
-	Enabled     *bool  `json:"enabled"`
 }
 
 type CreateServicePortRequest struct {
-	ServiceIP   string `json:"service_ip" validate:"required,ip"`
-	ServicePort int    `json:"service_port" validate:"required,min=1,max=65535"`
-	LocalPort   int    `json:"local_port" validate:"required,min=1,max=65535"`
+	ServiceIP       string   `json:"service_ip" validate:"required_unless=Direction dynamic,excluded_if=Direction dynamic,omitempty,ip"`
+	ServicePort     int      `json:"service_port" validate:"required_unless=Direction dynamic,excluded_if=Direction dynamic,omitempty,min=1,max=65535"`
+	LocalPort       int      `json:"local_port" validate:"required,min=1,max=65535"`
+	BindAddress     string   `json:"bind_address" validate:"omitempty,ip"`
+	Direction       string   `json:"direction" validate:"omitempty,oneof=remote local dynamic"`
+	Allowlist       []string `json:"allowlist" validate:"excluded_unless=Direction dynamic"`
+	ApplyToAllHosts bool     `json:"apply_to_all_hosts" validate:"excluded_if=Direction local,excluded_if=Direction dynamic"`
+	Description     string   `json:"description"`
+}
+
+type CreateHostGroupRequest struct {
+	Name        string `json:"name" validate:"required,max=191"`
+	Description string `json:"description"`
+}
+
+type CreateAPITokenRequest struct {
+	Description  string     `json:"description" validate:"required"`
+	Role         string     `json:"role" validate:"required,oneof=viewer operator admin"`
+	HostGroupIDs []uint     `json:"host_group_ids" validate:"omitempty,dive,min=1"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+type WebhookRequest struct {
+	Name             string   `json:"name" validate:"required,max=191"`
+	URL              string   `json:"url" validate:"required,http_url"`
+	Events           []string `json:"events" validate:"omitempty,dive,oneof=tunnel.error tunnel.reconnecting tunnel.failed tunnel.recovered"`
+	Secret           *string  `json:"secret"`
+	MaxRetries       *int     `json:"max_retries" validate:"omitempty,min=0,max=10"`
+	RetryIntervalSec *int     `json:"retry_interval_sec" validate:"omitempty,min=1,max=3600"`
+	Enabled          *bool    `json:"enabled"`
+	Description      string   `json:"description"`
+}
+
+type ScheduleRequest struct {
+	Name        string `json:"name" validate:"required,max=191"`
+	HostID      *uint  `json:"host_id" validate:"required_without=SPID,omitempty,min=1"`
+	SPID        *uint  `json:"sp_id" validate:"required_without=HostID,omitempty,min=1"`
+	Open        string `json:"open" validate:"required,max=255"`
+	Close       string `json:"close" validate:"required,max=255"`
+	TimeZone    string `json:"time_zone" validate:"max=64"`
+	Enabled     *bool  `json:"enabled"`
 	Description string `json:"description"`
 }
 
+type AssignmentRequest struct {
+	HostID uint `json:"host_id" validate:"required,min=1"`
+	SPID   uint `json:"sp_id" validate:"required,min=1"`
+}
+
+type BulkAssignmentRequest struct {
+	HostIDs []uint `json:"host_ids" validate:"required,min=1,dive,min=1"`
+	SPIDs   []uint `json:"sp_ids" validate:"required,min=1,dive,min=1"`
+}
+
+type TunnelControlRequest struct {
+	HostIDs []uint `json:"host_ids" validate:"required_without=SPIDs,omitempty,dive,min=1"`
+	SPIDs   []uint `json:"sp_ids" validate:"required_without=HostIDs,omitempty,dive,min=1"`
+}
+
+type TunnelControlResult struct {
+	HostID uint   `json:"host_id"`
+	SPID   uint   `json:"sp_id"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Inventory declares hosts, service ports and their assignments. Hosts are identified by IP,
+// service ports by service IP and port (bind address and local port for dynamic ones).
+type Inventory struct {
+	Hosts        []InventoryHost        `json:"hosts"`
+	ServicePorts []InventoryServicePort `json:"service_ports"`
+}
+
+type InventoryHost struct {
+	IP              string           `json:"ip"`
+	Port            int              `json:"port,omitempty"`
+	User            string           `json:"user"`
+	Password        string           `json:"password,omitempty"`
+	PrivateKey      string           `json:"private_key,omitempty"`
+	Passphrase      string           `json:"passphrase,omitempty"`
+	SSHKey          string           `json:"ssh_key,omitempty"`
+	HostGroup       string           `json:"host_group,omitempty"`
+	ReconnectPolicy *ReconnectPolicy `json:"reconnect_policy,omitempty"`
+	HostKey         string           `json:"host_key,omitempty"`
+	JumpHosts       []string         `json:"jump_hosts,omitempty"`
+	Enabled         *bool            `json:"enabled,omitempty"`
+	Description     string           `json:"description,omitempty"`
+}
+
+type InventoryServicePort struct {
+	ServiceIP       string   `json:"service_ip,omitempty"`
+	ServicePort     int      `json:"service_port,omitempty"`
+	LocalPort       int      `json:"local_port"`
+	BindAddress     string   `json:"bind_address,omitempty"`
+	Direction       string   `json:"direction,omitempty"`
+	Allowlist       []string `json:"allowlist,omitempty"`
+	ApplyToAllHosts bool     `json:"apply_to_all_hosts,omitempty"`
+	Hosts           []string `json:"hosts,omitempty"`
+	Description     string   `json:"description,omitempty"`
+}
+
+// InventoryChange is a create, update or delete of a host, service port, assignment or
+// jump host list planned by an inventory. Error is set when applying it failed.
+type InventoryChange struct {
+	Action string   `json:"action"`
+	Kind   string   `json:"kind"`
+	Key    string   `json:"key"`
+	Fields []string `json:"fields,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+type InventoryPlan struct {
+	Changes []InventoryChange `json:"changes"`
+	Create  int               `json:"create"`
+	Update  int               `json:"update"`
+	Delete  int               `json:"delete"`
+	Applied bool              `json:"applied"`
+	Failed  int               `json:"failed"`
+}
+
+// ImportRow is the outcome of importing a host or service port. Row is its position in the
+// hosts or service ports of the import, from 1.
+type ImportRow struct {
+	Kind   string `json:"kind"`
+	Row    int    `json:"row"`
+	Key    string `json:"key,omitempty"`
+	Action string `json:"action,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportResult reports every row of an import. Committed is false when an atomic import
+// was rolled back because a row failed.
+type ImportResult struct {
+	Rows      []ImportRow `json:"rows"`
+	Created   int         `json:"created"`
+	Updated   int         `json:"updated"`
+	Unchanged int         `json:"unchanged"`
+	Failed    int         `json:"failed"`
+	Committed bool        `json:"committed"`
+}
+
 type Response struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success  bool        `json:"success"`
+	Data     interface{} `json:"data,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
 }
@@ -0,0 +1,125 @@
+package secret
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// passphrasePrefix marks a value encrypted by a PassphraseCipher. The full format is
+// "enc:pass:v1:<salt>:<ciphertext>", with both parts base64 encoded.
+const passphrasePrefix = "enc:pass:v1:"
+
+const saltSize = 16
+
+// PassphraseCipher encrypts secrets that leave the database, such as exports, with a key derived
+// from a passphrase by scrypt. Every value carries the salt of its key, so values can be decrypted
+// one by one, while the key is only derived once per salt. It is not safe for concurrent use.
+type PassphraseCipher struct {
+	passphrase []byte
+	salt       []byte
+	keys       map[string]cipher.AEAD
+}
+
+// NewPassphraseCipher creates a cipher that encrypts with a new random salt.
+func NewPassphraseCipher(passphrase string) (*PassphraseCipher, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase is empty")
+	}
+
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	return &PassphraseCipher{
+		passphrase: []byte(passphrase),
+		salt:       salt,
+		keys:       make(map[string]cipher.AEAD),
+	}, nil
+}
+
+func (p *PassphraseCipher) aead(salt []byte) (cipher.AEAD, error) {
+	if aead, ok := p.keys[string(salt)]; ok {
+		return aead, nil
+	}
+
+	key, err := scrypt.Key(p.passphrase, salt, 1<<15, 8, 1, KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	p.keys[string(salt)] = aead
+
+	return aead, nil
+}
+
+// IsPassphraseEncrypted reports whether value was produced by PassphraseCipher.Encrypt.
+func IsPassphraseEncrypted(value string) bool {
+	return strings.HasPrefix(value, passphrasePrefix)
+}
+
+// Encrypt encrypts plaintext under the passphrase. Empty values are kept empty.
+func (p *PassphraseCipher) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	aead, err := p.aead(p.salt)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(aead, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return passphrasePrefix +
+		base64.RawStdEncoding.EncodeToString(p.salt) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt with the same passphrase. Values without the
+// prefix are returned as they are.
+func (p *PassphraseCipher) Decrypt(value string) (string, error) {
+	if !IsPassphraseEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, passphrasePrefix), ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed salt: %w", err)
+	}
+
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %w", err)
+	}
+
+	aead, err := p.aead(salt)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(aead, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value, the passphrase may be wrong")
+	}
+
+	return string(plaintext), nil
+}
@@ -0,0 +1,258 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeySize is the size in bytes of a key encryption key.
+const KeySize = 32
+
+// prefix marks a value encrypted by a Keyring. The full format is
+// "enc:v1:<key id>:<wrapped data key>:<ciphertext>", with both binary parts base64 encoded.
+const prefix = "enc:v1:"
+
+// Keyring encrypts secrets with envelope encryption. Every value gets its own random
+// data key, which is sealed with AES-GCM and then wrapped by the current key encryption key.
+// Values wrapped by any key in the keyring can be decrypted, which allows keys to be rotated.
+type Keyring struct {
+	current *kek
+	keys    map[string]*kek
+}
+
+type kek struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newKEK(key []byte) (*kek, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(key)
+
+	return &kek{
+		id:   hex.EncodeToString(sum[:4]),
+		aead: aead,
+	}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return aead, nil
+}
+
+// NewKeyring creates a keyring that encrypts with current and can also decrypt
+// values encrypted with any of the previous keys.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k, err := newKEK(current)
+	if err != nil {
+		return nil, fmt.Errorf("invalid current key: %w", err)
+	}
+
+	keyring := &Keyring{
+		current: k,
+		keys:    map[string]*kek{k.id: k},
+	}
+
+	for _, key := range previous {
+		p, err := newKEK(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous key: %w", err)
+		}
+		if _, exists := keyring.keys[p.id]; !exists {
+			keyring.keys[p.id] = p
+		}
+	}
+
+	return keyring, nil
+}
+
+// KeyID returns the identifier of the current key, which is stored with every encrypted value.
+func (k *Keyring) KeyID() string {
+	return k.current.id
+}
+
+// ParseKey decodes a base64 encoded key, as generated by "openssl rand -base64 32".
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// LoadKey returns the key given inline or read from keyFile, or nil when neither is set.
+func LoadKey(key, keyFile string) ([]byte, error) {
+	if key != "" {
+		return ParseKey(key)
+	}
+	if keyFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	return ParseKey(string(data))
+}
+
+// IsEncrypted reports whether value was produced by Keyring.Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyIDOf returns the identifier of the key that wrapped an encrypted value.
+func KeyIDOf(value string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return id
+}
+
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// Encrypt encrypts plaintext with a new data key wrapped by the current key.
+// Empty values are kept empty.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, KeySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(aead, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.current.aead, dataKey, []byte(k.current.id))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.current.id + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt. Values without the encryption prefix
+// are returned as they are, so rows written before encryption was enabled stay readable.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+
+	key, exists := k.keys[parts[0]]
+	if !exists {
+		return "", fmt.Errorf("value is encrypted with unknown key %q", parts[0])
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed data key: %w", err)
+	}
+
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %w", err)
+	}
+
+	dataKey, err := open(key.aead, wrapped, []byte(key.id))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(aead, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+var (
+	defaultKeyring   *Keyring
+	defaultKeyringMu sync.RWMutex
+)
+
+// SetDefault sets the keyring used by the "secret" serializer. A nil keyring stores
+// secrets in plain text.
+func SetDefault(keyring *Keyring) {
+	defaultKeyringMu.Lock()
+	defer defaultKeyringMu.Unlock()
+
+	defaultKeyring = keyring
+}
+
+// Default returns the keyring used by the "secret" serializer.
+func Default() *Keyring {
+	defaultKeyringMu.RLock()
+	defer defaultKeyringMu.RUnlock()
+
+	return defaultKeyring
+}
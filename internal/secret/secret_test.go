@@ -0,0 +1,128 @@
+package secret
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestKeyring(t *testing.T) {
+	oldKeyring, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	rotated, err := NewKeyring(testKey(2), testKey(1))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	otherKeyring, err := NewKeyring(testKey(3))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	encrypted, err := oldKeyring.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "secret") {
+		t.Fatalf("Encrypt() = %q, want an encrypted value", encrypted)
+	}
+	if KeyIDOf(encrypted) != oldKeyring.KeyID() {
+		t.Errorf("KeyIDOf() = %q, want %q", KeyIDOf(encrypted), oldKeyring.KeyID())
+	}
+
+	tampered := []byte(encrypted)
+	tampered[len(tampered)-2] ^= 1
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "same key", keyring: oldKeyring, value: encrypted, want: "secret"},
+		{name: "previous key after rotation", keyring: rotated, value: encrypted, want: "secret"},
+		{name: "unknown key", keyring: otherKeyring, value: encrypted, wantErr: true},
+		{name: "tampered ciphertext", keyring: oldKeyring, value: string(tampered), wantErr: true},
+		{name: "malformed value", keyring: oldKeyring, value: prefix + "abc", wantErr: true},
+		{name: "plain text", keyring: oldKeyring, value: "legacy", want: "legacy"},
+		{name: "empty", keyring: oldKeyring, value: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keyring.Decrypt(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Decrypt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// After rotation, values are encrypted with the new key only.
+	reencrypted, err := rotated.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if KeyIDOf(reencrypted) != rotated.KeyID() || rotated.KeyID() == oldKeyring.KeyID() {
+		t.Errorf("rotated value is wrapped by key %q, want %q", KeyIDOf(reencrypted), rotated.KeyID())
+	}
+	_, err = oldKeyring.Decrypt(reencrypted)
+	if err == nil {
+		t.Errorf("Decrypt() of a value of the rotated key with the old keyring succeeded, want error")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "32 bytes", key: "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=\n"},
+		{name: "16 bytes", key: "AQEBAQEBAQEBAQEBAQEBAQ==", wantErr: true},
+		{name: "not base64", key: "not a key", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPassphraseCipher(t *testing.T) {
+	encrypter, err := NewPassphraseCipher("export passphrase")
+	if err != nil {
+		t.Fatalf("NewPassphraseCipher() error = %v", err)
+	}
+	encrypted, err := encrypter.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !IsPassphraseEncrypted(encrypted) {
+		t.Fatalf("Encrypt() = %q, want a passphrase encrypted value", encrypted)
+	}
+
+	decrypter, _ := NewPassphraseCipher("export passphrase")
+	got, err := decrypter.Decrypt(encrypted)
+	if err != nil || got != "secret" {
+		t.Errorf("Decrypt() = %q, %v, want %q", got, err, "secret")
+	}
+
+	wrong, _ := NewPassphraseCipher("wrong passphrase")
+	_, err = wrong.Decrypt(encrypted)
+	if err == nil {
+		t.Errorf("Decrypt() with a wrong passphrase succeeded, want error")
+	}
+}
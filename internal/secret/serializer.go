@@ -0,0 +1,61 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("secret", Serializer{})
+}
+
+// Serializer encrypts string fields tagged with `gorm:"serializer:secret"` with the
+// default keyring when they are written and decrypts them when they are read.
+type Serializer struct{}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("unsupported secret value type %T", dbValue)
+	}
+
+	if IsEncrypted(value) {
+		keyring := Default()
+		if keyring == nil {
+			return fmt.Errorf("failed to decrypt %s: secrets are encrypted but no key is configured", field.Name)
+		}
+
+		plaintext, err := keyring.Decrypt(value)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+		}
+		value = plaintext
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+
+	return nil
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("unsupported secret field type %T", fieldValue)
+	}
+
+	keyring := Default()
+	if keyring == nil {
+		return value, nil
+	}
+
+	return keyring.Encrypt(value)
+}
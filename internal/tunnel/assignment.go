@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"fmt"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+)
+
+// ServicePortsForHost returns the service ports whose tunnels should run on the host:
+// the ones assigned to it and the ones applied to all hosts.
+func (m *Manager) ServicePortsForHost(hostID uint) ([]models.ServicePort, error) {
+	var sps []models.ServicePort
+	err := m.db.Where("apply_to_all_hosts = ?", true).
+		Or("id IN (?)", m.db.Model(&models.HostServicePort{}).Select("sp_id").Where("host_id = ?", hostID)).
+		Find(&sps).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch service ports of host_id=%d: %w", hostID, err)
+	}
+
+	return sps, nil
+}
+
+// HostsForServicePort returns the hosts on which the service port's tunnels should run.
+func (m *Manager) HostsForServicePort(sp *models.ServicePort) ([]models.Host, error) {
+	var hosts []models.Host
+
+	query := m.db
+	if !sp.ApplyToAllHosts {
+		query = query.Where("id IN (?)", m.db.Model(&models.HostServicePort{}).Select("host_id").Where("sp_id = ?", sp.ID))
+	}
+
+	err := query.Find(&hosts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch hosts of sp_id=%d: %w", sp.ID, err)
+	}
+
+	return hosts, nil
+}
+
+// IsAssigned reports whether the service port's tunnel should run on the host.
+func (m *Manager) IsAssigned(hostID uint, sp *models.ServicePort) (bool, error) {
+	if sp.ApplyToAllHosts {
+		return true, nil
+	}
+
+	var count int64
+	err := m.db.Model(&models.HostServicePort{}).
+		Where("host_id = ? AND sp_id = ?", hostID, sp.ID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check assignment (host_id=%d, sp_id=%d): %w", hostID, sp.ID, err)
+	}
+
+	return count > 0, nil
+}
+
+func (m *Manager) runningTunnels(match func(hostID, spID uint) bool) [][2]uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys [][2]uint
+	for hostID, conn := range m.connections {
+		for _, t := range conn.tunnelList() {
+			if match(hostID, *t.SPID) {
+				keys = append(keys, [2]uint{hostID, *t.SPID})
+			}
+		}
+	}
+
+	return keys
+}
+
+// StopHostTunnels stops every running tunnel of the host, closing its SSH connection.
+func (m *Manager) StopHostTunnels(hostID uint) {
+	for _, key := range m.runningTunnels(func(h, _ uint) bool { return h == hostID }) {
+		err := m.StopTunnel(key[0], key[1])
+		if err != nil {
+			m.logger.Warn("failed to stop tunnel",
+				zap.Uint("host_id", key[0]),
+				zap.Uint("service_port_id", key[1]),
+				zap.Error(err))
+		}
+	}
+}
+
+// StopServicePortTunnels stops the tunnels of the service port on every host.
+func (m *Manager) StopServicePortTunnels(spID uint) {
+	for _, key := range m.runningTunnels(func(_, s uint) bool { return s == spID }) {
+		err := m.StopTunnel(key[0], key[1])
+		if err != nil {
+			m.logger.Warn("failed to stop tunnel",
+				zap.Uint("host_id", key[0]),
+				zap.Uint("service_port_id", key[1]),
+				zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package tunnel
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestAssignments(t *testing.T) {
+	m, db := newTestManager(t)
+
+	hosts := []*models.Host{
+		{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret"},
+		{IP: "192.168.0.11", Port: 22, User: "root", Password: "secret"},
+		{IP: "192.168.0.12", Port: 22, User: "root", Password: "secret"},
+	}
+	for _, host := range hosts {
+		err := db.Create(host).Error
+		if err != nil {
+			t.Fatalf("failed to create host: %v", err)
+		}
+	}
+
+	serviceIP := "10.0.0.5"
+	all := createTestServicePort(t, db, models.ServicePort{ServiceIP: &serviceIP, ServicePort: 80, LocalPort: 8080, ApplyToAllHosts: true})
+	both := createTestServicePort(t, db, models.ServicePort{ServiceIP: &serviceIP, ServicePort: 443, LocalPort: 8443}, hosts[0].ID, hosts[1].ID)
+	one := createTestServicePort(t, db, models.ServicePort{ServiceIP: &serviceIP, ServicePort: 5432, LocalPort: 15432}, hosts[1].ID)
+	none := createTestServicePort(t, db, models.ServicePort{ServiceIP: &serviceIP, ServicePort: 6379, LocalPort: 16379})
+	// An assignment of a service port applied to all hosts changes nothing.
+	err := db.Create(&models.HostServicePort{HostID: hosts[2].ID, SPID: all.ID}).Error
+	if err != nil {
+		t.Fatalf("failed to assign service port: %v", err)
+	}
+
+	tests := []struct {
+		host    *models.Host
+		wantSPs []uint
+	}{
+		{host: hosts[0], wantSPs: []uint{all.ID, both.ID}},
+		{host: hosts[1], wantSPs: []uint{all.ID, both.ID, one.ID}},
+		{host: hosts[2], wantSPs: []uint{all.ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host.IP, func(t *testing.T) {
+			sps, err := m.ServicePortsForHost(tt.host.ID)
+			if err != nil {
+				t.Fatalf("ServicePortsForHost() error = %v", err)
+			}
+
+			var got []uint
+			for _, sp := range sps {
+				got = append(got, sp.ID)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantSPs) {
+				t.Errorf("ServicePortsForHost() = %v, want %v", got, tt.wantSPs)
+			}
+
+			for _, sp := range []*models.ServicePort{all, both, one, none} {
+				assigned, err := m.IsAssigned(tt.host.ID, sp)
+				if err != nil {
+					t.Fatalf("IsAssigned() error = %v", err)
+				}
+				if want := slices.Contains(tt.wantSPs, sp.ID); assigned != want {
+					t.Errorf("IsAssigned(sp %d) = %v, want %v", sp.ID, assigned, want)
+				}
+			}
+		})
+	}
+
+	hostsOf := map[*models.ServicePort][]uint{
+		all:  {hosts[0].ID, hosts[1].ID, hosts[2].ID},
+		both: {hosts[0].ID, hosts[1].ID},
+		one:  {hosts[1].ID},
+		none: nil,
+	}
+	for sp, want := range hostsOf {
+		t.Run(fmt.Sprintf("service port %d", sp.ServicePort), func(t *testing.T) {
+			hosts, err := m.HostsForServicePort(sp)
+			if err != nil {
+				t.Fatalf("HostsForServicePort() error = %v", err)
+			}
+
+			var got []uint
+			for _, host := range hosts {
+				got = append(got, host.ID)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("HostsForServicePort() = %v, want %v", got, want)
+			}
+		})
+	}
+}
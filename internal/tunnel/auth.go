@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"fmt"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// ParseSigner parses a PEM encoded private key, decrypting it with passphrase when one is given.
+func ParseSigner(privateKey, passphrase string) (ssh.Signer, error) {
+	var signer ssh.Signer
+	var err error
+
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(privateKey))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	return signer, nil
+}
+
+func (m *Manager) authMethods(host *models.Host) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	privateKey := host.PrivateKey
+	passphrase := host.Passphrase
+	if host.SSHKeyID != nil {
+		var key models.SSHKey
+		err := m.db.First(&key, *host.SSHKeyID).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch SSH key (id=%d): %w", *host.SSHKeyID, err)
+		}
+		privateKey = key.PrivateKey
+		passphrase = key.Passphrase
+	}
+
+	if privateKey != "" {
+		signer, err := ParseSigner(privateKey, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if host.Password != "" {
+		methods = append(methods, ssh.Password(host.Password))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no authentication method configured for host %s", host.IP)
+	}
+
+	return methods, nil
+}
@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthMethods(t *testing.T) {
+	authorizedKey, authorizedPublic := newTestKey(t, "")
+	encryptedKey, encryptedPublic := newTestKey(t, "key-passphrase")
+	storedKey, storedPublic := newTestKey(t, "")
+	unknownKey, _ := newTestKey(t, "")
+
+	tests := []struct {
+		name        string
+		host        models.Host
+		sshKey      *models.SSHKey
+		wantErr     bool
+		wantConnect bool
+	}{
+		{
+			name:        "password",
+			host:        models.Host{Password: "secret"},
+			wantConnect: true,
+		},
+		{
+			name:        "private key",
+			host:        models.Host{PrivateKey: authorizedKey},
+			wantConnect: true,
+		},
+		{
+			name:        "private key with passphrase",
+			host:        models.Host{PrivateKey: encryptedKey, Passphrase: "key-passphrase"},
+			wantConnect: true,
+		},
+		{
+			name:    "private key with wrong passphrase",
+			host:    models.Host{PrivateKey: encryptedKey, Passphrase: "wrong"},
+			wantErr: true,
+		},
+		{
+			name:    "encrypted private key without passphrase",
+			host:    models.Host{PrivateKey: encryptedKey},
+			wantErr: true,
+		},
+		{
+			name:        "stored SSH key",
+			host:        models.Host{},
+			sshKey:      &models.SSHKey{Name: "deploy", PrivateKey: storedKey},
+			wantConnect: true,
+		},
+		{
+			name:        "unknown private key falls back to password",
+			host:        models.Host{PrivateKey: unknownKey, Password: "secret"},
+			wantConnect: true,
+		},
+		{
+			name:        "unknown private key",
+			host:        models.Host{PrivateKey: unknownKey},
+			wantConnect: false,
+		},
+		{
+			name:        "wrong password",
+			host:        models.Host{Password: "wrong"},
+			wantConnect: false,
+		},
+		{
+			name:    "no credentials",
+			host:    models.Host{},
+			wantErr: true,
+		},
+	}
+
+	server := newTestSSHServer(t, "secret", authorizedPublic, encryptedPublic, storedPublic)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, db := newTestManager(t)
+
+			if tt.sshKey != nil {
+				err := db.Create(tt.sshKey).Error
+				if err != nil {
+					t.Fatalf("failed to create SSH key: %v", err)
+				}
+				tt.host.SSHKeyID = &tt.sshKey.ID
+			}
+			host := createTestHost(t, db, server, tt.host)
+
+			config, err := m.clientConfig(host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clientConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			client, err := ssh.Dial("tcp", server.addr, config)
+			if (err == nil) != tt.wantConnect {
+				t.Fatalf("ssh.Dial() error = %v, want connected %v", err, tt.wantConnect)
+			}
+			if err == nil {
+				_ = client.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package tunnel
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+// BackoffPolicy decides how long a host connection waits before each reconnect attempt.
+type BackoffPolicy struct {
+	// InitialDelay is the delay before the first retry.
+	InitialDelay time.Duration
+	// Multiplier grows the delay after every failed attempt.
+	Multiplier float64
+	// MaxDelay caps the delay.
+	MaxDelay time.Duration
+	// Jitter randomizes each delay by up to this fraction in either direction,
+	// so that hosts failing together do not retry together.
+	Jitter float64
+	// MaxAttempts is the number of failed attempts after which the connection gives up,
+	// or 0 to retry forever.
+	MaxAttempts int
+}
+
+// WithOverride returns the policy with the fields set in a host's reconnect policy replaced.
+func (p BackoffPolicy) WithOverride(override *models.ReconnectPolicy) BackoffPolicy {
+	if override == nil {
+		return p
+	}
+
+	if override.InitialDelaySec != nil {
+		p.InitialDelay = time.Duration(*override.InitialDelaySec) * time.Second
+	}
+	if override.Multiplier != nil {
+		p.Multiplier = *override.Multiplier
+	}
+	if override.MaxDelaySec != nil {
+		p.MaxDelay = time.Duration(*override.MaxDelaySec) * time.Second
+	}
+	if override.Jitter != nil {
+		p.Jitter = *override.Jitter
+	}
+	if override.MaxAttempts != nil {
+		p.MaxAttempts = *override.MaxAttempts
+	}
+
+	return p
+}
+
+// Validate returns an error for a policy whose maximum delay is shorter than its initial delay.
+func (p BackoffPolicy) Validate() error {
+	if p.MaxDelay > 0 && p.MaxDelay < p.InitialDelay {
+		return fmt.Errorf("max_delay_sec (%d) must not be less than initial_delay_sec (%d)",
+			int(p.MaxDelay/time.Second), int(p.InitialDelay/time.Second))
+	}
+
+	return nil
+}
+
+// Delay returns the delay after the given number of failed attempts, starting at 1.
+func (p BackoffPolicy) Delay(attempts int) time.Duration {
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempts-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(delay)
+}
+
+// GivesUp reports whether the connection stops retrying after the given number of failed attempts.
+func (p BackoffPolicy) GivesUp(attempts int) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
+
+// HostBackoff returns the global backoff policy with a host's reconnect policy laid over it.
+func (m *Manager) HostBackoff(override *models.ReconnectPolicy) BackoffPolicy {
+	return m.backoff.WithOverride(override)
+}
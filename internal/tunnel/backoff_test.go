@@ -0,0 +1,129 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	policy := BackoffPolicy{InitialDelay: time.Second, Multiplier: 2, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 3, want: 4 * time.Second},
+		{attempts: 4, want: 8 * time.Second},
+		{attempts: 5, want: 10 * time.Second},
+		{attempts: 50, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		got := policy.Delay(tt.attempts)
+		if got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+
+	constant := BackoffPolicy{InitialDelay: 3 * time.Second, Multiplier: 1}
+	if got := constant.Delay(20); got != 3*time.Second {
+		t.Errorf("Delay(20) with multiplier 1 = %v, want %v", got, 3*time.Second)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   BackoffPolicy
+		attempts int
+		min, max time.Duration
+	}{
+		{name: "initial delay", policy: BackoffPolicy{InitialDelay: 10 * time.Second, Multiplier: 2, Jitter: 0.2},
+			attempts: 1, min: 8 * time.Second, max: 12 * time.Second},
+		{name: "capped delay", policy: BackoffPolicy{InitialDelay: 10 * time.Second, Multiplier: 2, MaxDelay: 30 * time.Second, Jitter: 0.5},
+			attempts: 10, min: 15 * time.Second, max: 45 * time.Second},
+		{name: "full jitter", policy: BackoffPolicy{InitialDelay: 10 * time.Second, Multiplier: 1, Jitter: 1},
+			attempts: 3, min: 0, max: 20 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lowest, highest := tt.max, tt.min
+			for i := 0; i < 1000; i++ {
+				got := tt.policy.Delay(tt.attempts)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("Delay(%d) = %v, want between %v and %v", tt.attempts, got, tt.min, tt.max)
+				}
+				lowest, highest = min(lowest, got), max(highest, got)
+			}
+
+			// The delays are spread over the range rather than fixed.
+			spread := (tt.max - tt.min) / 2
+			if highest-lowest < spread {
+				t.Errorf("delays range from %v to %v, want a spread of at least %v", lowest, highest, spread)
+			}
+		})
+	}
+}
+
+func TestBackoffWithOverride(t *testing.T) {
+	global := BackoffPolicy{InitialDelay: 5 * time.Second, Multiplier: 2, MaxDelay: 300 * time.Second, Jitter: 0.1, MaxAttempts: 0}
+	initialDelay, maxAttempts := 10, 3
+	multiplier := 1.5
+
+	got := global.WithOverride(&models.ReconnectPolicy{InitialDelaySec: &initialDelay, Multiplier: &multiplier, MaxAttempts: &maxAttempts})
+	want := BackoffPolicy{InitialDelay: 10 * time.Second, Multiplier: 1.5, MaxDelay: 300 * time.Second, Jitter: 0.1, MaxAttempts: 3}
+	if got != want {
+		t.Errorf("WithOverride() = %+v, want %+v", got, want)
+	}
+
+	if got := global.WithOverride(nil); got != global {
+		t.Errorf("WithOverride(nil) = %+v, want %+v", got, global)
+	}
+}
+
+func TestBackoffValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  BackoffPolicy
+		wantErr bool
+	}{
+		{name: "max delay above initial delay", policy: BackoffPolicy{InitialDelay: 5 * time.Second, MaxDelay: 300 * time.Second}},
+		{name: "equal delays", policy: BackoffPolicy{InitialDelay: 5 * time.Second, MaxDelay: 5 * time.Second}},
+		{name: "no max delay", policy: BackoffPolicy{InitialDelay: 5 * time.Second}},
+		{name: "max delay below initial delay", policy: BackoffPolicy{InitialDelay: 60 * time.Second, MaxDelay: 10 * time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackoffGivesUp(t *testing.T) {
+	tests := []struct {
+		maxAttempts int
+		attempts    int
+		want        bool
+	}{
+		{maxAttempts: 0, attempts: 1000, want: false},
+		{maxAttempts: 3, attempts: 2, want: false},
+		{maxAttempts: 3, attempts: 3, want: true},
+		{maxAttempts: 3, attempts: 4, want: true},
+	}
+
+	for _, tt := range tests {
+		got := BackoffPolicy{MaxAttempts: tt.maxAttempts}.GivesUp(tt.attempts)
+		if got != tt.want {
+			t.Errorf("GivesUp(%d) with max_attempts %d = %v, want %v", tt.attempts, tt.maxAttempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultBindAddress returns the listener address used when a service port of the direction has none.
+// Remote listeners on the host bind to every interface, as they always have. Local and dynamic
+// listeners are opened on the manager and stay on loopback unless an address is given.
+func DefaultBindAddress(direction string) string {
+	if direction == "" || direction == DirectionRemote {
+		return "0.0.0.0"
+	}
+
+	return "127.0.0.1"
+}
+
+// LocalAddress returns the address the listener of a service port binds to:
+// on the host for remote tunnels and on the manager for local and dynamic tunnels.
+func LocalAddress(direction, bindAddress string, localPort int) string {
+	if bindAddress == "" {
+		bindAddress = DefaultBindAddress(direction)
+	}
+
+	return net.JoinHostPort(bindAddress, strconv.Itoa(localPort))
+}
+
+// BindAddressWarnings reports bind addresses whose effect depends on the GatewayPorts
+// setting of sshd on the host. Only "GatewayPorts clientspecified" honors a specific bind
+// address; "yes" forces remote listeners onto every interface and the default "no" onto loopback.
+// Local and dynamic listeners are opened by the manager itself and never produce a warning.
+func BindAddressWarnings(direction, bindAddress string) []string {
+	if direction != "" && direction != DirectionRemote {
+		return nil
+	}
+	if bindAddress == "" {
+		bindAddress = DefaultBindAddress(direction)
+	}
+
+	ip := net.ParseIP(bindAddress)
+	if ip == nil || ip.IsLoopback() {
+		return nil
+	}
+
+	if ip.IsUnspecified() {
+		return []string{fmt.Sprintf("bind address %s is only honored when sshd on the host sets "+
+			"'GatewayPorts yes' or 'GatewayPorts clientspecified'; with the default 'GatewayPorts no' "+
+			"the listener binds to loopback only", bindAddress)}
+	}
+
+	return []string{fmt.Sprintf("bind address %s is only honored when sshd on the host sets "+
+		"'GatewayPorts clientspecified'; 'GatewayPorts yes' binds the listener to all interfaces "+
+		"and the default 'GatewayPorts no' to loopback only", bindAddress)}
+}
+
+// checkGatewayPorts probes the listener from outside the host to detect whether sshd
+// bound it differently than requested, and records a warning on the tunnel if so.
+func (t *SSHTunnel) checkGatewayPorts(m *Manager) {
+	ip := t.Local.IP
+	if t.Server.IP.IsLoopback() {
+		return
+	}
+	if !ip.IsLoopback() && !ip.IsUnspecified() && !ip.Equal(t.Server.IP) {
+		// A specific interface address cannot be probed reliably from here.
+		return
+	}
+
+	probe := net.JoinHostPort(t.Server.IP.String(), strconv.Itoa(t.Local.Port))
+	conn, err := net.DialTimeout("tcp", probe, time.Duration(m.monitoringIntervalSec)*time.Second)
+	if err == nil {
+		_ = conn.Close()
+	}
+
+	var warning string
+	switch {
+	case ip.IsLoopback() && err == nil:
+		warning = fmt.Sprintf("remote listener requested on %s is reachable on %s; "+
+			"sshd 'GatewayPorts yes' on the host overrides the bind address", t.Local.String(), probe)
+	case !ip.IsLoopback() && err != nil:
+		warning = fmt.Sprintf("remote listener requested on %s is not reachable on %s; "+
+			"sshd 'GatewayPorts no' on the host may restrict it to loopback", t.Local.String(), probe)
+	default:
+		return
+	}
+
+	t.logger.Warn("remote listener bind address is not effective",
+		zap.String("local", t.Local.String()),
+		zap.String("server", t.Server.String()),
+		zap.String("warning", warning))
+
+	t.setWarning(m, warning)
+}
@@ -0,0 +1,58 @@
+package tunnel
+
+import "testing"
+
+func TestLocalAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		direction   string
+		bindAddress string
+		localPort   int
+		want        string
+	}{
+		{name: "default", localPort: 8080, want: "0.0.0.0:8080"},
+		{name: "remote default", direction: DirectionRemote, localPort: 8080, want: "0.0.0.0:8080"},
+		{name: "local default", direction: DirectionLocal, localPort: 8080, want: "127.0.0.1:8080"},
+		{name: "dynamic default", direction: DirectionDynamic, localPort: 1080, want: "127.0.0.1:1080"},
+		{name: "remote loopback", direction: DirectionRemote, bindAddress: "127.0.0.1", localPort: 8080, want: "127.0.0.1:8080"},
+		{name: "local all interfaces", direction: DirectionLocal, bindAddress: "0.0.0.0", localPort: 8080, want: "0.0.0.0:8080"},
+		{name: "IPv6 all interfaces", direction: DirectionRemote, bindAddress: "::", localPort: 8080, want: "[::]:8080"},
+		{name: "IPv6 interface", direction: DirectionLocal, bindAddress: "fe80::1", localPort: 443, want: "[fe80::1]:443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LocalAddress(tt.direction, tt.bindAddress, tt.localPort)
+			if got != tt.want {
+				t.Errorf("LocalAddress(%q, %q, %d) = %q, want %q", tt.direction, tt.bindAddress, tt.localPort, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindAddressWarnings(t *testing.T) {
+	tests := []struct {
+		name        string
+		direction   string
+		bindAddress string
+		wantWarning bool
+	}{
+		{name: "default", bindAddress: "", wantWarning: true},
+		{name: "all IPv4 interfaces", direction: DirectionRemote, bindAddress: "0.0.0.0", wantWarning: true},
+		{name: "all IPv6 interfaces", direction: DirectionRemote, bindAddress: "::", wantWarning: true},
+		{name: "specific interface", direction: DirectionRemote, bindAddress: "10.0.0.5", wantWarning: true},
+		{name: "IPv4 loopback", direction: DirectionRemote, bindAddress: "127.0.0.1"},
+		{name: "IPv6 loopback", direction: DirectionRemote, bindAddress: "::1"},
+		{name: "local listener", direction: DirectionLocal, bindAddress: "0.0.0.0"},
+		{name: "dynamic listener", direction: DirectionDynamic, bindAddress: "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := BindAddressWarnings(tt.direction, tt.bindAddress)
+			if (len(warnings) > 0) != tt.wantWarning {
+				t.Errorf("BindAddressWarnings(%q, %q) = %v, want warning %v", tt.direction, tt.bindAddress, warnings, tt.wantWarning)
+			}
+		})
+	}
+}
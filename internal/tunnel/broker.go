@@ -0,0 +1,67 @@
+package tunnel
+
+import (
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+// statusBufferSize is the number of status changes a subscriber can fall behind
+// before its subscription is ended.
+const statusBufferSize = 64
+
+// SubscribeStatus returns a channel receiving every tunnel status change and a function ending the subscription.
+// The channel is closed when the subscription ends or when the subscriber falls too far behind,
+// after which the subscriber should fetch the status again and resubscribe.
+func (m *Manager) SubscribeStatus() (<-chan models.Tunnel, func()) {
+	ch := make(chan models.Tunnel, statusBufferSize)
+
+	m.subscribersMu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.subscribersMu.Unlock()
+
+	return ch, func() {
+		m.subscribersMu.Lock()
+		defer m.subscribersMu.Unlock()
+
+		m.removeSubscriber(ch)
+	}
+}
+
+// ObserveStatus registers fn to be called with every tunnel status change, in the order of the
+// changes. Unlike subscriptions, observers never miss a change; fn is called by the goroutine
+// making the change and must not block.
+func (m *Manager) ObserveStatus(fn func(models.Tunnel)) {
+	m.subscribersMu.Lock()
+	defer m.subscribersMu.Unlock()
+
+	m.observers = append(m.observers, fn)
+}
+
+// removeSubscriber must be called with m.subscribersMu held.
+func (m *Manager) removeSubscriber(ch chan models.Tunnel) {
+	_, exists := m.subscribers[ch]
+	if !exists {
+		return
+	}
+
+	delete(m.subscribers, ch)
+	close(ch)
+}
+
+// publishStatus passes a tunnel status change to every observer and sends it to every subscriber
+// without blocking.
+func (m *Manager) publishStatus(tunnel models.Tunnel) {
+	m.subscribersMu.Lock()
+	defer m.subscribersMu.Unlock()
+
+	for _, observe := range m.observers {
+		observe(tunnel)
+	}
+
+	for ch := range m.subscribers {
+		select {
+		case ch <- tunnel:
+		default:
+			m.removeSubscriber(ch)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestStatusObserversAndSubscribers(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	var observed []models.Tunnel
+	m.ObserveStatus(func(tunnel models.Tunnel) {
+		observed = append(observed, tunnel)
+	})
+	ch, unsubscribe := m.SubscribeStatus()
+	defer unsubscribe()
+
+	n := statusBufferSize * 2
+	for i := 0; i < n; i++ {
+		m.publishStatus(models.Tunnel{HostID: uint(i + 1), SPID: 1, Status: "error"})
+	}
+
+	// The observer sees every change in order.
+	if len(observed) != n {
+		t.Fatalf("observed %d status changes, want %d", len(observed), n)
+	}
+	for i, tunnel := range observed {
+		if tunnel.HostID != uint(i+1) {
+			t.Fatalf("status change %d is for host %d, want %d", i, tunnel.HostID, i+1)
+		}
+	}
+
+	// The subscriber that fell behind is sent what fits and then unsubscribed.
+	received := 0
+	for range ch {
+		received++
+	}
+	if received != statusBufferSize {
+		t.Errorf("subscriber received %d status changes, want %d", received, statusBufferSize)
+	}
+}
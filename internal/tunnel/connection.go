@@ -0,0 +1,381 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// HostConnection owns the single SSH client of a host. Every tunnel of the host
+// listens on that client, and health checks and reconnects happen at the host level
+// so that one reconnect restores all of the host's tunnels at once.
+type HostConnection struct {
+	HostID      uint
+	Server      *net.TCPAddr
+	Config      *ssh.ClientConfig
+	jumps       []jumpHop
+	backoff     BackoffPolicy
+	client      *ssh.Client
+	jumpClients []*ssh.Client
+	clientMu    sync.RWMutex
+	tunnels     map[uint]*SSHTunnel
+	failStatus  string
+	failCause   error
+	tunnelsMu   sync.Mutex
+	relisten    chan struct{}
+	done        chan bool
+	isStopped   bool
+	stopMu      sync.Mutex
+	logger      *zap.Logger
+}
+
+func NewHostConnection(hostID uint, serverAddr string, sshConfig *ssh.ClientConfig, jumps []jumpHop, backoff BackoffPolicy, logger *zap.Logger) (*HostConnection, error) {
+	server, err := net.ResolveTCPAddr("tcp", serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve server address: %w", err)
+	}
+
+	return &HostConnection{
+		HostID:   hostID,
+		Server:   server,
+		Config:   sshConfig,
+		jumps:    jumps,
+		backoff:  backoff,
+		tunnels:  make(map[uint]*SSHTunnel),
+		relisten: make(chan struct{}, 1),
+		done:     make(chan bool),
+		logger:   logger,
+	}, nil
+}
+
+func (c *HostConnection) stopped() bool {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+
+	return c.isStopped
+}
+
+func (c *HostConnection) currentClient() *ssh.Client {
+	c.clientMu.RLock()
+	defer c.clientMu.RUnlock()
+
+	return c.client
+}
+
+func (c *HostConnection) tunnelList() []*SSHTunnel {
+	c.tunnelsMu.Lock()
+	defer c.tunnelsMu.Unlock()
+
+	tunnels := make([]*SSHTunnel, 0, len(c.tunnels))
+	for _, t := range c.tunnels {
+		tunnels = append(tunnels, t)
+	}
+
+	return tunnels
+}
+
+// TunnelCount returns the number of tunnels attached to the connection.
+func (c *HostConnection) TunnelCount() int {
+	c.tunnelsMu.Lock()
+	defer c.tunnelsMu.Unlock()
+
+	return len(c.tunnels)
+}
+
+// AddTunnel attaches t to the connection and starts listening right away
+// when the SSH client is already connected. A tunnel attached after the connection
+// gave up takes over the status it gave up with.
+func (c *HostConnection) AddTunnel(m *Manager, t *SSHTunnel) {
+	c.tunnelsMu.Lock()
+	c.tunnels[*t.SPID] = t
+	status, cause := c.failStatus, c.failCause
+	c.tunnelsMu.Unlock()
+
+	if status != "" {
+		t.setStatus(m, status, cause)
+		return
+	}
+
+	client := c.currentClient()
+	if client != nil {
+		go t.listen(m, client)
+	}
+}
+
+// RetryListeners asks the connection to open the listeners of its tunnels that are not listening
+// right away instead of on the next check. While the connection is reconnecting, they are opened
+// once it is connected again.
+func (c *HostConnection) RetryListeners() {
+	select {
+	case c.relisten <- struct{}{}:
+	default:
+	}
+}
+
+// listenAll opens the listeners of the tunnels that are not listening.
+func (c *HostConnection) listenAll(m *Manager, client *ssh.Client) {
+	for _, t := range c.tunnelList() {
+		if !t.isListening() {
+			t.listen(m, client)
+		}
+	}
+}
+
+// RemoveTunnel stops the tunnel of the service port and detaches it from the connection.
+func (c *HostConnection) RemoveTunnel(m *Manager, spID uint) error {
+	c.tunnelsMu.Lock()
+	t, exists := c.tunnels[spID]
+	delete(c.tunnels, spID)
+	c.tunnelsMu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("tunnel does not exist")
+	}
+
+	return t.Stop(m)
+}
+
+// gaveUp reports whether the connection stopped reconnecting. Its tunnels are only retried
+// once the manager replaces it with a new connection.
+func (c *HostConnection) gaveUp() bool {
+	c.tunnelsMu.Lock()
+	defer c.tunnelsMu.Unlock()
+
+	return c.failStatus != ""
+}
+
+// giveUp stops reconnecting and sets every tunnel, including the ones attached later, to status.
+func (c *HostConnection) giveUp(m *Manager, status string, cause error) {
+	c.tunnelsMu.Lock()
+	c.failStatus = status
+	c.failCause = cause
+	c.tunnelsMu.Unlock()
+
+	c.setTunnelsStatus(m, status, cause)
+}
+
+// takeTunnels detaches every tunnel from a connection that gave up, so that a new connection can adopt them.
+func (c *HostConnection) takeTunnels() map[uint]*SSHTunnel {
+	c.tunnelsMu.Lock()
+	defer c.tunnelsMu.Unlock()
+
+	tunnels := c.tunnels
+	c.tunnels = make(map[uint]*SSHTunnel)
+
+	return tunnels
+}
+
+func (c *HostConnection) setTunnelsStatus(m *Manager, status string, cause error) {
+	for _, t := range c.tunnelList() {
+		t.setStatus(m, status, cause)
+	}
+}
+
+func (c *HostConnection) closeClient() {
+	c.clientMu.Lock()
+	if c.client != nil {
+		_ = c.client.Close()
+		c.client = nil
+	}
+	closeClients(c.jumpClients)
+	c.jumpClients = nil
+	c.clientMu.Unlock()
+
+	for _, t := range c.tunnelList() {
+		t.closeListener(nil)
+	}
+}
+
+func (c *HostConnection) establishConnection(m *Manager) (*ssh.Client, error) {
+	dialStart := time.Now()
+	client, jumpClients, err := c.dial()
+	metrics.ObserveSSHDial(c.HostID, c.Server.IP.String(), time.Since(dialStart).Seconds(), err)
+	if err != nil {
+		class := Classify(err)
+		m.logger.Error("failed to establish SSH connection",
+			zap.String("server", c.Server.String()),
+			zap.String("class", string(class)),
+			zap.Error(err))
+
+		err = &ClassifiedError{Class: class, Err: err}
+		if !class.Permanent() {
+			c.setTunnelsStatus(m, class.Status(), err)
+		}
+
+		return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
+	}
+
+	c.clientMu.Lock()
+	c.client = client
+	c.jumpClients = jumpClients
+	c.clientMu.Unlock()
+
+	c.logger.Info("SSH connection established",
+		zap.String("server", c.Server.String()),
+		zap.Int("tunnels", c.TunnelCount()))
+
+	for _, t := range c.tunnelList() {
+		t.listen(m, client)
+	}
+
+	return client, nil
+}
+
+func (c *HostConnection) reconnect(m *Manager) {
+	if c.stopped() {
+		return
+	}
+
+	c.closeClient()
+	c.setTunnelsStatus(m, "reconnecting", nil)
+}
+
+// monitorConnection watches the SSH client until it fails or the connection is stopped.
+// Tunnels whose listener could not be opened are retried on every check and when
+// RetryListeners is called.
+func (c *HostConnection) monitorConnection(m *Manager, client *ssh.Client) {
+	ticker := time.NewTicker(time.Duration(m.monitoringIntervalSec) * time.Second)
+	defer ticker.Stop()
+
+	closed := make(chan bool)
+	go func() {
+		_ = client.Wait()
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.relisten:
+			c.listenAll(m, client)
+		case <-closed:
+			c.logger.Warn("SSH connection closed, attempting reconnection",
+				zap.String("server", c.Server.String()))
+			c.reconnect(m)
+			return
+		case <-ticker.C:
+			// Hosts behind jump hosts are usually not reachable directly,
+			// so they are only checked with the keepalive request.
+			if len(c.jumps) == 0 {
+				conn, err := net.DialTimeout("tcp", c.Server.String(),
+					time.Duration(m.monitoringIntervalSec)*time.Second)
+				if err != nil {
+					c.logger.Warn("SSH connection lost, attempting reconnection",
+						zap.String("server", c.Server.String()),
+						zap.Error(err))
+					c.reconnect(m)
+					return
+				}
+				_ = conn.Close()
+			}
+
+			_, _, err := client.SendRequest("keepalive@tunnel", true, nil)
+			if err != nil {
+				c.logger.Warn("SSH keepalive check failed, attempting reconnection",
+					zap.String("server", c.Server.String()),
+					zap.Error(err))
+				c.reconnect(m)
+				return
+			}
+
+			c.listenAll(m, client)
+		}
+	}
+}
+
+func (c *HostConnection) Start(m *Manager) {
+	c.logger.Info("attempting to connect to host",
+		zap.String("server", c.Server.String()))
+
+	attempts := 0
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+			if c.stopped() {
+				return
+			}
+
+			client, err := c.establishConnection(m)
+			if err != nil {
+				class := Classify(err)
+				if class.Permanent() {
+					c.logger.Error("connection failed, not retrying until the host is changed or retried",
+						zap.String("server", c.Server.String()),
+						zap.String("class", string(class)),
+						zap.Error(err))
+					c.giveUp(m, class.Status(), err)
+					return
+				}
+
+				attempts++
+				if c.backoff.GivesUp(attempts) {
+					c.logger.Error("connection failed, giving up after "+strconv.Itoa(attempts)+" attempts",
+						zap.String("server", c.Server.String()),
+						zap.Error(err))
+					c.giveUp(m, "failed", fmt.Errorf("gave up after %d attempts: %w", attempts, err))
+					return
+				}
+
+				delay := c.backoff.Delay(attempts)
+				c.logger.Error("connection failed, retrying in "+delay.Round(time.Millisecond).String(),
+					zap.String("server", c.Server.String()),
+					zap.Int("attempts", attempts),
+					zap.Error(err))
+
+				select {
+				case <-c.done:
+					return
+				case <-time.After(delay):
+				}
+
+				c.setTunnelsStatus(m, "reconnecting", nil)
+				continue
+			}
+
+			attempts = 0
+			c.monitorConnection(m, client)
+		}
+	}
+}
+
+// Stop closes the SSH client and stops every tunnel attached to the connection.
+func (c *HostConnection) Stop(m *Manager) error {
+	c.stopMu.Lock()
+	if c.isStopped {
+		c.stopMu.Unlock()
+		return nil
+	}
+	c.isStopped = true
+	close(c.done)
+	c.stopMu.Unlock()
+
+	var errs []string
+	for _, t := range c.tunnelList() {
+		err := t.Stop(m)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	c.tunnelsMu.Lock()
+	c.tunnels = make(map[uint]*SSHTunnel)
+	c.tunnelsMu.Unlock()
+
+	c.closeClient()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to stop tunnels: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
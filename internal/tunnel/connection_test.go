@@ -0,0 +1,86 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestHostConnectionShared(t *testing.T) {
+	server := newTestSSHServer(t, "secret")
+	m, db := newTestManager(t)
+
+	host := createTestHost(t, db, server, models.Host{Password: "secret"})
+	var sps []*models.ServicePort
+	for i := 0; i < 2; i++ {
+		serviceIP, port, _ := net.SplitHostPort(newEchoServer(t))
+		servicePort, _ := strconv.Atoi(port)
+		sps = append(sps, createTestServicePort(t, db, models.ServicePort{
+			ServiceIP:   &serviceIP,
+			ServicePort: servicePort,
+			LocalPort:   freePort(t),
+			BindAddress: "127.0.0.1",
+		}, host.ID))
+	}
+
+	for _, sp := range sps {
+		err := m.StartTunnel(host, sp)
+		if err != nil {
+			t.Fatalf("StartTunnel() error = %v", err)
+		}
+	}
+
+	checkForwarding := func() {
+		t.Helper()
+		for _, sp := range sps {
+			waitForStatus(t, db, host.ID, sp.ID, "connected")
+
+			addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(sp.LocalPort))
+			reply, err := echo(addr, "ping")
+			if err != nil || reply != "ping" {
+				t.Fatalf("echo through %s = %q, %v", addr, reply, err)
+			}
+		}
+	}
+
+	checkForwarding()
+	if n := server.handshakeCount(); n != 1 {
+		t.Fatalf("server accepted %d SSH connections for 2 tunnels, want 1", n)
+	}
+	if n := connectionCount(m); n != 1 {
+		t.Fatalf("manager has %d host connections, want 1", n)
+	}
+
+	// A lost connection is re-established once for all of the host's tunnels.
+	server.dropConnections()
+	waitFor(t, "the host to reconnect", func() bool { return server.handshakeCount() >= 2 })
+	checkForwarding()
+	if n := server.handshakeCount(); n != 2 {
+		t.Fatalf("server accepted %d SSH connections after one reconnect, want 2", n)
+	}
+
+	// The connection is closed with the last of its tunnels.
+	err := m.StopTunnel(host.ID, sps[0].ID)
+	if err != nil {
+		t.Fatalf("StopTunnel() error = %v", err)
+	}
+	if n := connectionCount(m); n != 1 {
+		t.Fatalf("manager has %d host connections with one tunnel left, want 1", n)
+	}
+	err = m.StopTunnel(host.ID, sps[1].ID)
+	if err != nil {
+		t.Fatalf("StopTunnel() error = %v", err)
+	}
+	if n := connectionCount(m); n != 0 {
+		t.Fatalf("manager has %d host connections without tunnels, want 0", n)
+	}
+}
+
+func connectionCount(m *Manager) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.connections)
+}
@@ -0,0 +1,195 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrTunnelRunning is returned by ReleaseTunnel for a tunnel that is already running.
+	ErrTunnelRunning = errors.New("tunnel is already running")
+	// ErrTunnelHeld is returned by RetryTunnel for a tunnel stopped with HoldTunnel.
+	ErrTunnelHeld = errors.New("tunnel is stopped, start it instead")
+)
+
+func (m *Manager) isRunning(hostID, spID uint) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, exists := m.tunnels[fmt.Sprintf("%d-%d", hostID, spID)]
+	return exists
+}
+
+// isHeld reports whether the tunnel was stopped with HoldTunnel.
+func (m *Manager) isHeld(hostID, spID uint) (bool, error) {
+	var count int64
+	err := m.db.Model(&models.StoppedTunnel{}).
+		Where("host_id = ? AND sp_id = ?", hostID, spID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check stopped tunnel (host_id=%d, sp_id=%d): %w", hostID, spID, err)
+	}
+
+	return count > 0, nil
+}
+
+// idleStatus returns the status of a tunnel that must not run now: stopped when it was stopped
+// with HoldTunnel and closed when its schedule is closed. It is empty for a tunnel that may run.
+func (m *Manager) idleStatus(hostID, spID uint) (string, error) {
+	held, err := m.isHeld(hostID, spID)
+	if err != nil {
+		return "", err
+	}
+	if held {
+		return "stopped", nil
+	}
+
+	closed, err := m.scheduleClosed(hostID, spID)
+	if err != nil {
+		return "", err
+	}
+	if closed {
+		return "closed", nil
+	}
+
+	return "", nil
+}
+
+// saveIdleTunnel records a tunnel that is not started because of its idle status.
+func (m *Manager) saveIdleTunnel(tunnel models.Tunnel) error {
+	var previous models.Tunnel
+	err := m.db.Where("host_id = ? AND sp_id = ?", tunnel.HostID, tunnel.SPID).Limit(1).Find(&previous).Error
+	if err != nil {
+		return fmt.Errorf("failed to fetch tunnel information: %w", err)
+	}
+
+	err = m.db.Where("host_id = ? AND sp_id = ?", tunnel.HostID, tunnel.SPID).
+		Assign(tunnel).
+		FirstOrCreate(&tunnel).Error
+	if err != nil {
+		return fmt.Errorf("failed to create tunnel information: %w", err)
+	}
+
+	// Stopping a running tunnel records its stopped event already.
+	if tunnel.Status == "closed" && previous.Status != "closed" {
+		err = m.db.Create(&models.TunnelEvent{
+			HostID: tunnel.HostID,
+			SPID:   tunnel.SPID,
+			Status: tunnel.Status,
+		}).Error
+		if err != nil {
+			m.logger.Error("failed to record tunnel event",
+				zap.Uint("host_id", tunnel.HostID),
+				zap.Uint("service_port_id", tunnel.SPID),
+				zap.String("status", tunnel.Status),
+				zap.Error(err))
+		}
+	}
+	m.publishStatus(tunnel)
+
+	return nil
+}
+
+// startedOrClosed returns ErrTunnelClosed when StartTunnel left the tunnel closed by its schedule.
+func (m *Manager) startedOrClosed(hostID, spID uint) error {
+	if m.isRunning(hostID, spID) {
+		return nil
+	}
+
+	closed, err := m.scheduleClosed(hostID, spID)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return ErrTunnelClosed
+	}
+
+	return nil
+}
+
+// release forgets that the tunnel was stopped with HoldTunnel.
+func (m *Manager) release(hostID, spID uint) error {
+	err := m.db.Where("host_id = ? AND sp_id = ?", hostID, spID).Delete(&models.StoppedTunnel{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete stopped tunnel (host_id=%d, sp_id=%d): %w", hostID, spID, err)
+	}
+
+	err = m.db.Where("host_id = ? AND sp_id = ? AND status = ?", hostID, spID, "stopped").Delete(&models.Tunnel{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete tunnel information: %w", err)
+	}
+
+	return nil
+}
+
+// HoldTunnel stops the tunnel of the service port on the host and keeps it stopped, also across
+// restarts of the manager and changes to the host or service port, until it is started again with
+// ReleaseTunnel or RestartTunnel. It is reported with the stopped status in the meantime.
+func (m *Manager) HoldTunnel(host *models.Host, sp *models.ServicePort) error {
+	err := m.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.StoppedTunnel{HostID: host.ID, SPID: sp.ID}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record stopped tunnel (host_id=%d, sp_id=%d): %w", host.ID, sp.ID, err)
+	}
+
+	if m.isRunning(host.ID, sp.ID) {
+		err = m.StopTunnel(host.ID, sp.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !host.Enabled {
+		return nil
+	}
+
+	// Records the stopped status without starting the tunnel.
+	return m.StartTunnel(host, sp)
+}
+
+// ReleaseTunnel starts a tunnel stopped with HoldTunnel, or one that is not running for another reason.
+// A tunnel closed by its schedule is released but only starts when the schedule opens (ErrTunnelClosed).
+func (m *Manager) ReleaseTunnel(host *models.Host, sp *models.ServicePort) error {
+	if m.isRunning(host.ID, sp.ID) {
+		return ErrTunnelRunning
+	}
+
+	err := m.release(host.ID, sp.ID)
+	if err != nil {
+		return err
+	}
+
+	err = m.StartTunnel(host, sp)
+	if err != nil {
+		return err
+	}
+
+	return m.startedOrClosed(host.ID, sp.ID)
+}
+
+// RestartTunnel stops the tunnel if it is running and starts it again. A tunnel stopped
+// with HoldTunnel is started, and one closed by its schedule stays closed (ErrTunnelClosed).
+func (m *Manager) RestartTunnel(host *models.Host, sp *models.ServicePort) error {
+	err := m.release(host.ID, sp.ID)
+	if err != nil {
+		return err
+	}
+
+	if m.isRunning(host.ID, sp.ID) {
+		err = m.StopTunnel(host.ID, sp.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.StartTunnel(host, sp)
+	if err != nil {
+		return err
+	}
+
+	return m.startedOrClosed(host.ID, sp.ID)
+}
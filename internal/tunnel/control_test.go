@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestHoldTunnel(t *testing.T) {
+	server := newTestSSHServer(t, "secret")
+	m, db := newTestManager(t)
+
+	host := createTestHost(t, db, server, models.Host{Password: "secret"})
+	var sps []*models.ServicePort
+	for i := 0; i < 2; i++ {
+		serviceIP, port, _ := net.SplitHostPort(newEchoServer(t))
+		servicePort, _ := strconv.Atoi(port)
+		sps = append(sps, createTestServicePort(t, db, models.ServicePort{
+			ServiceIP:   &serviceIP,
+			ServicePort: servicePort,
+			LocalPort:   freePort(t),
+			Direction:   DirectionLocal,
+		}, host.ID))
+	}
+	held, running := sps[0], sps[1]
+
+	for _, sp := range sps {
+		err := m.StartTunnel(host, sp)
+		if err != nil {
+			t.Fatalf("StartTunnel() error = %v", err)
+		}
+		waitForStatus(t, db, host.ID, sp.ID, "connected")
+	}
+
+	checkHeld := func(t *testing.T, m *Manager) {
+		t.Helper()
+
+		waitForStatus(t, db, host.ID, held.ID, "stopped")
+		waitForStatus(t, db, host.ID, running.ID, "connected")
+		if m.isRunning(host.ID, held.ID) {
+			t.Errorf("held tunnel is running")
+		}
+		_, err := echo(net.JoinHostPort("127.0.0.1", strconv.Itoa(held.LocalPort)), "ping")
+		if err == nil {
+			t.Errorf("held tunnel still forwards connections")
+		}
+	}
+
+	err := m.HoldTunnel(host, held)
+	if err != nil {
+		t.Fatalf("HoldTunnel() error = %v", err)
+	}
+	checkHeld(t, m)
+
+	// The tunnel stays stopped when the tunnels are restored by a restarted manager.
+	m.StopAllTunnels()
+	restarted, err := NewManager(db, zap.NewNop(), 1, true, BackoffPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 1})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	t.Cleanup(restarted.StopAllTunnels)
+
+	err = restarted.RestoreAllTunnels()
+	if err != nil {
+		t.Fatalf("RestoreAllTunnels() error = %v", err)
+	}
+	checkHeld(t, restarted)
+
+	// Changing the host restarts its tunnels, but not the held one.
+	restarted.StopHostTunnels(host.ID)
+	for _, sp := range sps {
+		err = restarted.StartTunnel(host, sp)
+		if err != nil {
+			t.Fatalf("StartTunnel() error = %v", err)
+		}
+	}
+	checkHeld(t, restarted)
+
+	err = restarted.RetryTunnel(host, held)
+	if !errors.Is(err, ErrTunnelHeld) {
+		t.Errorf("RetryTunnel() error = %v, want %v", err, ErrTunnelHeld)
+	}
+
+	err = restarted.ReleaseTunnel(host, held)
+	if err != nil {
+		t.Fatalf("ReleaseTunnel() error = %v", err)
+	}
+	waitForStatus(t, db, host.ID, held.ID, "connected")
+	reply, err := echo(net.JoinHostPort("127.0.0.1", strconv.Itoa(held.LocalPort)), "ping")
+	if err != nil || reply != "ping" {
+		t.Fatalf("echo through the released tunnel = %q, %v", reply, err)
+	}
+
+	err = restarted.ReleaseTunnel(host, held)
+	if !errors.Is(err, ErrTunnelRunning) {
+		t.Errorf("ReleaseTunnel() of a running tunnel error = %v, want %v", err, ErrTunnelRunning)
+	}
+}
@@ -0,0 +1,104 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// ErrorClass groups tunnel failures by what has to change before the tunnel can work again.
+type ErrorClass string
+
+const (
+	// ErrorUnknown is a failure that does not belong to any other class.
+	ErrorUnknown ErrorClass = "unknown"
+	// ErrorNetwork is a failure to reach a host or a lost connection. It usually goes away by itself.
+	ErrorNetwork ErrorClass = "network"
+	// ErrorAuth is a failure to authenticate to a host or one of its jump hosts.
+	ErrorAuth ErrorClass = "auth"
+	// ErrorHostKey is a host key that does not match the pinned one, or a host without a pinned key
+	// when trust on first use is disabled.
+	ErrorHostKey ErrorClass = "host_key"
+	// ErrorBind is a failure to open the listener of a tunnel, such as a port already in use
+	// or a forwarding request denied by sshd.
+	ErrorBind ErrorClass = "bind"
+)
+
+// ErrTunnelActive is returned by RetryTunnel for a tunnel that is still connected or retrying by itself.
+var ErrTunnelActive = errors.New("tunnel is still connected or retrying")
+
+// ClassifiedError is a tunnel failure whose class is known where it happens.
+type ClassifiedError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *ClassifiedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ClassifiedError) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the class of a tunnel failure. Failures that do not carry a class
+// are classified by their cause.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ErrorUnknown
+	}
+
+	var classified *ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+
+	var mismatch *HostKeyMismatchError
+	if errors.As(err, &mismatch) {
+		return ErrorHostKey
+	}
+
+	// golang.org/x/crypto/ssh does not export a typed authentication error.
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return ErrorAuth
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorNetwork
+	}
+
+	return ErrorUnknown
+}
+
+// Status returns the tunnel status reported for a failure of the class.
+func (c ErrorClass) Status() string {
+	switch c {
+	case ErrorAuth:
+		return "auth_failed"
+	case ErrorHostKey:
+		return "host_key_failed"
+	case ErrorBind:
+		return "bind_failed"
+	default:
+		return "error"
+	}
+}
+
+// Permanent reports whether retrying cannot succeed until the host or its credentials change,
+// in which case the host connection stops reconnecting.
+func (c ErrorClass) Permanent() bool {
+	return c == ErrorAuth || c == ErrorHostKey
+}
+
+// StoppedRetrying reports whether a tunnel in status no longer retries by itself
+// and has to be started again with RetryTunnel.
+func StoppedRetrying(status string) bool {
+	switch status {
+	case "failed", "auth_failed", "host_key_failed":
+		return true
+	}
+
+	return false
+}
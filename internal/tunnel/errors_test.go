@@ -0,0 +1,53 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		want       ErrorClass
+		wantStatus string
+		permanent  bool
+	}{
+		{name: "no error", err: nil, want: ErrorUnknown, wantStatus: "error"},
+		{name: "classified", err: &ClassifiedError{Class: ErrorBind, Err: errors.New("address already in use")},
+			want: ErrorBind, wantStatus: "bind_failed"},
+		{name: "wrapped classified", err: fmt.Errorf("jump host 1/2: %w", &ClassifiedError{Class: ErrorAuth, Err: errors.New("denied")}),
+			want: ErrorAuth, wantStatus: "auth_failed", permanent: true},
+		{name: "host key mismatch", err: fmt.Errorf("handshake: %w", &HostKeyMismatchError{}),
+			want: ErrorHostKey, wantStatus: "host_key_failed", permanent: true},
+		{name: "authentication", err: errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password]"),
+			want: ErrorAuth, wantStatus: "auth_failed", permanent: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			want: ErrorNetwork, wantStatus: "error"},
+		{name: "connection lost", err: fmt.Errorf("read: %w", io.EOF), want: ErrorNetwork, wantStatus: "error"},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, want: ErrorNetwork, wantStatus: "error"},
+		{name: "other", err: errors.New("something else"), want: ErrorUnknown, wantStatus: "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(tt.err)
+			if got != tt.want {
+				t.Fatalf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+			if status := got.Status(); status != tt.wantStatus {
+				t.Errorf("%s.Status() = %s, want %s", got, status, tt.wantStatus)
+			}
+			if permanent := got.Permanent(); permanent != tt.permanent {
+				t.Errorf("%s.Permanent() = %v, want %v", got, permanent, tt.permanent)
+			}
+			if StoppedRetrying(got.Status()) != tt.permanent {
+				t.Errorf("StoppedRetrying(%s) = %v, want %v", got.Status(), !tt.permanent, tt.permanent)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+)
+
+// recordEvent appends a state transition of the tunnel to its event history.
+// It must be called with t.recordMu held.
+func (t *SSHTunnel) recordEvent(m *Manager, status string) {
+	event := models.TunnelEvent{
+		HostID:     *t.HostID,
+		SPID:       *t.SPID,
+		Status:     status,
+		Error:      t.record.LastError,
+		RetryCount: t.record.RetryCount,
+	}
+
+	err := m.db.Create(&event).Error
+	if err != nil {
+		m.logger.Error("failed to record tunnel event",
+			zap.Uint("host_id", *t.HostID),
+			zap.Uint("service_port_id", *t.SPID),
+			zap.String("status", status),
+			zap.Error(err))
+	}
+}
+
+// PruneEvents deletes the tunnel events recorded before the given time.
+func (m *Manager) PruneEvents(before time.Time) (int64, error) {
+	result := m.db.Where("created_at < ?", before).Delete(&models.TunnelEvent{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune tunnel events: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// RunEventPruning deletes tunnel events older than retention every hour.
+func (m *Manager) RunEventPruning(retention time.Duration) {
+	prune := func() {
+		pruned, err := m.PruneEvents(time.Now().Add(-retention))
+		if err != nil {
+			m.logger.Error("failed to prune tunnel events", zap.Error(err))
+			return
+		}
+		if pruned > 0 {
+			m.logger.Info("pruned tunnel events", zap.Int64("count", pruned))
+		}
+	}
+
+	prune()
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		prune()
+	}
+}
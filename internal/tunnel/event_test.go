@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+// eventStatuses returns the statuses recorded for the tunnel, oldest first.
+func eventStatuses(t *testing.T, m *Manager, hostID, spID uint) ([]string, []models.TunnelEvent) {
+	t.Helper()
+
+	var events []models.TunnelEvent
+	err := m.db.Where("host_id = ? AND sp_id = ?", hostID, spID).Order("id").Find(&events).Error
+	if err != nil {
+		t.Fatalf("failed to fetch events: %v", err)
+	}
+
+	var statuses []string
+	for _, event := range events {
+		statuses = append(statuses, event.Status)
+	}
+
+	return statuses, events
+}
+
+func TestTunnelEvents(t *testing.T) {
+	server := newTestSSHServer(t, "secret")
+	m, db := newTestManager(t)
+
+	host := createTestHost(t, db, server, models.Host{Password: "secret"})
+	serviceIP := "127.0.0.1"
+	sp := createTestServicePort(t, db, models.ServicePort{ServiceIP: &serviceIP, ServicePort: 80, LocalPort: freePort(t)}, host.ID)
+
+	err := m.StartTunnel(host, sp)
+	if err != nil {
+		t.Fatalf("StartTunnel() error = %v", err)
+	}
+	waitForStatus(t, db, host.ID, sp.ID, "connected")
+	err = m.StopTunnel(host.ID, sp.ID)
+	if err != nil {
+		t.Fatalf("StopTunnel() error = %v", err)
+	}
+
+	// The history outlives the tunnel record, which is deleted on stop.
+	statuses, _ := eventStatuses(t, m, host.ID, sp.ID)
+	if want := []string{"starting", "connected", "stopped"}; !slices.Equal(statuses, want) {
+		t.Errorf("events = %v, want %v", statuses, want)
+	}
+
+	// Failures are recorded with their error.
+	wrong := createTestHost(t, db, newTestSSHServerOn(t, "127.0.0.2", "secret"), models.Host{Password: "wrong"})
+	err = db.Create(&models.HostServicePort{HostID: wrong.ID, SPID: sp.ID}).Error
+	if err != nil {
+		t.Fatalf("failed to assign service port: %v", err)
+	}
+	err = m.StartTunnel(wrong, sp)
+	if err != nil {
+		t.Fatalf("StartTunnel() error = %v", err)
+	}
+	waitForStatus(t, db, wrong.ID, sp.ID, "auth_failed")
+
+	statuses, events := eventStatuses(t, m, wrong.ID, sp.ID)
+	if want := []string{"starting", "auth_failed"}; !slices.Equal(statuses, want) {
+		t.Fatalf("events = %v, want %v", statuses, want)
+	}
+	if !strings.Contains(events[1].Error, "unable to authenticate") {
+		t.Errorf("auth_failed event error = %q, want the authentication error", events[1].Error)
+	}
+}
+
+func TestPruneEvents(t *testing.T) {
+	m, db := newTestManager(t)
+
+	now := time.Now()
+	for _, age := range []time.Duration{40 * 24 * time.Hour, 31 * 24 * time.Hour, 29 * 24 * time.Hour, time.Hour} {
+		err := db.Create(&models.TunnelEvent{HostID: 1, SPID: 1, Status: "connected", CreatedAt: now.Add(-age)}).Error
+		if err != nil {
+			t.Fatalf("failed to create event: %v", err)
+		}
+	}
+
+	pruned, err := m.PruneEvents(now.Add(-30 * 24 * time.Hour))
+	if err != nil || pruned != 2 {
+		t.Fatalf("PruneEvents() = %d, %v, want 2, nil", pruned, err)
+	}
+
+	var left int64
+	db.Model(&models.TunnelEvent{}).Count(&left)
+	if left != 2 {
+		t.Errorf("%d events left, want 2", left)
+	}
+}
@@ -0,0 +1,244 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gorm.io/gorm"
+)
+
+// HostKeyMismatchError is returned by the host key callback when the key presented
+// by a host differs from the one pinned in the database.
+type HostKeyMismatchError struct {
+	HostID   uint
+	Address  string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: expected %s, got %s "+
+		"(reset the pinned key with DELETE /api/host/%d/host-key if the change is expected)",
+		e.Address, e.Expected, e.Actual, e.HostID)
+}
+
+// ParseHostKey accepts either a public key in authorized_keys format or a SHA256 fingerprint
+// and returns the normalized public key (empty for a bare fingerprint) and its fingerprint.
+func ParseHostKey(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "SHA256:") {
+		_, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(s, "SHA256:"))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid host key fingerprint: %w", err)
+		}
+		return "", s, nil
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid host key: %w", err)
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))), ssh.FingerprintSHA256(key), nil
+}
+
+func hostKeyAlgorithms(hostKey string) []string {
+	if hostKey == "" {
+		return nil
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil
+	}
+
+	if key.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+
+	return []string{key.Type()}
+}
+
+// hostKeyCallback verifies the key presented by a host against the pinned fingerprint.
+// The pinned key is read from the database on every handshake, so a reset through the API
+// takes effect on the next reconnection. Hosts without a pinned key are trusted on first use
+// when trustOnFirstUse is enabled and their key is recorded.
+func (m *Manager) hostKeyCallback(hostID uint) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		var host models.Host
+		err := m.db.Select("id", "host_key", "host_key_fingerprint").First(&host, hostID).Error
+		if err != nil {
+			return fmt.Errorf("failed to fetch pinned host key (host_id=%d): %w", hostID, err)
+		}
+
+		if host.HostKeyFingerprint == "" {
+			if !m.trustOnFirstUse {
+				return &ClassifiedError{
+					Class: ErrorHostKey,
+					Err: fmt.Errorf("no host key pinned for %s (presented %s) and trust on first use is disabled",
+						hostname, fingerprint),
+				}
+			}
+
+			result := m.db.Model(&models.Host{}).
+				Where("id = ? AND (host_key_fingerprint = ? OR host_key_fingerprint IS NULL)", hostID, "").
+				Updates(map[string]interface{}{
+					"host_key":             strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+					"host_key_fingerprint": fingerprint,
+				})
+			if result.Error != nil {
+				return fmt.Errorf("failed to record host key (host_id=%d): %w", hostID, result.Error)
+			}
+
+			if result.RowsAffected == 0 {
+				// Another connection pinned a key in the meantime, verify against it.
+				err = m.db.Select("id", "host_key_fingerprint").First(&host, hostID).Error
+				if err != nil {
+					return fmt.Errorf("failed to fetch pinned host key (host_id=%d): %w", hostID, err)
+				}
+			} else {
+				m.logger.Info("trusted host key on first use",
+					zap.Uint("host_id", hostID),
+					zap.String("server", hostname),
+					zap.String("fingerprint", fingerprint))
+				return nil
+			}
+		}
+
+		if host.HostKeyFingerprint != fingerprint {
+			return &HostKeyMismatchError{
+				HostID:   hostID,
+				Address:  hostname,
+				Expected: host.HostKeyFingerprint,
+				Actual:   fingerprint,
+			}
+		}
+
+		return nil
+	}
+}
+
+// KnownHostsImportResult reports which hosts received a pinned key from a known_hosts import.
+type KnownHostsImportResult struct {
+	Imported []uint `json:"imported"`
+	Skipped  []uint `json:"skipped"`
+	Ignored  int    `json:"ignored_lines"`
+}
+
+func matchKnownHost(pattern, address string) bool {
+	if !strings.HasPrefix(pattern, "|1|") {
+		return pattern == address
+	}
+
+	parts := strings.Split(pattern[len("|1|"):], "|")
+	if len(parts) != 2 {
+		return false
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(address))
+
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// ImportKnownHosts pins host keys from an OpenSSH known_hosts file to the matching hosts.
+// Entries are matched by "ip" (port 22) or "[ip]:port", including hashed entries.
+// The first matching entry wins when a host has several keys listed.
+// Wildcard patterns and @cert-authority / @revoked markers are ignored.
+// Hosts that already have a pinned key are skipped unless overwrite is set.
+func ImportKnownHosts(db *gorm.DB, data []byte, overwrite bool) (*KnownHostsImportResult, error) {
+	var hosts []models.Host
+	err := db.Find(&hosts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch hosts: %w", err)
+	}
+
+	result := &KnownHostsImportResult{
+		Imported: []uint{},
+		Skipped:  []uint{},
+	}
+	pinned := make(map[uint]bool)
+
+	rest := data
+	for len(bytes.TrimSpace(rest)) > 0 {
+		var marker string
+		var patterns []string
+		var key ssh.PublicKey
+
+		marker, patterns, key, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse known_hosts: %w", err)
+		}
+
+		if marker != "" {
+			result.Ignored++
+			continue
+		}
+
+		matched := false
+		for i := range hosts {
+			host := &hosts[i]
+			if pinned[host.ID] {
+				continue
+			}
+
+			address := knownhosts.Normalize(net.JoinHostPort(host.IP, strconv.Itoa(host.Port)))
+			for _, pattern := range patterns {
+				if !matchKnownHost(pattern, address) {
+					continue
+				}
+
+				matched = true
+				pinned[host.ID] = true
+
+				if host.HostKeyFingerprint != "" && !overwrite {
+					result.Skipped = append(result.Skipped, host.ID)
+					break
+				}
+
+				err = db.Model(host).Updates(map[string]interface{}{
+					"host_key":             strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+					"host_key_fingerprint": ssh.FingerprintSHA256(key),
+				}).Error
+				if err != nil {
+					return nil, fmt.Errorf("failed to pin host key (host_id=%d): %w", host.ID, err)
+				}
+				result.Imported = append(result.Imported, host.ID)
+				break
+			}
+		}
+
+		if !matched {
+			result.Ignored++
+		}
+	}
+
+	return result, nil
+}
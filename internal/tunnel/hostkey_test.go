@@ -0,0 +1,165 @@
+package tunnel
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseHostKey(t *testing.T) {
+	_, public := newTestKey(t, "")
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(public)))
+	fingerprint := ssh.FingerprintSHA256(public)
+
+	tests := []struct {
+		name            string
+		hostKey         string
+		wantKey         string
+		wantFingerprint string
+		wantErr         bool
+	}{
+		{name: "public key", hostKey: authorized, wantKey: authorized, wantFingerprint: fingerprint},
+		{name: "public key with comment", hostKey: "  " + authorized + " root@host\n", wantKey: authorized, wantFingerprint: fingerprint},
+		{name: "fingerprint", hostKey: fingerprint, wantFingerprint: fingerprint},
+		{name: "invalid fingerprint", hostKey: "SHA256:not base64!", wantErr: true},
+		{name: "invalid public key", hostKey: "ssh-ed25519 AAAA", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, fingerprint, err := ParseHostKey(tt.hostKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHostKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if key != tt.wantKey || fingerprint != tt.wantFingerprint {
+				t.Errorf("ParseHostKey() = %q, %q, want %q, %q", key, fingerprint, tt.wantKey, tt.wantFingerprint)
+			}
+		})
+	}
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	server := newTestSSHServer(t, "secret")
+	serverFingerprint := ssh.FingerprintSHA256(server.hostKey.PublicKey())
+	_, otherKey := newTestKey(t, "")
+
+	tests := []struct {
+		name            string
+		pinned          string
+		trustOnFirstUse bool
+		wantClass       ErrorClass
+		wantPinned      string
+	}{
+		{name: "trust on first use", trustOnFirstUse: true, wantPinned: serverFingerprint},
+		{name: "pinned key", pinned: serverFingerprint, wantPinned: serverFingerprint},
+		{name: "mismatch", pinned: ssh.FingerprintSHA256(otherKey), trustOnFirstUse: true,
+			wantClass: ErrorHostKey, wantPinned: ssh.FingerprintSHA256(otherKey)},
+		{name: "no pinned key without trust on first use", wantClass: ErrorHostKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, db := newTestManager(t)
+			m.trustOnFirstUse = tt.trustOnFirstUse
+			host := createTestHost(t, db, server, models.Host{Password: "secret", HostKeyFingerprint: tt.pinned})
+
+			config, err := m.clientConfig(host)
+			if err != nil {
+				t.Fatalf("clientConfig() error = %v", err)
+			}
+
+			client, err := ssh.Dial("tcp", server.addr, config)
+			if err == nil {
+				_ = client.Close()
+			}
+			if tt.wantClass == "" && err != nil {
+				t.Fatalf("ssh.Dial() error = %v", err)
+			}
+			if tt.wantClass != "" && Classify(err) != tt.wantClass {
+				t.Fatalf("ssh.Dial() error = %v, want class %s", err, tt.wantClass)
+			}
+
+			var mismatch *HostKeyMismatchError
+			if tt.pinned != "" && tt.pinned != serverFingerprint && !errors.As(err, &mismatch) {
+				t.Errorf("ssh.Dial() error = %v, want HostKeyMismatchError", err)
+			}
+
+			var stored models.Host
+			db.First(&stored, host.ID)
+			if stored.HostKeyFingerprint != tt.wantPinned {
+				t.Errorf("pinned fingerprint = %q, want %q", stored.HostKeyFingerprint, tt.wantPinned)
+			}
+		})
+	}
+}
+
+func TestImportKnownHosts(t *testing.T) {
+	_, key1 := newTestKey(t, "")
+	_, key2 := newTestKey(t, "")
+	_, key3 := newTestKey(t, "")
+	line := func(pattern string, key ssh.PublicKey) string {
+		return pattern + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + "\n"
+	}
+	hashed := func(address string) string {
+		salt := []byte("0123456789abcdefghij")
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(address))
+		return fmt.Sprintf("|1|%s|%s", base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	}
+
+	_, db := newTestManager(t)
+	hosts := []models.Host{
+		{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret"},
+		{IP: "192.168.0.11", Port: 2222, User: "root", Password: "secret"},
+		{IP: "192.168.0.12", Port: 22, User: "root", Password: "secret", HostKeyFingerprint: "SHA256:pinned"},
+		{IP: "192.168.0.13", Port: 22, User: "root", Password: "secret"},
+	}
+	for i := range hosts {
+		err := db.Create(&hosts[i]).Error
+		if err != nil {
+			t.Fatalf("failed to create host: %v", err)
+		}
+	}
+
+	knownHosts := line("192.168.0.10", key1) +
+		line("192.168.0.10", key2) +
+		line(hashed("[192.168.0.11]:2222"), key2) +
+		line("192.168.0.12", key3) +
+		line("192.168.0.*", key3) +
+		line("@revoked 192.168.0.13", key3)
+
+	result, err := ImportKnownHosts(db, []byte(knownHosts), false)
+	if err != nil {
+		t.Fatalf("ImportKnownHosts() error = %v", err)
+	}
+	if fmt.Sprint(result.Imported) != fmt.Sprint([]uint{hosts[0].ID, hosts[1].ID}) {
+		t.Errorf("imported = %v, want %v", result.Imported, []uint{hosts[0].ID, hosts[1].ID})
+	}
+	if fmt.Sprint(result.Skipped) != fmt.Sprint([]uint{hosts[2].ID}) {
+		t.Errorf("skipped = %v, want %v", result.Skipped, []uint{hosts[2].ID})
+	}
+	if result.Ignored != 3 {
+		t.Errorf("ignored = %d, want 3", result.Ignored)
+	}
+
+	want := map[uint]string{
+		hosts[0].ID: ssh.FingerprintSHA256(key1),
+		hosts[1].ID: ssh.FingerprintSHA256(key2),
+		hosts[2].ID: "SHA256:pinned",
+		hosts[3].ID: "",
+	}
+	for id, fingerprint := range want {
+		var host models.Host
+		db.First(&host, id)
+		if host.HostKeyFingerprint != fingerprint {
+			t.Errorf("host %d fingerprint = %q, want %q", id, host.HostKeyFingerprint, fingerprint)
+		}
+	}
+}
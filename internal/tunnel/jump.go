@@ -0,0 +1,136 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// jumpHop is one jump host an SSH connection passes through on the way to its host.
+type jumpHop struct {
+	HostID  uint
+	Address string
+	Config  *ssh.ClientConfig
+}
+
+// JumpHostsForHost returns the jump hosts of a host in the order they are dialed.
+func (m *Manager) JumpHostsForHost(hostID uint) ([]models.Host, error) {
+	var jumps []models.HostJump
+	err := m.db.Where("host_id = ?", hostID).Order("position").Find(&jumps).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jump hosts: %w", err)
+	}
+
+	hosts := make([]models.Host, 0, len(jumps))
+	for _, jump := range jumps {
+		var host models.Host
+		err = m.db.First(&host, jump.JumpHostID).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch jump host (host_id=%d): %w", jump.JumpHostID, err)
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts, nil
+}
+
+// jumpHops prepares the client configuration of every jump host of a host. Each hop
+// authenticates and verifies its host key with the credentials of its own Host record.
+func (m *Manager) jumpHops(hostID uint) ([]jumpHop, error) {
+	hosts, err := m.JumpHostsForHost(hostID)
+	if err != nil {
+		return nil, err
+	}
+
+	hops := make([]jumpHop, 0, len(hosts))
+	for _, host := range hosts {
+		config, err := m.clientConfig(&host)
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s (host_id=%d): %w", hostAddress(&host), host.ID, err)
+		}
+
+		hops = append(hops, jumpHop{
+			HostID:  host.ID,
+			Address: hostAddress(&host),
+			Config:  config,
+		})
+	}
+
+	return hops, nil
+}
+
+func hostAddress(host *models.Host) string {
+	return net.JoinHostPort(host.IP, strconv.Itoa(host.Port))
+}
+
+// dialVia opens an SSH connection to addr through an already connected client.
+func dialVia(client *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := client.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", addr, err)
+	}
+
+	// Channel connections do not support deadlines, so the handshake timeout
+	// is enforced by closing the connection instead.
+	timer := time.AfterFunc(config.Timeout, func() {
+		_ = conn.Close()
+	})
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	timer.Stop()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// dial connects to the host, passing through each jump host in turn like OpenSSH's ProxyJump.
+// It returns the host's client and the clients of the jump hosts, which must be closed after it.
+func (c *HostConnection) dial() (*ssh.Client, []*ssh.Client, error) {
+	if len(c.jumps) == 0 {
+		client, err := ssh.Dial("tcp", c.Server.String(), c.Config)
+		return client, nil, err
+	}
+
+	var jumpClients []*ssh.Client
+	fail := func(err error) (*ssh.Client, []*ssh.Client, error) {
+		closeClients(jumpClients)
+		return nil, nil, err
+	}
+
+	for i, hop := range c.jumps {
+		var client *ssh.Client
+		var err error
+		if i == 0 {
+			client, err = ssh.Dial("tcp", hop.Address, hop.Config)
+		} else {
+			client, err = dialVia(jumpClients[i-1], hop.Address, hop.Config)
+		}
+		if err != nil {
+			return fail(fmt.Errorf("jump host %d/%d %s (host_id=%d) failed: %w",
+				i+1, len(c.jumps), hop.Address, hop.HostID, err))
+		}
+		jumpClients = append(jumpClients, client)
+	}
+
+	last := c.jumps[len(c.jumps)-1]
+	client, err := dialVia(jumpClients[len(jumpClients)-1], c.Server.String(), c.Config)
+	if err != nil {
+		return fail(fmt.Errorf("host %s via jump host %s (host_id=%d) failed: %w",
+			c.Server.String(), last.Address, last.HostID, err))
+	}
+
+	return client, jumpClients, nil
+}
+
+// closeClients closes jump host clients from the innermost hop outwards.
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		_ = clients[i].Close()
+	}
+}
@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestJumpHosts(t *testing.T) {
+	tests := []struct {
+		name          string
+		jumpPasswords []string
+		wantStatus    string
+		wantError     string
+	}{
+		{name: "one jump host", jumpPasswords: []string{"jump-1"}},
+		{name: "two jump hosts", jumpPasswords: []string{"jump-1", "jump-2"}},
+		{name: "second jump host fails", jumpPasswords: []string{"jump-1", "wrong"},
+			wantStatus: "auth_failed", wantError: "jump host 2/2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, db := newTestManager(t)
+
+			server := newTestSSHServer(t, "secret")
+			host := createTestHost(t, db, server, models.Host{Password: "secret"})
+
+			var jumpServers []*testSSHServer
+			for i, password := range tt.jumpPasswords {
+				jumpServer := newTestSSHServerOn(t, "127.0.0."+strconv.Itoa(i+2), "jump-"+strconv.Itoa(i+1))
+				jumpHost := createTestHost(t, db, jumpServer, models.Host{Password: password})
+				err := db.Create(&models.HostJump{HostID: host.ID, Position: i, JumpHostID: jumpHost.ID}).Error
+				if err != nil {
+					t.Fatalf("failed to create jump host: %v", err)
+				}
+				jumpServers = append(jumpServers, jumpServer)
+			}
+
+			serviceIP, port, _ := net.SplitHostPort(newEchoServer(t))
+			servicePort, _ := strconv.Atoi(port)
+			sp := createTestServicePort(t, db, models.ServicePort{
+				ServiceIP:   &serviceIP,
+				ServicePort: servicePort,
+				LocalPort:   freePort(t),
+				Direction:   DirectionLocal,
+			}, host.ID)
+
+			err := m.StartTunnel(host, sp)
+			if err != nil {
+				t.Fatalf("StartTunnel() error = %v", err)
+			}
+
+			if tt.wantStatus != "" {
+				record := waitForStatus(t, db, host.ID, sp.ID, tt.wantStatus)
+				if !strings.Contains(record.LastError, tt.wantError) {
+					t.Errorf("last error = %q, want it to name %q", record.LastError, tt.wantError)
+				}
+				if n := server.handshakeCount(); n != 0 {
+					t.Errorf("host accepted %d SSH connections, want 0", n)
+				}
+				return
+			}
+
+			waitForStatus(t, db, host.ID, sp.ID, "connected")
+			addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(sp.LocalPort))
+			reply, err := echo(addr, "ping")
+			if err != nil || reply != "ping" {
+				t.Fatalf("echo through %s = %q, %v", addr, reply, err)
+			}
+
+			// Every hop is passed through with one SSH connection.
+			for i, jumpServer := range append(jumpServers, server) {
+				if n := jumpServer.handshakeCount(); n != 1 {
+					t.Errorf("hop %d accepted %d SSH connections, want 1", i+1, n)
+				}
+			}
+		})
+	}
+}
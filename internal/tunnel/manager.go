@@ -2,9 +2,12 @@ package tunnel
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/jollaman999/tunnel-manager/internal/metrics"
 	"github.com/jollaman999/tunnel-manager/internal/models"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
@@ -14,20 +17,101 @@ import (
 type Manager struct {
 	db                    *gorm.DB
 	tunnels               map[string]*SSHTunnel
+	connections           map[uint]*HostConnection
 	mu                    sync.RWMutex
 	logger                *zap.Logger
 	monitoringIntervalSec int
+	trustOnFirstUse       bool
+	backoff               BackoffPolicy
+	subscribers           map[chan models.Tunnel]struct{}
+	observers             []func(models.Tunnel)
+	subscribersMu         sync.Mutex
+	schedulesChanged      chan struct{}
+	schedules             []*compiledSchedule
+	schedulesLoaded       bool
+	schedulesMu           sync.Mutex
 }
 
-func NewManager(db *gorm.DB, logger *zap.Logger, monitoringIntervalSec int) (*Manager, error) {
+func NewManager(db *gorm.DB, logger *zap.Logger, monitoringIntervalSec int, trustOnFirstUse bool, backoff BackoffPolicy) (*Manager, error) {
 	return &Manager{
 		db:                    db,
 		tunnels:               make(map[string]*SSHTunnel),
+		connections:           make(map[uint]*HostConnection),
 		logger:                logger,
 		monitoringIntervalSec: monitoringIntervalSec,
+		trustOnFirstUse:       trustOnFirstUse,
+		backoff:               backoff,
+		subscribers:           make(map[chan models.Tunnel]struct{}),
+		schedulesChanged:      make(chan struct{}, 1),
 	}, nil
 }
 
+func (m *Manager) clientConfig(host *models.Host) (*ssh.ClientConfig, error) {
+	auth, err := m.authMethods(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare SSH authentication: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:              host.User,
+		Auth:              auth,
+		HostKeyCallback:   m.hostKeyCallback(host.ID),
+		HostKeyAlgorithms: hostKeyAlgorithms(host.HostKey),
+		Timeout:           time.Second * 10,
+	}, nil
+}
+
+// hostConnection returns the running connection of the host, starting one when there is none.
+// A connection that gave up is replaced by a new one built from the current host settings,
+// which adopts its tunnels and retries them. It must be called with m.mu held.
+func (m *Manager) hostConnection(host *models.Host) (*HostConnection, error) {
+	previous, exists := m.connections[host.ID]
+	if exists && !previous.gaveUp() {
+		return previous, nil
+	}
+
+	sshConfig, err := m.clientConfig(host)
+	if err != nil {
+		return nil, err
+	}
+
+	jumps, err := m.jumpHops(host.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare jump hosts: %w", err)
+	}
+
+	conn, err := NewHostConnection(host.ID, hostAddress(host), sshConfig, jumps, m.HostBackoff(host.ReconnectPolicy), m.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host connection: %w", err)
+	}
+
+	if exists {
+		conn.tunnels = previous.takeTunnels()
+		conn.setTunnelsStatus(m, "starting", nil)
+	}
+	m.connections[host.ID] = conn
+
+	go func(m *Manager, conn *HostConnection) {
+		conn.Start(m)
+	}(m, conn)
+
+	return conn, nil
+}
+
+// releaseConnection stops a host connection that StartTunnel prepared for a tunnel it could not
+// record, unless other tunnels use it.
+func (m *Manager) releaseConnection(hostID uint, conn *HostConnection) {
+	if conn.TunnelCount() > 0 {
+		return
+	}
+	delete(m.connections, hostID)
+
+	err := conn.Stop(m)
+	if err != nil {
+		m.logger.Warn("failed to close host connection", zap.Uint("host_id", hostID), zap.Error(err))
+	}
+}
+
 func (m *Manager) StartTunnel(host *models.Host, sp *models.ServicePort) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -37,69 +121,187 @@ func (m *Manager) StartTunnel(host *models.Host, sp *models.ServicePort) error {
 		return fmt.Errorf("tunnel already exists")
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User: host.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(host.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Second * 10,
+	direction := sp.Direction
+	if direction == "" {
+		direction = DirectionRemote
 	}
 
 	tunnel := models.Tunnel{
-		HostID: host.ID,
-		SPID:   sp.ID,
-		Status: "starting",
-		Local:  fmt.Sprintf("0.0.0.0:%d", sp.LocalPort),
-		Server: fmt.Sprintf("%s:%d", host.IP, host.Port),
-		Remote: fmt.Sprintf("%s:%d", sp.ServiceIP, sp.ServicePort),
+		HostID:    host.ID,
+		SPID:      sp.ID,
+		Direction: direction,
+		Status:    "starting",
+		Local:     LocalAddress(direction, sp.BindAddress, sp.LocalPort),
+		Server:    fmt.Sprintf("%s:%d", host.IP, host.Port),
+		Remote:    "*",
+	}
+	if direction != DirectionDynamic {
+		if sp.ServiceIP == nil {
+			return fmt.Errorf("service port has no service IP")
+		}
+		tunnel.Remote = net.JoinHostPort(*sp.ServiceIP, strconv.Itoa(sp.ServicePort))
+	}
+
+	idle, err := m.idleStatus(host.ID, sp.ID)
+	if err != nil {
+		return err
+	}
+	if idle != "" {
+		tunnel.Status = idle
+		return m.saveIdleTunnel(tunnel)
+	}
+
+	allowlist, err := ParseAllowlist(sp.Allowlist)
+	if err != nil {
+		return fmt.Errorf("failed to parse allowlist: %w", err)
+	}
+	if direction == DirectionDynamic {
+		bindAddress := sp.BindAddress
+		if bindAddress == "" {
+			bindAddress = DefaultBindAddress(direction)
+		}
+		err = CheckDynamicBind(bindAddress, allowlist)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The host connection is prepared before the tunnel is recorded, so that a host that cannot
+	// be connected to, such as one with an invalid private key, does not leave a starting tunnel behind.
+	conn, err := m.hostConnection(host)
+	if err != nil {
+		return err
 	}
 
 	t, err := NewSSHTunnel(
 		&tunnel.HostID,
 		&tunnel.SPID,
+		tunnel.Direction,
 		tunnel.Local,
 		tunnel.Server,
 		tunnel.Remote,
-		sshConfig,
+		&tunnel,
 		m.logger,
 	)
 	if err != nil {
+		m.releaseConnection(host.ID, conn)
 		return fmt.Errorf("failed to create tunnel: %w", err)
 	}
 
-	m.tunnels[tunnelKey] = t
+	// A tunnel that is not running may have left its record behind, such as the closed status.
+	err = m.db.Where("host_id = ? AND sp_id = ?", host.ID, sp.ID).Delete(&models.Tunnel{}).Error
+	if err != nil {
+		m.releaseConnection(host.ID, conn)
+		return fmt.Errorf("failed to delete previous tunnel information: %w", err)
+	}
 
-	err = m.db.Where("host_id = ? AND sp_id = ?", host.ID, sp.ID).
-		Attrs(tunnel).
-		FirstOrCreate(&tunnel).Error
+	err = m.db.Create(&tunnel).Error
 	if err != nil {
+		m.releaseConnection(host.ID, conn)
 		return fmt.Errorf("failed to create tunnel information: %w", err)
 	}
 
-	go func(m *Manager, t *SSHTunnel, tunnel *models.Tunnel) {
-		t.Start(m, tunnel)
-	}(m, t, &tunnel)
+	t.allowlist = allowlist
+	t.metrics = metrics.NewTunnel(host.ID, host.IP, sp.ID, sp.ServicePort, direction)
+	t.metrics.SetStatus(tunnel.Status)
+	t.recordEvent(m, tunnel.Status)
+	m.publishStatus(tunnel)
+
+	m.tunnels[tunnelKey] = t
+	conn.AddTunnel(m, t)
 
 	return nil
 }
 
+// RetryTunnel starts the tunnel of the service port on the host again after it stopped retrying
+// by itself or could not open its listener. When its host connection gave up, the connection is
+// rebuilt from the current host settings and every tunnel of the host is retried with it.
+// A tunnel that is not running is started, unless it was stopped with HoldTunnel (ErrTunnelHeld)
+// or is closed by its schedule (ErrTunnelClosed).
+// ErrTunnelActive is returned for any other tunnel.
+func (m *Manager) RetryTunnel(host *models.Host, sp *models.ServicePort) error {
+	m.mu.Lock()
+	t, exists := m.tunnels[fmt.Sprintf("%d-%d", host.ID, sp.ID)]
+	if !exists {
+		m.mu.Unlock()
+
+		held, err := m.isHeld(host.ID, sp.ID)
+		if err != nil {
+			return err
+		}
+		if held {
+			return ErrTunnelHeld
+		}
+
+		closed, err := m.scheduleClosed(host.ID, sp.ID)
+		if err != nil {
+			return err
+		}
+		if closed {
+			return ErrTunnelClosed
+		}
+
+		return m.StartTunnel(host, sp)
+	}
+	defer m.mu.Unlock()
+
+	status := t.status()
+	switch {
+	case StoppedRetrying(status):
+		_, err := m.hostConnection(host)
+		return err
+	case status == ErrorBind.Status():
+		conn, exists := m.connections[host.ID]
+		if !exists {
+			return fmt.Errorf("host connection does not exist")
+		}
+		conn.RetryListeners()
+		return nil
+	}
+
+	return ErrTunnelActive
+}
+
 func (m *Manager) StopTunnel(hostID uint, spID uint) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	tunnelKey := fmt.Sprintf("%d-%d", hostID, spID)
-	tunnel, exists := m.tunnels[tunnelKey]
+	_, exists := m.tunnels[tunnelKey]
 	if !exists {
+		// A tunnel stopped with HoldTunnel or closed by its schedule is not running,
+		// only its status is recorded.
+		result := m.db.Where("host_id = ? AND sp_id = ? AND status IN ?", hostID, spID, []string{"stopped", "closed"}).
+			Delete(&models.Tunnel{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete tunnel information: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+
 		return fmt.Errorf("tunnel does not exist")
 	}
+	delete(m.tunnels, tunnelKey)
+
+	conn, exists := m.connections[hostID]
+	if !exists {
+		return fmt.Errorf("host connection does not exist")
+	}
 
-	err := tunnel.Stop(m)
+	err := conn.RemoveTunnel(m, spID)
 	if err != nil {
 		return fmt.Errorf("failed to stop tunnel: %w", err)
 	}
 
-	delete(m.tunnels, tunnelKey)
+	if conn.TunnelCount() == 0 {
+		delete(m.connections, hostID)
+
+		err = conn.Stop(m)
+		if err != nil {
+			return fmt.Errorf("failed to close host connection: %w", err)
+		}
+	}
 
 	return nil
 }
@@ -115,6 +317,18 @@ func (m *Manager) GetHostTunnels(hostID uint) (*[]models.Tunnel, error) {
 		return nil, fmt.Errorf("failed to fetch Host's tunnels (host_id=%d): %w", hostID, err)
 	}
 
+	err = m.attachTraffic(tunnels)
+	if err != nil {
+		m.logger.Error("failed to fetch tunnel traffic", zap.Error(err))
+		return nil, err
+	}
+
+	err = m.attachSchedules(tunnels)
+	if err != nil {
+		m.logger.Error("failed to fetch tunnel schedules", zap.Error(err))
+		return nil, err
+	}
+
 	return &tunnels, nil
 }
 
@@ -129,6 +343,18 @@ func (m *Manager) GetAllTunnels() (*[]models.Tunnel, error) {
 		return nil, fmt.Errorf("failed to fetch tunnels: %w", err)
 	}
 
+	err = m.attachTraffic(tunnels)
+	if err != nil {
+		m.logger.Error("failed to fetch tunnel traffic", zap.Error(err))
+		return nil, err
+	}
+
+	err = m.attachSchedules(tunnels)
+	if err != nil {
+		m.logger.Error("failed to fetch tunnel schedules", zap.Error(err))
+		return nil, err
+	}
+
 	return &tunnels, nil
 }
 
@@ -148,19 +374,6 @@ func (m *Manager) RestoreAllTunnels() error {
 		return nil
 	}
 
-	var servicePorts []models.ServicePort
-	err = m.db.Find(&servicePorts).Error
-	if err != nil {
-		m.mu.Unlock()
-		return fmt.Errorf("failed to fetch service ports: %w", err)
-	}
-
-	if len(servicePorts) == 0 {
-		m.mu.Unlock()
-		m.logger.Info("no service ports to restore")
-		return nil
-	}
-
 	m.mu.Unlock()
 
 	for _, host := range hosts {
@@ -169,6 +382,14 @@ func (m *Manager) RestoreAllTunnels() error {
 			return fmt.Errorf("failed to reset tunnel status for host_id=%d: %w", host.ID, err)
 		}
 
+		servicePorts, err := m.ServicePortsForHost(host.ID)
+		if err != nil {
+			m.logger.Error("failed to fetch assigned service ports",
+				zap.Error(err),
+				zap.String("host_ip", host.IP))
+			continue
+		}
+
 		for _, sp := range servicePorts {
 			err = m.StartTunnel(&host, &sp)
 			if err != nil {
@@ -186,36 +407,22 @@ func (m *Manager) RestoreAllTunnels() error {
 
 func (m *Manager) StopAllTunnels() {
 	m.mu.Lock()
-	var hosts []models.Host
-	err := m.db.Find(&hosts).Error
-	if err != nil {
-		m.mu.Unlock()
-		m.logger.Error("failed to fetch Hosts", zap.Error(err))
-	}
-
-	var servicePorts []models.ServicePort
-	err = m.db.Find(&servicePorts).Error
-	if err != nil {
-		m.mu.Unlock()
-		m.logger.Error(fmt.Sprintf("failed to fetch service ports: %v", err))
-	}
-	m.mu.Unlock()
+	defer m.mu.Unlock()
 
-	for _, host := range hosts {
-		err = m.db.Unscoped().Where("host_id = ?", host.ID).Delete(&models.Tunnel{}).Error
+	for hostID, conn := range m.connections {
+		err := conn.Stop(m)
 		if err != nil {
-			m.logger.Error(fmt.Sprintf("failed to reset tunnel status for host_id=%d: %w", host.ID, err))
+			m.logger.Error("failed to stop host connection",
+				zap.Error(err),
+				zap.Uint("host_id", hostID),
+				zap.String("server", conn.Server.String()))
 		}
+		delete(m.connections, hostID)
+	}
+	m.tunnels = make(map[string]*SSHTunnel)
 
-		for _, sp := range servicePorts {
-			err = m.StopTunnel(host.ID, sp.ID)
-			if err != nil {
-				m.logger.Error("failed to stop tunnel",
-					zap.Error(err),
-					zap.String("host_ip", host.IP),
-					zap.Int("service_port", sp.ServicePort))
-				continue
-			}
-		}
+	err := m.db.Unscoped().Where("1 = 1").Delete(&models.Tunnel{}).Error
+	if err != nil {
+		m.logger.Error("failed to reset tunnel status", zap.Error(err))
 	}
 }
@@ -0,0 +1,221 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/jollaman999/tunnel-manager/internal/database"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestManager returns a manager with a migrated SQLite database. Its tunnels are stopped
+// when the test ends.
+func newTestManager(t *testing.T) (*Manager, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = database.MigrateUp(db)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	m, err := NewManager(db, zap.NewNop(), 1, true, BackoffPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 1})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	t.Cleanup(m.StopAllTunnels)
+
+	return m, db
+}
+
+// createTestHost records a Host for the server with the given credentials.
+func createTestHost(t *testing.T, db *gorm.DB, server *testSSHServer, host models.Host) *models.Host {
+	t.Helper()
+
+	host.IP, host.Port = server.host()
+	if host.User == "" {
+		host.User = "tunnel"
+	}
+	host.Enabled = true
+
+	err := db.Create(&host).Error
+	if err != nil {
+		t.Fatalf("failed to create host: %v", err)
+	}
+
+	return &host
+}
+
+// createTestServicePort records a service port and assigns it to hostIDs.
+func createTestServicePort(t *testing.T, db *gorm.DB, sp models.ServicePort, hostIDs ...uint) *models.ServicePort {
+	t.Helper()
+
+	if sp.Direction == "" {
+		sp.Direction = DirectionRemote
+	}
+	if sp.BindAddress == "" {
+		sp.BindAddress = DefaultBindAddress(sp.Direction)
+	}
+
+	err := db.Create(&sp).Error
+	if err != nil {
+		t.Fatalf("failed to create service port: %v", err)
+	}
+
+	for _, hostID := range hostIDs {
+		err = db.Create(&models.HostServicePort{HostID: hostID, SPID: sp.ID}).Error
+		if err != nil {
+			t.Fatalf("failed to assign service port: %v", err)
+		}
+	}
+
+	return &sp
+}
+
+// waitFor waits until done returns true.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitForStatus waits until the recorded status of the tunnel is status and returns the record.
+func waitForStatus(t *testing.T, db *gorm.DB, hostID, spID uint, status string) models.Tunnel {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	var record models.Tunnel
+	for time.Now().Before(deadline) {
+		record = models.Tunnel{}
+		err := db.Where("host_id = ? AND sp_id = ?", hostID, spID).Limit(1).Find(&record).Error
+		if err == nil && record.Status == status {
+			return record
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("tunnel %d-%d has status %q (%s), want %q", hostID, spID, record.Status, record.LastError, status)
+	return record
+}
+
+func TestRetryTunnel(t *testing.T) {
+	m, db := newTestManager(t)
+	// Listeners are only opened again by RetryTunnel during the test, not by the periodic check.
+	m.monitoringIntervalSec = 60
+
+	newServicePort := func(t *testing.T, hostID uint) *models.ServicePort {
+		serviceIP, port, _ := net.SplitHostPort(newEchoServer(t))
+		servicePort, _ := strconv.Atoi(port)
+		return createTestServicePort(t, db, models.ServicePort{
+			ServiceIP:   &serviceIP,
+			ServicePort: servicePort,
+			LocalPort:   freePort(t),
+			Direction:   DirectionLocal,
+		}, hostID)
+	}
+	checkForwarding := func(t *testing.T, sp *models.ServicePort) {
+		t.Helper()
+		addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(sp.LocalPort))
+		reply, err := echo(addr, "ping")
+		if err != nil || reply != "ping" {
+			t.Fatalf("echo through %s = %q, %v", addr, reply, err)
+		}
+	}
+
+	t.Run("listener", func(t *testing.T) {
+		server := newTestSSHServerOn(t, "127.0.0.1", "secret")
+		host := createTestHost(t, db, server, models.Host{Password: "secret"})
+		sp := newServicePort(t, host.ID)
+
+		occupied, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(sp.LocalPort)))
+		if err != nil {
+			t.Fatalf("failed to occupy local port: %v", err)
+		}
+		err = m.StartTunnel(host, sp)
+		if err != nil {
+			t.Fatalf("StartTunnel() error = %v", err)
+		}
+		waitForStatus(t, db, host.ID, sp.ID, "bind_failed")
+
+		_ = occupied.Close()
+		err = m.RetryTunnel(host, sp)
+		if err != nil {
+			t.Fatalf("RetryTunnel() error = %v", err)
+		}
+		waitForStatus(t, db, host.ID, sp.ID, "connected")
+		checkForwarding(t, sp)
+
+		err = m.RetryTunnel(host, sp)
+		if !errors.Is(err, ErrTunnelActive) {
+			t.Errorf("RetryTunnel() of a connected tunnel error = %v, want %v", err, ErrTunnelActive)
+		}
+		if n := server.handshakeCount(); n != 1 {
+			t.Errorf("server accepted %d SSH connections, want 1", n)
+		}
+	})
+
+	t.Run("authentication", func(t *testing.T) {
+		server := newTestSSHServerOn(t, "127.0.0.2", "secret")
+		host := createTestHost(t, db, server, models.Host{Password: "wrong"})
+		sp := newServicePort(t, host.ID)
+
+		err := m.StartTunnel(host, sp)
+		if err != nil {
+			t.Fatalf("StartTunnel() error = %v", err)
+		}
+		waitForStatus(t, db, host.ID, sp.ID, "auth_failed")
+
+		// The connection is rebuilt from the changed host.
+		host.Password = "secret"
+		err = db.Save(host).Error
+		if err != nil {
+			t.Fatalf("failed to update host: %v", err)
+		}
+		err = m.RetryTunnel(host, sp)
+		if err != nil {
+			t.Fatalf("RetryTunnel() error = %v", err)
+		}
+		waitForStatus(t, db, host.ID, sp.ID, "connected")
+		checkForwarding(t, sp)
+	})
+
+	t.Run("not running", func(t *testing.T) {
+		server := newTestSSHServerOn(t, "127.0.0.3", "secret")
+		host := createTestHost(t, db, server, models.Host{Password: "secret"})
+		sp := newServicePort(t, host.ID)
+		held := newServicePort(t, host.ID)
+
+		err := m.RetryTunnel(host, sp)
+		if err != nil {
+			t.Fatalf("RetryTunnel() error = %v", err)
+		}
+		waitForStatus(t, db, host.ID, sp.ID, "connected")
+
+		err = m.HoldTunnel(host, held)
+		if err != nil {
+			t.Fatalf("HoldTunnel() error = %v", err)
+		}
+		err = m.RetryTunnel(host, held)
+		if !errors.Is(err, ErrTunnelHeld) {
+			t.Errorf("RetryTunnel() of a held tunnel error = %v, want %v", err, ErrTunnelHeld)
+		}
+	})
+}
@@ -0,0 +1,330 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// ErrTunnelClosed is returned when a tunnel is asked to start outside the open window of its schedules.
+var ErrTunnelClosed = errors.New("tunnel is closed by its schedule and starts when the schedule opens")
+
+// DefaultTimeZone is the time zone of schedules that do not set one.
+const DefaultTimeZone = "UTC"
+
+// compiledSchedule is a Schedule with its open and close rules parsed.
+type compiledSchedule struct {
+	model models.Schedule
+	open  cron.Schedule
+	close cron.Schedule
+}
+
+func parseRule(rule string, location *time.Location) (cron.Schedule, error) {
+	parsed, err := cron.ParseStandard(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, ok := parsed.(*cron.SpecSchedule)
+	if !ok {
+		return nil, fmt.Errorf("@every is not supported")
+	}
+	spec.Location = location
+
+	// The cron package accepts dates that do not exist, such as February 30.
+	if spec.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("rule never fires")
+	}
+
+	return spec, nil
+}
+
+func compileSchedule(schedule *models.Schedule) (*compiledSchedule, error) {
+	timeZone := schedule.TimeZone
+	if timeZone == "" {
+		timeZone = DefaultTimeZone
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone: %w", err)
+	}
+
+	openRule, err := parseRule(schedule.Open, location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid open rule: %w", err)
+	}
+
+	closeRule, err := parseRule(schedule.Close, location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid close rule: %w", err)
+	}
+
+	return &compiledSchedule{
+		model: *schedule,
+		open:  openRule,
+		close: closeRule,
+	}, nil
+}
+
+// ValidateSchedule checks the open and close rules and the time zone of a schedule.
+func ValidateSchedule(schedule *models.Schedule) error {
+	_, err := compileSchedule(schedule)
+	return err
+}
+
+// lookbacks are the growing windows searched for the previous activation of a rule.
+var lookbacks = []time.Duration{
+	time.Minute,
+	time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	32 * 24 * time.Hour,
+	366 * 24 * time.Hour,
+	5 * 366 * 24 * time.Hour,
+}
+
+// previous returns the last activation of rule at or before now, or the zero time when it has
+// not fired for years. The cron package only looks forward, so the smallest window before now
+// that contains an activation is searched forward.
+func previous(rule cron.Schedule, now time.Time) time.Time {
+	for _, lookback := range lookbacks {
+		last := rule.Next(now.Add(-lookback))
+		if last.IsZero() {
+			return time.Time{}
+		}
+		if last.After(now) {
+			continue
+		}
+
+		for next := rule.Next(last); !next.IsZero() && !next.After(now); next = rule.Next(next) {
+			last = next
+		}
+		return last
+	}
+
+	return time.Time{}
+}
+
+// state reports whether the schedule is open at now: its open rule fired after its close rule.
+// When both fire at the same time, close wins.
+func (s *compiledSchedule) state(now time.Time) models.ScheduleState {
+	return models.ScheduleState{
+		Open:      previous(s.open, now).After(previous(s.close, now)),
+		NextOpen:  s.open.Next(now),
+		NextClose: s.close.Next(now),
+	}
+}
+
+func (s *compiledSchedule) appliesTo(hostID, spID uint) bool {
+	return (s.model.HostID == nil || *s.model.HostID == hostID) &&
+		(s.model.SPID == nil || *s.model.SPID == spID)
+}
+
+// ScheduleState returns the current state of a schedule and its next transitions.
+func ScheduleState(schedule *models.Schedule, now time.Time) (*models.ScheduleState, error) {
+	compiled, err := compileSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	state := compiled.state(now)
+	return &state, nil
+}
+
+// loadSchedules returns the enabled schedules. They are read and compiled once and kept until
+// ReloadSchedules is called. Schedules that can no longer be parsed, for example because their
+// time zone is unknown on this system, are logged and ignored.
+func (m *Manager) loadSchedules() ([]*compiledSchedule, error) {
+	m.schedulesMu.Lock()
+	defer m.schedulesMu.Unlock()
+
+	if m.schedulesLoaded {
+		return m.schedules, nil
+	}
+
+	var schedules []models.Schedule
+	err := m.db.Where("enabled = ?", true).Find(&schedules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schedules: %w", err)
+	}
+
+	compiled := make([]*compiledSchedule, 0, len(schedules))
+	for i := range schedules {
+		c, err := compileSchedule(&schedules[i])
+		if err != nil {
+			m.logger.Error("ignoring invalid schedule",
+				zap.Uint("schedule_id", schedules[i].ID),
+				zap.String("name", schedules[i].Name),
+				zap.Error(err))
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+	m.schedules = compiled
+	m.schedulesLoaded = true
+
+	return compiled, nil
+}
+
+// tunnelSchedule combines the schedules applying to a tunnel. The tunnel is open while every one
+// of them is open, and its state may change at the earliest next transition of any of them.
+// It returns nil when no schedule applies.
+func tunnelSchedule(schedules []*compiledSchedule, hostID, spID uint, now time.Time) *models.TunnelSchedule {
+	var result *models.TunnelSchedule
+	for _, s := range schedules {
+		if !s.appliesTo(hostID, spID) {
+			continue
+		}
+
+		if result == nil {
+			result = &models.TunnelSchedule{Open: true}
+		}
+		result.ScheduleIDs = append(result.ScheduleIDs, s.model.ID)
+
+		state := s.state(now)
+		result.Open = result.Open && state.Open
+
+		next := state.NextOpen
+		if state.Open {
+			next = state.NextClose
+		}
+		if result.NextTransition == nil || next.Before(*result.NextTransition) {
+			result.NextTransition = &next
+		}
+	}
+
+	return result
+}
+
+// scheduleClosed reports whether the schedules of the tunnel keep it closed now.
+func (m *Manager) scheduleClosed(hostID, spID uint) (bool, error) {
+	schedules, err := m.loadSchedules()
+	if err != nil {
+		return false, err
+	}
+
+	schedule := tunnelSchedule(schedules, hostID, spID, time.Now())
+	return schedule != nil && !schedule.Open, nil
+}
+
+// attachSchedules sets the schedule state of every tunnel that has a schedule.
+func (m *Manager) attachSchedules(tunnels []models.Tunnel) error {
+	schedules, err := m.loadSchedules()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range tunnels {
+		tunnels[i].Schedule = tunnelSchedule(schedules, tunnels[i].HostID, tunnels[i].SPID, now)
+	}
+
+	return nil
+}
+
+// ReloadSchedules must be called after schedules, or the hosts and service ports they apply to,
+// are changed. The schedules are read again and the scheduler applies them right away instead
+// of on its next check.
+func (m *Manager) ReloadSchedules() {
+	m.schedulesMu.Lock()
+	m.schedules = nil
+	m.schedulesLoaded = false
+	m.schedulesMu.Unlock()
+
+	select {
+	case m.schedulesChanged <- struct{}{}:
+	default:
+	}
+}
+
+// RunScheduler opens and closes tunnels according to their schedules at the start of every minute
+// and whenever ReloadSchedules is called. A tunnel closed by its schedule is stopped and reported
+// with the closed status until the schedule opens again. Tunnels stopped through the API stay stopped.
+func (m *Manager) RunScheduler() {
+	for {
+		m.applySchedules(time.Now())
+
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-time.After(time.Until(next)):
+		case <-m.schedulesChanged:
+		}
+	}
+}
+
+func (m *Manager) applySchedules(now time.Time) {
+	schedules, err := m.loadSchedules()
+	if err != nil {
+		m.logger.Error("failed to apply schedules", zap.Error(err))
+		return
+	}
+
+	var closedTunnels []models.Tunnel
+	err = m.db.Where("status = ?", "closed").Find(&closedTunnels).Error
+	if err != nil {
+		m.logger.Error("failed to fetch closed tunnels", zap.Error(err))
+		return
+	}
+	closed := make(map[string]bool)
+	for _, t := range closedTunnels {
+		closed[fmt.Sprintf("%d-%d", t.HostID, t.SPID)] = true
+	}
+
+	if len(schedules) == 0 && len(closed) == 0 {
+		return
+	}
+
+	var hosts []models.Host
+	err = m.db.Where("enabled = ?", true).Find(&hosts).Error
+	if err != nil {
+		m.logger.Error("failed to fetch Hosts", zap.Error(err))
+		return
+	}
+
+	for _, host := range hosts {
+		sps, err := m.ServicePortsForHost(host.ID)
+		if err != nil {
+			m.logger.Error("failed to fetch assigned service ports",
+				zap.Error(err),
+				zap.String("host_ip", host.IP))
+			continue
+		}
+
+		for _, sp := range sps {
+			schedule := tunnelSchedule(schedules, host.ID, sp.ID, now)
+			open := schedule == nil || schedule.Open
+
+			switch {
+			case !open && m.isRunning(host.ID, sp.ID):
+				m.logger.Info("closing tunnel by schedule",
+					zap.String("host_ip", host.IP),
+					zap.Int("service_port", sp.ServicePort))
+
+				err = m.StopTunnel(host.ID, sp.ID)
+				if err == nil {
+					// Records the closed status without starting the tunnel.
+					err = m.StartTunnel(&host, &sp)
+				}
+			case open && closed[fmt.Sprintf("%d-%d", host.ID, sp.ID)]:
+				m.logger.Info("opening tunnel by schedule",
+					zap.String("host_ip", host.IP),
+					zap.Int("service_port", sp.ServicePort))
+
+				err = m.StartTunnel(&host, &sp)
+			default:
+				continue
+			}
+			if err != nil {
+				m.logger.Error("failed to apply schedule",
+					zap.Error(err),
+					zap.String("host_ip", host.IP),
+					zap.Int("service_port", sp.ServicePort))
+			}
+		}
+	}
+}
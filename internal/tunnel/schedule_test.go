@@ -0,0 +1,227 @@
+package tunnel
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+func mustParseRule(t *testing.T, rule string, location *time.Location) cron.Schedule {
+	t.Helper()
+
+	parsed, err := parseRule(rule, location)
+	if err != nil {
+		t.Fatalf("parseRule(%q) error = %v", rule, err)
+	}
+
+	return parsed
+}
+
+func TestPrevious(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Fatalf("failed to load time zone: %v", err)
+	}
+	now := time.Date(2026, 3, 11, 10, 30, 0, 0, time.UTC) // Wednesday
+
+	tests := []struct {
+		name     string
+		rule     string
+		location *time.Location
+		want     time.Time
+	}{
+		{name: "earlier today", rule: "0 9 * * *", location: time.UTC, want: time.Date(2026, 3, 11, 9, 0, 0, 0, time.UTC)},
+		{name: "at now", rule: "30 10 * * *", location: time.UTC, want: now},
+		{name: "yesterday", rule: "0 11 * * *", location: time.UTC, want: time.Date(2026, 3, 10, 11, 0, 0, 0, time.UTC)},
+		{name: "every minute", rule: "* * * * *", location: time.UTC, want: now},
+		{name: "last weekend", rule: "0 8 * * 6", location: time.UTC, want: time.Date(2026, 3, 7, 8, 0, 0, 0, time.UTC)},
+		{name: "last month", rule: "0 0 15 * *", location: time.UTC, want: time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "last year", rule: "0 0 1 6 *", location: time.UTC, want: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "last leap day", rule: "0 0 29 2 *", location: time.UTC, want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "other time zone", rule: "0 18 * * *", location: seoul, want: time.Date(2026, 3, 11, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := previous(mustParseRule(t, tt.rule, tt.location), now)
+			if !got.Equal(tt.want) {
+				t.Errorf("previous(%q) = %v, want %v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleState(t *testing.T) {
+	// Open on weekdays from 9:00 to 18:00 in Seoul (0:00 to 9:00 UTC).
+	schedule := &models.Schedule{Open: "0 9 * * 1-5", Close: "0 18 * * 1-5", TimeZone: "Asia/Seoul"}
+
+	tests := []struct {
+		name          string
+		now           time.Time
+		wantOpen      bool
+		wantNextOpen  time.Time
+		wantNextClose time.Time
+	}{
+		{name: "working hours", now: time.Date(2026, 3, 11, 3, 0, 0, 0, time.UTC), wantOpen: true,
+			wantNextOpen: time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), wantNextClose: time.Date(2026, 3, 11, 9, 0, 0, 0, time.UTC)},
+		{name: "evening", now: time.Date(2026, 3, 11, 12, 0, 0, 0, time.UTC), wantOpen: false,
+			wantNextOpen: time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), wantNextClose: time.Date(2026, 3, 12, 9, 0, 0, 0, time.UTC)},
+		{name: "weekend", now: time.Date(2026, 3, 14, 3, 0, 0, 0, time.UTC), wantOpen: false,
+			wantNextOpen: time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC), wantNextClose: time.Date(2026, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{name: "at opening", now: time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC), wantOpen: true,
+			wantNextOpen: time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), wantNextClose: time.Date(2026, 3, 11, 9, 0, 0, 0, time.UTC)},
+		{name: "at closing", now: time.Date(2026, 3, 11, 9, 0, 0, 0, time.UTC), wantOpen: false,
+			wantNextOpen: time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), wantNextClose: time.Date(2026, 3, 12, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := ScheduleState(schedule, tt.now)
+			if err != nil {
+				t.Fatalf("ScheduleState() error = %v", err)
+			}
+			if state.Open != tt.wantOpen || !state.NextOpen.Equal(tt.wantNextOpen) || !state.NextClose.Equal(tt.wantNextClose) {
+				t.Errorf("ScheduleState() = open %v, next open %v, next close %v, want open %v, next open %v, next close %v",
+					state.Open, state.NextOpen.UTC(), state.NextClose.UTC(), tt.wantOpen, tt.wantNextOpen, tt.wantNextClose)
+			}
+		})
+	}
+
+	// Close wins when both rules fire at the same time.
+	same := &models.Schedule{Open: "0 * * * *", Close: "0 */2 * * *"}
+	state, err := ScheduleState(same, time.Date(2026, 3, 11, 2, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("ScheduleState() error = %v", err)
+	}
+	if state.Open {
+		t.Errorf("ScheduleState() at 2:30 is open, want closed by the close rule firing at 2:00")
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule models.Schedule
+		wantErr  bool
+	}{
+		{name: "valid", schedule: models.Schedule{Open: "0 9 * * 1-5", Close: "0 18 * * 1-5", TimeZone: "Asia/Seoul"}},
+		{name: "default time zone", schedule: models.Schedule{Open: "0 9 * * *", Close: "0 18 * * *"}},
+		{name: "unknown time zone", schedule: models.Schedule{Open: "0 9 * * *", Close: "0 18 * * *", TimeZone: "Mars/Olympus"}, wantErr: true},
+		{name: "invalid open rule", schedule: models.Schedule{Open: "0 25 * * *", Close: "0 18 * * *"}, wantErr: true},
+		{name: "every", schedule: models.Schedule{Open: "@every 1h", Close: "0 18 * * *"}, wantErr: true},
+		{name: "rule that never fires", schedule: models.Schedule{Open: "0 9 * * *", Close: "0 0 30 2 *"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSchedule(&tt.schedule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTunnelSchedule(t *testing.T) {
+	hostID, spID := uint(1), uint(2)
+	compile := func(id uint, open, close string, hostID, spID *uint) *compiledSchedule {
+		compiled, err := compileSchedule(&models.Schedule{ID: id, Open: open, Close: close, HostID: hostID, SPID: spID})
+		if err != nil {
+			t.Fatalf("compileSchedule() error = %v", err)
+		}
+		return compiled
+	}
+	// Open from 9:00 to 18:00 for the host, and from 12:00 to 20:00 for the service port.
+	schedules := []*compiledSchedule{
+		compile(1, "0 9 * * *", "0 18 * * *", &hostID, nil),
+		compile(2, "0 12 * * *", "0 20 * * *", nil, &spID),
+	}
+
+	at := func(hour int) time.Time {
+		return time.Date(2026, 3, 11, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		hostID   uint
+		spID     uint
+		now      time.Time
+		wantIDs  []uint
+		wantOpen bool
+		wantNext time.Time
+	}{
+		{name: "host schedule open only", hostID: hostID, spID: 3, now: at(10), wantIDs: []uint{1}, wantOpen: true, wantNext: at(18)},
+		{name: "one of two open", hostID: hostID, spID: spID, now: at(10), wantIDs: []uint{1, 2}, wantOpen: false, wantNext: at(12)},
+		{name: "both open", hostID: hostID, spID: spID, now: at(13), wantIDs: []uint{1, 2}, wantOpen: true, wantNext: at(18)},
+		{name: "service port schedule on another host", hostID: 5, spID: spID, now: at(19), wantIDs: []uint{2}, wantOpen: true, wantNext: at(20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tunnelSchedule(schedules, tt.hostID, tt.spID, tt.now)
+			if got == nil {
+				t.Fatalf("tunnelSchedule() = nil, want schedules %v", tt.wantIDs)
+			}
+			if !slices.Equal(got.ScheduleIDs, tt.wantIDs) || got.Open != tt.wantOpen || !got.NextTransition.Equal(tt.wantNext) {
+				t.Errorf("tunnelSchedule() = schedules %v, open %v, next %v, want schedules %v, open %v, next %v",
+					got.ScheduleIDs, got.Open, got.NextTransition, tt.wantIDs, tt.wantOpen, tt.wantNext)
+			}
+		})
+	}
+
+	if got := tunnelSchedule(schedules, 5, 3, at(10)); got != nil {
+		t.Errorf("tunnelSchedule() without schedules = %+v, want nil", got)
+	}
+}
+
+func TestLoadSchedulesCache(t *testing.T) {
+	m, db := newTestManager(t)
+
+	queries := 0
+	err := db.Callback().Query().Before("gorm:query").Register("test:count_schedules", func(tx *gorm.DB) {
+		if tx.Statement.Table == "schedules" {
+			queries++
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to register callback: %v", err)
+	}
+
+	hostID := uint(1)
+	err = db.Create(&models.Schedule{Name: "never", HostID: &hostID, Open: "0 0 1 1 *", Close: "* * * * *"}).Error
+	if err != nil {
+		t.Fatalf("failed to create schedule: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		closed, err := m.scheduleClosed(hostID, 1)
+		if err != nil {
+			t.Fatalf("scheduleClosed() error = %v", err)
+		}
+		if !closed {
+			t.Fatalf("scheduleClosed() = false, want true")
+		}
+	}
+	if queries != 1 {
+		t.Errorf("schedules were read %d times for 10 tunnels, want 1", queries)
+	}
+
+	// A disabled schedule no longer applies once the schedules are reloaded.
+	err = db.Model(&models.Schedule{}).Where("name = ?", "never").Update("enabled", false).Error
+	if err != nil {
+		t.Fatalf("failed to disable schedule: %v", err)
+	}
+	m.ReloadSchedules()
+
+	closed, err := m.scheduleClosed(hostID, 1)
+	if err != nil {
+		t.Fatalf("scheduleClosed() error = %v", err)
+	}
+	if closed || queries != 2 {
+		t.Errorf("scheduleClosed() after reload = %v with %d reads, want false with 2", closed, queries)
+	}
+}
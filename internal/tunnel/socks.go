@@ -0,0 +1,306 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyNotAllowed          = 0x02
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddrNotSupported    = 0x08
+
+	socksHandshakeTimeout = 10 * time.Second
+)
+
+// allowRule matches destinations by CIDR, IP address or domain name, or every destination,
+// optionally restricted to one port.
+type allowRule struct {
+	network *net.IPNet
+	domain  string
+	any     bool
+	port    int
+}
+
+// Allowlist restricts the destinations a dynamic tunnel may connect to.
+// An empty allowlist allows every destination, which CheckDynamicBind only accepts on loopback.
+type Allowlist []allowRule
+
+// ParseAllowlist parses allowlist entries. Each entry is a CIDR ("10.0.0.0/8"), an IP address,
+// a domain name ("db.internal"), a domain suffix ("*.internal") or "*" for every destination,
+// optionally followed by ":port". IPv6 addresses with a port are written in brackets ("[fd00::1]:443").
+func ParseAllowlist(entries []string) (Allowlist, error) {
+	var allowlist Allowlist
+	for _, entry := range entries {
+		rule, err := parseAllowRule(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist entry %q: %w", entry, err)
+		}
+		allowlist = append(allowlist, rule)
+	}
+
+	return allowlist, nil
+}
+
+func parseAllowRule(entry string) (allowRule, error) {
+	if entry == "" {
+		return allowRule{}, fmt.Errorf("empty entry")
+	}
+
+	var rule allowRule
+	pattern := entry
+	if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+		host, port, err := net.SplitHostPort(entry)
+		if err == nil {
+			rule.port, err = strconv.Atoi(port)
+			if err != nil || rule.port < 1 || rule.port > 65535 {
+				return allowRule{}, fmt.Errorf("invalid port %q", port)
+			}
+			pattern = host
+		}
+	}
+
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		rule.network = network
+		return rule, nil
+	}
+
+	if pattern == "*" {
+		rule.any = true
+		return rule, nil
+	}
+
+	if ip := net.ParseIP(pattern); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		rule.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return rule, nil
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if domain == "" || domain == "*" || strings.ContainsAny(domain, " /[]") {
+		return allowRule{}, fmt.Errorf("not a CIDR, IP address or domain name")
+	}
+	rule.domain = domain
+
+	return rule, nil
+}
+
+func (r allowRule) match(host string, port int) bool {
+	if r.port != 0 && r.port != port {
+		return false
+	}
+
+	if r.any {
+		return true
+	}
+
+	if r.network != nil {
+		ip := net.ParseIP(host)
+		return ip != nil && r.network.Contains(ip)
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if suffix, ok := strings.CutPrefix(r.domain, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+
+	return host == r.domain
+}
+
+// Allows reports whether the destination may be connected to. Domain names are only
+// matched against domain rules because they are resolved by the host, not the manager.
+func (a Allowlist) Allows(host string, port int) bool {
+	if len(a) == 0 {
+		return true
+	}
+
+	for _, rule := range a {
+		if rule.match(host, port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckDynamicBind refuses a dynamic tunnel that would listen on a non-loopback bind address with
+// an empty allowlist, which makes it an open proxy into the network of the host for anyone who can
+// reach the manager. Every destination can still be allowed there with the "*" entry.
+func CheckDynamicBind(bindAddress string, allowlist Allowlist) error {
+	if len(allowlist) > 0 {
+		return nil
+	}
+
+	ip := net.ParseIP(bindAddress)
+	if ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return fmt.Errorf("dynamic tunnel listening on %s needs an allowlist; use \"*\" to allow every destination", bindAddress)
+}
+
+// proxy serves one SOCKS5 client and connects it to the requested destination through dial.
+// Only the CONNECT command without authentication is supported.
+func (t *SSHTunnel) proxy(localConn net.Conn, dial func(network, addr string) (net.Conn, error)) {
+	defer func() {
+		_ = localConn.Close()
+	}()
+
+	_ = localConn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+
+	host, port, err := socksHandshake(localConn)
+	if err != nil {
+		t.logger.Debug("SOCKS5 handshake failed",
+			zap.String("local", t.Local.String()),
+			zap.String("client", localConn.RemoteAddr().String()),
+			zap.Error(err))
+		return
+	}
+
+	destination := net.JoinHostPort(host, strconv.Itoa(port))
+	if !t.allowlist.Allows(host, port) {
+		t.logger.Warn("SOCKS5 destination not allowed",
+			zap.String("local", t.Local.String()),
+			zap.String("server", t.Server.String()),
+			zap.String("destination", destination))
+		_ = socksReply(localConn, socksReplyNotAllowed)
+		return
+	}
+
+	remoteConn, err := dial("tcp", destination)
+	if err != nil {
+		t.logger.Error("failed to dial SOCKS5 destination",
+			zap.String("local", t.Local.String()),
+			zap.String("server", t.Server.String()),
+			zap.String("destination", destination),
+			zap.Error(err))
+		_ = socksReply(localConn, socksReplyHostUnreachable)
+		return
+	}
+	defer func() {
+		_ = remoteConn.Close()
+	}()
+
+	err = socksReply(localConn, socksReplySucceeded)
+	if err != nil {
+		return
+	}
+	_ = localConn.SetDeadline(time.Time{})
+
+	t.pipe(localConn, remoteConn)
+}
+
+// socksHandshake negotiates the authentication method and reads the CONNECT request.
+func socksHandshake(conn net.Conn) (string, int, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read greeting: %w", err)
+	}
+	if header[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	_, err = io.ReadFull(conn, methods)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read authentication methods: %w", err)
+	}
+
+	method := byte(socksMethodNoAcceptable)
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			method = socksMethodNoAuth
+			break
+		}
+	}
+	_, err = conn.Write([]byte{socksVersion, method})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write method selection: %w", err)
+	}
+	if method == socksMethodNoAcceptable {
+		return "", 0, errors.New("client does not support unauthenticated access")
+	}
+
+	request := make([]byte, 4)
+	_, err = io.ReadFull(conn, request)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read request: %w", err)
+	}
+	if request[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", request[0])
+	}
+	if request[1] != socksCmdConnect {
+		_ = socksReply(conn, socksReplyCommandNotSupported)
+		return "", 0, fmt.Errorf("unsupported command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		_, err = io.ReadFull(conn, ip)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read address: %w", err)
+		}
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		_, err = io.ReadFull(conn, length)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read address: %w", err)
+		}
+		domain := make([]byte, length[0])
+		_, err = io.ReadFull(conn, domain)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read address: %w", err)
+		}
+		host = string(domain)
+	default:
+		_ = socksReply(conn, socksReplyAddrNotSupported)
+		return "", 0, fmt.Errorf("unsupported address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	_, err = io.ReadFull(conn, port)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read port: %w", err)
+	}
+
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
+
+// socksReply sends a reply with an unspecified bound address.
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,206 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr bool
+	}{
+		{name: "empty list", entries: nil},
+		{name: "CIDR", entries: []string{"10.0.0.0/8"}},
+		{name: "CIDR with port", entries: []string{"10.0.0.0/8:5432"}},
+		{name: "IPv4 address", entries: []string{"192.168.0.1"}},
+		{name: "IPv6 address", entries: []string{"fd00::1"}},
+		{name: "IPv6 address with port", entries: []string{"[fd00::1]:443"}},
+		{name: "domain", entries: []string{"db.internal"}},
+		{name: "domain suffix with port", entries: []string{" *.internal:443 "}},
+		{name: "empty entry", entries: []string{""}, wantErr: true},
+		{name: "every destination", entries: []string{"*"}},
+		{name: "every destination with port", entries: []string{"*:443"}},
+		{name: "wildcard domain", entries: []string{"*."}, wantErr: true},
+		{name: "port out of range", entries: []string{"db.internal:70000"}, wantErr: true},
+		{name: "invalid port", entries: []string{"db.internal:http"}, wantErr: true},
+		{name: "invalid CIDR", entries: []string{"10.0.0.0/33"}, wantErr: true},
+		{name: "one invalid entry", entries: []string{"10.0.0.0/8", "bad entry"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowlist, err := ParseAllowlist(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAllowlist(%q) error = %v, wantErr %v", tt.entries, err, tt.wantErr)
+			}
+			if err == nil && len(allowlist) != len(tt.entries) {
+				t.Errorf("ParseAllowlist(%q) returned %d rules, want %d", tt.entries, len(allowlist), len(tt.entries))
+			}
+		})
+	}
+}
+
+func TestAllowlistAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		host    string
+		port    int
+		want    bool
+	}{
+		{name: "empty allowlist", entries: nil, host: "example.com", port: 443, want: true},
+		{name: "inside CIDR", entries: []string{"10.0.0.0/8"}, host: "10.1.2.3", port: 22, want: true},
+		{name: "outside CIDR", entries: []string{"10.0.0.0/8"}, host: "11.0.0.1", port: 22, want: false},
+		{name: "CIDR port matches", entries: []string{"10.0.0.0/8:5432"}, host: "10.0.0.5", port: 5432, want: true},
+		{name: "CIDR port differs", entries: []string{"10.0.0.0/8:5432"}, host: "10.0.0.5", port: 5433, want: false},
+		{name: "same IPv4 address", entries: []string{"192.168.0.1"}, host: "192.168.0.1", port: 80, want: true},
+		{name: "other IPv4 address", entries: []string{"192.168.0.1"}, host: "192.168.0.2", port: 80, want: false},
+		{name: "IPv6 address with port", entries: []string{"[fd00::1]:443"}, host: "fd00::1", port: 443, want: true},
+		{name: "IPv6 address other port", entries: []string{"[fd00::1]:443"}, host: "fd00::1", port: 80, want: false},
+		{name: "domain", entries: []string{"db.internal"}, host: "db.internal", port: 5432, want: true},
+		{name: "domain case and trailing dot", entries: []string{"DB.Internal."}, host: "db.INTERNAL.", port: 5432, want: true},
+		{name: "domain does not match subdomain", entries: []string{"internal"}, host: "db.internal", port: 5432, want: false},
+		{name: "domain suffix", entries: []string{"*.internal"}, host: "a.db.internal", port: 5432, want: true},
+		{name: "domain suffix without subdomain", entries: []string{"*.internal"}, host: "internal", port: 5432, want: false},
+		{name: "domain suffix of another name", entries: []string{"*.internal"}, host: "notinternal", port: 5432, want: false},
+		{name: "domain not matched by CIDR", entries: []string{"0.0.0.0/0"}, host: "db.internal", port: 5432, want: false},
+		{name: "IP not matched by domain", entries: []string{"db.internal"}, host: "10.0.0.5", port: 5432, want: false},
+		{name: "every destination", entries: []string{"*"}, host: "db.internal", port: 5432, want: true},
+		{name: "every destination port matches", entries: []string{"*:443"}, host: "10.0.0.5", port: 443, want: true},
+		{name: "every destination port differs", entries: []string{"*:443"}, host: "10.0.0.5", port: 80, want: false},
+		{name: "any rule matches", entries: []string{"10.0.0.0/8", "*.internal:443"}, host: "web.internal", port: 443, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowlist, err := ParseAllowlist(tt.entries)
+			if err != nil {
+				t.Fatalf("ParseAllowlist(%q) error = %v", tt.entries, err)
+			}
+
+			got := allowlist.Allows(tt.host, tt.port)
+			if got != tt.want {
+				t.Errorf("Allows(%q, %d) with %q = %v, want %v", tt.host, tt.port, tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDynamicBind(t *testing.T) {
+	tests := []struct {
+		name        string
+		bindAddress string
+		entries     []string
+		wantErr     bool
+	}{
+		{name: "IPv4 loopback without allowlist", bindAddress: "127.0.0.1"},
+		{name: "IPv6 loopback without allowlist", bindAddress: "::1"},
+		{name: "all interfaces without allowlist", bindAddress: "0.0.0.0", wantErr: true},
+		{name: "interface without allowlist", bindAddress: "10.0.0.5", wantErr: true},
+		{name: "all interfaces with allowlist", bindAddress: "0.0.0.0", entries: []string{"10.0.0.0/8"}},
+		{name: "all interfaces with every destination", bindAddress: "::", entries: []string{"*"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowlist, err := ParseAllowlist(tt.entries)
+			if err != nil {
+				t.Fatalf("ParseAllowlist(%q) error = %v", tt.entries, err)
+			}
+
+			err = CheckDynamicBind(tt.bindAddress, allowlist)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckDynamicBind(%q, %q) error = %v, wantErr %v", tt.bindAddress, tt.entries, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDynamicTunnel(t *testing.T) {
+	server := newTestSSHServer(t, "secret")
+	m, db := newTestManager(t)
+	allowed := newEchoServer(t)
+	denied := newEchoServer(t)
+
+	host := createTestHost(t, db, server, models.Host{Password: "secret"})
+	sp := createTestServicePort(t, db, models.ServicePort{
+		LocalPort: freePort(t),
+		Direction: DirectionDynamic,
+		Allowlist: []string{allowed},
+	}, host.ID)
+
+	err := m.StartTunnel(host, sp)
+	if err != nil {
+		t.Fatalf("StartTunnel() error = %v", err)
+	}
+	waitForStatus(t, db, host.ID, sp.ID, "connected")
+	proxy := net.JoinHostPort("127.0.0.1", strconv.Itoa(sp.LocalPort))
+
+	reply, err := socksEcho(proxy, allowed, "ping")
+	if err != nil || reply != "ping" {
+		t.Fatalf("echo to %s through %s = %q, %v", allowed, proxy, reply, err)
+	}
+
+	_, err = socksEcho(proxy, denied, "ping")
+	if err == nil {
+		t.Errorf("echo to %s through %s succeeded, want it refused by the allowlist", denied, proxy)
+	}
+
+	// Without an allowlist, a proxy reachable from other machines is not started.
+	exposed := createTestServicePort(t, db, models.ServicePort{
+		LocalPort:   freePort(t),
+		BindAddress: "0.0.0.0",
+		Direction:   DirectionDynamic,
+	}, host.ID)
+	err = m.StartTunnel(host, exposed)
+	if err == nil {
+		t.Errorf("StartTunnel() of a dynamic tunnel on 0.0.0.0 without allowlist succeeded, want error")
+	}
+}
+
+// socksEcho connects to addr through the SOCKS5 proxy, sends msg and returns the echoed reply.
+func socksEcho(proxy, addr, msg string) (string, error) {
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+	request := []byte{socksVersion, 1, socksMethodNoAuth, socksVersion, socksCmdConnect, 0, socksAddrIPv4}
+	request = append(request, net.ParseIP(host).To4()...)
+	request = binary.BigEndian.AppendUint16(request, uint16(portNum))
+	_, err = conn.Write(request)
+	if err != nil {
+		return "", err
+	}
+
+	reply := make([]byte, 2+10)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return "", err
+	}
+	if reply[3] != socksReplySucceeded {
+		return "", fmt.Errorf("SOCKS5 reply %d", reply[3])
+	}
+
+	_, err = conn.Write([]byte(msg))
+	if err != nil {
+		return "", err
+	}
+	echoed := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, echoed)
+
+	return string(echoed), err
+}
@@ -3,33 +3,48 @@ package tunnel
 import (
 	"errors"
 	"fmt"
-	"github.com/jollaman999/tunnel-manager/internal/models"
-	"go.uber.org/zap"
-	"golang.org/x/crypto/ssh"
 	"io"
 	"net"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/metrics"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
 )
 
+const (
+	// DirectionRemote listens on the host and forwards to the service from the manager (ssh -R).
+	DirectionRemote = "remote"
+	// DirectionLocal listens on the manager and forwards to the service from the host (ssh -L).
+	DirectionLocal = "local"
+	// DirectionDynamic runs a SOCKS5 proxy on the manager and connects to each requested
+	// destination from the host (ssh -D).
+	DirectionDynamic = "dynamic"
+)
+
+// SSHTunnel forwards one service port over the SSH client shared by its HostConnection.
 type SSHTunnel struct {
-	HostID    *uint
-	SPID      *uint
-	Local     *net.TCPAddr
-	Server    *net.TCPAddr
-	Remote    *net.TCPAddr
-	Config    *ssh.ClientConfig
-	client    *ssh.Client
-	clientMu  sync.RWMutex
-	done      chan bool
-	isStopped bool
-	stopMu    sync.Mutex
-	logger    *zap.Logger
+	HostID     *uint
+	SPID       *uint
+	Direction  string
+	Local      *net.TCPAddr
+	Server     *net.TCPAddr
+	Remote     *net.TCPAddr
+	allowlist  Allowlist
+	metrics    *metrics.Tunnel
+	traffic    trafficCounter
+	record     *models.Tunnel
+	recordMu   sync.Mutex
+	listener   net.Listener
+	listenerMu sync.Mutex
+	isStopped  bool
+	stopMu     sync.Mutex
+	logger     *zap.Logger
 }
 
-func NewSSHTunnel(hostID, spID *uint, localAddr, serverAddr, remoteAddr string, sshConfig *ssh.ClientConfig, logger *zap.Logger) (*SSHTunnel, error) {
+func NewSSHTunnel(hostID, spID *uint, direction, localAddr, serverAddr, remoteAddr string, record *models.Tunnel, logger *zap.Logger) (*SSHTunnel, error) {
 	local, err := net.ResolveTCPAddr("tcp", localAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve local address: %w", err)
@@ -40,193 +55,158 @@ func NewSSHTunnel(hostID, spID *uint, localAddr, serverAddr, remoteAddr string,
 		return nil, fmt.Errorf("failed to resolve server address: %w", err)
 	}
 
-	remote, err := net.ResolveTCPAddr("tcp", remoteAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve remote address: %w", err)
+	var remote *net.TCPAddr
+	if direction != DirectionDynamic {
+		remote, err = net.ResolveTCPAddr("tcp", remoteAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve remote address: %w", err)
+		}
 	}
 
 	return &SSHTunnel{
-		HostID: hostID,
-		SPID:   spID,
-		Local:  local,
-		Server: server,
-		Remote: remote,
-		Config: sshConfig,
-		done:   make(chan bool),
-		logger: logger,
+		HostID:    hostID,
+		SPID:      spID,
+		Direction: direction,
+		Local:     local,
+		Server:    server,
+		Remote:    remote,
+		record:    record,
+		logger:    logger,
 	}, nil
 }
 
-func (t *SSHTunnel) saveTunnelStatus(m *Manager, tunnel *models.Tunnel) {
+func (t *SSHTunnel) stopped() bool {
 	t.stopMu.Lock()
-	if t.isStopped {
-		t.stopMu.Unlock()
+	defer t.stopMu.Unlock()
+
+	return t.isStopped
+}
+
+func (t *SSHTunnel) saveTunnelStatus(m *Manager, tunnel *models.Tunnel) {
+	if t.stopped() {
 		return
 	}
-	t.stopMu.Unlock()
 
 	err := m.db.Save(tunnel).Error
 	if err != nil {
 		m.logger.Error("failed to update tunnel connected status", zap.Error(err))
 	}
+
+	m.publishStatus(*tunnel)
 }
 
-func (t *SSHTunnel) reconnect(m *Manager, tunnel *models.Tunnel) {
-	t.stopMu.Lock()
-	if t.isStopped {
-		t.stopMu.Unlock()
-		return
+// setStatus updates the tunnel record for a state transition and saves it.
+func (t *SSHTunnel) setStatus(m *Manager, status string, cause error) {
+	t.recordMu.Lock()
+	defer t.recordMu.Unlock()
+
+	t.record.Status = status
+	switch status {
+	case "connected":
+		t.record.RetryCount = 0
+		t.record.LastError = ""
+		t.record.Warning = ""
+		t.record.LastConnectedAt = time.Now()
+	case "reconnecting":
+		t.record.RetryCount++
+		t.metrics.Reconnected()
 	}
-	t.stopMu.Unlock()
-
-	tunnel.Status = "reconnecting"
-	tunnel.RetryCount++
-	t.saveTunnelStatus(m, tunnel)
-
-	t.clientMu.Lock()
-	if t.client != nil {
-		_ = t.client.Close()
-		t.client = nil
+	if cause != nil {
+		t.record.LastError = cause.Error()
 	}
-	t.clientMu.Unlock()
+	t.metrics.SetStatus(status)
 
-	err := t.establishConnection(m, tunnel)
-	if err != nil {
-		t.logger.Error("reconnection failed",
-			zap.String("local", t.Local.String()),
-			zap.String("server", t.Server.String()),
-			zap.String("remote", t.Remote.String()),
-			zap.Error(err))
+	if t.stopped() {
 		return
 	}
-
-	t.logger.Info("reconnection successful",
-		zap.String("local", t.Local.String()),
-		zap.String("server", t.Server.String()),
-		zap.String("remote", t.Remote.String()))
+	t.saveTunnelStatus(m, t.record)
+	t.recordEvent(m, status)
 }
 
-func (t *SSHTunnel) monitorConnection(m *Manager, tunnel *models.Tunnel) {
-	ticker := time.NewTicker(time.Duration(m.monitoringIntervalSec) * time.Second)
-	defer ticker.Stop()
+func (t *SSHTunnel) status() string {
+	t.recordMu.Lock()
+	defer t.recordMu.Unlock()
 
-	for {
-		select {
-		case <-t.done:
-			return
-		case <-ticker.C:
-			t.clientMu.RLock()
-			client := t.client
-			t.clientMu.RUnlock()
-
-			if client != nil {
-				conn, err := net.DialTimeout("tcp", t.Server.String(),
-					time.Duration(m.monitoringIntervalSec)*time.Second)
-				if err != nil {
-					t.logger.Warn("SSH connection lost, attempting reconnection",
-						zap.String("local", t.Local.String()),
-						zap.String("server", t.Server.String()),
-						zap.String("remote", t.Remote.String()),
-						zap.Error(err))
-					t.reconnect(m, tunnel)
-					continue
-				}
-				_ = conn.Close()
-
-				_, _, err = client.SendRequest("keepalive@tunnel", true, nil)
-				if err != nil {
-					t.logger.Warn("SSH keepalive check failed, attempting reconnection",
-						zap.String("server", t.Server.String()),
-						zap.Error(err))
-					t.reconnect(m, tunnel)
-				}
-			}
-		}
-	}
+	return t.record.Status
 }
 
-func (t *SSHTunnel) forward(localConn net.Conn) {
-	defer func() {
-		_ = localConn.Close()
-	}()
+func (t *SSHTunnel) setWarning(m *Manager, warning string) {
+	t.recordMu.Lock()
+	defer t.recordMu.Unlock()
 
-	remoteConn, err := net.Dial("tcp", t.Remote.String())
-	if err != nil {
-		t.logger.Error("failed to dial remote service",
-			zap.String("local", t.Local.String()),
-			zap.String("server", t.Server.String()),
-			zap.String("remote", t.Remote.String()),
-			zap.Error(err))
-		return
-	}
-	defer func() {
-		_ = remoteConn.Close()
-	}()
+	t.record.Warning = warning
+	t.saveTunnelStatus(m, t.record)
+}
 
-	errc := make(chan error, 2)
-	go func() {
-		_, err := io.Copy(localConn, remoteConn)
-		errc <- err
-	}()
-	go func() {
-		_, err := io.Copy(remoteConn, localConn)
-		errc <- err
-	}()
+func (t *SSHTunnel) isListening() bool {
+	t.listenerMu.Lock()
+	defer t.listenerMu.Unlock()
 
-	err = <-errc
-	if err != nil && err != io.EOF {
-		t.logger.Debug("copy error", zap.Error(err))
-	}
+	return t.listener != nil
 }
 
-func (t *SSHTunnel) establishConnection(m *Manager, tunnel *models.Tunnel) error {
-	client, err := ssh.Dial("tcp", t.Server.String(), t.Config)
-	if err != nil {
-		m.logger.Error("failed to establish SSH connection",
-			zap.String("local", t.Local.String()),
-			zap.String("server", t.Server.String()),
-			zap.String("remote", t.Remote.String()), zap.Error(err))
-
-		tunnel.Status = "error"
-		tunnel.LastError = err.Error()
-		t.saveTunnelStatus(m, tunnel)
+// listen opens the tunnel's listener and serves it in the background. In remote mode
+// the listener is opened on the host through client and connections are forwarded
+// from the manager; in local and dynamic mode it is opened on the manager and
+// connections are forwarded through client. It is a no-op when the tunnel is stopped
+// or already listening.
+func (t *SSHTunnel) listen(m *Manager, client *ssh.Client) {
+	if t.stopped() {
+		return
+	}
 
-		return fmt.Errorf("failed to establish SSH connection: %w", err)
+	t.listenerMu.Lock()
+	if t.listener != nil {
+		t.listenerMu.Unlock()
+		return
 	}
 
-	listener, err := client.Listen("tcp", t.Local.String())
+	var listener net.Listener
+	var handle func(conn net.Conn)
+	var err error
+	switch t.Direction {
+	case DirectionLocal:
+		listener, err = net.Listen("tcp", t.Local.String())
+		handle = func(conn net.Conn) { t.forward(conn, client.Dial) }
+	case DirectionDynamic:
+		listener, err = net.Listen("tcp", t.Local.String())
+		handle = func(conn net.Conn) { t.proxy(conn, client.Dial) }
+	default:
+		listener, err = client.Listen("tcp", t.Local.String())
+		handle = func(conn net.Conn) { t.forward(conn, net.Dial) }
+	}
 	if err != nil {
-		m.logger.Error("failed to start remote listener",
+		t.listenerMu.Unlock()
+
+		m.logger.Error("failed to start "+t.Direction+" listener",
 			zap.String("local", t.Local.String()),
 			zap.String("server", t.Server.String()),
 			zap.String("remote", t.Remote.String()), zap.Error(err))
 
-		tunnel.Status = "error"
-		tunnel.LastError = err.Error()
-		t.saveTunnelStatus(m, tunnel)
-
-		return fmt.Errorf("failed to start remote listener: %w", err)
+		t.setStatus(m, ErrorBind.Status(), &ClassifiedError{
+			Class: ErrorBind,
+			Err:   fmt.Errorf("failed to start %s listener: %w", t.Direction, err),
+		})
+		return
 	}
-	defer func() {
-		_ = listener.Close()
-	}()
-
-	t.clientMu.Lock()
-	t.client = client
-	t.clientMu.Unlock()
+	t.listener = listener
+	t.listenerMu.Unlock()
 
-	tunnel.Status = "connected"
-	tunnel.RetryCount = 0
-	tunnel.LastError = ""
-	tunnel.LastConnectedAt = time.Now()
-	t.saveTunnelStatus(m, tunnel)
+	t.setStatus(m, "connected", nil)
 
 	t.logger.Info("tunnel connected successfully",
 		zap.String("local", t.Local.String()),
 		zap.String("server", t.Server.String()),
 		zap.String("remote", t.Remote.String()))
 
-	go t.monitorConnection(m, tunnel)
+	go t.serve(m, listener, handle)
+	if t.Direction == DirectionRemote {
+		go t.checkGatewayPorts(m)
+	}
+}
+
+func (t *SSHTunnel) serve(m *Manager, listener net.Listener, handle func(conn net.Conn)) {
+	defer t.closeListener(listener)
 
 	for {
 		conn, err := listener.Accept()
@@ -241,12 +221,12 @@ func (t *SSHTunnel) establishConnection(m *Manager, tunnel *models.Tunnel) error
 				continue
 			}
 
-			if err == io.EOF {
+			if err == io.EOF || t.stopped() {
 				t.logger.Info("connection closed",
 					zap.String("local", t.Local.String()),
 					zap.String("server", t.Server.String()),
 					zap.String("remote", t.Remote.String()))
-				return nil
+				return
 			}
 
 			m.logger.Error("listener accept error",
@@ -254,54 +234,93 @@ func (t *SSHTunnel) establishConnection(m *Manager, tunnel *models.Tunnel) error
 				zap.String("server", t.Server.String()),
 				zap.String("remote", t.Remote.String()), zap.Error(err))
 
-			return fmt.Errorf("listener accept error: %w", err)
+			t.setStatus(m, "error", fmt.Errorf("listener accept error: %w", err))
+			return
 		}
-		go t.forward(conn)
+		go handle(conn)
 	}
 }
 
-func (t *SSHTunnel) Start(m *Manager, tunnel *models.Tunnel) {
-	t.logger.Info("attempting to start tunnel",
-		zap.String("local", t.Local.String()),
-		zap.String("server", t.Server.String()),
-		zap.String("remote", t.Remote.String()))
+// closeListener closes listener and forgets it if it is still the active one.
+func (t *SSHTunnel) closeListener(listener net.Listener) {
+	t.listenerMu.Lock()
+	defer t.listenerMu.Unlock()
 
-	for {
-		select {
-		case <-t.done:
-			return
-		default:
-			t.stopMu.Lock()
-			if t.isStopped {
-				t.stopMu.Unlock()
-				return
-			}
-			t.stopMu.Unlock()
-
-			err := t.establishConnection(m, tunnel)
-			if err != nil {
-				if strings.Contains(err.Error(), "unable to authenticate") {
-					t.logger.Error("connection failed",
-						zap.String("local", t.Local.String()),
-						zap.String("server", t.Server.String()),
-						zap.String("remote", t.Remote.String()),
-						zap.Error(err))
-					return
-				}
-
-				t.logger.Error("connection failed, retrying in "+strconv.Itoa(m.monitoringIntervalSec)+" seconds",
-					zap.String("local", t.Local.String()),
-					zap.String("server", t.Server.String()),
-					zap.String("remote", t.Remote.String()),
-					zap.Error(err))
+	if listener == nil {
+		listener = t.listener
+	}
+	if listener == nil {
+		return
+	}
+
+	_ = listener.Close()
+	if t.listener == listener {
+		t.listener = nil
+	}
+}
 
-				time.Sleep(time.Duration(m.monitoringIntervalSec) * time.Second)
+func (t *SSHTunnel) forward(localConn net.Conn, dial func(network, addr string) (net.Conn, error)) {
+	defer func() {
+		_ = localConn.Close()
+	}()
 
-				tunnel.Status = "reconnecting"
-				tunnel.RetryCount++
-				t.saveTunnelStatus(m, tunnel)
-			}
-		}
+	remoteConn, err := dial("tcp", t.Remote.String())
+	if err != nil {
+		t.logger.Error("failed to dial remote service",
+			zap.String("local", t.Local.String()),
+			zap.String("server", t.Server.String()),
+			zap.String("remote", t.Remote.String()),
+			zap.Error(err))
+		return
+	}
+	defer func() {
+		_ = remoteConn.Close()
+	}()
+
+	t.pipe(localConn, remoteConn)
+}
+
+// countingWriter reports the size of every write to count.
+type countingWriter struct {
+	w     io.Writer
+	count func(n int)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.count(n)
+	return n, err
+}
+
+// pipe copies data between the client and the service connection until either side is done,
+// counting the forwarded connection and its bytes in the tunnel's metrics and traffic.
+func (t *SSHTunnel) pipe(clientConn, serviceConn net.Conn) {
+	t.metrics.ConnectionOpened()
+	defer t.metrics.ConnectionClosed()
+	t.traffic.connectionOpened()
+
+	toClient := func(n int) {
+		t.metrics.ForwardedToClient(n)
+		t.traffic.addOut(n)
+	}
+	toService := func(n int) {
+		t.metrics.ForwardedToService(n)
+		t.traffic.addIn(n)
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(&countingWriter{w: clientConn, count: toClient}, serviceConn)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(&countingWriter{w: serviceConn, count: toService}, clientConn)
+		errc <- err
+	}()
+
+	err := <-errc
+	if err != nil && err != io.EOF {
+		t.logger.Debug("copy error", zap.Error(err))
 	}
 }
 
@@ -311,22 +330,27 @@ func (t *SSHTunnel) Stop(m *Manager) error {
 		t.stopMu.Unlock()
 		return nil
 	}
+	t.isStopped = true
+	t.stopMu.Unlock()
 
-	defer func() {
-		t.isStopped = true
-		t.stopMu.Unlock()
-	}()
+	t.closeListener(nil)
+	t.metrics.Delete()
 
-	close(t.done)
+	t.recordMu.Lock()
+	t.record.Status = "stopped"
+	t.recordEvent(m, "stopped")
+	m.publishStatus(*t.record)
+	t.recordMu.Unlock()
 
-	t.clientMu.Lock()
-	if t.client != nil {
-		_ = t.client.Close()
-		t.client = nil
+	err := t.flushTraffic(m.db)
+	if err != nil {
+		m.logger.Error("failed to flush tunnel traffic",
+			zap.Uint("host_id", *t.HostID),
+			zap.Uint("service_port_id", *t.SPID),
+			zap.Error(err))
 	}
-	t.clientMu.Unlock()
 
-	err := m.db.Where("host_id = ? and sp_id = ?", t.HostID, t.SPID).
+	err = m.db.Where("host_id = ? and sp_id = ?", t.HostID, t.SPID).
 		Delete(&models.Tunnel{}).Error
 	if err != nil {
 		return fmt.Errorf("failed to delete tunnel: %w", err)
@@ -0,0 +1,60 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestForwardingDirections(t *testing.T) {
+	tests := []struct {
+		name      string
+		direction string
+		wantLocal string
+	}{
+		{name: "remote listener on the host", direction: DirectionRemote, wantLocal: "0.0.0.0"},
+		{name: "local listener on the manager", direction: DirectionLocal, wantLocal: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestSSHServer(t, "secret")
+			m, db := newTestManager(t)
+
+			host := createTestHost(t, db, server, models.Host{Password: "secret"})
+			serviceIP, port, _ := net.SplitHostPort(newEchoServer(t))
+			servicePort, _ := strconv.Atoi(port)
+			sp := &models.ServicePort{
+				ServiceIP:   &serviceIP,
+				ServicePort: servicePort,
+				LocalPort:   freePort(t),
+				Direction:   tt.direction,
+			}
+			err := db.Create(sp).Error
+			if err != nil {
+				t.Fatalf("failed to create service port: %v", err)
+			}
+			// The listener address follows the direction when the service port gives none.
+			sp.BindAddress = ""
+
+			err = m.StartTunnel(host, sp)
+			if err != nil {
+				t.Fatalf("StartTunnel() error = %v", err)
+			}
+			record := waitForStatus(t, db, host.ID, sp.ID, "connected")
+
+			wantLocal := net.JoinHostPort(tt.wantLocal, strconv.Itoa(sp.LocalPort))
+			if record.Local != wantLocal || record.Direction != tt.direction {
+				t.Errorf("tunnel is %s on %s, want %s on %s", record.Direction, record.Local, tt.direction, wantLocal)
+			}
+
+			addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(sp.LocalPort))
+			reply, err := echo(addr, "ping")
+			if err != nil || reply != "ping" {
+				t.Fatalf("echo through %s = %q, %v", addr, reply, err)
+			}
+		})
+	}
+}
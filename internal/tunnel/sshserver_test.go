@@ -0,0 +1,366 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server that accepts a password and a set of public keys,
+// forwards direct-tcpip channels like sshd does for ssh -L, -D and ProxyJump, and opens
+// listeners for tcpip-forward requests like it does for ssh -R.
+type testSSHServer struct {
+	t           *testing.T
+	addr        string
+	hostKey     ssh.Signer
+	password    string
+	authorized  [][]byte
+	denyForward bool
+	listener    net.Listener
+	mu          sync.Mutex
+	handshakes  int
+	conns       []*ssh.ServerConn
+}
+
+// newTestSSHServer starts a server on a loopback port that accepts password and the given keys.
+func newTestSSHServer(t *testing.T, password string, keys ...ssh.PublicKey) *testSSHServer {
+	t.Helper()
+
+	return newTestSSHServerOn(t, "127.0.0.1", password, keys...)
+}
+
+// newTestSSHServerOn starts a server on a port of the loopback address ip, so that several
+// servers can be recorded as Hosts, whose IPs are unique.
+func newTestSSHServerOn(t *testing.T, ip string, password string, keys ...ssh.PublicKey) *testSSHServer {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("failed to create host key signer: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testSSHServer{
+		t:        t,
+		addr:     listener.Addr().String(),
+		hostKey:  signer,
+		password: password,
+		listener: listener,
+	}
+	for _, key := range keys {
+		s.authorized = append(s.authorized, key.Marshal())
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if s.password != "" && string(password) == s.password {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, authorized := range s.authorized {
+				if bytes.Equal(authorized, key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unknown public key")
+		},
+	}
+	config.AddHostKey(signer)
+
+	go s.serve(config)
+	t.Cleanup(s.close)
+
+	return s
+}
+
+// host returns the IP and port of the server.
+func (s *testSSHServer) host() (string, int) {
+	host, port, _ := net.SplitHostPort(s.addr)
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+// handshakeCount returns the number of SSH connections the server accepted.
+func (s *testSSHServer) handshakeCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.handshakes
+}
+
+// dropConnections closes every accepted SSH connection, as a restarted sshd would.
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *testSSHServer) close() {
+	_ = s.listener.Close()
+	s.dropConnections()
+}
+
+func (s *testSSHServer) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+
+			s.mu.Lock()
+			s.handshakes++
+			s.conns = append(s.conns, sshConn)
+			s.mu.Unlock()
+
+			go s.handleRequests(sshConn, reqs)
+			for ch := range chans {
+				go s.handleChannel(ch)
+			}
+		}()
+	}
+}
+
+// handleRequests answers tcpip-forward requests and rejects every other global request,
+// such as keepalives, which still tells the client that the connection is alive.
+func (s *testSSHServer) handleRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}()
+
+	for req := range reqs {
+		if req.Type != "tcpip-forward" || s.denyForward {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		var forward struct {
+			Addr string
+			Port uint32
+		}
+		err := ssh.Unmarshal(req.Payload, &forward)
+		if err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(forward.Addr, strconv.Itoa(int(forward.Port))))
+		if err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		listeners = append(listeners, listener)
+
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+		go func() {
+			for {
+				local, err := listener.Accept()
+				if err != nil {
+					return
+				}
+
+				origin := local.RemoteAddr().(*net.TCPAddr)
+				payload := ssh.Marshal(struct {
+					Addr       string
+					Port       uint32
+					OriginAddr string
+					OriginPort uint32
+				}{forward.Addr, port, origin.IP.String(), uint32(origin.Port)})
+
+				ch, chReqs, err := conn.OpenChannel("forwarded-tcpip", payload)
+				if err != nil {
+					_ = local.Close()
+					continue
+				}
+				go ssh.DiscardRequests(chReqs)
+				go pipeTestConns(ch, local)
+			}
+		}()
+	}
+}
+
+// handleChannel connects a direct-tcpip channel to its destination.
+func (s *testSSHServer) handleChannel(newChannel ssh.NewChannel) {
+	if newChannel.ChannelType() != "direct-tcpip" {
+		_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		return
+	}
+
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginAddr string
+		OriginPort uint32
+	}
+	err := ssh.Unmarshal(newChannel.ExtraData(), &target)
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		_ = remote.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	pipeTestConns(ch, remote)
+}
+
+func pipeTestConns(a io.ReadWriteCloser, b io.ReadWriteCloser) {
+	defer func() {
+		_ = a.Close()
+		_ = b.Close()
+	}()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// newTestKey returns a new ed25519 key as an OpenSSH PEM private key, encrypted when
+// passphrase is set, and its public key.
+func newTestKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(private, "")
+	}
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %v", err)
+	}
+
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("failed to convert public key: %v", err)
+	}
+
+	return string(pem.EncodeToMemory(block)), sshPublic
+}
+
+// newEchoServer starts a TCP server on a loopback port that writes back what it reads.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// freePort returns a loopback TCP port that is not in use right now.
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// echo writes message to addr and returns what comes back.
+func echo(addr, message string) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = conn.Write([]byte(message))
+	if err != nil {
+		return "", err
+	}
+
+	reply := make([]byte, len(message))
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return "", fmt.Errorf("failed to read reply: %w", err)
+	}
+
+	return string(reply), nil
+}
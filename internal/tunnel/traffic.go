@@ -0,0 +1,235 @@
+package tunnel
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// trafficCounter counts the traffic of a tunnel until it is flushed to the database.
+type trafficCounter struct {
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+	connections  atomic.Int64
+	lastActivity atomic.Int64
+	flushMu      sync.Mutex
+}
+
+type trafficDelta struct {
+	bytesIn      int64
+	bytesOut     int64
+	connections  int64
+	lastActivity int64
+}
+
+func (c *trafficCounter) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *trafficCounter) connectionOpened() {
+	c.connections.Add(1)
+	c.touch()
+}
+
+func (c *trafficCounter) addIn(n int) {
+	c.bytesIn.Add(int64(n))
+	c.touch()
+}
+
+func (c *trafficCounter) addOut(n int) {
+	c.bytesOut.Add(int64(n))
+	c.touch()
+}
+
+// pending returns the traffic counted since the last flush.
+func (c *trafficCounter) pending() trafficDelta {
+	return trafficDelta{
+		bytesIn:      c.bytesIn.Load(),
+		bytesOut:     c.bytesOut.Load(),
+		connections:  c.connections.Load(),
+		lastActivity: c.lastActivity.Load(),
+	}
+}
+
+// take returns the traffic counted since the last flush and resets the counters.
+func (c *trafficCounter) take() trafficDelta {
+	return trafficDelta{
+		bytesIn:      c.bytesIn.Swap(0),
+		bytesOut:     c.bytesOut.Swap(0),
+		connections:  c.connections.Swap(0),
+		lastActivity: c.lastActivity.Swap(0),
+	}
+}
+
+// restore adds back a delta that could not be flushed.
+func (c *trafficCounter) restore(d trafficDelta) {
+	c.bytesIn.Add(d.bytesIn)
+	c.bytesOut.Add(d.bytesOut)
+	c.connections.Add(d.connections)
+	c.lastActivity.CompareAndSwap(0, d.lastActivity)
+}
+
+func (d trafficDelta) empty() bool {
+	return d.bytesIn == 0 && d.bytesOut == 0 && d.connections == 0 && d.lastActivity == 0
+}
+
+// apply adds the delta to the traffic record.
+func (d trafficDelta) apply(traffic *models.TunnelTraffic) {
+	traffic.BytesIn += d.bytesIn
+	traffic.BytesOut += d.bytesOut
+	traffic.Connections += d.connections
+	if d.lastActivity != 0 {
+		lastActivity := time.Unix(0, d.lastActivity).UTC()
+		if traffic.LastActivityAt == nil || lastActivity.After(*traffic.LastActivityAt) {
+			traffic.LastActivityAt = &lastActivity
+		}
+	}
+}
+
+// flushTraffic adds the traffic counted since the last flush to the tunnel's traffic record.
+func (t *SSHTunnel) flushTraffic(db *gorm.DB) error {
+	t.traffic.flushMu.Lock()
+	defer t.traffic.flushMu.Unlock()
+
+	delta := t.traffic.take()
+	if delta.empty() {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		traffic := models.TunnelTraffic{HostID: *t.HostID, SPID: *t.SPID}
+		err := tx.Where("host_id = ? AND sp_id = ?", *t.HostID, *t.SPID).FirstOrCreate(&traffic).Error
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"bytes_in":    gorm.Expr("bytes_in + ?", delta.bytesIn),
+			"bytes_out":   gorm.Expr("bytes_out + ?", delta.bytesOut),
+			"connections": gorm.Expr("connections + ?", delta.connections),
+		}
+		previous := traffic.LastActivityAt
+		delta.apply(&traffic)
+		if traffic.LastActivityAt != previous {
+			updates["last_activity_at"] = traffic.LastActivityAt
+		}
+
+		return tx.Model(&traffic).Where("host_id = ? AND sp_id = ?", *t.HostID, *t.SPID).Updates(updates).Error
+	})
+	if err != nil {
+		t.traffic.restore(delta)
+		return fmt.Errorf("failed to save tunnel traffic: %w", err)
+	}
+
+	return nil
+}
+
+// FlushTraffic saves the traffic counted by every running tunnel.
+func (m *Manager) FlushTraffic() {
+	m.mu.RLock()
+	tunnels := make([]*SSHTunnel, 0, len(m.tunnels))
+	for _, t := range m.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	m.mu.RUnlock()
+
+	for _, t := range tunnels {
+		err := t.flushTraffic(m.db)
+		if err != nil {
+			m.logger.Error("failed to flush tunnel traffic",
+				zap.Uint("host_id", *t.HostID),
+				zap.Uint("service_port_id", *t.SPID),
+				zap.Error(err))
+		}
+	}
+}
+
+// RunTrafficFlush flushes the traffic of every running tunnel every interval.
+// Traffic of a stopped tunnel is flushed when it stops.
+func (m *Manager) RunTrafficFlush(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.FlushTraffic()
+	}
+}
+
+// attachTraffic sets the traffic of each tunnel, including traffic not flushed yet.
+// It must be called with m.mu held.
+func (m *Manager) attachTraffic(tunnels []models.Tunnel) error {
+	if len(tunnels) == 0 {
+		return nil
+	}
+
+	var records []models.TunnelTraffic
+	err := m.db.Find(&records).Error
+	if err != nil {
+		return fmt.Errorf("failed to fetch tunnel traffic: %w", err)
+	}
+
+	byKey := make(map[string]models.TunnelTraffic, len(records))
+	for _, record := range records {
+		byKey[fmt.Sprintf("%d-%d", record.HostID, record.SPID)] = record
+	}
+
+	for i := range tunnels {
+		key := fmt.Sprintf("%d-%d", tunnels[i].HostID, tunnels[i].SPID)
+		traffic, exists := byKey[key]
+		if !exists {
+			traffic = models.TunnelTraffic{HostID: tunnels[i].HostID, SPID: tunnels[i].SPID}
+		}
+
+		t, running := m.tunnels[key]
+		if running {
+			t.traffic.pending().apply(&traffic)
+		}
+		tunnels[i].Traffic = &traffic
+	}
+
+	return nil
+}
+
+// ListTraffic returns the traffic records of every tunnel, including stopped tunnels
+// and traffic not flushed yet.
+func (m *Manager) ListTraffic() ([]models.TunnelTraffic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var records []models.TunnelTraffic
+	err := m.db.Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tunnel traffic: %w", err)
+	}
+
+	seen := make(map[string]bool, len(records))
+	for i := range records {
+		key := fmt.Sprintf("%d-%d", records[i].HostID, records[i].SPID)
+		seen[key] = true
+		if t, running := m.tunnels[key]; running {
+			t.traffic.pending().apply(&records[i])
+		}
+	}
+
+	for key, t := range m.tunnels {
+		if seen[key] {
+			continue
+		}
+
+		delta := t.traffic.pending()
+		if delta.empty() {
+			continue
+		}
+
+		record := models.TunnelTraffic{HostID: *t.HostID, SPID: *t.SPID}
+		delta.apply(&record)
+		records = append(records, record)
+	}
+
+	return records, nil
+}
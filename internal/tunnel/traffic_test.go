@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/jollaman999/tunnel-manager/internal/models"
+)
+
+func TestTunnelTraffic(t *testing.T) {
+	server := newTestSSHServer(t, "secret")
+	m, db := newTestManager(t)
+
+	host := createTestHost(t, db, server, models.Host{Password: "secret"})
+	serviceIP, port, _ := net.SplitHostPort(newEchoServer(t))
+	servicePort, _ := strconv.Atoi(port)
+	sp := createTestServicePort(t, db, models.ServicePort{
+		ServiceIP:   &serviceIP,
+		ServicePort: servicePort,
+		LocalPort:   freePort(t),
+		Direction:   DirectionLocal,
+	}, host.ID)
+
+	err := m.StartTunnel(host, sp)
+	if err != nil {
+		t.Fatalf("StartTunnel() error = %v", err)
+	}
+	waitForStatus(t, db, host.ID, sp.ID, "connected")
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(sp.LocalPort))
+	send := func(msg string) {
+		t.Helper()
+		reply, err := echo(addr, msg)
+		if err != nil || reply != msg {
+			t.Fatalf("echo through %s = %q, %v", addr, reply, err)
+		}
+	}
+
+	// statusTraffic returns the traffic reported with the tunnel's status.
+	statusTraffic := func() models.TunnelTraffic {
+		t.Helper()
+		tunnels, err := m.GetHostTunnels(host.ID)
+		if err != nil || len(*tunnels) != 1 || (*tunnels)[0].Traffic == nil {
+			t.Fatalf("GetHostTunnels() = %v, %v, want one tunnel with traffic", tunnels, err)
+		}
+		return *(*tunnels)[0].Traffic
+	}
+	storedTraffic := func() models.TunnelTraffic {
+		t.Helper()
+		var traffic models.TunnelTraffic
+		db.Where("host_id = ? AND sp_id = ?", host.ID, sp.ID).Limit(1).Find(&traffic)
+		return traffic
+	}
+	wantTraffic := func(what string, traffic models.TunnelTraffic, bytes, connections int64) {
+		t.Helper()
+		if traffic.BytesIn != bytes || traffic.BytesOut != bytes || traffic.Connections != connections {
+			t.Errorf("%s traffic = %d in, %d out, %d connections, want %d, %d, %d", what,
+				traffic.BytesIn, traffic.BytesOut, traffic.Connections, bytes, bytes, connections)
+		}
+		if connections > 0 && traffic.LastActivityAt == nil {
+			t.Errorf("%s traffic has no last activity", what)
+		}
+	}
+
+	send("ping")
+	send("hello")
+	waitFor(t, "the traffic to be counted", func() bool { return statusTraffic().BytesOut == 9 })
+
+	// Traffic not flushed yet is reported, but not stored.
+	wantTraffic("reported", statusTraffic(), 9, 2)
+	wantTraffic("stored", storedTraffic(), 0, 0)
+
+	m.FlushTraffic()
+	wantTraffic("stored", storedTraffic(), 9, 2)
+	wantTraffic("reported after flush", statusTraffic(), 9, 2)
+
+	// Flushing again adds nothing, and the traffic of a stopped tunnel is flushed when it stops.
+	m.FlushTraffic()
+	send("bye")
+	waitFor(t, "the traffic to be counted", func() bool { return statusTraffic().BytesOut == 12 })
+	err = m.StopTunnel(host.ID, sp.ID)
+	if err != nil {
+		t.Fatalf("StopTunnel() error = %v", err)
+	}
+	wantTraffic("stored after stop", storedTraffic(), 12, 3)
+
+	records, err := m.ListTraffic()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListTraffic() = %v, %v, want one record", records, err)
+	}
+	wantTraffic("listed", records[0], 12, 3)
+}
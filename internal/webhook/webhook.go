@@ -0,0 +1,345 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jollaman999/tunnel-manager/internal/metrics"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Events sent to webhooks.
+const (
+	// EventError is sent when a connected tunnel enters the error or bind_failed status.
+	EventError = "tunnel.error"
+	// EventReconnecting is sent when a tunnel has not been connected for longer than the reconnecting threshold.
+	EventReconnecting = "tunnel.reconnecting"
+	// EventFailed is sent when a tunnel stops reconnecting: its host's reconnect attempts are used up (failed),
+	// or authentication (auth_failed) or host key verification (host_key_failed) failed.
+	EventFailed = "tunnel.failed"
+	// EventRecovered is sent when a tunnel reported with EventError, EventReconnecting or EventFailed
+	// is connected again.
+	EventRecovered = "tunnel.recovered"
+)
+
+// Headers of a webhook request.
+const (
+	HeaderEvent     = "X-Tunnel-Manager-Event"
+	HeaderID        = "X-Tunnel-Manager-Delivery"
+	HeaderTimestamp = "X-Tunnel-Manager-Timestamp"
+	HeaderSignature = "X-Tunnel-Manager-Signature"
+)
+
+const requestTimeout = 10 * time.Second
+
+// queueSize is the number of tunnel status changes that can wait for the dispatcher.
+// Every tunnel of a host changes status when the host connection is lost, so it is sized
+// for a burst of all tunnels of many hosts rather than for a steady rate.
+const queueSize = 4096
+
+// Payload is the JSON body of a webhook request.
+type Payload struct {
+	ID        string        `json:"id"`
+	Event     string        `json:"event"`
+	Timestamp time.Time     `json:"timestamp"`
+	Tunnel    models.Tunnel `json:"tunnel"`
+}
+
+// Source provides the tunnel status changes webhooks are sent for.
+type Source interface {
+	ObserveStatus(fn func(models.Tunnel))
+}
+
+// tunnelState tracks the current outage of a tunnel.
+type tunnelState struct {
+	tunnel         models.Tunnel
+	unhealthySince time.Time
+	errorSent      bool
+	reconnectSent  bool
+	failedSent     bool
+}
+
+// Dispatcher sends webhooks for tunnel status changes and records every delivery attempt.
+type Dispatcher struct {
+	db                    *gorm.DB
+	logger                *zap.Logger
+	client                *http.Client
+	reconnectingThreshold time.Duration
+	retention             time.Duration
+	states                map[string]*tunnelState
+	queue                 chan models.Tunnel
+	dropped               atomic.Int64
+}
+
+// NewDispatcher returns a Dispatcher sending EventReconnecting once a tunnel has not been connected
+// for reconnectingThreshold, and deleting delivery records older than retention.
+func NewDispatcher(db *gorm.DB, logger *zap.Logger, reconnectingThreshold, retention time.Duration) *Dispatcher {
+	return &Dispatcher{
+		db:                    db,
+		logger:                logger,
+		client:                &http.Client{Timeout: requestTimeout},
+		reconnectingThreshold: reconnectingThreshold,
+		retention:             retention,
+		states:                make(map[string]*tunnelState),
+		queue:                 make(chan models.Tunnel, queueSize),
+	}
+}
+
+// Notify queues a tunnel status change without blocking. A change that does not fit in the queue
+// is dropped, logged and counted, since blocking would hold up the tunnel making the change.
+func (d *Dispatcher) Notify(t models.Tunnel) {
+	select {
+	case d.queue <- t:
+	default:
+		dropped := d.dropped.Add(1)
+		metrics.WebhookStatusChangeDropped()
+		d.logger.Warn("webhook queue is full, dropping tunnel status change",
+			zap.Uint("host_id", t.HostID),
+			zap.Uint("service_port_id", t.SPID),
+			zap.String("status", t.Status),
+			zap.Int64("dropped", dropped))
+	}
+}
+
+// Run sends webhooks for the status changes of source until the process exits.
+func (d *Dispatcher) Run(source Source) {
+	source.ObserveStatus(d.Notify)
+
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	d.pruneDeliveries()
+
+	for {
+		select {
+		case t := <-d.queue:
+			d.observe(t)
+		case <-check.C:
+			d.checkReconnecting()
+		case <-prune.C:
+			d.pruneDeliveries()
+		}
+	}
+}
+
+func (d *Dispatcher) observe(t models.Tunnel) {
+	key := fmt.Sprintf("%d-%d", t.HostID, t.SPID)
+	if t.Status == "stopped" || t.Status == "closed" {
+		delete(d.states, key)
+		return
+	}
+
+	state, exists := d.states[key]
+	if !exists {
+		state = &tunnelState{}
+		d.states[key] = state
+	}
+	state.tunnel = t
+
+	switch t.Status {
+	case "connected":
+		if state.errorSent || state.reconnectSent || state.failedSent {
+			d.dispatch(EventRecovered, t)
+		}
+		*state = tunnelState{tunnel: t}
+	case "error", "bind_failed":
+		if state.unhealthySince.IsZero() {
+			state.unhealthySince = time.Now()
+		}
+		if !state.errorSent {
+			state.errorSent = true
+			d.dispatch(EventError, t)
+		}
+	case "reconnecting":
+		if state.unhealthySince.IsZero() {
+			state.unhealthySince = time.Now()
+		}
+	case "failed", "auth_failed", "host_key_failed":
+		if !state.failedSent {
+			state.failedSent = true
+			d.dispatch(EventFailed, t)
+		}
+	}
+}
+
+func (d *Dispatcher) checkReconnecting() {
+	for _, state := range d.states {
+		if state.unhealthySince.IsZero() || state.reconnectSent || state.failedSent {
+			continue
+		}
+		if time.Since(state.unhealthySince) < d.reconnectingThreshold {
+			continue
+		}
+
+		state.reconnectSent = true
+		d.dispatch(EventReconnecting, state.tunnel)
+	}
+}
+
+// dispatch sends event to every enabled webhook subscribed to it.
+func (d *Dispatcher) dispatch(event string, t models.Tunnel) {
+	var webhooks []models.Webhook
+	err := d.db.Where("enabled = ?", true).Find(&webhooks).Error
+	if err != nil {
+		d.logger.Error("failed to fetch webhooks", zap.Error(err))
+		return
+	}
+
+	id, err := newEventID()
+	if err != nil {
+		d.logger.Error("failed to create webhook event ID", zap.Error(err))
+		return
+	}
+
+	t.Traffic = nil
+	payload := Payload{
+		ID:        id,
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Tunnel:    t,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.logger.Error("failed to encode webhook payload", zap.Error(err))
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !Subscribed(&webhook, event) {
+			continue
+		}
+		go d.deliver(webhook, payload, body)
+	}
+}
+
+// Subscribed reports whether webhook receives event. A webhook without events receives every event.
+func Subscribed(webhook *models.Webhook, event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deliver sends a webhook request, retrying with a doubling interval until it succeeds
+// or the webhook's retries are used up. Every attempt is recorded.
+func (d *Dispatcher) deliver(webhook models.Webhook, payload Payload, body []byte) {
+	interval := time.Duration(webhook.RetryIntervalSec) * time.Second
+	for attempt := 1; attempt <= webhook.MaxRetries+1; attempt++ {
+		if attempt > 1 {
+			time.Sleep(interval)
+			interval *= 2
+		}
+
+		delivery := d.send(&webhook, payload, body)
+		delivery.Attempt = attempt
+
+		err := d.db.Create(&delivery).Error
+		if err != nil {
+			d.logger.Error("failed to record webhook delivery", zap.Uint("webhook_id", webhook.ID), zap.Error(err))
+		}
+
+		if delivery.Success {
+			return
+		}
+		d.logger.Warn("webhook delivery failed",
+			zap.Uint("webhook_id", webhook.ID),
+			zap.String("event", payload.Event),
+			zap.Int("attempt", attempt),
+			zap.Int("status_code", delivery.StatusCode),
+			zap.String("error", delivery.Error))
+	}
+}
+
+func (d *Dispatcher) send(webhook *models.Webhook, payload Payload, body []byte) models.WebhookDelivery {
+	delivery := models.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   payload.ID,
+		Event:     payload.Event,
+		HostID:    payload.Tunnel.HostID,
+		SPID:      payload.Tunnel.SPID,
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tunnel-manager")
+	req.Header.Set(HeaderEvent, payload.Event)
+	req.Header.Set(HeaderID, payload.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if webhook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+	}
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	_ = resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = "unexpected status: " + resp.Status
+	}
+
+	return delivery
+}
+
+// Sign returns the signature header value of a webhook request: the hex HMAC-SHA256
+// of the timestamp, a dot and the body, keyed with the webhook's secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (d *Dispatcher) pruneDeliveries() {
+	result := d.db.Where("created_at < ?", time.Now().Add(-d.retention)).Delete(&models.WebhookDelivery{})
+	if result.Error != nil {
+		d.logger.Error("failed to prune webhook deliveries", zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		d.logger.Info("pruned webhook deliveries", zap.Int64("count", result.RowsAffected))
+	}
+}
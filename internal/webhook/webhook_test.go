@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/jollaman999/tunnel-manager/internal/database"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testSource passes status changes to the observer registered by Dispatcher.Run.
+type testSource struct {
+	mu      sync.Mutex
+	observe func(models.Tunnel)
+}
+
+func (s *testSource) ObserveStatus(fn func(models.Tunnel)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observe = fn
+}
+
+func (s *testSource) publish(t *testing.T, tunnels ...models.Tunnel) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		observe := s.observe
+		s.mu.Unlock()
+		if observe != nil {
+			for _, tunnel := range tunnels {
+				observe(tunnel)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dispatcher did not observe the source")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// receiver records the webhook requests it receives.
+type receiver struct {
+	mu       sync.Mutex
+	payloads []Payload
+	invalid  int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var payload Payload
+	err := json.Unmarshal(body, &payload)
+	if err != nil || req.Header.Get(HeaderEvent) != payload.Event ||
+		req.Header.Get(HeaderSignature) != Sign("webhook-secret", req.Header.Get(HeaderTimestamp), body) {
+		r.invalid++
+	}
+	r.payloads = append(r.payloads, payload)
+}
+
+func (r *receiver) events(hostID uint) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []string
+	for _, payload := range r.payloads {
+		if payload.Tunnel.HostID == hostID {
+			events = append(events, payload.Event)
+		}
+	}
+
+	return events
+}
+
+// count returns the number of event webhooks received for hosts from firstHostID.
+func (r *receiver) count(event string, firstHostID uint) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, payload := range r.payloads {
+		if payload.Event == event && payload.Tunnel.HostID >= firstHostID {
+			n++
+		}
+	}
+
+	return n
+}
+
+func newTestDispatcher(t *testing.T, url string) (*Dispatcher, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = database.MigrateUp(db)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	err = db.Create(&models.Webhook{Name: "test", URL: url, Secret: "webhook-secret", RetryIntervalSec: 1}).Error
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	err = db.Model(&models.Webhook{}).Where("1 = 1").UpdateColumn("max_retries", 0).Error
+	if err != nil {
+		t.Fatalf("failed to update webhook: %v", err)
+	}
+
+	return NewDispatcher(db, zap.NewNop(), 500*time.Millisecond, time.Hour), db
+}
+
+func waitForEvents(t *testing.T, r *receiver, hostID uint, want []string) {
+	t.Helper()
+
+	want = slices.Sorted(slices.Values(want))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// Deliveries are sent concurrently, so they may arrive in any order.
+		got := r.events(hostID)
+		slices.Sort(got)
+		if len(got) >= len(want) {
+			if !slices.Equal(got, want) {
+				t.Fatalf("host %d events = %v, want %v", hostID, got, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("host %d events = %v, want %v", hostID, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	d, db := newTestDispatcher(t, server.URL)
+	source := &testSource{}
+	go d.Run(source)
+
+	tunnel := func(hostID uint, status string) models.Tunnel {
+		return models.Tunnel{HostID: hostID, SPID: 1, Status: status}
+	}
+
+	tests := []struct {
+		name     string
+		hostID   uint
+		statuses []string
+		want     []string
+	}{
+		{name: "error and recovery", hostID: 1, statuses: []string{"starting", "connected", "error", "error", "connected"},
+			want: []string{EventError, EventRecovered}},
+		{name: "reconnecting past the threshold", hostID: 2, statuses: []string{"connected", "reconnecting"},
+			want: []string{EventReconnecting}},
+		{name: "gave up", hostID: 3, statuses: []string{"connected", "reconnecting", "failed"},
+			want: []string{EventFailed}},
+		{name: "authentication failed", hostID: 4, statuses: []string{"starting", "auth_failed", "connected"},
+			want: []string{EventFailed, EventRecovered}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, status := range tt.statuses {
+				source.publish(t, tunnel(tt.hostID, status))
+			}
+			waitForEvents(t, r, tt.hostID, tt.want)
+		})
+	}
+
+	// A burst of status changes larger than a status subscription can hold is not lost.
+	var burst []models.Tunnel
+	for hostID := uint(100); hostID < 400; hostID++ {
+		burst = append(burst, tunnel(hostID, "connected"), tunnel(hostID, "error"))
+	}
+	source.publish(t, burst...)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for r.count(EventError, 100) < 300 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := r.count(EventError, 100); n != 300 {
+		t.Errorf("received %d webhooks for a burst of 300 errors, want 300", n)
+	}
+	if d.dropped.Load() != 0 {
+		t.Errorf("dispatcher dropped %d status changes, want 0", d.dropped.Load())
+	}
+
+	r.mu.Lock()
+	invalid := r.invalid
+	r.mu.Unlock()
+	if invalid != 0 {
+		t.Errorf("%d webhook requests had invalid headers or signatures", invalid)
+	}
+
+	var delivery models.WebhookDelivery
+	err := db.Where("host_id = ? AND event = ?", 1, EventError).First(&delivery).Error
+	if err != nil || !delivery.Success || delivery.Attempt != 1 {
+		t.Errorf("delivery of %s for host 1 = %+v, %v, want a successful first attempt", EventError, delivery, err)
+	}
+}
+
+func TestNotifyQueueFull(t *testing.T) {
+	d := NewDispatcher(nil, zap.NewNop(), time.Minute, time.Hour)
+
+	for i := 0; i < queueSize+3; i++ {
+		d.Notify(models.Tunnel{HostID: uint(i), SPID: 1, Status: "error"})
+	}
+
+	if n := d.dropped.Load(); n != 3 {
+		t.Errorf("dropped %d status changes, want 3", n)
+	}
+	if n := len(d.queue); n != queueSize {
+		t.Errorf("queued %d status changes, want %d", n, queueSize)
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		event  string
+		want   bool
+	}{
+		{name: "every event", event: EventError, want: true},
+		{name: "subscribed event", events: []string{EventFailed, EventError}, event: EventError, want: true},
+		{name: "other event", events: []string{EventFailed}, event: EventRecovered, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Subscribed(&models.Webhook{Events: tt.events}, tt.event)
+			if got != tt.want {
+				t.Errorf("Subscribed(%v, %s) = %v, want %v", tt.events, tt.event, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"gorm.io/gorm"
@@ -12,15 +13,22 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
+	// Embeds the time zone database for the time zones of schedules on systems without one.
+	_ "time/tzdata"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/jollaman999/tunnel-manager/internal/api"
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/client"
 	"github.com/jollaman999/tunnel-manager/internal/config"
 	"github.com/jollaman999/tunnel-manager/internal/database"
+	"github.com/jollaman999/tunnel-manager/internal/inventory"
+	"github.com/jollaman999/tunnel-manager/internal/metrics"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/secret"
 	"github.com/jollaman999/tunnel-manager/internal/tunnel"
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"github.com/jollaman999/tunnel-manager/internal/webhook"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -37,9 +45,14 @@ func initDatabase(cfg *config.Config, logger *zap.Logger) (*gorm.DB, error) {
 		case <-timeout:
 			return nil, fmt.Errorf("timeout waiting for database connection after %s seconds", strconv.Itoa(cfg.Database.TimeoutSec))
 		case <-tick:
-			db, err := database.NewDatabase(cfg.Database.Host, cfg.Database.Port, cfg.Database.User, cfg.Database.Password, cfg.Database.Name)
+			db, err := database.NewDatabase(&cfg.Database)
 			if err != nil {
-				logger.Info("attempting to connect to database...", zap.String("host", cfg.Database.Host), zap.Int("port", cfg.Database.Port))
+				if cfg.Database.Driver == database.DriverSQLite {
+					logger.Info("attempting to open database...", zap.String("path", cfg.Database.Path), zap.Error(err))
+				} else {
+					logger.Info("attempting to connect to database...", zap.String("driver", cfg.Database.Driver),
+						zap.String("host", cfg.Database.Host), zap.Int("port", cfg.Database.Port))
+				}
 				continue
 			}
 			logger.Info("successfully connected to database")
@@ -101,6 +114,236 @@ func initLogger(cfg *config.Config) (*zap.Logger, error) {
 	return zap.New(core, zap.AddCaller()), nil
 }
 
+func previousKeys(cfg *config.Config) ([][]byte, error) {
+	var keys [][]byte
+	for _, keyFile := range cfg.Secrets.PreviousKeyFiles {
+		key, err := secret.LoadKey("", keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load previous key %s: %w", keyFile, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func loadKeyring(cfg *config.Config) (*secret.Keyring, error) {
+	key, err := secret.LoadKey(cfg.Secrets.Key, cfg.Secrets.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	previous, err := previousKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret.NewKeyring(key, previous...)
+}
+
+// rotateKey re-encrypts every stored secret with the key in newKeyFile.
+// Secrets encrypted with the configured keys are decrypted with them first.
+func rotateKey(db *gorm.DB, cfg *config.Config, newKeyFile string) error {
+	newKey, err := secret.LoadKey("", newKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load new key: %w", err)
+	}
+
+	oldKey, err := secret.LoadKey(cfg.Secrets.Key, cfg.Secrets.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load current key: %w", err)
+	}
+
+	previous, err := previousKeys(cfg)
+	if err != nil {
+		return err
+	}
+	if oldKey != nil {
+		previous = append(previous, oldKey)
+	}
+
+	keyring, err := secret.NewKeyring(newKey, previous...)
+	if err != nil {
+		return err
+	}
+
+	updated, err := database.MigrateSecrets(db, keyring)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Re-encrypted %d secrets with key %s.\n", updated, keyring.KeyID())
+	fmt.Printf("Set secrets.key_file to %s in the config file before starting tunnel-manager.\n", newKeyFile)
+
+	return nil
+}
+
+// bootstrapToken creates an API token and prints it, so that the first token
+// can be created before any token exists to call the API with.
+func bootstrapToken(db *gorm.DB, description, role string, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("invalid token TTL: %s", ttl)
+	}
+
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+
+	apiToken, err := auth.CreateToken(db, description, role, nil, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s API token %d (%s).\n", apiToken.Role, apiToken.ID, apiToken.Description)
+	if expiresAt != nil {
+		fmt.Printf("It expires at %s.\n", expiresAt.Format(time.RFC3339))
+	}
+	fmt.Printf("Store it now, it cannot be shown again:\n%s\n", apiToken.Token)
+
+	return nil
+}
+
+// applyInventory sends the inventory file to the API of a running tunnel-manager and prints the changes.
+// With dryRun the changes are only planned.
+func applyInventory(server, file string, prune, dryRun bool) error {
+	token := os.Getenv(client.TokenEnv)
+	if token == "" {
+		return fmt.Errorf("set an API token with the admin role in %s", client.TokenEnv)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read inventory: %w", err)
+	}
+
+	_, err = inventory.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	c := client.NewClient(server, token)
+	var plan *models.InventoryPlan
+	if dryRun {
+		plan, err = c.PlanInventory(data, prune)
+	} else {
+		plan, err = c.ApplyInventory(data, prune)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = inventory.WritePlan(os.Stdout, plan)
+	if err != nil {
+		return err
+	}
+	if plan.Failed > 0 {
+		return fmt.Errorf("%d of %d changes failed", plan.Failed, len(plan.Changes))
+	}
+
+	return nil
+}
+
+// printMigrationStatus prints every migration and whether it is applied.
+func printMigrationStatus(db *gorm.DB) error {
+	statuses, err := database.MigrationStatuses(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED AT\tDESCRIPTION")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		description := status.Description
+		if !status.Known {
+			description += " (unknown to this binary)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, appliedAt, description)
+	}
+
+	return w.Flush()
+}
+
+// runMigration runs a -migrate command: status, up, down or to <version>.
+// Reverting the initial schema drops every table and is only done with dropAll.
+func runMigration(db *gorm.DB, command string, args []string, dropAll bool) error {
+	var ran []database.Migration
+	var err error
+
+	switch command {
+	case "status":
+		return printMigrationStatus(db)
+	case "up":
+		ran, err = database.MigrateUp(db)
+	case "down":
+		ran, err = database.MigrateDown(db, dropAll)
+	case "to":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: -migrate to <version>")
+		}
+		target, parseErr := strconv.ParseUint(args[0], 10, 32)
+		if parseErr != nil {
+			return fmt.Errorf("invalid schema version: %s", args[0])
+		}
+		ran, err = database.MigrateTo(db, uint(target), dropAll)
+	default:
+		return fmt.Errorf("unknown migrate command: %s (use status, up, down or to)", command)
+	}
+
+	if errors.Is(err, database.ErrDropsAllData) {
+		return fmt.Errorf("%w, back up the database and add -migrate-drop-all to go ahead", err)
+	}
+
+	// Migrations up to the resulting version were applied, the ones above it reverted.
+	current, versionErr := database.SchemaVersion(db)
+	if versionErr != nil {
+		return versionErr
+	}
+	for _, m := range ran {
+		if m.Version <= current {
+			fmt.Printf("Applied migration %d (%s).\n", m.Version, m.Description)
+		} else {
+			fmt.Printf("Reverted migration %d (%s).\n", m.Version, m.Description)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema is at version %d, the latest version is %d.\n", current, database.LatestVersion())
+
+	return nil
+}
+
+// prepareSchema applies pending migrations when auto_migrate is enabled and refuses
+// a schema that is out of date or newer than this binary.
+func prepareSchema(db *gorm.DB, cfg *config.Config, logger *zap.Logger) error {
+	if *cfg.Database.AutoMigrate {
+		ran, err := database.MigrateUp(db)
+		for _, m := range ran {
+			logger.Info("applied schema migration", zap.Uint("version", m.Version), zap.String("description", m.Description))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err := database.CheckSchema(db)
+	if errors.Is(err, database.ErrSchemaOutdated) {
+		return fmt.Errorf("%w, run tunnel-manager -migrate up or enable database.auto_migrate", err)
+	}
+
+	return err
+}
+
 func checkUlimit(logger *zap.Logger) {
 	var rLimit syscall.Rlimit
 	desiredCur := uint64(65535)
@@ -167,6 +410,16 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 func main() {
 	versionFlag := flag.Bool("version", false, "show the version and exit")
 	configPath := flag.String("config", "config/config.yaml", "path to config file")
+	rotateKeyFile := flag.String("rotate-key", "", "re-encrypt stored secrets with the key in the given file and exit")
+	createToken := flag.String("create-token", "", "create an API token with the given description, print it and exit")
+	tokenTTL := flag.Duration("token-ttl", 0, "lifetime of the token created with -create-token (0 means it never expires)")
+	tokenRole := flag.String("token-role", auth.RoleAdmin, "role of the token created with -create-token (viewer, operator or admin)")
+	migrateCommand := flag.String("migrate", "", "run a schema migration command and exit: status, up, down or to <version>")
+	migrateDropAll := flag.Bool("migrate-drop-all", false, "with -migrate down or to 0, allow reverting the initial schema, which drops every table and its data")
+	applyFile := flag.String("apply", "", "apply an inventory file through the API of a running tunnel-manager and exit")
+	server := flag.String("server", client.DefaultServer, "URL of the tunnel-manager used by -apply")
+	prune := flag.Bool("prune", false, "with -apply, delete Hosts and service ports that are not in the inventory")
+	dryRun := flag.Bool("dry-run", false, "with -apply, only show the changes the inventory would make")
 	flag.Parse()
 
 	if *versionFlag {
@@ -174,6 +427,15 @@ func main() {
 		os.Exit(0)
 	}
 
+	// -apply is a client of the API and needs neither root nor the config file.
+	if *applyFile != "" {
+		err := applyInventory(*server, *applyFile, *prune, *dryRun)
+		if err != nil {
+			log.Fatalf("Failed to apply inventory: %v", err)
+		}
+		os.Exit(0)
+	}
+
 	if os.Geteuid() != 0 {
 		log.Fatal("This program must be run as root")
 	}
@@ -200,17 +462,108 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
-	manager, err := tunnel.NewManager(db, logger, cfg.Monitoring.IntervalSec)
+	if *migrateCommand != "" {
+		err = runMigration(db, *migrateCommand, flag.Args(), *migrateDropAll)
+		if err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	err = prepareSchema(db, cfg, logger)
+	if err != nil {
+		log.Fatalf("Failed to prepare database schema: %v", err)
+	}
+
+	err = metrics.InstrumentDB(db)
+	if err != nil {
+		log.Fatalf("Failed to instrument database: %v", err)
+	}
+
+	if *rotateKeyFile != "" {
+		err = rotateKey(db, cfg, *rotateKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to rotate secrets key: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if *createToken != "" {
+		err = bootstrapToken(db, *createToken, *tokenRole, *tokenTTL)
+		if err != nil {
+			log.Fatalf("Failed to create API token: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	keyring, err := loadKeyring(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load secrets key: %v", err)
+	}
+	secret.SetDefault(keyring)
+
+	encrypted, err := database.MigrateSecrets(db, keyring)
+	if err != nil {
+		log.Fatalf("Failed to migrate stored secrets: %v", err)
+	}
+	if keyring == nil {
+		logger.Warn("secrets key is not configured, Host credentials are stored in plain text")
+	} else if encrypted > 0 {
+		logger.Info("encrypted stored secrets", zap.Int("count", encrypted), zap.String("key_id", keyring.KeyID()))
+	}
+
+	var tokenCount int64
+	err = db.Model(&models.APIToken{}).Count(&tokenCount).Error
+	if err != nil {
+		log.Fatalf("Failed to count API tokens: %v", err)
+	}
+	if tokenCount == 0 {
+		logger.Warn("no API token exists, create one with -create-token to use the API")
+	}
+
+	backoff := tunnel.BackoffPolicy{
+		InitialDelay: time.Duration(cfg.Reconnect.InitialDelaySec) * time.Second,
+		Multiplier:   cfg.Reconnect.Multiplier,
+		MaxDelay:     time.Duration(cfg.Reconnect.MaxDelaySec) * time.Second,
+		Jitter:       *cfg.Reconnect.Jitter,
+		MaxAttempts:  cfg.Reconnect.MaxAttempts,
+	}
+	manager, err := tunnel.NewManager(db, logger, cfg.Monitoring.IntervalSec, *cfg.SSH.TrustOnFirstUse, backoff)
 	if err != nil {
 		log.Fatalf("Failed to create tunnel manager: %v", err)
 	}
 
+	if cfg.SSH.KnownHostsFile != "" {
+		data, err := os.ReadFile(cfg.SSH.KnownHostsFile)
+		if err != nil {
+			log.Fatalf("Failed to read known_hosts file: %v", err)
+		}
+
+		result, err := tunnel.ImportKnownHosts(db, data, false)
+		if err != nil {
+			log.Fatalf("Failed to import known_hosts file: %v", err)
+		}
+		logger.Info("imported known_hosts file",
+			zap.String("path", cfg.SSH.KnownHostsFile),
+			zap.Int("imported", len(result.Imported)),
+			zap.Int("skipped", len(result.Skipped)))
+	}
+
 	logger.Info("Restoring all tunnels...")
 	err = manager.RestoreAllTunnels()
 	if err != nil {
 		logger.Error("failed to restore tunnels", zap.Error(err))
 	}
 
+	go manager.RunTrafficFlush(time.Duration(cfg.Monitoring.TrafficFlushIntervalSec) * time.Second)
+	go manager.RunEventPruning(time.Duration(cfg.Monitoring.EventRetentionDays) * 24 * time.Hour)
+	go manager.RunScheduler()
+
+	dispatcher := webhook.NewDispatcher(db, logger,
+		time.Duration(cfg.Webhook.ReconnectingThresholdSec)*time.Second,
+		time.Duration(cfg.Monitoring.EventRetentionDays)*24*time.Hour)
+	go dispatcher.Run(manager)
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
@@ -221,29 +574,6 @@ func main() {
 		os.Exit(0)
 	}()
 
-	e := echo.New()
-	e.Validator = &CustomValidator{validator: validator.New()}
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
-
-	h := api.NewHandler(db, manager, logger)
-	g := e.Group("/api")
-
-	g.POST("/host", h.CreateHost)
-	g.GET("/host", h.ListHosts)
-	g.GET("/host/:id", h.GetHost)
-	g.PUT("/host/:id", h.UpdateHost)
-	g.DELETE("/host/:id", h.DeleteHost)
-
-	g.POST("/service-port", h.CreateServicePort)
-	g.GET("/service-port", h.ListServicePorts)
-	g.GET("/service-port/:id", h.GetServicePort)
-	g.PUT("/service-port/:id", h.UpdateServicePort)
-	g.DELETE("/service-port/:id", h.DeleteServicePort)
-
-	g.GET("/status", h.GetStatus)
-	g.GET("/status/:hostId", h.GetHostStatus)
-
+	e := newServer(cfg, db, manager, logger)
 	e.Logger.Fatal(e.Start(fmt.Sprintf(":%d", cfg.API.Port)))
 }
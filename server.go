@@ -0,0 +1,122 @@
+package main
+
+import (
+	"github.com/go-playground/validator/v10"
+	"github.com/jollaman999/tunnel-manager/internal/api"
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/config"
+	"github.com/jollaman999/tunnel-manager/internal/metrics"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// newServer returns the API server with its middleware and routes.
+func newServer(cfg *config.Config, db *gorm.DB, manager *tunnel.Manager, logger *zap.Logger) *echo.Echo {
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: validator.New()}
+	e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
+	if len(cfg.API.CORSAllowedOrigins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins: cfg.API.CORSAllowedOrigins,
+			AllowHeaders: []string{echo.HeaderAuthorization, echo.HeaderContentType, api.PassphraseHeader},
+		}))
+	}
+
+	if *cfg.Metrics.Enabled {
+		if cfg.Metrics.RequireToken {
+			e.GET("/metrics", echo.WrapHandler(metrics.Handler()),
+				auth.Middleware(db, logger), auth.RequireGlobal(auth.RoleViewer))
+		} else {
+			e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+		}
+	}
+
+	h := api.NewHandler(db, manager, logger, cfg.API.CORSAllowedOrigins)
+	g := e.Group("/api", auth.Middleware(db, logger))
+
+	viewer := auth.Require(auth.RoleViewer)
+	operator := auth.Require(auth.RoleOperator)
+	admin := auth.Require(auth.RoleAdmin)
+	globalAdmin := auth.RequireGlobal(auth.RoleAdmin)
+
+	g.POST("/token", h.CreateAPIToken, globalAdmin)
+	g.GET("/token", h.ListAPITokens, globalAdmin)
+	g.GET("/token/:id", h.GetAPIToken, globalAdmin)
+	g.DELETE("/token/:id", h.DeleteAPIToken, globalAdmin)
+
+	g.POST("/host-group", h.CreateHostGroup, globalAdmin)
+	g.GET("/host-group", h.ListHostGroups, viewer)
+	g.GET("/host-group/:id", h.GetHostGroup, viewer)
+	g.PUT("/host-group/:id", h.UpdateHostGroup, globalAdmin)
+	g.DELETE("/host-group/:id", h.DeleteHostGroup, globalAdmin)
+
+	g.POST("/host", h.CreateHost, admin)
+	g.GET("/host", h.ListHosts, viewer)
+	g.GET("/host/:id", h.GetHost, viewer)
+	g.PUT("/host/:id", h.UpdateHost, operator)
+	g.DELETE("/host/:id", h.DeleteHost, admin)
+	g.GET("/host/:id/host-key", h.GetHostKey, viewer)
+	g.PUT("/host/:id/host-key", h.PinHostKey, admin)
+	g.DELETE("/host/:id/host-key", h.ResetHostKey, admin)
+	g.GET("/host/:id/jump-hosts", h.GetJumpHosts, viewer)
+	g.PUT("/host/:id/jump-hosts", h.SetJumpHosts, admin)
+	g.POST("/host-key/import", h.ImportKnownHosts, globalAdmin)
+
+	g.POST("/ssh-key", h.CreateSSHKey, globalAdmin)
+	g.GET("/ssh-key", h.ListSSHKeys, viewer)
+	g.GET("/ssh-key/:id", h.GetSSHKey, viewer)
+	g.PUT("/ssh-key/:id", h.UpdateSSHKey, globalAdmin)
+	g.DELETE("/ssh-key/:id", h.DeleteSSHKey, globalAdmin)
+
+	g.POST("/service-port", h.CreateServicePort, globalAdmin)
+	g.GET("/service-port", h.ListServicePorts, viewer)
+	g.GET("/service-port/:id", h.GetServicePort, viewer)
+	g.PUT("/service-port/:id", h.UpdateServicePort, globalAdmin)
+	g.DELETE("/service-port/:id", h.DeleteServicePort, globalAdmin)
+
+	g.POST("/webhook", h.CreateWebhook, globalAdmin)
+	g.GET("/webhook", h.ListWebhooks, globalAdmin)
+	g.GET("/webhook/:id", h.GetWebhook, globalAdmin)
+	g.PUT("/webhook/:id", h.UpdateWebhook, globalAdmin)
+	g.DELETE("/webhook/:id", h.DeleteWebhook, globalAdmin)
+	g.GET("/webhook/:id/deliveries", h.ListWebhookDeliveries, globalAdmin)
+
+	g.POST("/schedule", h.CreateSchedule, globalAdmin)
+	g.GET("/schedule", h.ListSchedules, globalAdmin)
+	g.GET("/schedule/:id", h.GetSchedule, globalAdmin)
+	g.PUT("/schedule/:id", h.UpdateSchedule, globalAdmin)
+	g.DELETE("/schedule/:id", h.DeleteSchedule, globalAdmin)
+
+	g.GET("/assignment", h.ListAssignments, viewer)
+	g.POST("/assignment", h.CreateAssignment, admin)
+	g.POST("/assignment/attach", h.BulkAttach, admin)
+	g.POST("/assignment/detach", h.BulkDetach, admin)
+	g.GET("/assignment/:hostId/:spId", h.GetAssignment, viewer)
+	g.DELETE("/assignment/:hostId/:spId", h.DeleteAssignment, admin)
+
+	g.POST("/inventory/plan", h.PlanInventory, globalAdmin)
+	g.POST("/inventory/apply", h.ApplyInventory, globalAdmin)
+	g.GET("/export", h.Export, globalAdmin)
+	g.POST("/import", h.Import, globalAdmin)
+
+	g.GET("/status", h.GetStatus, viewer)
+	g.GET("/status/stream", h.StreamStatus, viewer)
+	g.GET("/status/ws", h.StatusWebSocket, viewer)
+	g.GET("/status/:hostId", h.GetHostStatus, viewer)
+	g.GET("/traffic", h.ListTraffic, viewer)
+	g.GET("/tunnel/:hostId/:spId/events", h.ListTunnelEvents, viewer)
+	g.POST("/tunnel/start", h.BulkStartTunnels, operator)
+	g.POST("/tunnel/stop", h.BulkStopTunnels, operator)
+	g.POST("/tunnel/restart", h.BulkRestartTunnels, operator)
+	g.POST("/tunnel/retry", h.BulkRetryTunnels, operator)
+	g.POST("/tunnel/:hostId/:spId/start", h.StartTunnel, operator)
+	g.POST("/tunnel/:hostId/:spId/stop", h.StopTunnel, operator)
+	g.POST("/tunnel/:hostId/:spId/restart", h.RestartTunnel, operator)
+	g.POST("/tunnel/:hostId/:spId/retry", h.RetryTunnel, operator)
+
+	return e
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/jollaman999/tunnel-manager/internal/auth"
+	"github.com/jollaman999/tunnel-manager/internal/config"
+	"github.com/jollaman999/tunnel-manager/internal/database"
+	"github.com/jollaman999/tunnel-manager/internal/models"
+	"github.com/jollaman999/tunnel-manager/internal/tunnel"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestServer returns the API server of a migrated SQLite database. configure, if not nil,
+// changes the configuration, in which metrics are disabled.
+func newTestServer(t *testing.T, configure func(cfg *config.Config)) (*echo.Echo, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = database.MigrateUp(db)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	manager, err := tunnel.NewManager(db, zap.NewNop(), 1, true, tunnel.BackoffPolicy{})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	t.Cleanup(manager.StopAllTunnels)
+
+	cfg := &config.Config{}
+	metricsEnabled := false
+	cfg.Metrics.Enabled = &metricsEnabled
+	if configure != nil {
+		configure(cfg)
+	}
+
+	return newServer(cfg, db, manager, zap.NewNop()), db
+}
+
+// newTestToken creates an API token and returns it in plain text.
+func newTestToken(t *testing.T, db *gorm.DB, role string, hostGroupIDs []uint, expiresAt *time.Time) string {
+	t.Helper()
+
+	apiToken, err := auth.CreateToken(db, "test", role, hostGroupIDs, expiresAt)
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+
+	return apiToken.Token
+}
+
+// serve sends a request with the given headers to the server and returns the response.
+func serve(e *echo.Echo, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{echo.HeaderAuthorization: "Bearer " + token}
+}
+
+func TestAPIRequiresToken(t *testing.T) {
+	e, db := newTestServer(t, nil)
+
+	valid := newTestToken(t, db, auth.RoleViewer, nil, nil)
+	past := time.Now().Add(-time.Minute)
+	expired := newTestToken(t, db, auth.RoleViewer, nil, &past)
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "missing token", wantStatus: http.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic " + valid, wantStatus: http.StatusUnauthorized},
+		{name: "empty bearer", authorization: "Bearer ", wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", authorization: "Bearer " + auth.TokenPrefix + "invalid", wantStatus: http.StatusUnauthorized},
+		{name: "expired token", authorization: "Bearer " + expired, wantStatus: http.StatusUnauthorized},
+		{name: "valid token", authorization: "Bearer " + valid, wantStatus: http.StatusOK},
+		{name: "lower case scheme", authorization: "bearer " + valid, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.authorization != "" {
+				headers[echo.HeaderAuthorization] = tt.authorization
+			}
+
+			for _, path := range []string{"/api/host", "/api/status", "/api/service-port"} {
+				rec := serve(e, http.MethodGet, path, "", headers)
+				if rec.Code != tt.wantStatus {
+					t.Errorf("GET %s = %d, want %d: %s", path, rec.Code, tt.wantStatus, rec.Body.String())
+				}
+				if tt.wantStatus == http.StatusUnauthorized && rec.Header().Get(echo.HeaderWWWAuthenticate) == "" {
+					t.Errorf("GET %s did not ask for a bearer token", path)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		name       string
+		origins    []string
+		origin     string
+		wantOrigin string
+	}{
+		{name: "allowed origin", origins: []string{"https://ui.example.com"}, origin: "https://ui.example.com",
+			wantOrigin: "https://ui.example.com"},
+		{name: "other origin", origins: []string{"https://ui.example.com"}, origin: "https://evil.example.com"},
+		{name: "no allowed origins", origin: "https://ui.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, db := newTestServer(t, func(cfg *config.Config) {
+				cfg.API.CORSAllowedOrigins = tt.origins
+			})
+			token := newTestToken(t, db, auth.RoleViewer, nil, nil)
+
+			preflight := serve(e, http.MethodOptions, "/api/host", "", map[string]string{
+				echo.HeaderOrigin:                      tt.origin,
+				echo.HeaderAccessControlRequestMethod:  http.MethodGet,
+				echo.HeaderAccessControlRequestHeaders: echo.HeaderAuthorization,
+			})
+			if got := preflight.Header().Get(echo.HeaderAccessControlAllowOrigin); got != tt.wantOrigin {
+				t.Errorf("preflight allowed origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.wantOrigin != "" && !strings.Contains(preflight.Header().Get(echo.HeaderAccessControlAllowHeaders), echo.HeaderAuthorization) {
+				t.Errorf("preflight allowed headers = %q, want %s", preflight.Header().Get(echo.HeaderAccessControlAllowHeaders), echo.HeaderAuthorization)
+			}
+
+			headers := bearer(token)
+			headers[echo.HeaderOrigin] = tt.origin
+			rec := serve(e, http.MethodGet, "/api/host", "", headers)
+			if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != tt.wantOrigin {
+				t.Errorf("allowed origin = %q, want %q", got, tt.wantOrigin)
+			}
+		})
+	}
+}
+
+func TestRoles(t *testing.T) {
+	e, db := newTestServer(t, nil)
+
+	host := models.Host{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret"}
+	err := db.Create(&host).Error
+	if err != nil {
+		t.Fatalf("failed to create host: %v", err)
+	}
+	hostPath := fmt.Sprintf("/api/host/%d", host.ID)
+	hostBody := `{"ip":"192.168.0.11","port":22,"user":"root","password":"secret"}`
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		role   string
+	}{
+		{method: http.MethodGet, path: "/api/host", role: auth.RoleViewer},
+		{method: http.MethodGet, path: hostPath, role: auth.RoleViewer},
+		{method: http.MethodGet, path: "/api/status", role: auth.RoleViewer},
+		{method: http.MethodPut, path: hostPath, body: `{"enabled":false}`, role: auth.RoleOperator},
+		{method: http.MethodPost, path: "/api/tunnel/stop", body: `{"host_ids":[1]}`, role: auth.RoleOperator},
+		{method: http.MethodPost, path: "/api/host", body: hostBody, role: auth.RoleAdmin},
+		{method: http.MethodPut, path: hostPath + "/jump-hosts", body: `{"jump_host_ids":[]}`, role: auth.RoleAdmin},
+		{method: http.MethodPost, path: "/api/service-port", body: `{"service_ip":"10.0.0.5","service_port":80,"local_port":8080}`, role: auth.RoleAdmin},
+		{method: http.MethodPost, path: "/api/token", body: `{"role":"viewer"}`, role: auth.RoleAdmin},
+		{method: http.MethodDelete, path: hostPath, role: auth.RoleAdmin},
+	}
+
+	roles := []string{auth.RoleViewer, auth.RoleOperator, auth.RoleAdmin}
+	tokens := make(map[string]string)
+	for _, role := range roles {
+		tokens[role] = newTestToken(t, db, role, nil, nil)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			for _, role := range roles {
+				rec := serve(e, tt.method, tt.path, tt.body, bearer(tokens[role]))
+				allowed := auth.HasRole(&models.APIToken{Role: role}, tt.role)
+				if allowed && rec.Code == http.StatusForbidden {
+					t.Errorf("%s token was refused: %s", role, rec.Body.String())
+				}
+				if !allowed && rec.Code != http.StatusForbidden {
+					t.Errorf("%s token got %d, want %d", role, rec.Code, http.StatusForbidden)
+				}
+			}
+		})
+	}
+}
+
+func TestHostGroupScope(t *testing.T) {
+	e, db := newTestServer(t, nil)
+
+	groups := []models.HostGroup{{Name: "team-a"}, {Name: "team-b"}}
+	for i := range groups {
+		err := db.Create(&groups[i]).Error
+		if err != nil {
+			t.Fatalf("failed to create host group: %v", err)
+		}
+	}
+	inside := models.Host{IP: "192.168.0.10", Port: 22, User: "root", Password: "secret", HostGroupID: &groups[0].ID}
+	outside := models.Host{IP: "192.168.0.20", Port: 22, User: "root", Password: "secret", HostGroupID: &groups[1].ID}
+	ungrouped := models.Host{IP: "192.168.0.30", Port: 22, User: "root", Password: "secret"}
+	for _, host := range []*models.Host{&inside, &outside, &ungrouped} {
+		err := db.Create(host).Error
+		if err != nil {
+			t.Fatalf("failed to create host: %v", err)
+		}
+	}
+
+	token := newTestToken(t, db, auth.RoleAdmin, []uint{groups[0].ID}, nil)
+
+	rec := serve(e, http.MethodGet, "/api/host", "", bearer(token))
+	var res struct {
+		Data []models.Host `json:"data"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &res)
+	if err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/host = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(res.Data) != 1 || res.Data[0].ID != inside.ID {
+		t.Errorf("GET /api/host returned %d hosts, want only host %d", len(res.Data), inside.ID)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{name: "get host in scope", method: http.MethodGet, path: fmt.Sprintf("/api/host/%d", inside.ID), wantStatus: http.StatusOK},
+		{name: "get host outside scope", method: http.MethodGet, path: fmt.Sprintf("/api/host/%d", outside.ID), wantStatus: http.StatusNotFound},
+		{name: "get ungrouped host", method: http.MethodGet, path: fmt.Sprintf("/api/host/%d", ungrouped.ID), wantStatus: http.StatusNotFound},
+		{name: "get status outside scope", method: http.MethodGet, path: fmt.Sprintf("/api/status/%d", outside.ID), wantStatus: http.StatusNotFound},
+		{name: "update host outside scope", method: http.MethodPut, path: fmt.Sprintf("/api/host/%d", outside.ID),
+			body: `{"description":"changed"}`, wantStatus: http.StatusNotFound},
+		{name: "delete host outside scope", method: http.MethodDelete, path: fmt.Sprintf("/api/host/%d", outside.ID), wantStatus: http.StatusNotFound},
+		{name: "move host out of scope", method: http.MethodPut, path: fmt.Sprintf("/api/host/%d", inside.ID),
+			body: fmt.Sprintf(`{"host_group_id":%d}`, groups[1].ID), wantStatus: http.StatusBadRequest},
+		{name: "create host outside scope", method: http.MethodPost, path: "/api/host",
+			body:       fmt.Sprintf(`{"ip":"192.168.0.21","port":22,"user":"root","password":"secret","host_group_id":%d}`, groups[1].ID),
+			wantStatus: http.StatusBadRequest},
+		{name: "create service port", method: http.MethodPost, path: "/api/service-port",
+			body: `{"service_ip":"10.0.0.5","service_port":80,"local_port":8080}`, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(e, tt.method, tt.path, tt.body, bearer(token))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	var stored models.Host
+	db.First(&stored, outside.ID)
+	if stored.Description != "" || stored.HostGroupID == nil || *stored.HostGroupID != groups[1].ID {
+		t.Errorf("host outside scope was changed: %+v", stored)
+	}
+	stored = models.Host{}
+	db.First(&stored, inside.ID)
+	if stored.HostGroupID == nil || *stored.HostGroupID != groups[0].ID {
+		t.Errorf("host in scope was moved to host group %v", stored.HostGroupID)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	tests := []struct {
+		name         string
+		enabled      bool
+		requireToken bool
+		token        bool
+		wantStatus   int
+	}{
+		{name: "disabled", wantStatus: http.StatusNotFound},
+		{name: "enabled", enabled: true, wantStatus: http.StatusOK},
+		{name: "token required", enabled: true, requireToken: true, wantStatus: http.StatusUnauthorized},
+		{name: "token given", enabled: true, requireToken: true, token: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, db := newTestServer(t, func(cfg *config.Config) {
+				cfg.Metrics.Enabled = &tt.enabled
+				cfg.Metrics.RequireToken = tt.requireToken
+			})
+
+			headers := map[string]string{}
+			if tt.token {
+				headers = bearer(newTestToken(t, db, auth.RoleViewer, nil, nil))
+			}
+
+			rec := serve(e, http.MethodGet, "/metrics", "", headers)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("GET /metrics = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusOK && !strings.Contains(rec.Body.String(), "go_goroutines") {
+				t.Errorf("GET /metrics did not return Prometheus metrics")
+			}
+		})
+	}
+}
+
+func TestStatusWebSocket(t *testing.T) {
+	e, db := newTestServer(t, func(cfg *config.Config) {
+		cfg.API.CORSAllowedOrigins = []string{"https://ui.example.com"}
+	})
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	token := newTestToken(t, db, auth.RoleViewer, nil, nil)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/status/ws"
+
+	tests := []struct {
+		name         string
+		origin       string
+		header       bool
+		protocols    []string
+		wantProtocol string
+		wantErr      bool
+	}{
+		{name: "bearer header from own origin", origin: server.URL, header: true},
+		{name: "token subprotocol from allowed origin", origin: "https://ui.example.com",
+			protocols: []string{auth.WebSocketProtocol, "bearer." + token}, wantProtocol: auth.WebSocketProtocol},
+		{name: "token subprotocol from other origin", origin: "https://evil.example.com",
+			protocols: []string{auth.WebSocketProtocol, "bearer." + token}, wantErr: true},
+		{name: "bearer header from other origin", origin: "https://evil.example.com", header: true, wantErr: true},
+		{name: "without token", origin: "https://ui.example.com", protocols: []string{auth.WebSocketProtocol}, wantErr: true},
+		{name: "invalid token subprotocol", origin: "https://ui.example.com",
+			protocols: []string{auth.WebSocketProtocol, "bearer." + auth.TokenPrefix + "invalid"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := websocket.NewConfig(wsURL, tt.origin)
+			if err != nil {
+				t.Fatalf("websocket.NewConfig() error = %v", err)
+			}
+			config.Protocol = tt.protocols
+			if tt.header {
+				config.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			}
+
+			ws, err := websocket.DialConfig(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("websocket.DialConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+
+			if got := strings.Join(ws.Config().Protocol, ","); got != tt.wantProtocol {
+				t.Errorf("selected protocol = %q, want %q", got, tt.wantProtocol)
+			}
+
+			var message struct {
+				Type string `json:"type"`
+			}
+			_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+			err = websocket.JSON.Receive(ws, &message)
+			if err != nil || message.Type != "snapshot" {
+				t.Errorf("first message = %q, %v, want snapshot", message.Type, err)
+			}
+		})
+	}
+}